@@ -191,6 +191,9 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 		case reply.Error != "":
 			log.Printf("server returned an error:\r")
 			log.Printf("  %s\r", reply.Error)
+			if reply.RetryAfter > 0 {
+				log.Printf("the server is busy; please try again in %d seconds\r", reply.RetryAfter)
+			}
 			return
 
 		case reply.CommitBundle != nil:
@@ -203,6 +206,8 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 				fmt.Fprintf(out, "%s", reply.Event.Dump())
 			case "stderr":
 				fmt.Fprintf(stderr, "%s", reply.Event.Dump())
+			case "queue":
+				fmt.Fprintf(out, "waiting for the server: number %d in line\r\n", reply.Event.QueuePosition)
 			case "files":
 				if reply.Event.Files != nil {
 					for name, contents := range reply.Event.Files {
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandExport(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	unique := args[0]
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalf("getting output flag: %v", err)
+	}
+	if output == "" {
+		output = unique + ".tar.gz"
+	}
+
+	// export the problem set with this name, or else the problem
+	var path string
+	params := make(url.Values)
+	params.Add("unique", unique)
+	problemSets := []*ProblemSet{}
+	mustGetObject("/problem_sets", params, &problemSets)
+	if len(problemSets) == 1 {
+		path = fmt.Sprintf("/problem_sets/%d/archive", problemSets[0].ID)
+		fmt.Printf("exporting problem set %q and its problems\n", problemSets[0].Unique)
+	} else {
+		problems := []*Problem{}
+		mustGetObject("/problems", params, &problems)
+		if len(problems) != 1 {
+			log.Fatalf("no problem set or problem found with unique ID %q", unique)
+		}
+		path = fmt.Sprintf("/problems/%d/archive", problems[0].ID)
+		fmt.Printf("exporting problem %q\n", problems[0].Unique)
+	}
+
+	archive := new(bytes.Buffer)
+	mustGetObject(path, nil, archive)
+	if err := ioutil.WriteFile(output, archive.Bytes(), 0644); err != nil {
+		log.Fatalf("saving %s: %v", output, err)
+	}
+	fmt.Printf("archive saved to %s\n", output)
+}
+
+func CommandImport(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	rename, err := cmd.Flags().GetBool("rename")
+	if err != nil {
+		log.Fatalf("getting rename flag: %v", err)
+	}
+	archive, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		log.Fatalf("reading %s: %v", args[0], err)
+	}
+
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	if !user.Author && !user.Admin {
+		log.Fatalf("you must be an author or admin to use this command")
+	}
+
+	// the server checks the archive and prepares a bundle for each problem
+	params := make(url.Values)
+	if rename {
+		params.Add("rename", "true")
+	}
+	unpacked := new(ArchiveImport)
+	mustPostObject("/problem_archives", params, bytes.NewReader(archive), unpacked)
+	fmt.Printf("archive from %s has %d problem%s and %d problem set%s\n",
+		unpacked.Manifest.Hostname, len(unpacked.Bundles), plural(len(unpacked.Bundles)),
+		len(unpacked.Manifest.ProblemSets), plural(len(unpacked.Manifest.ProblemSets)))
+	for old, name := range unpacked.RenamedProblems {
+		fmt.Printf("  problem %q will be imported as %q\n", old, name)
+	}
+	for old, name := range unpacked.RenamedProblemSets {
+		fmt.Printf("  problem set %q will be imported as %q\n", old, name)
+	}
+
+	// confirm every solution before saving anything
+	var signedBundles []*ProblemBundle
+	for _, unsigned := range unpacked.Bundles {
+		fmt.Printf("confirming problem %q\n", unsigned.Problem.Unique)
+		signed := new(ProblemBundle)
+		mustPostObject("/problem_bundles/unconfirmed", nil, unsigned, signed)
+		if signed.Hostname == "" {
+			log.Fatalf("server was unable to find a suitable daycare, unable to validate")
+		}
+		mustConfirmProblemBundle(signed)
+		signedBundles = append(signedBundles, signed)
+	}
+	fmt.Println("all problems and solutions confirmed successfully")
+
+	// save the problems and then the problem sets
+	problemIDs := make(map[string]int64)
+	for _, signed := range signedBundles {
+		final := new(ProblemBundle)
+		mustPostObject("/problem_bundles/confirmed", nil, signed, final)
+		problemIDs[final.Problem.Unique] = final.Problem.ID
+		fmt.Printf("problem %q created and ready to use\n", final.Problem.Unique)
+	}
+	for _, set := range unpacked.Manifest.ProblemSets {
+		bundle := &ProblemSetBundle{
+			ProblemSet: &ProblemSet{
+				Unique:     set.Unique,
+				Note:       set.Note,
+				Tags:       set.Tags,
+				LatePolicy: set.LatePolicy,
+				CreatedAt:  now,
+				UpdatedAt:  now,
+			},
+		}
+		for _, elt := range set.Problems {
+			bundle.ProblemSetProblems = append(bundle.ProblemSetProblems, &ProblemSetProblem{
+				ProblemID: problemIDs[elt.Unique],
+				Weight:    elt.Weight,
+			})
+		}
+		final := new(ProblemSetBundle)
+		mustPostObject("/problem_set_bundles", nil, bundle, final)
+		fmt.Printf("problem set %q created and ready to use\n", final.ProblemSet.Unique)
+	}
+}
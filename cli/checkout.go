@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCheckout(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) == 0 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "id:"), 10, 64)
+	if err != nil || id < 1 {
+		log.Printf("unknown commit identifier %q", args[0])
+		log.Fatalf("   run '%s history' to see the commits for this step", os.Args[0])
+	}
+
+	_, problem, assignment, current, _, problemDir := gatherStudent(now, ".")
+
+	commit := new(Commit)
+	mustGetObject(fmt.Sprintf("/commits/%d", id), nil, commit)
+	if commit.AssignmentID != assignment.ID || commit.ProblemID != problem.ID || commit.Step != current.Step {
+		log.Printf("commit %d is not from %s step %d", id, problem.Unique, current.Step)
+		log.Fatalf("   run '%s history' to see the commits for this step", os.Args[0])
+	}
+
+	// gather the requested files from the commit
+	files := make(map[string][]byte)
+	for _, requested := range args[1:] {
+		// find this file in the commit
+		found := false
+		clean := filepath.Clean(requested)
+		for name, contents := range commit.Files {
+			if clean == filepath.FromSlash(name) {
+				// we count an exact match ...
+				files[filepath.FromSlash(name)] = contents
+				found = true
+			} else if clean == filepath.Base(clean) && clean == filepath.Base(filepath.FromSlash(name)) {
+				// ... or an exact match of a filename in a subdirectory
+				files[filepath.FromSlash(name)] = contents
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("no file matching %q in commit %d", requested, id)
+		}
+	}
+	if len(args) == 1 {
+		// no files listed, so restore all of them
+		for name, contents := range commit.Files {
+			files[filepath.FromSlash(name)] = contents
+		}
+	}
+
+	// report which files are already up to date
+	changed := false
+	for name, contents := range files {
+		ondisk, err := ioutil.ReadFile(filepath.Join(problemDir, name))
+		if err != nil && os.IsNotExist(err) {
+			changed = true
+		} else if err != nil {
+			log.Fatalf("error reading %s: %v", name, err)
+		} else if !bytes.Equal(ondisk, contents) {
+			changed = true
+		}
+	}
+
+	updateFiles(problemDir, files, nil, true)
+
+	if !changed {
+		fmt.Printf("files already match commit %d\n", id)
+	} else {
+		fmt.Printf("restored files from commit %d saved %s\n", id, commit.UpdatedAt.Format("2006-01-02 15:04:05"))
+	}
+}
@@ -47,6 +47,10 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 
 	action := cmd.Flag("action").Value.String()
 	isUpdate := cmd.Flag("update").Value.String() == "true"
+	migrate := cmd.Flag("migrate").Value.String() == "true"
+	if migrate && !isUpdate {
+		log.Fatalf("--migrate is only valid with --update")
+	}
 
 	// branch off for problem set creation
 	// the rest of this function is for problems
@@ -103,39 +107,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// validate the commits one at a time
-	for n := 0; n < len(signed.ProblemSteps); n++ {
-		fmt.Printf("validating solution for step %d\n", n+1)
-		unvalidated := &CommitBundle{
-			ProblemType:          signed.ProblemTypes[signed.ProblemSteps[n].ProblemType],
-			ProblemTypeSignature: signed.ProblemTypeSignatures[signed.ProblemSteps[n].ProblemType],
-			Problem:              signed.Problem,
-			ProblemSteps:         signed.ProblemSteps,
-			ProblemSignature:     signed.ProblemSignature,
-			Hostname:             signed.Hostname,
-			UserID:               signed.UserID,
-			Commit:               signed.Commits[n],
-			CommitSignature:      signed.CommitSignatures[n],
-		}
-		validated := mustConfirmCommitBundle(unvalidated, nil)
-		fmt.Println("  finished validating solution")
-		if validated.Commit.ReportCard == nil || validated.Commit.Score != 1.0 || !validated.Commit.ReportCard.Passed {
-			fmt.Printf("  solution for step %d failed: %s\n", n+1, validated.Commit.ReportCard.Note)
-
-			// play the transcript
-			if err := validated.Commit.DumpTranscript(os.Stdout); err != nil {
-				log.Fatalf("failed to dump transcript: %v", err)
-			}
-			log.Fatalf("please fix solution and try again")
-		}
-		signed.ProblemTypes[validated.ProblemType.Name] = validated.ProblemType
-		signed.ProblemTypeSignatures[validated.ProblemType.Name] = validated.ProblemTypeSignature
-		signed.Problem = validated.Problem
-		signed.ProblemSteps = validated.ProblemSteps
-		signed.ProblemSignature = validated.ProblemSignature
-		signed.Commits[n] = validated.Commit
-		signed.CommitSignatures[n] = validated.CommitSignature
-	}
+	mustConfirmProblemBundle(signed)
 
 	fmt.Println("problem and solution confirmed successfully")
 
@@ -145,8 +117,12 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 		mustPostObject("/problem_bundles/confirmed", nil, signed, final)
 		fmt.Printf("problem %q created and ready to use\n", final.Problem.Unique)
 	} else {
+		signed.MigrateAssignments = migrate
 		mustPutObject(fmt.Sprintf("/problem_bundles/%d", signed.Problem.ID), nil, signed, final)
-		fmt.Printf("problem %q saved and ready to use\n", final.Problem.Unique)
+		fmt.Printf("problem %q saved as revision %d and ready to use\n", final.Problem.Unique, final.Problem.Revision)
+		if !migrate {
+			fmt.Println("  assignments already in progress will keep using the revision they started on")
+		}
 	}
 
 	if signed.Problem.ID == 0 {
@@ -176,6 +152,44 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 	}
 }
 
+// mustConfirmProblemBundle runs the solution for each step of a signed problem bundle
+// on the daycare, updating the bundle with the validated commits and signatures.
+func mustConfirmProblemBundle(signed *ProblemBundle) {
+	// validate the commits one at a time
+	for n := 0; n < len(signed.ProblemSteps); n++ {
+		fmt.Printf("validating solution for step %d\n", n+1)
+		unvalidated := &CommitBundle{
+			ProblemType:          signed.ProblemTypes[signed.ProblemSteps[n].ProblemType],
+			ProblemTypeSignature: signed.ProblemTypeSignatures[signed.ProblemSteps[n].ProblemType],
+			Problem:              signed.Problem,
+			ProblemSteps:         signed.ProblemSteps,
+			ProblemSignature:     signed.ProblemSignature,
+			Hostname:             signed.Hostname,
+			UserID:               signed.UserID,
+			Commit:               signed.Commits[n],
+			CommitSignature:      signed.CommitSignatures[n],
+		}
+		validated := mustConfirmCommitBundle(unvalidated, nil)
+		fmt.Println("  finished validating solution")
+		if validated.Commit.ReportCard == nil || validated.Commit.Score != 1.0 || !validated.Commit.ReportCard.Passed {
+			fmt.Printf("  solution for step %d failed: %s\n", n+1, validated.Commit.ReportCard.Note)
+
+			// play the transcript
+			if err := validated.Commit.DumpTranscript(os.Stdout); err != nil {
+				log.Fatalf("failed to dump transcript: %v", err)
+			}
+			log.Fatalf("please fix solution and try again")
+		}
+		signed.ProblemTypes[validated.ProblemType.Name] = validated.ProblemType
+		signed.ProblemTypeSignatures[validated.ProblemType.Name] = validated.ProblemTypeSignature
+		signed.Problem = validated.Problem
+		signed.ProblemSteps = validated.ProblemSteps
+		signed.ProblemSignature = validated.ProblemSignature
+		signed.Commits[n] = validated.Commit
+		signed.CommitSignatures[n] = validated.CommitSignature
+	}
+}
+
 func findProblemCfg(now time.Time, startDir string) (string, string, int, *Problem, []*ProblemStep, bool) {
 	// find the absolute directory so we can walk up the tree if needed
 	directory, err := filepath.Abs(startDir)
@@ -573,6 +587,11 @@ func createProblemSet(path string, isUpdate bool) {
 		Problem map[string]*struct {
 			Weight float64
 		}
+		Late struct {
+			PercentPerDay float64
+			MaxPercent    float64
+			GraceMinutes  int64
+		}
 	}{}
 	fmt.Printf("creating problem set using %s\n", path)
 	if err := gcfg.ReadFileInto(&cfg, path); err != nil {
@@ -586,6 +605,21 @@ func createProblemSet(path string, isUpdate bool) {
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
+	if cfg.Late.PercentPerDay > 0.0 {
+		problemSet.LatePolicy = &LatePolicy{
+			PercentPerDay: cfg.Late.PercentPerDay,
+			MaxPercent:    cfg.Late.MaxPercent,
+			GraceMinutes:  cfg.Late.GraceMinutes,
+		}
+		fmt.Printf("late policy: %g%% per day", cfg.Late.PercentPerDay)
+		if cfg.Late.MaxPercent > 0.0 {
+			fmt.Printf(", at most %g%%", cfg.Late.MaxPercent)
+		}
+		if cfg.Late.GraceMinutes > 0 {
+			fmt.Printf(", after a grace period of %d minutes", cfg.Late.GraceMinutes)
+		}
+		fmt.Println()
+	}
 
 	// require the file name to match the unique ID
 	if filepath.Base(path) != problemSet.Unique+".cfg" {
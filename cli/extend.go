@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+var extendDateFormats = []string{
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	time.RFC3339,
+}
+
+func CommandExtend(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	list, err := cmd.Flags().GetBool("list")
+	if err != nil {
+		log.Fatalf("getting list flag: %v", err)
+	}
+	remove, err := cmd.Flags().GetBool("remove")
+	if err != nil {
+		log.Fatalf("getting remove flag: %v", err)
+	}
+
+	if remove {
+		if len(args) == 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		for _, arg := range args {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id < 1 {
+				log.Fatalf("extension ID must be a positive number, not %q", arg)
+			}
+			mustDeleteObject(fmt.Sprintf("/extensions/%d", id), nil)
+			fmt.Printf("extension %d removed\n", id)
+		}
+		return
+	}
+
+	if len(args) == 0 || (list && len(args) != 1) || (!list && len(args) < 2) {
+		cmd.Help()
+		os.Exit(1)
+	}
+	assignmentID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || assignmentID < 1 {
+		log.Fatalf("assignment ID must be a positive number, not %q", args[0])
+	}
+	assignment := new(Assignment)
+	mustGetObject(fmt.Sprintf("/assignments/%d", assignmentID), nil, assignment)
+	users := []*User{}
+	mustGetObject(fmt.Sprintf("/courses/%d/users", assignment.CourseID), nil, &users)
+
+	if list {
+		extensions := []*Extension{}
+		mustGetObject(fmt.Sprintf("/courses/%d/extensions", assignment.CourseID), nil, &extensions)
+		printExtensions(extensions, users)
+		return
+	}
+
+	// gather the extension details
+	grant := &ExtensionGrant{AssignmentID: assignmentID}
+	if course, err := cmd.Flags().GetBool("course"); err != nil {
+		log.Fatalf("getting course flag: %v", err)
+	} else if course {
+		grant.AssignmentID = 0
+	}
+	if grant.DueAt, err = parseExtendDate(cmd, "due"); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if grant.LockAt, err = parseExtendDate(cmd, "lock"); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if grant.Multiplier, err = cmd.Flags().GetFloat64("multiplier"); err != nil {
+		log.Fatalf("getting multiplier flag: %v", err)
+	}
+	if grant.Note, err = cmd.Flags().GetString("note"); err != nil {
+		log.Fatalf("getting note flag: %v", err)
+	}
+	if grant.DueAt == nil && grant.LockAt == nil && grant.Multiplier == 0.0 {
+		log.Fatalf("give a new due date, lock date, or multiplier for the extension")
+	}
+
+	// find the students
+	for _, arg := range args[1:] {
+		user := findExtendUser(users, arg)
+		if user == nil {
+			log.Fatalf("no unique student in the course matches %q", arg)
+		}
+		grant.UserIDs = append(grant.UserIDs, user.ID)
+	}
+
+	extensions := []*Extension{}
+	mustPostObject(fmt.Sprintf("/courses/%d/extensions", assignment.CourseID), nil, grant, &extensions)
+	printExtensions(extensions, users)
+}
+
+func parseExtendDate(cmd *cobra.Command, name string) (*time.Time, error) {
+	s, err := cmd.Flags().GetString(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s flag: %v", name, err)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range extendDateFormats {
+		if when, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				// a date alone means the end of that day
+				when = when.Add(24*time.Hour - time.Minute)
+			}
+			return &when, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to parse %s date %q; use a format like %q", name, s, extendDateFormats[0])
+}
+
+// findExtendUser finds a student by ID, email, Canvas login, or a unique part of their name.
+func findExtendUser(users []*User, key string) *User {
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		for _, user := range users {
+			if user.ID == id {
+				return user
+			}
+		}
+		return nil
+	}
+	var match *User
+	for _, user := range users {
+		if strings.EqualFold(user.Email, key) || strings.EqualFold(user.CanvasLogin, key) {
+			return user
+		}
+		if strings.Contains(strings.ToLower(user.Name), strings.ToLower(key)) {
+			if match != nil {
+				return nil
+			}
+			match = user
+		}
+	}
+	return match
+}
+
+func printExtensions(extensions []*Extension, users []*User) {
+	if len(extensions) == 0 {
+		fmt.Println("no extensions found")
+		return
+	}
+	names := make(map[int64]string)
+	for _, user := range users {
+		names[user.ID] = user.Name
+	}
+	for _, ext := range extensions {
+		what := ext.CanvasTitle
+		if ext.LtiID == "" {
+			what = "all assignments"
+		}
+		var terms []string
+		if ext.Multiplier != 1.0 {
+			terms = append(terms, fmt.Sprintf("time x%g", ext.Multiplier))
+		}
+		if ext.DueAt != nil {
+			terms = append(terms, "due "+ext.DueAt.Local().Format("Jan 2 15:04"))
+		}
+		if ext.LockAt != nil {
+			terms = append(terms, "locks "+ext.LockAt.Local().Format("Jan 2 15:04"))
+		}
+		fmt.Printf("id:%-6d %s (%d): %s, %s\n", ext.ID, names[ext.UserID], ext.UserID, what, strings.Join(terms, ", "))
+		if ext.Note != "" {
+			fmt.Printf("    %s\n", ext.Note)
+		}
+	}
+}
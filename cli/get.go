@@ -126,7 +126,7 @@ func getAssignment(assignment *Assignment, rootDir string) string {
 			info.Step = 1
 		}
 
-		mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+		mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", assignment.ID, problem.ID, info.Step), nil, step)
 		infos[problem.Unique] = info
 		commits[problem.Unique] = commit
 		steps[problem.Unique] = step
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandGradebook(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		log.Fatalf("getting format flag: %v", err)
+	}
+	if format != "csv" && format != "json" {
+		log.Fatalf("format must be csv or json, not %q", format)
+	}
+	problemSetName, err := cmd.Flags().GetString("problem-set")
+	if err != nil {
+		log.Fatalf("getting problem-set flag: %v", err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalf("getting output flag: %v", err)
+	}
+
+	course := findCourse(args[0])
+	params := make(url.Values)
+	if problemSetName != "" {
+		params.Add("problem_set_id", strconv.FormatInt(findProblemSet(problemSetName).ID, 10))
+	}
+	gradebook := new(Gradebook)
+	mustGetObject(fmt.Sprintf("/courses/%d/gradebook", course.ID), params, gradebook)
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		fp, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("creating %s: %v", output, err)
+		}
+		defer fp.Close()
+		out = fp
+	}
+	if format == "csv" {
+		err = gradebook.WriteCSV(out)
+	} else {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(gradebook)
+	}
+	if err != nil {
+		log.Fatalf("writing gradebook: %v", err)
+	}
+	if output != "" {
+		fmt.Printf("gradebook for %s saved to %s\n", course.Name, output)
+	}
+}
+
+// findCourse finds a course by ID, or by its label or name.
+func findCourse(key string) *Course {
+	course := new(Course)
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil && id > 0 {
+		mustGetObject(fmt.Sprintf("/courses/%d", id), nil, course)
+		return course
+	}
+	courses := []*Course{}
+	mustGetObject("/courses", nil, &courses)
+	var matches []*Course
+	for _, elt := range courses {
+		if strings.EqualFold(elt.Label, key) || strings.EqualFold(elt.Name, key) {
+			return elt
+		}
+		if strings.Contains(strings.ToLower(elt.Label+" "+elt.Name), strings.ToLower(key)) {
+			matches = append(matches, elt)
+		}
+	}
+	if len(matches) != 1 {
+		log.Printf("no unique course matches %q; your courses are:", key)
+		for _, elt := range courses {
+			log.Printf("    %d: %s (%s)", elt.ID, elt.Name, elt.Label)
+		}
+		log.Fatalf("please give the course ID")
+	}
+	return matches[0]
+}
+
+// findProblemSet finds a problem set by ID or unique name.
+func findProblemSet(key string) *ProblemSet {
+	problemSet := new(ProblemSet)
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil && id > 0 {
+		mustGetObject(fmt.Sprintf("/problem_sets/%d", id), nil, problemSet)
+		return problemSet
+	}
+	problemSets := []*ProblemSet{}
+	params := make(url.Values)
+	params.Add("unique", key)
+	mustGetObject("/problem_sets", params, &problemSets)
+	if len(problemSets) != 1 {
+		log.Fatalf("no problem set found with unique ID %q", key)
+	}
+	return problemSets[0]
+}
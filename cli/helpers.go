@@ -20,11 +20,11 @@ func nextStep(directory string, info *ProblemInfo, problem *Problem, commit *Com
 
 	// advance to the next step
 	oldStep, newStep := new(ProblemStep), new(ProblemStep)
-	if !getObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step+1), nil, newStep) {
+	if !getObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", commit.AssignmentID, problem.ID, commit.Step+1), nil, newStep) {
 		fmt.Println("you have completed all steps for this problem")
 		return false
 	}
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step), nil, oldStep)
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", commit.AssignmentID, problem.ID, commit.Step), nil, oldStep)
 	fmt.Printf("moving to step %d\n", newStep.Step)
 
 	if _, exists := types[oldStep.ProblemType]; !exists {
@@ -157,7 +157,7 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Ass
 	mustGetObject(fmt.Sprintf("/problems/%d", info.ID), nil, problem)
 
 	step := new(ProblemStep)
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", dotfile.AssignmentID, problem.ID, info.Step), nil, step)
 
 	problemType := new(ProblemType)
 	mustGetObject(fmt.Sprintf("/problem_types/%s", step.ProblemType), nil, problemType)
@@ -301,11 +301,18 @@ func mustConfirmCommitBundle(bundle *CommitBundle, args []string) *CommitBundle
 		switch {
 		case reply.Error != "":
 			log.Printf("server returned an error:")
+			if reply.RetryAfter > 0 {
+				log.Printf("   %s", reply.Error)
+				log.Fatalf("the server is busy; please try again in %d seconds", reply.RetryAfter)
+			}
 			log.Fatalf("   %s", reply.Error)
 
 		case reply.CommitBundle != nil:
 			return reply.CommitBundle
 
+		case reply.Event != nil && reply.Event.Event == "queue":
+			fmt.Printf("waiting for the server: number %d in line\n", reply.Event.QueuePosition)
+
 		case reply.Event != nil:
 			// ignore the streamed data
 
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandHistory(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	_, problem, assignment, commit, _, _ := gatherStudent(now, ".")
+
+	commits := []*Commit{}
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits", assignment.ID, problem.ID, commit.Step), nil, &commits)
+
+	title := fmt.Sprintf("%s step %d", problem.Unique, commit.Step)
+	fmt.Println(title)
+	fmt.Println(dashes(len(title)))
+	if len(commits) == 0 {
+		fmt.Println("no saved commits found for this step")
+		return
+	}
+
+	// find the longest commit ID, action
+	longestID, longestAction := 1, 1
+	for _, elt := range commits {
+		if n := len(strconv.FormatInt(elt.ID, 10)); n > longestID {
+			longestID = n
+		}
+		if n := len(historyAction(elt)); n > longestAction {
+			longestAction = n
+		}
+	}
+	for _, elt := range commits {
+		score := ""
+		if elt.ReportCard != nil {
+			passed := 0
+			for _, result := range elt.ReportCard.Results {
+				if result.Outcome == "passed" {
+					passed++
+				}
+			}
+			score = fmt.Sprintf(" %3.0f%% (%d/%d test%s passed)", elt.Score*100.0, passed, len(elt.ReportCard.Results), plural(len(elt.ReportCard.Results)))
+		}
+		fmt.Printf("id:%-*d %s %-*s%s\n", longestID, elt.ID, elt.UpdatedAt.Format("2006-01-02 15:04:05"), longestAction, historyAction(elt), score)
+	}
+	fmt.Printf("\nuse '%s checkout <id>' to restore your files from one of these commits\n", os.Args[0])
+}
+
+func historyAction(commit *Commit) string {
+	if commit.Action == "" {
+		return "save"
+	}
+	return commit.Action
+}
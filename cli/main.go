@@ -129,6 +129,21 @@ func main() {
 	}
 	cmdGrind.AddCommand(cmdAction)
 
+	cmdTest := &cobra.Command{
+		Use:   "test --local [action name]",
+		Short: "run an action on this computer without saving your work",
+		Long: fmt.Sprintf("Give the name of the action to be performed (grade by default).\n"+
+			"The action runs in a container using docker or podman on this\n"+
+			"computer with the same files and image that the server would use.\n\n"+
+			"   Example: '%s test --local'\n\n"+
+			"Note: results are not saved and do not count for credit;\n"+
+			"use '%s grade' to submit your work.", os.Args[0], os.Args[0]),
+		Run: CommandTest,
+	}
+	cmdTest.Flags().BoolP("local", "l", false, "run the action using a local container runtime")
+	cmdTest.Flags().StringP("runtime", "r", "", "container runtime to use: docker or podman (default: whichever is found first)")
+	cmdGrind.AddCommand(cmdTest)
+
 	cmdReset := &cobra.Command{
 		Use:   "reset [file1] [file2] [...]",
 		Short: "go back to the beginning of the current step for specified files",
@@ -140,6 +155,30 @@ func main() {
 	}
 	cmdGrind.AddCommand(cmdReset)
 
+	cmdHistory := &cobra.Command{
+		Use:   "history",
+		Short: "list your saved commits for the current step",
+		Long: fmt.Sprintf("This lists every save, action, and grade attempt\n"+
+			"for the current step, oldest first, with the score\n"+
+			"for each one that was graded.\n\n"+
+			"Use '%s checkout <id>' to restore the files from one of them.", os.Args[0]),
+		Run: CommandHistory,
+	}
+	cmdGrind.AddCommand(cmdHistory)
+
+	cmdCheckout := &cobra.Command{
+		Use:   "checkout <commit id> [file1] [file2] [...]",
+		Short: "restore your files from an earlier commit of the current step",
+		Long: fmt.Sprintf("Give the numeric ID of a commit (listed by '%s history').\n\n"+
+			"If you provide a list of files, only those files will be restored.\n"+
+			"Otherwise, every file saved in the commit will be restored.\n\n"+
+			"   Example: '%s checkout 1234'\n\n"+
+			"Note: this overwrites your current files; use '%s save' first\n"+
+			"if you want to be able to come back to them.", os.Args[0], os.Args[0], os.Args[0]),
+		Run: CommandCheckout,
+	}
+	cmdGrind.AddCommand(cmdCheckout)
+
 	if isInstructor {
 		cmdCreate := &cobra.Command{
 			Use:   "create [filename]",
@@ -149,10 +188,14 @@ func main() {
 				"To create a problem set, give the name of the .cfg file.\n\n"+
 				"   Example: '%s create cs1400-problem-set.cfg'\n\n"+
 				"Note: a problem set is automatically created with the same unique ID\n"+
-				"when a new problem is created\n", os.Args[0], os.Args[0]),
+				"when a new problem is created\n\n"+
+				"Updating a problem with --update publishes a new revision. Assignments\n"+
+				"already in progress keep the revision they started on unless --migrate\n"+
+				"is also given.\n", os.Args[0], os.Args[0]),
 			Run: CommandCreate,
 		}
 		cmdCreate.Flags().BoolP("update", "u", false, "update an existing problem/problem set")
+		cmdCreate.Flags().Bool("migrate", false, "move assignments already in progress to the updated problem")
 		cmdCreate.Flags().StringP("action", "a", "", "run interactive action for problem step")
 		cmdGrind.AddCommand(cmdCreate)
 
@@ -196,6 +239,98 @@ func main() {
 			Run: CommandExportQuizzes,
 		}
 		cmdGrind.AddCommand(cmdExportQuizzes)
+
+		cmdOutbox := &cobra.Command{
+			Use:   "outbox [--resend <id> ...]",
+			Short: "list grade posts to the LMS that failed or are stuck (administrators only)",
+			Long: fmt.Sprintf("With no arguments, list grades that failed to post to the LMS\n"+
+				"and pending grades that have failed at least once or are overdue.\n\n"+
+				"Use --status to list pending, sent, failed, or stale posts instead.\n\n"+
+				"To send posts again, give their IDs:\n\n"+
+				"   Example: '%s outbox --resend 12 15'\n", os.Args[0]),
+			Run: CommandOutbox,
+		}
+		cmdOutbox.Flags().StringP("status", "s", "", "list posts with this status: pending, sent, failed, stale, or problems")
+		cmdOutbox.Flags().BoolP("resend", "r", false, "send the given posts again")
+		cmdGrind.AddCommand(cmdOutbox)
+
+		cmdExtend := &cobra.Command{
+			Use:   "extend <assignment id> <student> ...",
+			Short: "give students extra time on an assignment (instructors only)",
+			Long: fmt.Sprintf("Give an assignment ID from the course and one or more students.\n"+
+				"A student can be named by user ID, email, Canvas login, or part of their name.\n\n"+
+				"   Example: '%s extend 1234 alice@example.edu bob --due \"2026-10-20 23:59\"'\n\n"+
+				"Use --multiplier for accommodations that scale the time allowed,\n"+
+				"and --course to apply the extension to every assignment in the course:\n\n"+
+				"   Example: '%s extend 1234 carol --multiplier 1.5 --course'\n\n"+
+				"To list extensions in the course, use '%s extend --list 1234'.\n"+
+				"To remove extensions, give their IDs: '%s extend --remove 12 15'.\n",
+				os.Args[0], os.Args[0], os.Args[0], os.Args[0]),
+			Run: CommandExtend,
+		}
+		cmdExtend.Flags().StringP("due", "d", "", "new due date, e.g., \"2026-10-20 23:59\"")
+		cmdExtend.Flags().StringP("lock", "", "", "new lock date, after which no work is accepted")
+		cmdExtend.Flags().Float64P("multiplier", "m", 0.0, "stretch the time allowed by this factor, e.g., 1.5")
+		cmdExtend.Flags().StringP("note", "n", "", "note explaining the extension")
+		cmdExtend.Flags().BoolP("course", "c", false, "apply to every assignment in the course")
+		cmdExtend.Flags().BoolP("list", "l", false, "list extensions in the course")
+		cmdExtend.Flags().BoolP("remove", "r", false, "remove the given extensions")
+		cmdGrind.AddCommand(cmdExtend)
+
+		cmdSimilarity := &cobra.Command{
+			Use:   "similarity <problem> [step]",
+			Short: "find students whose code is suspiciously similar (instructors only)",
+			Long: fmt.Sprintf("Give the problem ID or unique name, and optionally the step (default 1).\n"+
+				"Every student's final submission for the step is compared against the others,\n"+
+				"ignoring the starter code, and the most similar pairs are listed.\n\n"+
+				"   Example: '%s similarity hello-world'\n\n"+
+				"To show the shared regions of a pair side by side, give the report and pair numbers:\n\n"+
+				"   Example: '%s similarity --report 12 --pair 1'\n", os.Args[0], os.Args[0]),
+			Run: CommandSimilarity,
+		}
+		cmdSimilarity.Flags().Int64P("report", "r", 0, "show an existing report")
+		cmdSimilarity.Flags().IntP("pair", "p", 0, "show the matched regions of a pair side by side")
+		cmdGrind.AddCommand(cmdSimilarity)
+
+		cmdGradebook := &cobra.Command{
+			Use:   "gradebook <course>",
+			Short: "export scores for a course (instructors only)",
+			Long: fmt.Sprintf("Give the course ID, label, or name. Every student's overall score\n"+
+				"and raw scores for each problem step are exported as CSV or JSON,\n"+
+				"along with the last commit time, days late, and LMS grade posting status.\n\n"+
+				"   Example: '%s gradebook CS-1400 --output grades.csv'\n\n"+
+				"Use --problem-set to export a single problem set.\n", os.Args[0]),
+			Run: CommandGradebook,
+		}
+		cmdGradebook.Flags().StringP("format", "f", "csv", "output format: csv or json")
+		cmdGradebook.Flags().StringP("problem-set", "p", "", "only export this problem set (ID or unique name)")
+		cmdGradebook.Flags().StringP("output", "o", "", "file to save the gradebook (default is standard output)")
+		cmdGrind.AddCommand(cmdGradebook)
+
+		cmdExport := &cobra.Command{
+			Use:   "export <unique>",
+			Short: "save a problem set or problem as an archive to share (authors only)",
+			Long: fmt.Sprintf("Give the unique ID of a problem set to export it with all of its problems,\n"+
+				"or the unique ID of a problem to export just that problem. The archive holds\n"+
+				"the problem steps, solutions, required problem types, and problem sets.\n\n"+
+				"   Example: '%s export cs1400-lists --output lists.tar.gz'\n", os.Args[0]),
+			Run: CommandExport,
+		}
+		cmdExport.Flags().StringP("output", "o", "", "file to save the archive (default is <unique>.tar.gz)")
+		cmdGrind.AddCommand(cmdExport)
+
+		cmdImport := &cobra.Command{
+			Use:   "import <archive>",
+			Short: "create problems and problem sets from an exported archive (authors only)",
+			Long: fmt.Sprintf("Give an archive saved by '%s export', possibly on another server.\n"+
+				"Every solution is run on the daycare to confirm it before anything is saved.\n\n"+
+				"   Example: '%s import lists.tar.gz'\n\n"+
+				"Unique IDs are kept, so importing fails if any are already in use.\n"+
+				"Use --rename to give those problems and problem sets new unique IDs instead.\n", os.Args[0], os.Args[0]),
+			Run: CommandImport,
+		}
+		cmdImport.Flags().BoolP("rename", "r", false, "give new unique IDs to problems and problem sets that conflict")
+		cmdGrind.AddCommand(cmdImport)
 	}
 
 	cmdGrind.Execute()
@@ -255,6 +390,10 @@ func mustPutObject(path string, params url.Values, upload interface{}, download
 	doRequest(path, params, "PUT", upload, download, false)
 }
 
+func mustDeleteObject(path string, params url.Values) {
+	doRequest(path, params, "DELETE", nil, nil, false)
+}
+
 func doRequest(path string, params url.Values, method string, upload interface{}, download interface{}, notfoundokay bool) bool {
 	if !strings.HasPrefix(path, "/") {
 		log.Panicf("doRequest path must start with /")
@@ -279,13 +418,17 @@ func doRequest(path string, params url.Values, method string, upload interface{}
 
 	// set the headers
 	req.Header.Add("Cookie", Config.Cookie)
-	if download != nil {
+	if _, raw := download.(io.Writer); download != nil && !raw {
 		req.Header.Add("Accept", "application/json")
 		req.Header.Add("Accept-Encoding", "gzip")
 	}
 
 	// upload the payload if any
-	if upload != nil && (method == "POST" || method == "PUT") {
+	if r, raw := upload.(io.Reader); raw && (method == "POST" || method == "PUT") {
+		// send a file as is
+		req.Header.Add("Content-Type", "application/octet-stream")
+		req.Body = ioutil.NopCloser(r)
+	} else if upload != nil && (method == "POST" || method == "PUT") {
 		req.Header.Add("Content-Type", "application/json")
 		req.Header.Add("Content-Encoding", "gzip")
 		payload := new(bytes.Buffer)
@@ -325,6 +468,14 @@ func doRequest(path string, params url.Values, method string, upload interface{}
 		log.Fatalf("giving up")
 	}
 
+	// save a file download as is
+	if w, raw := download.(io.Writer); raw {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Fatalf("error downloading from %s: %v", url, err)
+		}
+		return true
+	}
+
 	// parse the result if any
 	if download != nil {
 		body := resp.Body
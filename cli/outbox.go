@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandOutbox(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	status, err := cmd.Flags().GetString("status")
+	if err != nil {
+		log.Fatalf("getting status flag: %v", err)
+	}
+	resend, err := cmd.Flags().GetBool("resend")
+	if err != nil {
+		log.Fatalf("getting resend flag: %v", err)
+	}
+
+	if resend {
+		if len(args) == 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		for _, arg := range args {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id < 1 {
+				log.Fatalf("grade post ID must be a positive number, not %q", arg)
+			}
+			post := new(GradePost)
+			mustPostObject(fmt.Sprintf("/grade_posts/%d/resend", id), nil, nil, post)
+			fmt.Printf("grade post %d for assignment %d queued to send again\n", post.ID, post.AssignmentID)
+		}
+		return
+	}
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	params := make(url.Values)
+	if status != "" {
+		params.Add("status", status)
+	}
+	posts := []*GradePost{}
+	mustGetObject("/grade_posts", params, &posts)
+	if len(posts) == 0 {
+		fmt.Println("no grade posts found")
+		return
+	}
+
+	now := time.Now()
+	for _, post := range posts {
+		when := ""
+		switch post.Status {
+		case "pending":
+			when = fmt.Sprintf("next try in %v", post.NextAttemptAt.Sub(now).Round(time.Second))
+		case "sent":
+			if post.SentAt != nil {
+				when = fmt.Sprintf("sent %s", post.SentAt.Format("Jan 2 15:04"))
+			}
+		case "failed":
+			when = fmt.Sprintf("gave up %s", post.UpdatedAt.Format("Jan 2 15:04"))
+		}
+		fmt.Printf("id:%-6d assignment:%-8d %-7s attempts:%-2d %s\n", post.ID, post.AssignmentID, post.Status, post.Attempts, when)
+		if post.LastError != "" {
+			fmt.Printf("    %s\n", post.LastError)
+		}
+	}
+	fmt.Printf("\nto send again, use '%s outbox --resend <id> ...'\n", os.Args[0])
+}
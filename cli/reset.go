@@ -25,7 +25,7 @@ func CommandReset(cmd *cobra.Command, args []string) {
 	info := dotfile.Problems[problem.Unique]
 
 	step := new(ProblemStep)
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", assignment.ID, problem.ID, info.Step), nil, step)
 
 	listed := make(map[string]struct{})
 	for _, requested := range args {
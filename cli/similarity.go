@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+const similarityColumnWidth = 38
+
+func CommandSimilarity(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	reportID, err := cmd.Flags().GetInt64("report")
+	if err != nil {
+		log.Fatalf("getting report flag: %v", err)
+	}
+	pairNumber, err := cmd.Flags().GetInt("pair")
+	if err != nil {
+		log.Fatalf("getting pair flag: %v", err)
+	}
+
+	report := new(SimilarityReport)
+	if reportID > 0 {
+		if len(args) != 0 {
+			cmd.Help()
+			os.Exit(1)
+		}
+		mustGetObject(fmt.Sprintf("/similarity_reports/%d", reportID), nil, report)
+	} else {
+		if len(args) < 1 || len(args) > 2 {
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		// find the problem by ID or unique name
+		problem := new(Problem)
+		if id, err := strconv.ParseInt(args[0], 10, 64); err == nil && id > 0 {
+			mustGetObject(fmt.Sprintf("/problems/%d", id), nil, problem)
+		} else {
+			problems := []*Problem{}
+			params := make(url.Values)
+			params.Add("unique", args[0])
+			mustGetObject("/problems", params, &problems)
+			if len(problems) != 1 {
+				log.Fatalf("no problem found with unique ID %q", args[0])
+			}
+			problem = problems[0]
+		}
+		step := int64(1)
+		if len(args) == 2 {
+			if step, err = strconv.ParseInt(args[1], 10, 64); err != nil || step < 1 {
+				log.Fatalf("step must be a positive number, not %q", args[1])
+			}
+		}
+
+		mustPostObject(fmt.Sprintf("/problems/%d/steps/%d/similarity", problem.ID, step), nil, nil, report)
+		fmt.Printf("comparing submissions for %s step %d (report %d)", problem.Unique, step, report.ID)
+		for report.Status == "running" {
+			time.Sleep(2 * time.Second)
+			fmt.Print(".")
+			mustGetObject(fmt.Sprintf("/similarity_reports/%d", report.ID), nil, report)
+		}
+		fmt.Println()
+	}
+
+	if report.Status == "failed" {
+		log.Fatalf("report %d failed: %s", report.ID, report.Error)
+	}
+	if report.Status != "done" {
+		fmt.Printf("report %d is still running, try again later\n", report.ID)
+		return
+	}
+
+	if pairNumber > 0 {
+		if pairNumber > len(report.Pairs) {
+			log.Fatalf("report %d only has %d pairs", report.ID, len(report.Pairs))
+		}
+		printSimilarityPair(report.Pairs[pairNumber-1])
+		return
+	}
+
+	fmt.Printf("compared %d submissions, found %d similar pairs\n", report.Submissions, len(report.Pairs))
+	for i, pair := range report.Pairs {
+		fmt.Printf("%3d. %3.0f%%  %s (%d, %.0f%%)  %s (%d, %.0f%%)  %d regions\n", i+1, pair.Score*100,
+			pair.A.UserName, pair.A.AssignmentID, pair.A.Shared*100,
+			pair.B.UserName, pair.B.AssignmentID, pair.B.Shared*100,
+			len(pair.Matches))
+	}
+	if len(report.Pairs) > 0 {
+		fmt.Printf("\nto compare a pair side by side, use '%s similarity --report %d --pair <n>'\n", os.Args[0], report.ID)
+	}
+}
+
+func printSimilarityPair(pair *SimilarityPair) {
+	fmt.Printf("%s (assignment %d) shares %.0f%% with %s (assignment %d) sharing %.0f%%\n",
+		pair.A.UserName, pair.A.AssignmentID, pair.A.Shared*100,
+		pair.B.UserName, pair.B.AssignmentID, pair.B.Shared*100)
+	for _, m := range pair.Matches {
+		fmt.Println()
+		fmt.Printf("%-*s | %s\n",
+			similarityColumnWidth, fitColumn(fmt.Sprintf("%s:%d-%d", m.FileA, m.StartLineA, m.EndLineA)),
+			fmt.Sprintf("%s:%d-%d", m.FileB, m.StartLineB, m.EndLineB))
+		fmt.Printf("%s-+-%s\n", strings.Repeat("-", similarityColumnWidth), strings.Repeat("-", similarityColumnWidth))
+		left, right := strings.Split(m.TextA, "\n"), strings.Split(m.TextB, "\n")
+		for i := 0; i < len(left) || i < len(right); i++ {
+			l, r := "", ""
+			if i < len(left) {
+				l = left[i]
+			}
+			if i < len(right) {
+				r = right[i]
+			}
+			fmt.Printf("%-*s | %s\n", similarityColumnWidth, fitColumn(l), fitColumn(r))
+		}
+	}
+}
+
+func fitColumn(s string) string {
+	s = strings.Replace(s, "\t", "    ", -1)
+	if len(s) > similarityColumnWidth {
+		s = s[:similarityColumnWidth-1] + "…"
+	}
+	return s
+}
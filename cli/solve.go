@@ -29,7 +29,7 @@ func CommandSolve(cmd *cobra.Command, args []string) {
 
 	_, problem, _, commit, _, problemDir := gatherStudent(now, ".")
 	step := new(ProblemStep)
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step), nil, step)
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", commit.AssignmentID, problem.ID, commit.Step), nil, step)
 
 	if step.Solution == nil || len(step.Solution) == 0 {
 		log.Fatalf("no solution files found")
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// localUID is the user that runs actions in a local container,
+// chosen from the same range the daycare uses
+const localUID = 10000
+
+func CommandTest(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	local, err := cmd.Flags().GetBool("local")
+	if err != nil {
+		log.Fatalf("error getting local flag: %v", err)
+	}
+	runtimeName, err := cmd.Flags().GetString("runtime")
+	if err != nil {
+		log.Fatalf("error getting runtime flag: %v", err)
+	}
+	if len(args) > 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	if !local {
+		log.Printf("'%s test' only runs tests on this computer, so --local is required", os.Args[0])
+		log.Fatalf("  to run tests on the server, use '%s grade' or '%s action'", os.Args[0], os.Args[0])
+	}
+	actionName := "grade"
+	if len(args) == 1 {
+		actionName = args[0]
+	}
+
+	problemType, problem, _, commit, _, _ := gatherStudent(now, ".")
+	commit.Action = actionName
+
+	// if the requested action does not exist, report available choices
+	action, exists := problemType.Actions[actionName]
+	if !exists {
+		fmt.Printf("available actions for problem type %s:\n", problemType.Name)
+		for elt := range problemType.Actions {
+			fmt.Printf("   %s\n", elt)
+		}
+		log.Fatalf("use '%s test --local [action]' to run an action", os.Args[0])
+	}
+	var parser ReportCardParser
+	if action.Parser != "" {
+		if parser, exists = ReportCardParsers[action.Parser]; !exists {
+			log.Fatalf("unknown parser %q for problem type %s action %s; you may need to upgrade %s",
+				action.Parser, problemType.Name, actionName, os.Args[0])
+		}
+	}
+
+	// collect the files from the problem step, commit, and problem type
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d", commit.AssignmentID, problem.ID, commit.Step), nil, step)
+	files := make(map[string][]byte)
+	for name, contents := range step.Files {
+		files[name] = contents
+	}
+	for name, contents := range commit.Files {
+		files[name] = contents
+	}
+	for name, contents := range problemType.Files {
+		files[name] = contents
+	}
+
+	// start a container
+	rt := mustFindContainerRuntime(runtimeName)
+	fmt.Printf("running %s on %s step %d using %s (image %s)\n", actionName, problem.Unique, commit.Step, rt.command, problemType.Image)
+	rt.mustStart(problemType.Image, action, problem.Options)
+
+	if err := rt.putFiles(files); err != nil {
+		rt.remove()
+		log.Fatalf("error copying files to the container: %v", err)
+	}
+
+	// run the action
+	cmdline := strings.Fields(action.Command)
+	commit.ReportCard = NewReportCard()
+	start := time.Now()
+	if action.Interactive && parser == nil {
+		status, err := rt.exec(cmdline, os.Stdin, os.Stdout, os.Stderr, true)
+		if err != nil {
+			rt.remove()
+			log.Fatalf("%q exec error: %v", strings.Join(cmdline, " "), err)
+		}
+		if status != 0 {
+			commit.ReportCard.Failf("%q failed with exit status %d", strings.Join(cmdline, " "), status)
+		}
+	} else {
+		commit.Transcript = append(commit.Transcript, &EventMessage{Time: time.Now(), Event: "exec", ExecCommand: cmdline})
+		var mutex sync.Mutex
+		stdout := &transcriptWriter{event: "stdout", commit: commit, mutex: &mutex}
+		stderr := &transcriptWriter{event: "stderr", commit: commit, mutex: &mutex}
+		status, err := rt.exec(cmdline, nil, stdout, stderr, false)
+		if err != nil {
+			rt.remove()
+			log.Fatalf("%q exec error: %v", strings.Join(cmdline, " "), err)
+		}
+		commit.Transcript = append(commit.Transcript, &EventMessage{Time: time.Now(), Event: "exit", ExitStatus: status})
+
+		switch {
+		case status > 127 && parser != nil:
+			commit.ReportCard.Failf("Crashed with exit status %d while running unit tests", status)
+		case parser != nil:
+			commit.ReportCard.Passed = status == 0
+			contents, err := rt.getFile(parser.ResultsFile())
+			if err != nil {
+				commit.ReportCard.Failf("Error getting unit test results")
+			} else {
+				parser.Parse(commit.ReportCard, start, contents)
+				commit.ReportCard.ParsePointsAnnotations()
+			}
+		case status != 0:
+			commit.ReportCard.Failf("%q failed with exit status %d", strings.Join(cmdline, " "), status)
+		}
+	}
+	commit.ReportCard.AddTime(time.Since(start))
+	rt.remove()
+	commit.Score = commit.ReportCard.ComputeScore()
+
+	// report the results, which are never saved
+	if err := commit.DumpTranscript(os.Stdout); err != nil {
+		log.Fatalf("failed to dump transcript: %v", err)
+	}
+	if parser != nil {
+		fmt.Printf("  ReportCard: %s\n", commit.ReportCard.Note)
+		for _, result := range commit.ReportCard.Results {
+			points := ""
+			if result.PointsPossible > 0.0 {
+				points = fmt.Sprintf(" (%g/%g points)", result.EarnedPoints(), result.PossiblePoints())
+			}
+			fmt.Printf("    %-7s %s%s\n", result.Outcome, result.Name, points)
+		}
+		fmt.Printf("  score for step %d: %.0f%%\n", commit.Step, commit.Score*100.0)
+	} else if !commit.ReportCard.Passed {
+		fmt.Printf("  %s\n", commit.ReportCard.Note)
+	}
+	fmt.Printf("note: this was a local test run; use '%s grade' to submit your work for credit\n", os.Args[0])
+}
+
+// transcriptWriter records output from a local container
+// as transcript events, merging consecutive writes to the same stream
+type transcriptWriter struct {
+	event  string
+	commit *Commit
+	mutex  *sync.Mutex
+}
+
+func (w *transcriptWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	transcript := w.commit.Transcript
+	if len(transcript) > 0 && transcript[len(transcript)-1].Event == w.event {
+		prev := transcript[len(transcript)-1]
+		prev.StreamData = append(prev.StreamData, data...)
+		prev.Time = time.Now()
+	} else {
+		w.commit.Transcript = append(transcript, &EventMessage{
+			Time:       time.Now(),
+			Event:      w.event,
+			StreamData: append([]byte{}, data...),
+		})
+	}
+	return len(data), nil
+}
+
+// containerRuntime runs commands in a local container using the
+// docker or podman command-line tool
+type containerRuntime struct {
+	command   string
+	container string
+}
+
+func mustFindContainerRuntime(name string) *containerRuntime {
+	candidates := []string{"docker", "podman"}
+	if name != "" {
+		candidates = []string{name}
+	}
+	for _, elt := range candidates {
+		if path, err := exec.LookPath(elt); err == nil {
+			return &containerRuntime{command: path}
+		}
+	}
+	log.Fatalf("unable to find a container runtime; install %s and make sure it is in your PATH", strings.Join(candidates, " or "))
+	return nil
+}
+
+func (rt *containerRuntime) mustStart(image string, action *ProblemTypeAction, options []string) {
+	// apply the same limits the daycare would use
+	limits := map[string]int64{
+		"maxCPU":      action.MaxCPU,
+		"maxSession":  action.MaxSession,
+		"maxTimeout":  action.MaxTimeout,
+		"maxFD":       action.MaxFD,
+		"maxFileSize": action.MaxFileSize,
+		"maxMemory":   action.MaxMemory,
+		"maxThreads":  action.MaxThreads,
+	}
+	for _, elt := range options {
+		parts := strings.Split(elt, "=")
+		if len(parts) != 2 {
+			continue
+		}
+		val, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 63)
+		if err != nil {
+			continue
+		}
+		if _, exists := limits[strings.TrimSpace(parts[0])]; exists {
+			limits[strings.TrimSpace(parts[0])] = val
+		}
+	}
+	mem := limits["maxMemory"] * 1024 * 1024
+	disk := limits["maxFileSize"] * 1024 * 1024
+	timeLimit := limits["maxCPU"] * 2
+	if action.Interactive {
+		timeLimit = limits["maxSession"]
+	}
+
+	args := []string{
+		"run", "--detach", "--rm",
+		"--name", fmt.Sprintf("grind-%d-%d", os.Getpid(), time.Now().Unix()),
+		"--hostname", "grind",
+		"--user", fmt.Sprintf("%d:%d", localUID, localUID),
+		"--network", "none",
+		"--read-only",
+		"--memory", strconv.FormatInt(mem, 10),
+		"--pids-limit", strconv.FormatInt(limits["maxThreads"], 10),
+		"--ulimit", "core=0:0",
+		"--ulimit", fmt.Sprintf("cpu=%d:%d", limits["maxCPU"], limits["maxCPU"]),
+		"--ulimit", fmt.Sprintf("fsize=%d:%d", disk, disk),
+		"--ulimit", fmt.Sprintf("nofile=%d:%d", limits["maxFD"], limits["maxFD"]),
+		"--tmpfs", fmt.Sprintf("/home/student:rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", disk/1024, localUID, localUID),
+		"--tmpfs", fmt.Sprintf("/tmp:rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", disk/1024, localUID, localUID),
+		"--workdir", "/home/student",
+		"--env", "USER=student",
+		"--env", "HOME=/home/student",
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		args = append(args, "--env", "TERM="+term)
+	}
+	args = append(args, image, "/bin/sleep", strconv.FormatInt(timeLimit, 10)+"s")
+
+	cmd := exec.Command(rt.command, args...)
+	stdout := new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("error starting container: %v", err)
+	}
+	rt.container = strings.TrimSpace(stdout.String())
+}
+
+func (rt *containerRuntime) remove() {
+	if rt.container == "" {
+		return
+	}
+	if out, err := exec.Command(rt.command, "rm", "--force", rt.container).CombinedOutput(); err != nil {
+		log.Printf("%s", out)
+		log.Printf("error removing container %s: %v", rt.container, err)
+	}
+	rt.container = ""
+}
+
+func (rt *containerRuntime) exec(cmdline []string, stdin io.Reader, stdout, stderr io.Writer, useTTY bool) (int, error) {
+	args := []string{"exec", "--workdir", "/home/student"}
+	if stdin != nil {
+		args = append(args, "--interactive")
+	}
+	if useTTY {
+		args = append(args, "--tty")
+	}
+	args = append(args, rt.container)
+	args = append(args, cmdline...)
+	cmd := exec.Command(rt.command, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// putFiles copies files into the student directory of the container,
+// relying on tar being available in the image
+func (rt *containerRuntime) putFiles(files map[string][]byte) error {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	nowish := time.Now().Add(-time.Second)
+	dirs := make(map[string]bool)
+	for _, name := range names {
+		for dir := path.Dir(name); dir != "." && dir != "/" && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	var dirList []string
+	for dir := range dirs {
+		dirList = append(dirList, dir)
+	}
+	sort.Strings(dirList)
+	for _, dir := range dirList {
+		header := &tar.Header{Name: dir + "/", Mode: 0777, Typeflag: tar.TypeDir, ModTime: nowish}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		contents := files[name]
+		header := &tar.Header{Name: name, Mode: 0666, Size: int64(len(contents)), ModTime: nowish}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(contents); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	stderr := new(bytes.Buffer)
+	status, err := rt.exec([]string{"tar", "xf", "-", "-C", "/home/student"}, buf, ioutil.Discard, stderr, false)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("tar failed with exit status %d: %s", status, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (rt *containerRuntime) getFile(name string) ([]byte, error) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	status, err := rt.exec([]string{"cat", "/home/student/" + name}, nil, stdout, stderr, false)
+	if err != nil {
+		return nil, err
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("cat failed with exit status %d: %s", status, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
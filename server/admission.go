@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// errQueueFull is returned when a request cannot even wait for a container
+var errQueueFull = errors.New("all containers are busy and the wait queue is full")
+
+// errDraining is returned when the daycare is shutting down and will not start any more containers
+var errDraining = errors.New("daycare is shutting down")
+
+// admissionQueue limits the number of containers running at once on a daycare.
+// Requests beyond the limit wait in FIFO order, up to a maximum queue length.
+// The queue is kept in memory only: each waiting request is an open websocket,
+// so it cannot outlive the daycare process anyway. When the daycare shuts down,
+// drain refuses everything still waiting and those clients are told when to
+// try again; if the process dies without a clean shutdown, their connections
+// are simply dropped.
+//
+// Idle containers in the warm pool also take up slots, but only ones that
+// no request needs: a request admitted while they fill the remaining slots
+// either takes one of them over or has one destroyed to make room.
+type admissionQueue struct {
+	sync.Mutex
+	slots      int
+	maxWaiting int
+	running    int
+	warm       int
+	waiting    []*admissionTicket
+	draining   bool
+
+	// moving average of how long each ticket holds a slot
+	averageDuration time.Duration
+
+	// moving average of how long requests take from entering the queue
+	// until their report cards are ready
+	averageLatency time.Duration
+}
+
+// admissionTicket represents one request that is running or waiting to run.
+type admissionTicket struct {
+	// ready is closed when the ticket is granted a slot
+	ready chan struct{}
+
+	// position receives the latest 1-based position in the queue;
+	// only the most recent value is kept
+	position chan int
+
+	// refused is closed if the daycare starts shutting down before the ticket is granted
+	refused chan struct{}
+
+	granted time.Time
+}
+
+// admission is the queue for the daycare role
+var admission *admissionQueue
+
+func newAdmissionQueue(slots, maxWaiting int) *admissionQueue {
+	return &admissionQueue{
+		slots:           slots,
+		maxWaiting:      maxWaiting,
+		averageDuration: 10 * time.Second,
+	}
+}
+
+// enter adds a request to the queue, granting it a slot immediately if one is free.
+// It returns errQueueFull if the request would have to wait and the queue is full.
+func (q *admissionQueue) enter() (*admissionTicket, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	ticket := &admissionTicket{
+		ready:    make(chan struct{}),
+		position: make(chan int, 1),
+		refused:  make(chan struct{}),
+	}
+	if q.draining {
+		return nil, errDraining
+	}
+	if q.running < q.slots && len(q.waiting) == 0 {
+		q.grant(ticket)
+		return ticket, nil
+	}
+	if len(q.waiting) >= q.maxWaiting {
+		return nil, errQueueFull
+	}
+	q.waiting = append(q.waiting, ticket)
+	ticket.setPosition(len(q.waiting))
+	return ticket, nil
+}
+
+// leave releases a ticket, whether it is running or still waiting.
+// It must be called exactly once for every ticket returned by enter.
+func (q *admissionQueue) leave(ticket *admissionTicket) {
+	q.Lock()
+	defer q.Unlock()
+
+	if ticket.granted.IsZero() {
+		// give up a place in line
+		for i, elt := range q.waiting {
+			if elt == ticket {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	} else {
+		// give up a slot
+		q.running--
+		duration := time.Since(ticket.granted)
+		q.averageDuration = (q.averageDuration*9 + duration) / 10
+	}
+
+	// fill free slots from the front of the line
+	for q.running < q.slots && len(q.waiting) > 0 {
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.grant(next)
+	}
+	for i, elt := range q.waiting {
+		elt.setPosition(i + 1)
+	}
+}
+
+// grant gives a slot to a ticket. The caller must hold the lock.
+func (q *admissionQueue) grant(ticket *admissionTicket) {
+	q.running++
+	ticket.granted = time.Now()
+	close(ticket.ready)
+}
+
+// reserveWarm claims a slot for a new warm container.
+// It returns false if every slot is in use or the daycare is shutting down.
+func (q *admissionQueue) reserveWarm() bool {
+	q.Lock()
+	defer q.Unlock()
+	if q.draining || q.running+q.warm >= q.slots {
+		return false
+	}
+	q.warm++
+	return true
+}
+
+// releaseWarm gives back a slot claimed by reserveWarm, either because the
+// warm container is gone or because it was handed to a running request.
+func (q *admissionQueue) releaseWarm() {
+	q.Lock()
+	defer q.Unlock()
+	q.warm--
+}
+
+// overcommitted reports whether running requests and warm containers
+// together hold more than the available slots.
+func (q *admissionQueue) overcommitted() bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.running+q.warm > q.slots
+}
+
+// full reports whether a new request would be rejected.
+func (q *admissionQueue) full() bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.draining || q.running >= q.slots && len(q.waiting) >= q.maxWaiting
+}
+
+// drain stops the queue from granting any more slots. New requests are
+// rejected and requests still waiting in line are refused, but requests
+// that already have a slot keep it until they leave.
+func (q *admissionQueue) drain() {
+	q.Lock()
+	defer q.Unlock()
+	q.draining = true
+	for _, ticket := range q.waiting {
+		close(ticket.refused)
+	}
+	q.waiting = nil
+}
+
+// isDraining reports whether drain has been called.
+func (q *admissionQueue) isDraining() bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.draining
+}
+
+// idle waits until no request holds a slot, checking every interval.
+// It returns false if done is closed first.
+func (q *admissionQueue) idle(interval time.Duration, done <-chan struct{}) bool {
+	for {
+		if _, running, _, _ := q.stats(); running == 0 {
+			return true
+		}
+		select {
+		case <-done:
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
+
+// retryAfter estimates how long a rejected request should wait before trying again.
+func (q *admissionQueue) retryAfter() time.Duration {
+	q.Lock()
+	defer q.Unlock()
+	rounds := math.Ceil(float64(len(q.waiting)+1) / float64(q.slots))
+	wait := time.Duration(rounds * float64(q.averageDuration)).Round(time.Second)
+	if wait < 5*time.Second {
+		wait = 5 * time.Second
+	}
+	return wait
+}
+
+// recordLatency notes how long a request took from entering the queue until its report card was ready.
+func (q *admissionQueue) recordLatency(latency time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+	q.averageLatency = (q.averageLatency*9 + latency) / 10
+}
+
+// stats reports the container limit, the number of running and waiting
+// requests, and the recent average latency from admission to report card.
+func (q *admissionQueue) stats() (slots, running, waiting int, latency time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+	return q.slots, q.running, len(q.waiting), q.averageLatency
+}
+
+func (ticket *admissionTicket) setPosition(position int) {
+	// discard any position that has not been reported yet
+	select {
+	case <-ticket.position:
+	default:
+	}
+	ticket.position <- position
+}
+
+// wait blocks until the ticket is granted a slot, calling report
+// each time its position in the queue changes. If report returns
+// an error, wait gives up and returns that error. If the daycare
+// starts shutting down first, wait returns errDraining.
+func (ticket *admissionTicket) wait(report func(position int) error) error {
+	for {
+		select {
+		case <-ticket.ready:
+			return nil
+		case <-ticket.refused:
+			return errDraining
+		case position := <-ticket.position:
+			if err := report(position); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueue(t *testing.T) {
+	q := newAdmissionQueue(1, 2)
+
+	// the first request runs immediately
+	first, err := q.enter()
+	if err != nil {
+		t.Fatalf("first enter: %v", err)
+	}
+	if err := first.wait(func(int) error { return nil }); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+
+	// the next two wait in line and the one after that is turned away
+	second, err := q.enter()
+	if err != nil {
+		t.Fatalf("second enter: %v", err)
+	}
+	third, err := q.enter()
+	if err != nil {
+		t.Fatalf("third enter: %v", err)
+	}
+	if _, err := q.enter(); err != errQueueFull {
+		t.Fatalf("fourth enter: expected errQueueFull, got %v", err)
+	}
+	if !q.full() {
+		t.Errorf("queue should report that it is full")
+	}
+	if retry := q.retryAfter(); retry < 5*time.Second {
+		t.Errorf("retryAfter gave %v, expected at least 5s", retry)
+	}
+
+	// the third request moves up when the second gives up
+	q.leave(second)
+	var positions []int
+	done := make(chan error)
+	go func() {
+		done <- third.wait(func(position int) error {
+			positions = append(positions, position)
+			if len(positions) == 1 {
+				q.leave(first)
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("third wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("third request never got a slot")
+	}
+	if len(positions) == 0 || positions[0] != 1 {
+		t.Errorf("expected the third request to reach position 1, got positions %v", positions)
+	}
+	if _, running, waiting, _ := q.stats(); running != 1 || waiting != 0 {
+		t.Errorf("expected 1 running and 0 waiting, got %d and %d", running, waiting)
+	}
+	q.leave(third)
+}
+
+func TestAdmissionQueueDrain(t *testing.T) {
+	q := newAdmissionQueue(1, 2)
+	running, err := q.enter()
+	if err != nil {
+		t.Fatalf("first enter: %v", err)
+	}
+	waiting, err := q.enter()
+	if err != nil {
+		t.Fatalf("second enter: %v", err)
+	}
+
+	// requests in line are refused, but the running one keeps its slot
+	q.drain()
+	if err := waiting.wait(func(int) error { return nil }); err != errDraining {
+		t.Errorf("waiting request: expected errDraining, got %v", err)
+	}
+	q.leave(waiting)
+	if _, err := q.enter(); err != errDraining {
+		t.Errorf("new request: expected errDraining, got %v", err)
+	}
+	if !q.full() || !q.isDraining() {
+		t.Errorf("a draining queue should report that it is full and draining")
+	}
+	done := make(chan struct{})
+	close(done)
+	if q.idle(time.Millisecond, done) {
+		t.Errorf("queue reported idle with a request running")
+	}
+
+	q.leave(running)
+	if !q.idle(time.Millisecond, nil) {
+		t.Errorf("queue should be idle once the running request leaves")
+	}
+	if _, running, waiting, _ := q.stats(); running != 0 || waiting != 0 {
+		t.Errorf("expected nothing running or waiting, got %d and %d", running, waiting)
+	}
+}
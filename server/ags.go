@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+	"golang.org/x/sync/singleflight"
+)
+
+// LTI Advantage Assignment and Grade Services: we post scores to a line item
+// (a gradebook column) using an OAuth2 token requested from the platform.
+
+const (
+	agsScopeLineItem = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"
+	agsScopeScore    = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+
+	agsLineItemType          = "application/vnd.ims.lis.v2.lineitem+json"
+	agsLineItemContainerType = "application/vnd.ims.lis.v2.lineitemcontainer+json"
+	agsScoreType             = "application/vnd.ims.lis.v1.score+json"
+
+	// scores are posted as a fraction of this many points,
+	// and new line items are created with the same maximum
+	agsScoreMaximum = 100.0
+
+	// agsTimeout bounds each request to the platform, including reading the response
+	agsTimeout = 30 * time.Second
+)
+
+// agsClient sends every grade services request, including token requests.
+var agsClient = &http.Client{Timeout: agsTimeout}
+
+// AGSLineItem is a gradebook column in the LMS.
+type AGSLineItem struct {
+	ID             string  `json:"id,omitempty"`
+	ScoreMaximum   float64 `json:"scoreMaximum"`
+	Label          string  `json:"label"`
+	ResourceLinkID string  `json:"resourceLinkId,omitempty"`
+	Tag            string  `json:"tag,omitempty"`
+}
+
+// AGSScore is a single grade to post to a line item.
+type AGSScore struct {
+	UserID           string  `json:"userId"`
+	ScoreGiven       float64 `json:"scoreGiven"`
+	ScoreMaximum     float64 `json:"scoreMaximum"`
+	Comment          string  `json:"comment,omitempty"`
+	Timestamp        string  `json:"timestamp"`
+	ActivityProgress string  `json:"activityProgress"`
+	GradingProgress  string  `json:"gradingProgress"`
+}
+
+// agsTokens caches access tokens by platform and scope.
+// The lock only guards the cache: it is not held while a token is requested,
+// and concurrent requests for the same key share a single fetch.
+type agsTokens struct {
+	sync.Mutex
+	tokens map[string]*agsToken
+	fetch  singleflight.Group
+}
+
+type agsToken struct {
+	token   string
+	expires time.Time
+}
+
+var agsAccessTokens = &agsTokens{tokens: make(map[string]*agsToken)}
+
+// Get returns an access token for the given scopes, requesting a new one
+// from the platform if necessary. The request is authenticated with a JWT
+// signed by the tool key.
+func (a *agsTokens) Get(ctx context.Context, platform *LTIPlatform, scopes ...string) (string, error) {
+	scope := strings.Join(scopes, " ")
+	key := fmt.Sprintf("%d %s", platform.ID, scope)
+
+	a.Lock()
+	elt, exists := a.tokens[key]
+	a.Unlock()
+	if exists && time.Now().Before(elt.expires) {
+		return elt.token, nil
+	}
+
+	fetch := a.fetch.DoChan(key, func() (interface{}, error) {
+		elt, err := requestAGSToken(platform, scope)
+		if err != nil {
+			return "", err
+		}
+		a.Lock()
+		a.tokens[key] = elt
+		a.Unlock()
+		return elt.token, nil
+	})
+	return waitShared(ctx, fetch)
+}
+
+// waitShared waits for a fetch shared with other callers. The fetch is not
+// tied to any one caller's context, so a caller that gives up leaves it
+// running (bounded by agsTimeout) for the others.
+func waitShared(ctx context.Context, fetch <-chan singleflight.Result) (string, error) {
+	select {
+	case res := <-fetch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// requestAGSToken requests a new access token from the platform.
+func requestAGSToken(platform *LTIPlatform, scope string) (*agsToken, error) {
+	if ltiKey == nil {
+		return nil, fmt.Errorf("no LTI 1.3 key is configured")
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(ltiKey, ltiKeyID, map[string]interface{}{
+		"iss": platform.ClientID,
+		"sub": platform.ClientID,
+		"aud": platform.AuthTokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"jti": randomToken(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	}
+	resp, err := agsClient.PostForm(platform.AuthTokenURL, form)
+	if err != nil {
+		return nil, fmt.Errorf("requesting access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requesting access token from %s: %s", platform.AuthTokenURL, resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decoding access token: %v", err)
+	}
+	if token.AccessToken == "" || !strings.EqualFold(token.TokenType, "bearer") {
+		return nil, fmt.Errorf("platform returned an unusable access token of type %q", token.TokenType)
+	}
+
+	// stop using the token a little before it expires
+	lifetime := time.Duration(token.ExpiresIn)*time.Second - time.Minute
+	if lifetime < 0 {
+		lifetime = 0
+	}
+	return &agsToken{token: token.AccessToken, expires: now.Add(lifetime)}, nil
+}
+
+// agsRequest sends one grade services request. The request body, if any,
+// is sent as JSON, and a JSON response is decoded into out if it is not nil.
+func agsRequest(ctx context.Context, platform *LTIPlatform, method, target, contentType, accept string, body, out interface{}, scopes ...string) error {
+	token, err := agsAccessTokens.Get(ctx, platform, scopes...)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := agsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, target, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response from %s: %v", target, err)
+		}
+	}
+	return nil
+}
+
+// agsLineItems remembers line items that we found or created, keyed by
+// line item container URL and tag, so every student in a course posts to
+// the same line item without looking it up each time. As with agsTokens,
+// the lock is not held during lookups, which are shared per key.
+type agsLineItems struct {
+	sync.Mutex
+	items  map[string]string
+	lookup singleflight.Group
+}
+
+var lineItems = &agsLineItems{items: make(map[string]string)}
+
+// Get finds the line item for an assignment. If the platform did not give
+// one in the launch, it looks for one we created earlier and creates it if
+// it is not found.
+func (l *agsLineItems) Get(ctx context.Context, platform *LTIPlatform, asst *Assignment) (string, error) {
+	if asst.LineItemURL != "" {
+		return asst.LineItemURL, nil
+	}
+	if asst.LineItemsURL == "" {
+		return "", fmt.Errorf("the platform did not provide a line item for assignment %d, and does not allow us to create one", asst.ID)
+	}
+
+	// the tag is the resource link ID, which is unique per course+link
+	tag := asst.LtiID
+	key := asst.LineItemsURL + " " + tag
+
+	l.Lock()
+	itemURL, exists := l.items[key]
+	l.Unlock()
+	if exists {
+		return itemURL, nil
+	}
+
+	// only one lookup per key at a time, so concurrent posts for a new
+	// assignment do not each create a line item
+	lookup := l.lookup.DoChan(key, func() (interface{}, error) {
+		l.Lock()
+		itemURL, exists := l.items[key]
+		l.Unlock()
+		if exists {
+			return itemURL, nil
+		}
+		itemURL, err := findOrCreateLineItem(platform, asst, tag)
+		if err != nil {
+			return "", err
+		}
+		l.Lock()
+		l.items[key] = itemURL
+		l.Unlock()
+		return itemURL, nil
+	})
+	return waitShared(ctx, lookup)
+}
+
+// findOrCreateLineItem searches the platform for the line item with the
+// given tag, creating it if it does not exist. It is shared by every
+// caller waiting for the same line item, so it does not use their contexts.
+func findOrCreateLineItem(platform *LTIPlatform, asst *Assignment, tag string) (string, error) {
+	ctx := context.Background()
+
+	// search for an existing line item
+	search, err := url.Parse(asst.LineItemsURL)
+	if err != nil {
+		return "", fmt.Errorf("bad line item container URL %q: %v", asst.LineItemsURL, err)
+	}
+	query := search.Query()
+	query.Set("tag", tag)
+	search.RawQuery = query.Encode()
+	var found []*AGSLineItem
+	if err := agsRequest(ctx, platform, "GET", search.String(), "", agsLineItemContainerType, nil, &found, agsScopeLineItem); err != nil {
+		return "", err
+	}
+	for _, item := range found {
+		if item.Tag == tag && item.ID != "" {
+			return item.ID, nil
+		}
+	}
+
+	// create a new one
+	label := asst.CanvasTitle
+	if label == "" {
+		label = "CodeGrinder"
+	}
+	item := &AGSLineItem{ScoreMaximum: agsScoreMaximum, Label: label, Tag: tag}
+	created := new(AGSLineItem)
+	if err := agsRequest(ctx, platform, "POST", asst.LineItemsURL, agsLineItemType, agsLineItemType, item, created, agsScopeLineItem); err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", fmt.Errorf("platform did not return an ID for the new line item")
+	}
+	log.Printf("created line item %s (%q) for assignment %d", created.ID, label, asst.ID)
+	return created.ID, nil
+}
+
+// saveScore posts an assignment grade to the LMS using grade services.
+func saveScore(ctx context.Context, asst *Assignment, platform *LTIPlatform, text string) error {
+	if asst.Instructor {
+		// instructors do not get grades
+		return nil
+	}
+	if platform == nil {
+		return loggedErrorf("cannot post grade for assignment %d user %d because LTI platform %d was not found", asst.ID, asst.UserID, asst.LTIPlatformID)
+	}
+	if asst.LTIUserID == "" {
+		log.Printf("cannot post grade for assignment %d user %d because the platform did not grant grade services", asst.ID, asst.UserID)
+		return nil
+	}
+
+	itemURL, err := lineItems.Get(ctx, platform, asst)
+	if err != nil {
+		return err
+	}
+
+	// scores go to the line item URL plus /scores, keeping any query
+	target, err := url.Parse(itemURL)
+	if err != nil {
+		return fmt.Errorf("bad line item URL %q: %v", itemURL, err)
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/scores"
+
+	activity := "Submitted"
+	if asst.Score >= 1.0 {
+		activity = "Completed"
+	}
+	score := &AGSScore{
+		UserID:           asst.LTIUserID,
+		ScoreGiven:       asst.Score * agsScoreMaximum,
+		ScoreMaximum:     agsScoreMaximum,
+		Comment:          text,
+		Timestamp:        time.Now().Format(time.RFC3339Nano),
+		ActivityProgress: activity,
+		GradingProgress:  "FullyGraded",
+	}
+	if err := agsRequest(ctx, platform, "POST", target.String(), agsScoreType, "", score, nil, agsScopeScore); err != nil {
+		return err
+	}
+	log.Printf("assignment %q grade of %0.5f posted for user %d using grade services", asst.CanvasTitle, asst.Score, asst.UserID)
+	return nil
+}
+
+// getLTIPlatforms loads all registered LTI 1.3 platforms, keyed by ID.
+func getLTIPlatforms(tx *sql.Tx) (map[int64]*LTIPlatform, error) {
+	platforms := []*LTIPlatform{}
+	if err := meddler.QueryAll(tx, &platforms, `SELECT * FROM lti_platforms`); err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]*LTIPlatform)
+	for _, elt := range platforms {
+		byID[elt.ID] = elt
+	}
+	return byID, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// fakeGradebook implements the platform side of grade services.
+type fakeGradebook struct {
+	sync.Mutex
+	server    *httptest.Server
+	tokens    int
+	lineItems []*AGSLineItem
+	scores    map[string][]*AGSScore
+}
+
+func newFakeGradebook(t *testing.T) *fakeGradebook {
+	g := &fakeGradebook{scores: make(map[string][]*AGSScore)}
+	g.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Lock()
+		defer g.Unlock()
+
+		// token requests are authenticated with a JWT signed by the tool
+		if r.URL.Path == "/token" {
+			claims := make(map[string]interface{})
+			getKey := func(kid string) (*rsa.PublicKey, error) {
+				if kid != ltiKeyID {
+					return nil, fmt.Errorf("unknown key %q", kid)
+				}
+				return &ltiKey.PublicKey, nil
+			}
+			if err := verifyJWT(r.FormValue("client_assertion"), getKey, &claims); err != nil {
+				t.Errorf("token request: %v", err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if claims["sub"] != "10000000000042" || claims["aud"] != g.server.URL+"/token" {
+				t.Errorf("token request: bad claims %v", claims)
+			}
+			g.tokens++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": fmt.Sprintf("token-%d", g.tokens),
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+				"scope":        r.FormValue("scope"),
+			})
+			return
+		}
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			http.Error(w, "missing access token", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/lineitems":
+			var found []*AGSLineItem
+			for _, item := range g.lineItems {
+				if item.Tag == r.URL.Query().Get("tag") {
+					found = append(found, item)
+				}
+			}
+			if found == nil {
+				found = []*AGSLineItem{}
+			}
+			json.NewEncoder(w).Encode(found)
+		case r.Method == "POST" && r.URL.Path == "/lineitems":
+			item := new(AGSLineItem)
+			json.NewDecoder(r.Body).Decode(item)
+			item.ID = fmt.Sprintf("%s/lineitems/%d", g.server.URL, len(g.lineItems)+1)
+			g.lineItems = append(g.lineItems, item)
+			json.NewEncoder(w).Encode(item)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/scores"):
+			if r.Header.Get("Content-Type") != agsScoreType {
+				t.Errorf("score posted with content type %q", r.Header.Get("Content-Type"))
+			}
+			score := new(AGSScore)
+			json.NewDecoder(r.Body).Decode(score)
+			item := strings.TrimSuffix(r.URL.Path, "/scores")
+			g.scores[item] = append(g.scores[item], score)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return g
+}
+
+func TestSaveScore(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	setLTIKey(key)
+
+	g := newFakeGradebook(t)
+	defer g.server.Close()
+	platform := &LTIPlatform{
+		ID:           7,
+		Issuer:       "https://lms.example.edu",
+		ClientID:     "10000000000042",
+		AuthTokenURL: g.server.URL + "/token",
+	}
+
+	// the first student creates the line item, the second finds it
+	for i, userID := range []string{"student-1", "student-2"} {
+		asst := &Assignment{
+			ID:            int64(i + 1),
+			LtiID:         "resource-link-1",
+			CanvasTitle:   "Loops",
+			Score:         0.5,
+			LTIPlatformID: platform.ID,
+			LTIUserID:     userID,
+			LineItemsURL:  g.server.URL + "/lineitems",
+		}
+		if err := saveGrade(context.Background(), asst, platform, "looks good"); err != nil {
+			t.Fatalf("saveGrade for %s: %v", userID, err)
+		}
+	}
+
+	// a platform-created line item is used directly
+	asst := &Assignment{
+		ID:            3,
+		LtiID:         "resource-link-2",
+		Score:         1.0,
+		LTIPlatformID: platform.ID,
+		LTIUserID:     "student-1",
+		LineItemURL:   g.server.URL + "/lineitems/99",
+	}
+	if err := saveGrade(context.Background(), asst, platform, ""); err != nil {
+		t.Fatalf("saveGrade with line item: %v", err)
+	}
+
+	// instructors are skipped
+	asst.Instructor = true
+	if err := saveGrade(context.Background(), asst, platform, ""); err != nil {
+		t.Fatalf("saveGrade for instructor: %v", err)
+	}
+
+	if g.tokens != 2 {
+		t.Errorf("requested %d access tokens, expected one per scope", g.tokens)
+	}
+	if len(g.lineItems) != 1 || g.lineItems[0].Label != "Loops" || g.lineItems[0].ScoreMaximum != agsScoreMaximum {
+		t.Fatalf("expected one line item labeled Loops, found %v", g.lineItems)
+	}
+	scores := g.scores["/lineitems/1"]
+	if len(scores) != 2 {
+		t.Fatalf("expected 2 scores on the created line item, found %d", len(scores))
+	}
+	for i, score := range scores {
+		if score.UserID != fmt.Sprintf("student-%d", i+1) || score.ScoreGiven != 50 || score.ScoreMaximum != 100 ||
+			score.Comment != "looks good" || score.ActivityProgress != "Submitted" || score.GradingProgress != "FullyGraded" {
+			t.Errorf("score %d: got %+v", i, score)
+		}
+	}
+	if scores := g.scores["/lineitems/99"]; len(scores) != 1 || scores[0].ActivityProgress != "Completed" {
+		t.Errorf("expected one completed score on the platform line item, found %v", scores)
+	}
+}
+
+func TestSaveScoreConcurrent(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	setLTIKey(key)
+
+	g := newFakeGradebook(t)
+	defer g.server.Close()
+	platform := &LTIPlatform{
+		ID:           8,
+		Issuer:       "https://lms.example.edu",
+		ClientID:     "10000000000042",
+		AuthTokenURL: g.server.URL + "/token",
+	}
+
+	// students posting at the same time share one token and one new line item
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		asst := &Assignment{
+			ID:            int64(i),
+			LtiID:         "resource-link-3",
+			CanvasTitle:   "Recursion",
+			Score:         1.0,
+			LTIPlatformID: platform.ID,
+			LTIUserID:     fmt.Sprintf("student-%d", i),
+			LineItemsURL:  g.server.URL + "/lineitems",
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := saveGrade(context.Background(), asst, platform, ""); err != nil {
+				t.Errorf("saveGrade for %s: %v", asst.LTIUserID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if g.tokens != 2 {
+		t.Errorf("requested %d access tokens, expected one per scope", g.tokens)
+	}
+	if len(g.lineItems) != 1 {
+		t.Fatalf("expected one line item, found %d", len(g.lineItems))
+	}
+	if scores := g.scores["/lineitems/1"]; len(scores) != 8 {
+		t.Errorf("expected 8 scores on the line item, found %d", len(scores))
+	}
+}
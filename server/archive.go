@@ -0,0 +1,541 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// maxArchiveSize is the largest problem archive accepted for import.
+	maxArchiveSize = 64 << 20
+
+	// maxArchiveUnpackedSize limits the decompressed size of an archive,
+	// including the tar headers, and maxArchiveFileSize limits each file in it,
+	// so a small archive cannot expand to fill memory.
+	maxArchiveUnpackedSize = 256 << 20
+	maxArchiveFileSize     = 32 << 20
+)
+
+var errArchiveTooLarge = fmt.Errorf("archive is larger than %d MB when unpacked", maxArchiveUnpackedSize>>20)
+
+// unpackLimit reads from r, failing with errArchiveTooLarge
+// if there are more than n bytes.
+type unpackLimit struct {
+	r io.Reader
+	n int64
+}
+
+func (l *unpackLimit) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// at the limit: fine if this is the end, otherwise too large
+		var extra [1]byte
+		if n, err := l.r.Read(extra[:]); n == 0 {
+			return 0, err
+		}
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// writeArchive writes a problem archive as a gzipped tar file.
+// steps holds the steps of each problem in the manifest, keyed by unique ID.
+func writeArchive(w io.Writer, manifest *ArchiveManifest, steps map[string][]*ProblemStep) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	add := func(name string, contents []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(contents)),
+			ModTime: manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(contents)
+		return err
+	}
+
+	raw, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := add(ArchiveManifestName, append(raw, '\n')); err != nil {
+		return err
+	}
+	for _, problem := range manifest.Problems {
+		for _, step := range steps[problem.Unique] {
+			for kind, files := range map[string]map[string][]byte{"files": step.Files, "solution": step.Solution} {
+				var names []string
+				for name := range files {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					if err := add(path.Join("problems", problem.Unique, strconv.FormatInt(step.Step, 10), kind, name), files[name]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readArchive reads a problem archive written by writeArchive and checks that it is complete.
+// It returns the manifest and the steps of each problem keyed by unique ID,
+// with the author solution of each step in its Solution field.
+func readArchive(r io.Reader) (*ArchiveManifest, map[string][]*ProblemStep, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("archive is not a gzipped tar file: %v", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(&unpackLimit{r: gr, n: maxArchiveUnpackedSize})
+
+	var manifest *ArchiveManifest
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err == errArchiveTooLarge {
+			return nil, nil, err
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("reading archive: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			return nil, nil, fmt.Errorf("archive entry %s is not a regular file", hdr.Name)
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, nil, fmt.Errorf("archive entry %s is outside the archive", hdr.Name)
+		}
+		if hdr.Size > maxArchiveFileSize {
+			return nil, nil, fmt.Errorf("archive entry %s is larger than %d MB", name, maxArchiveFileSize>>20)
+		}
+		contents, err := ioutil.ReadAll(io.LimitReader(tr, maxArchiveFileSize+1))
+		if err == errArchiveTooLarge {
+			return nil, nil, err
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("reading %s from archive: %v", name, err)
+		}
+		if len(contents) > maxArchiveFileSize {
+			return nil, nil, fmt.Errorf("archive entry %s is larger than %d MB", name, maxArchiveFileSize>>20)
+		}
+		if name == ArchiveManifestName {
+			manifest = new(ArchiveManifest)
+			if err := json.Unmarshal(contents, manifest); err != nil {
+				return nil, nil, fmt.Errorf("parsing %s: %v", ArchiveManifestName, err)
+			}
+			continue
+		}
+		files[name] = contents
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("archive does not contain %s", ArchiveManifestName)
+	}
+	if manifest.Version < 1 || manifest.Version > ArchiveVersion {
+		return nil, nil, fmt.Errorf("archive is version %d, but this server only understands versions up to %d", manifest.Version, ArchiveVersion)
+	}
+
+	// gather the steps of each problem
+	steps := make(map[string][]*ProblemStep)
+	for _, problem := range manifest.Problems {
+		if problem.Unique == "" {
+			return nil, nil, fmt.Errorf("archive contains a problem with no unique ID")
+		}
+		if _, exists := steps[problem.Unique]; exists {
+			return nil, nil, fmt.Errorf("archive contains problem %q more than once", problem.Unique)
+		}
+		if len(problem.Steps) == 0 {
+			return nil, nil, fmt.Errorf("problem %q has no steps", problem.Unique)
+		}
+		list := []*ProblemStep{}
+		for i, elt := range problem.Steps {
+			if elt.Step != int64(i+1) {
+				return nil, nil, fmt.Errorf("problem %q lists step %d where step %d was expected", problem.Unique, elt.Step, i+1)
+			}
+			step := &ProblemStep{
+				Step:        elt.Step,
+				ProblemType: elt.ProblemType,
+				Note:        elt.Note,
+				Weight:      elt.Weight,
+				Files:       make(map[string][]byte),
+				Whitelist:   make(map[string]bool),
+				Solution:    make(map[string][]byte),
+			}
+			for _, name := range elt.Whitelist {
+				step.Whitelist[name] = true
+			}
+			list = append(list, step)
+		}
+		steps[problem.Unique] = list
+	}
+	for name, contents := range files {
+		parts := strings.SplitN(name, "/", 5)
+		if len(parts) != 5 || parts[0] != "problems" {
+			return nil, nil, fmt.Errorf("archive entry %s is not part of any problem", name)
+		}
+		list, exists := steps[parts[1]]
+		if !exists {
+			return nil, nil, fmt.Errorf("archive entry %s is for problem %q, which is not in the manifest", name, parts[1])
+		}
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 1 || n > len(list) {
+			return nil, nil, fmt.Errorf("archive entry %s is for a step that is not in the manifest", name)
+		}
+		switch parts[3] {
+		case "files":
+			list[n-1].Files[parts[4]] = contents
+		case "solution":
+			list[n-1].Solution[parts[4]] = contents
+		default:
+			return nil, nil, fmt.Errorf("archive entry %s is not a step file or solution file", name)
+		}
+	}
+	for unique, list := range steps {
+		for _, step := range list {
+			if len(step.Solution) == 0 {
+				return nil, nil, fmt.Errorf("step %d of problem %q has no solution", step.Step, unique)
+			}
+		}
+	}
+
+	// problem sets can only refer to problems in the archive
+	seen := make(map[string]bool)
+	for _, set := range manifest.ProblemSets {
+		if set.Unique == "" {
+			return nil, nil, fmt.Errorf("archive contains a problem set with no unique ID")
+		}
+		if seen[set.Unique] {
+			return nil, nil, fmt.Errorf("archive contains problem set %q more than once", set.Unique)
+		}
+		seen[set.Unique] = true
+		if len(set.Problems) == 0 {
+			return nil, nil, fmt.Errorf("problem set %q has no problems", set.Unique)
+		}
+		for _, elt := range set.Problems {
+			if _, exists := steps[elt.Unique]; !exists {
+				return nil, nil, fmt.Errorf("problem set %q refers to problem %q, which is not in the archive", set.Unique, elt.Unique)
+			}
+		}
+	}
+
+	return manifest, steps, nil
+}
+
+// archiveProblems gathers problems and their steps for an archive.
+func archiveProblems(tx *sql.Tx, problemIDs []int64) ([]*ArchiveProblem, map[string][]*ProblemStep, error) {
+	problems := []*ArchiveProblem{}
+	steps := make(map[string][]*ProblemStep)
+	for _, id := range problemIDs {
+		problem := new(Problem)
+		if err := meddler.Load(tx, "problems", problem, id); err != nil {
+			return nil, nil, err
+		}
+		list := []*ProblemStep{}
+		if err := meddler.QueryAll(tx, &list, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, id); err != nil {
+			return nil, nil, err
+		}
+		elt := &ArchiveProblem{
+			Unique:       problem.Unique,
+			Note:         problem.Note,
+			Tags:         problem.Tags,
+			Options:      problem.Options,
+			ProblemTypes: []string{},
+			Steps:        []*ArchiveStep{},
+		}
+		types := make(map[string]bool)
+		for _, step := range list {
+			if !types[step.ProblemType] {
+				types[step.ProblemType] = true
+				elt.ProblemTypes = append(elt.ProblemTypes, step.ProblemType)
+			}
+			whitelist := []string{}
+			for name := range step.Whitelist {
+				whitelist = append(whitelist, name)
+			}
+			sort.Strings(whitelist)
+			elt.Steps = append(elt.Steps, &ArchiveStep{
+				Step:        step.Step,
+				ProblemType: step.ProblemType,
+				Note:        step.Note,
+				Weight:      step.Weight,
+				Whitelist:   whitelist,
+			})
+		}
+		problems = append(problems, elt)
+		steps[problem.Unique] = list
+	}
+	return problems, steps, nil
+}
+
+// renderArchive sends an archive as a file download.
+func renderArchive(w http.ResponseWriter, name string, manifest *ArchiveManifest, steps map[string][]*ProblemStep) {
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, manifest, steps); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing archive: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+".tar.gz")
+	w.Write(buf.Bytes())
+}
+
+// GetProblemArchive handles a request to /v2/problems/:problem_id/archive,
+// returning a portable archive of the problem.
+// The archive includes a problem set with the same unique ID holding just this problem.
+func GetProblemArchive(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+
+	problems, steps, err := archiveProblems(tx, []int64{problemID})
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	problem := problems[0]
+	manifest := &ArchiveManifest{
+		Version:   ArchiveVersion,
+		Hostname:  Config.Hostname,
+		CreatedAt: time.Now(),
+		Problems:  problems,
+		ProblemSets: []*ArchiveProblemSet{{
+			Unique:   problem.Unique,
+			Note:     "Problem set for: " + problem.Note,
+			Tags:     problem.Tags,
+			Problems: []*ArchiveProblemSetProblem{{Unique: problem.Unique, Weight: 1.0}},
+		}},
+	}
+	renderArchive(w, problem.Unique, manifest, steps)
+}
+
+// GetProblemSetArchive handles a request to /v2/problem_sets/:problem_set_id/archive,
+// returning a portable archive of the problem set and all of its problems.
+func GetProblemSetArchive(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	problemSet := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", problemSet, problemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	psps := []*ProblemSetProblem{}
+	if err := meddler.QueryAll(tx, &psps, `SELECT * FROM problem_set_problems WHERE problem_set_id = ? ORDER BY problem_id`, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	var problemIDs []int64
+	for _, psp := range psps {
+		problemIDs = append(problemIDs, psp.ProblemID)
+	}
+	problems, steps, err := archiveProblems(tx, problemIDs)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	set := &ArchiveProblemSet{
+		Unique:     problemSet.Unique,
+		Note:       problemSet.Note,
+		Tags:       problemSet.Tags,
+		LatePolicy: problemSet.LatePolicy,
+		Problems:   []*ArchiveProblemSetProblem{},
+	}
+	for i, psp := range psps {
+		set.Problems = append(set.Problems, &ArchiveProblemSetProblem{Unique: problems[i].Unique, Weight: psp.Weight})
+	}
+	manifest := &ArchiveManifest{
+		Version:     ArchiveVersion,
+		Hostname:    Config.Hostname,
+		CreatedAt:   time.Now(),
+		Problems:    problems,
+		ProblemSets: []*ArchiveProblemSet{set},
+	}
+	renderArchive(w, problemSet.Unique, manifest, steps)
+}
+
+// uniqueInUse checks if a unique ID is already used in the given table.
+func uniqueInUse(tx *sql.Tx, table, unique string) (bool, error) {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM `+table+` WHERE unique_id = ?`, unique).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// freeUniques finds a unique ID for each name that is not already used in the given table.
+// If rename is false, a name in use is an error.
+// Otherwise it is renamed by adding the first free numeric suffix.
+func freeUniques(tx *sql.Tx, table, kind string, names []string, rename bool) (map[string]string, error) {
+	renamed := make(map[string]string)
+	taken := make(map[string]bool)
+	for _, name := range names {
+		taken[name] = true
+	}
+	for _, name := range names {
+		used, err := uniqueInUse(tx, table, name)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			continue
+		}
+		if !rename {
+			return nil, fmt.Errorf("%s %q already exists; import with renaming to give it a new unique ID", kind, name)
+		}
+		for n := 2; ; n++ {
+			candidate := fmt.Sprintf("%s-%d", name, n)
+			if taken[candidate] {
+				continue
+			}
+			if used, err = uniqueInUse(tx, table, candidate); err != nil {
+				return nil, err
+			} else if !used {
+				renamed[name] = candidate
+				taken[candidate] = true
+				break
+			}
+		}
+	}
+	return renamed, nil
+}
+
+// PostProblemArchive handles a request to /v2/problem_archives,
+// unpacking an uploaded archive and checking that it can be imported.
+// It returns an unsigned problem bundle for each problem, to be confirmed on a daycare
+// and saved like a new problem, followed by the problem sets.
+// With parameter rename=true, problems and problem sets whose unique IDs are already in use
+// are given new ones; otherwise a conflict is an error.
+// Nothing is saved by this request.
+func PostProblemArchive(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	now := time.Now()
+	rename := r.FormValue("rename") == "true"
+
+	manifest, steps, err := readArchive(http.MaxBytesReader(w, r.Body, maxArchiveSize))
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// the problem types must be known here
+	for _, problem := range manifest.Problems {
+		for _, step := range steps[problem.Unique] {
+			if _, err := getProblemType(tx, step.ProblemType); err == sql.ErrNoRows {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "problem %q requires problem type %q, which is not installed here", problem.Unique, step.ProblemType)
+				return
+			} else if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+		}
+	}
+
+	// keep the unique IDs or find new ones
+	var problemNames, setNames []string
+	for _, problem := range manifest.Problems {
+		problemNames = append(problemNames, problem.Unique)
+	}
+	for _, set := range manifest.ProblemSets {
+		setNames = append(setNames, set.Unique)
+	}
+	renamedProblems, err := freeUniques(tx, "problems", "problem", problemNames, rename)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	renamedSets, err := freeUniques(tx, "problem_sets", "problem set", setNames, rename)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	for _, set := range manifest.ProblemSets {
+		if name, exists := renamedSets[set.Unique]; exists {
+			set.Unique = name
+		}
+		for _, elt := range set.Problems {
+			if name, exists := renamedProblems[elt.Unique]; exists {
+				elt.Unique = name
+			}
+		}
+	}
+
+	// form a bundle for each problem with the author solutions as commits
+	result := &ArchiveImport{
+		Manifest:           manifest,
+		Bundles:            []*ProblemBundle{},
+		RenamedProblems:    renamedProblems,
+		RenamedProblemSets: renamedSets,
+	}
+	for _, elt := range manifest.Problems {
+		list := steps[elt.Unique]
+		if name, exists := renamedProblems[elt.Unique]; exists {
+			elt.Unique = name
+		}
+		bundle := &ProblemBundle{
+			Problem: &Problem{
+				Unique:    elt.Unique,
+				Note:      elt.Note,
+				Tags:      elt.Tags,
+				Options:   elt.Options,
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+			ProblemSteps: list,
+			UserID:       currentUser.ID,
+		}
+		for _, step := range list {
+			bundle.Commits = append(bundle.Commits, &Commit{
+				Step:      step.Step,
+				Action:    "grade",
+				Note:      "author solution imported via grind",
+				Files:     step.Solution,
+				CreatedAt: now,
+				UpdatedAt: now,
+			})
+			step.Solution = nil
+		}
+		if err := bundle.Problem.Normalize(now, bundle.ProblemSteps); err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "problem %q: %v", elt.Unique, err)
+			return
+		}
+		result.Bundles = append(result.Bundles, bundle)
+	}
+
+	render.JSON(http.StatusOK, result)
+}
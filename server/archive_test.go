@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func TestReadArchive(t *testing.T) {
+	manifest := &ArchiveManifest{
+		Version: ArchiveVersion,
+		Problems: []*ArchiveProblem{{
+			Unique: "hello",
+			Note:   "Hello",
+			Steps:  []*ArchiveStep{{Step: 1, ProblemType: "python3unittest", Note: "Say hello", Weight: 1.0, Whitelist: []string{"hello.py"}}},
+		}},
+		ProblemSets: []*ArchiveProblemSet{{Unique: "hello", Problems: []*ArchiveProblemSetProblem{{Unique: "hello", Weight: 1.0}}}},
+	}
+	steps := map[string][]*ProblemStep{
+		"hello": {{
+			Step:     1,
+			Files:    map[string][]byte{"doc/doc.md": []byte("Say hello\n"), "hello.py": []byte("# your code here\n")},
+			Solution: map[string][]byte{"hello.py": []byte("print('hello')\n")},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, manifest, steps); err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+	m, s, err := readArchive(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if len(m.Problems) != 1 || len(s["hello"]) != 1 {
+		t.Fatalf("round trip: got %d problems with steps %v", len(m.Problems), s)
+	}
+	step := s["hello"][0]
+	if string(step.Files["doc/doc.md"]) != "Say hello\n" || string(step.Solution["hello.py"]) != "print('hello')\n" || !step.Whitelist["hello.py"] {
+		t.Errorf("round trip step: got %+v", step)
+	}
+
+	// archives that are broken or reach outside themselves are rejected
+	broken := func(name string, contents string) error {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		raw, _ := json.Marshal(manifest)
+		for _, file := range []struct{ name, contents string }{{ArchiveManifestName, string(raw)}, {name, contents}} {
+			tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.contents)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(file.contents))
+		}
+		tw.Close()
+		gw.Close()
+		_, _, err := readArchive(&buf)
+		return err
+	}
+	if err := broken("../../etc/passwd", "root"); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("entry outside the archive: got %v", err)
+	}
+	if err := broken("problems/other/1/files/x.py", ""); err == nil {
+		t.Errorf("entry for an unknown problem: expected an error")
+	}
+	if err := broken("problems/hello/1/files/hello.py", ""); err == nil || !strings.Contains(err.Error(), "no solution") {
+		t.Errorf("step with no solution: got %v", err)
+	}
+
+	// a file that unpacks to more than the limit is rejected, however well it compresses
+	if err := broken("problems/hello/1/files/big.py", strings.Repeat("\x00", maxArchiveFileSize+1)); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("oversized entry: got %v", err)
+	}
+}
+
+func TestUnpackLimit(t *testing.T) {
+	tests := []struct {
+		size  int
+		limit int64
+		err   error
+	}{
+		{10, 20, nil},
+		{20, 20, nil},
+		{21, 20, errArchiveTooLarge},
+		{1000, 20, errArchiveTooLarge},
+	}
+	for _, test := range tests {
+		r := &unpackLimit{r: bytes.NewReader(make([]byte, test.size)), n: test.limit}
+		got, err := ioutil.ReadAll(r)
+		if err != test.err || (err == nil && len(got) != test.size) {
+			t.Errorf("%d bytes with a limit of %d: got %d bytes, error %v, expected %v", test.size, test.limit, len(got), err, test.err)
+		}
+	}
+}
+
+func TestProblemArchive(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		now := time.Now().Round(time.Second)
+
+		// a problem in a problem set with a late policy
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		problem := &Problem{Unique: "hello", Note: "Hello", Tags: []string{"intro"}, Options: []string{}, Revision: 1, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problems", problem); err != nil {
+			t.Fatalf("inserting problem: %v", err)
+		}
+		for n := int64(1); n <= 2; n++ {
+			step := &ProblemStep{
+				ProblemID:   problem.ID,
+				Step:        n,
+				ProblemType: "python3unittest",
+				Note:        fmt.Sprintf("Step %d", n),
+				Weight:      float64(n),
+				Files:       map[string][]byte{"doc/doc.md": []byte(fmt.Sprintf("Step %d\n", n)), "tests/test.py": []byte("import hello\n")},
+				Whitelist:   map[string]bool{"hello.py": true},
+				Solution:    map[string][]byte{"hello.py": []byte(fmt.Sprintf("print(%d)\n", n))},
+			}
+			if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+				t.Fatalf("inserting problem step: %v", err)
+			}
+		}
+		set := &ProblemSet{Unique: "intro", Note: "Intro", Tags: []string{}, LatePolicy: &LatePolicy{PercentPerDay: 10}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+			t.Fatalf("inserting problem set: %v", err)
+		}
+		if err := meddler.Insert(tx, "problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 2.0}); err != nil {
+			t.Fatalf("inserting problem set problem: %v", err)
+		}
+		author := &User{Name: "author", Email: "author@example.com", Author: true, CreatedAt: now, UpdatedAt: now, LastSignedInAt: now}
+		if err := meddler.Insert(tx, "users", author); err != nil {
+			t.Fatalf("inserting user: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		m := martini.New()
+		m.Use(render.Renderer())
+		r := martini.NewRouter()
+		withTx := testWithTx(t, db, &author)
+		r.Get("/v2/problems/:problem_id/archive", withTx, GetProblemArchive)
+		r.Get("/v2/problem_sets/:problem_set_id/archive", withTx, GetProblemSetArchive)
+		r.Post("/v2/problem_archives", withTx, PostProblemArchive)
+		m.Action(r.Handle)
+
+		request := func(method, path string, body []byte) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, req)
+			return w
+		}
+
+		// export the problem set
+		w := request("GET", fmt.Sprintf("/v2/problem_sets/%d/archive", set.ID), nil)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" {
+			t.Fatalf("exporting problem set: got status %d, type %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		archive := w.Body.Bytes()
+		manifest, steps, err := readArchive(bytes.NewReader(archive))
+		if err != nil {
+			t.Fatalf("readArchive: %v", err)
+		}
+		if len(manifest.ProblemSets) != 1 || manifest.ProblemSets[0].LatePolicy == nil || manifest.ProblemSets[0].Problems[0].Weight != 2.0 {
+			t.Errorf("exported problem set: got %+v", manifest.ProblemSets)
+		}
+		if len(manifest.Problems) != 1 || len(steps["hello"]) != 2 || manifest.Problems[0].ProblemTypes[0] != "python3unittest" {
+			t.Errorf("exported problem: got %+v", manifest.Problems)
+		}
+
+		// a single problem comes with a problem set of its own
+		w = request("GET", fmt.Sprintf("/v2/problems/%d/archive", problem.ID), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("exporting problem: got status %d", w.Code)
+		}
+		if manifest, _, err = readArchive(w.Body); err != nil || len(manifest.ProblemSets) != 1 || manifest.ProblemSets[0].Unique != "hello" {
+			t.Errorf("exported problem: got %+v, err %v", manifest, err)
+		}
+
+		// importing it here conflicts unless it is renamed
+		if w = request("POST", "/v2/problem_archives", archive); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "already exists") {
+			t.Errorf("importing a conflicting problem: got status %d: %s", w.Code, w.Body.String())
+		}
+		if w = request("POST", "/v2/problem_archives?rename=true", archive); w.Code != http.StatusOK {
+			t.Fatalf("importing with rename: got status %d: %s", w.Code, w.Body.String())
+		}
+		unpacked := new(ArchiveImport)
+		if err := json.Unmarshal(w.Body.Bytes(), unpacked); err != nil {
+			t.Fatalf("decoding import: %v", err)
+		}
+		if unpacked.RenamedProblems["hello"] != "hello-2" || unpacked.RenamedProblemSets["intro"] != "intro-2" {
+			t.Errorf("renamed: got %v and %v", unpacked.RenamedProblems, unpacked.RenamedProblemSets)
+		}
+		if len(unpacked.Manifest.ProblemSets) != 1 || unpacked.Manifest.ProblemSets[0].Problems[0].Unique != "hello-2" {
+			t.Errorf("renamed problem set: got %+v", unpacked.Manifest.ProblemSets)
+		}
+		if len(unpacked.Bundles) != 1 {
+			t.Fatalf("expected 1 bundle, got %d", len(unpacked.Bundles))
+		}
+		bundle := unpacked.Bundles[0]
+		if bundle.Problem.Unique != "hello-2" || bundle.Problem.ID != 0 || bundle.UserID != author.ID || len(bundle.ProblemSteps) != 2 || len(bundle.Commits) != 2 {
+			t.Fatalf("bundle: got %+v", bundle)
+		}
+		if string(bundle.Commits[1].Files["hello.py"]) != "print(2)\n" || bundle.ProblemSteps[1].Solution != nil || bundle.ProblemSteps[1].Instructions == "" {
+			t.Errorf("bundle step 2: got %+v with commit %+v", bundle.ProblemSteps[1], bundle.Commits[1])
+		}
+
+		// problem types must be installed
+		manifest, steps, _ = readArchive(bytes.NewReader(archive))
+		manifest.Problems[0].Steps[0].ProblemType = "cobol"
+		var buf bytes.Buffer
+		if err := writeArchive(&buf, manifest, steps); err != nil {
+			t.Fatalf("writeArchive: %v", err)
+		}
+		if w = request("POST", "/v2/problem_archives?rename=true", buf.Bytes()); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "cobol") {
+			t.Errorf("importing with an unknown problem type: got status %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
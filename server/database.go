@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/russross/meddler"
+)
+
+// The TA database can be SQLite (the default) or PostgreSQL.
+// Queries throughout the server are written with ? placeholders
+// and portable SQL; the postgres driver registered here rewrites
+// the placeholders into the $1, $2, ... form that PostgreSQL expects.
+const (
+	sqliteDriver   = "sqlite3"
+	postgresDriver = "postgres"
+)
+
+func init() {
+	sql.Register("codegrinder-postgres", pgDriver{})
+}
+
+// Transactions that fail because the database is busy are retried
+// up to busyRetries times, starting busyRetryDelay apart and doubling.
+const (
+	busyRetries    = 5
+	busyRetryDelay = 50 * time.Millisecond
+)
+
+// sqliteOptions are the connection settings for every SQLite connection.
+// WAL mode lets readers run alongside the single writer.
+const sqliteOptions = "?" + "_busy_timeout=10000" +
+	"&" + "_case_sensitive_like=OFF" +
+	"&" + "_foreign_keys=ON" +
+	"&" + "_journal_mode=WAL" +
+	"&" + "mode=rw" +
+	"&" + "_synchronous=FULL"
+
+// setupDB opens the TA database and sets the matching meddler dialect.
+// For sqlite3 the data source is the path to the database file;
+// for postgres it is a connection string or URL as understood by lib/pq.
+//
+// SQLite transactions on this pool take the write lock when they begin,
+// so a writer waits its turn up front instead of failing partway through
+// when another writer got there first.
+func setupDB(driverName, dataSource string) *sql.DB {
+	var db *sql.DB
+	var err error
+	switch driverName {
+	case "", sqliteDriver:
+		meddler.Default = meddler.SQLite
+		db, err = sql.Open("sqlite3", dataSource+sqliteOptions+"&_txlock=immediate")
+
+	case postgresDriver:
+		meddler.Default = meddler.PostgreSQL
+		db, err = sql.Open("codegrinder-postgres", dataSource)
+
+	default:
+		log.Fatalf("unknown database driver %q; must be %q or %q", driverName, sqliteDriver, postgresDriver)
+	}
+	if err != nil {
+		log.Fatalf("error opening database: %v", err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+
+	return db
+}
+
+// openConfigDB opens the TA database described in the config file.
+func openConfigDB() *sql.DB {
+	switch Config.DatabaseDriver {
+	case sqliteDriver:
+		if Config.SQLite3Path == "" {
+			log.Fatalf("cannot use sqlite3 with no sqlite3Path in the config file")
+		}
+		return setupDB(sqliteDriver, Config.SQLite3Path)
+	case postgresDriver:
+		if Config.PostgresURL == "" {
+			log.Fatalf("cannot use postgres with no postgresURL in the config file")
+		}
+		return setupDB(postgresDriver, Config.PostgresURL)
+	default:
+		log.Fatalf("databaseDriver must be %q or %q", sqliteDriver, postgresDriver)
+	}
+	return nil
+}
+
+// setupReadDB opens a pool for read-only transactions on the database
+// that db was opened on. SQLite gets a separate pool of query-only
+// connections that read from a snapshot without taking the write lock;
+// postgres shares db and marks each transaction read only instead.
+func setupReadDB(driverName, dataSource string, db *sql.DB) *sql.DB {
+	if driverName != "" && driverName != sqliteDriver {
+		return db
+	}
+	readDB, err := sql.Open("sqlite3", dataSource+sqliteOptions+"&_query_only=true")
+	if err != nil {
+		log.Fatalf("error opening database: %v", err)
+	}
+	if err = readDB.Ping(); err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	return readDB
+}
+
+// openConfigReadDB opens the read-only pool for the TA database
+// described in the config file. db must come from openConfigDB.
+func openConfigReadDB(db *sql.DB) *sql.DB {
+	if Config.DatabaseDriver == sqliteDriver {
+		return setupReadDB(sqliteDriver, Config.SQLite3Path, db)
+	}
+	return setupReadDB(Config.DatabaseDriver, Config.PostgresURL, db)
+}
+
+// isBusy reports whether err means the transaction lost a race with
+// another one and could succeed if tried again.
+func isBusy(err error) bool {
+	switch e := err.(type) {
+	case sqlite3.Error:
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	case *pq.Error:
+		// serialization_failure, deadlock_detected, lock_not_available
+		return e.Code == "40001" || e.Code == "40P01" || e.Code == "55P03"
+	}
+	return false
+}
+
+// beginTx starts a transaction, retrying while the database is busy.
+func beginTx(db *sql.DB, readOnly bool) (*sql.Tx, error) {
+	delay := busyRetryDelay
+	for attempt := 0; ; attempt++ {
+		tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: readOnly})
+		if err == nil || !isBusy(err) || attempt >= busyRetries {
+			return tx, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// writeTx runs f in a transaction for a background worker.
+// The transaction commits if f succeeds, and the whole thing is
+// tried again from the start if it fails because the database is busy.
+func writeTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	return retryTx(db, false, f)
+}
+
+// forUpdate gives the clause that locks the rows read by a SELECT until the
+// transaction ends. Use it when reading a row that will be written back, such
+// as an assignment whose scores are being updated. PostgreSQL transactions run
+// at READ COMMITTED, so without it two transactions could read the same row
+// and the second to write would undo the first. SQLite write transactions
+// already hold the database write lock, and do not accept the clause.
+func forUpdate() string {
+	if meddler.Default == meddler.PostgreSQL {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// readTx is like writeTx, but runs f in a read-only transaction.
+// db should be the read pool from openConfigReadDB.
+func readTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	return retryTx(db, true, f)
+}
+
+func retryTx(db *sql.DB, readOnly bool, f func(tx *sql.Tx) error) error {
+	delay := busyRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := func() error {
+			tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: readOnly})
+			if err != nil {
+				return err
+			}
+			if err := f(tx); err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}()
+		if err == nil || !isBusy(err) || attempt >= busyRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// withTransaction returns the martini service that wraps a handler in a transaction.
+// GET and HEAD requests only read, so they get a read-only transaction from readDB
+// and run alongside each other and alongside the writer. Everything else gets a
+// write transaction from db. The transaction commits if the handler succeeds.
+func withTransaction(db, readDB *sql.DB) martini.Handler {
+	return func(c martini.Context, w http.ResponseWriter, r *http.Request) {
+		// start a transaction
+		var tx *sql.Tx
+		var err error
+		if r.Method == "GET" || r.Method == "HEAD" {
+			tx, err = beginTx(readDB, true)
+		} else {
+			tx, err = beginTx(db, false)
+		}
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error starting transaction: %v", err)
+			return
+		}
+
+		// pass it on to the main handler
+		c.Map(tx)
+		c.Next()
+
+		// was it a successful result?
+		rw := w.(martini.ResponseWriter)
+		if rw.Status() < http.StatusBadRequest {
+			// commit the transaction
+			if err := tx.Commit(); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error committing transaction: %v", err)
+				return
+			}
+		} else {
+			// rollback
+			//log.Printf("rolling back transaction")
+			if err := tx.Rollback(); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error rolling back transaction: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// pgDriver wraps lib/pq so that queries written for SQLite work unchanged.
+type pgDriver struct{}
+
+func (pgDriver) Open(dataSource string) (driver.Conn, error) {
+	conn, err := pq.Open(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &pgConn{conn: conn}, nil
+}
+
+// pgConn is a lib/pq connection with placeholder rewriting.
+type pgConn struct {
+	conn driver.Conn
+}
+
+func (c *pgConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(rebindPostgres(query))
+}
+
+func (c *pgConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *pgConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *pgConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *pgConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.conn.(driver.QueryerContext).QueryContext(ctx, rebindPostgres(query), args)
+}
+
+func (c *pgConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.conn.(driver.ExecerContext).ExecContext(ctx, rebindPostgres(query), args)
+}
+
+// CheckNamedValue converts []byte arguments to strings.
+// meddler encodes JSON fields as []byte, which lib/pq would send as bytea,
+// but those fields are stored in text columns.
+func (c *pgConn) CheckNamedValue(nv *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if raw, ok := value.([]byte); ok {
+		value = string(raw)
+	}
+	nv.Value = value
+	return nil
+}
+
+// rebindPostgres replaces each ? placeholder with $1, $2, etc.
+// Question marks inside quoted strings and identifiers are left alone.
+func rebindPostgres(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var out strings.Builder
+	n := 0
+	var quote rune
+	for _, ch := range query {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?':
+			n++
+			out.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		out.WriteRune(ch)
+	}
+	return out.String()
+}
+
+// migration is one step in the evolution of the TA database schema.
+type migration struct {
+	Version  int
+	Note     string
+	SQLite   string
+	Postgres string
+}
+
+// schemaVersion gives the latest migration applied to the database.
+// A database created before migrations were tracked has no
+// schema_migrations table; it is marked as being at version 1.
+func schemaVersion(db *sql.DB, driverName string) (int, error) {
+	exists := func(table string) (bool, error) {
+		query := `SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?`
+		if driverName == postgresDriver {
+			query = `SELECT COUNT(1) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+		}
+		var count int
+		err := db.QueryRow(query, table).Scan(&count)
+		return count > 0, err
+	}
+
+	found, err := exists("schema_migrations")
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		legacy, err := exists("users")
+		if err != nil {
+			return 0, err
+		}
+		timestamp := "datetime"
+		if driverName == postgresDriver {
+			timestamp = "timestamptz"
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`CREATE TABLE schema_migrations (` +
+			`version integer PRIMARY KEY, ` +
+			`note text NOT NULL, ` +
+			`applied_at ` + timestamp + ` NOT NULL)`); err != nil {
+			return 0, err
+		}
+		if legacy {
+			log.Printf("found a database without a schema version; assuming it matches migration 1")
+			if _, err := tx.Exec(`INSERT INTO schema_migrations (version, note, applied_at) VALUES (?, ?, ?)`,
+				1, migrations[0].Note, time.Now()); err != nil {
+				return 0, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// migrateDB brings the database schema up to date. If apply is false,
+// it only checks that no migrations are pending. It refuses to work with
+// a database that has migrations newer than this server knows about.
+func migrateDB(db *sql.DB, driverName string, apply bool) error {
+	version, err := schemaVersion(db, driverName)
+	if err != nil {
+		return fmt.Errorf("finding schema version: %v", err)
+	}
+	latest := migrations[len(migrations)-1].Version
+	if version > latest {
+		return fmt.Errorf("database schema is at version %d but this server only understands up to version %d; upgrade codegrinder", version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+	if !apply {
+		return fmt.Errorf("database schema is at version %d but this server needs version %d; run codegrinder -migrate", version, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		script := m.SQLite
+		if driverName == postgresDriver {
+			script = m.Postgres
+		}
+		log.Printf("applying database migration %d: %s", m.Version, m.Note)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(script); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %v", m.Version, m.Note, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, note, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Note, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %d: %v", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("committing migration %d: %v", m.Version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// forEachDatabase runs a test against a fresh SQLite database and a fresh
+// PostgreSQL database on the server from postgresTestURL.
+// The schema is migrated to the latest version and the standard problem types
+// are loaded before the test runs.
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *sql.DB)) {
+	forEachEmptyDatabase(t, func(t *testing.T, db *sql.DB, driverName string) {
+		loadSchema(t, db, driverName)
+		test(t, db)
+	})
+}
+
+// forEachEmptyDatabase is like forEachDatabase but the database starts out empty.
+// Each PostgreSQL run uses a new schema that is dropped afterward.
+func forEachEmptyDatabase(t *testing.T, test func(t *testing.T, db *sql.DB, driverName string)) {
+	t.Run(sqliteDriver, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "codegrinder")
+		if err != nil {
+			t.Fatalf("creating temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "codegrinder.db")
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("creating database file: %v", err)
+		}
+		db := setupDB(sqliteDriver, path)
+		defer db.Close()
+		test(t, db, sqliteDriver)
+	})
+
+	t.Run(postgresDriver, func(t *testing.T) {
+		url, err := postgresTestURL()
+		if err != nil {
+			t.Skipf("cannot test against PostgreSQL: %v", err)
+		}
+		admin := setupDB(postgresDriver, url)
+		defer admin.Close()
+		schema := fmt.Sprintf("codegrinder_test_%d", rand.Int63())
+		if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			t.Fatalf("creating schema: %v", err)
+		}
+		defer admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		db := setupDB(postgresDriver, url+sep+"search_path="+schema)
+		defer db.Close()
+		test(t, db, postgresDriver)
+	})
+	meddler.Default = meddler.SQLite
+}
+
+// loadSchema applies all migrations and loads the standard problem types.
+func loadSchema(t testing.TB, db *sql.DB, driverName string) {
+	if err := migrateDB(db, driverName, true); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	script, err := ioutil.ReadFile(filepath.Join("..", "setup", "problemtypes.sql"))
+	if err != nil {
+		t.Fatalf("reading problem types: %v", err)
+	}
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatalf("loading problem types: %v", err)
+	}
+}
+
+// testLaunchForm gives an LTI launch of the test assignment in the test course
+// by the named user. role is Learner or Instructor.
+func testLaunchForm(name, role string, canvasUserID int64) *LTIRequest {
+	return &LTIRequest{
+		PersonNameFull:     name,
+		UserID:             "user-" + name,
+		Roles:              role,
+		ContextID:          "course-lti-id",
+		ResourceLinkID:     "assignment-lti-id",
+		CanvasUserLoginID:  name,
+		CanvasUserID:       canvasUserID,
+		CanvasCourseID:     1,
+		CanvasAssignmentID: 2,
+	}
+}
+
+// launchTestUser handles the user and course parts of an LTI launch.
+func launchTestUser(t testing.TB, tx *sql.Tx, form *LTIRequest, now time.Time) (*User, *Course) {
+	user, err := getUpdateUser(tx, form, now)
+	if err != nil {
+		t.Fatalf("getUpdateUser: %v", err)
+	}
+	course, err := getUpdateCourse(tx, form, now)
+	if err != nil {
+		t.Fatalf("getUpdateCourse: %v", err)
+	}
+	return user, course
+}
+
+// launchTestAssignment handles an LTI launch the way the TA does, creating or
+// updating the user, course, and assignment. set may be nil.
+func launchTestAssignment(t testing.TB, tx *sql.Tx, form *LTIRequest, now time.Time, set *ProblemSet) (*User, *Course, *Assignment) {
+	user, course := launchTestUser(t, tx, form, now)
+	asst, err := getUpdateAssignment(tx, form, now, course, set, user)
+	if err != nil {
+		t.Fatalf("getUpdateAssignment: %v", err)
+	}
+	return user, course, asst
+}
+
+// testWithTx is martini middleware for handler tests. It runs each request in
+// a transaction on db that is committed afterward. If currentUser is not nil,
+// the user it points to when the request arrives is mapped as well.
+func testWithTx(t testing.TB, db *sql.DB, currentUser **User) martini.Handler {
+	return func(c martini.Context) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		c.Map(tx)
+		if currentUser != nil {
+			c.Map(*currentUser)
+		}
+		c.Next()
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+}
+
+func TestRebindPostgres(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{`SELECT * FROM users`, `SELECT * FROM users`},
+		{`SELECT * FROM users WHERE id = ?`, `SELECT * FROM users WHERE id = $1`},
+		{`UPDATE quizzes SET is_graded = ? WHERE id = ? AND is_graded`, `UPDATE quizzes SET is_graded = $1 WHERE id = $2 AND is_graded`},
+		{`SELECT 'what?' AS "huh?" FROM t WHERE a = ? AND b LIKE '%?%'`, `SELECT 'what?' AS "huh?" FROM t WHERE a = $1 AND b LIKE '%?%'`},
+		{`SELECT 'it''s?' WHERE x IN (?, ?)`, `SELECT 'it''s?' WHERE x IN ($1, $2)`},
+	}
+	for _, test := range tests {
+		if got := rebindPostgres(test.in); got != test.out {
+			t.Errorf("rebindPostgres(%q): got %q, expected %q", test.in, got, test.out)
+		}
+	}
+}
+
+func TestDatabaseRoundTrip(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		now := time.Now().Round(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		defer tx.Rollback()
+
+		// problem types come from setup/problemtypes.sql
+		problemType, err := getProblemType(tx, "python3unittest")
+		if err != nil {
+			t.Fatalf("getProblemType: %v", err)
+		}
+		if problemType.Actions["grade"] == nil || problemType.Actions["grade"].Interactive || !problemType.Actions["debug"].Interactive {
+			t.Errorf("problem type actions did not load correctly: %v", problemType.Actions)
+		}
+
+		// a problem with two steps in a problem set
+		problem := &Problem{Unique: "hello", Note: "Hello, World", Tags: []string{"Intro"}, Options: []string{}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problems", problem); err != nil {
+			t.Fatalf("inserting problem: %v", err)
+		}
+		for n := int64(1); n <= 2; n++ {
+			step := &ProblemStep{
+				ProblemID:   problem.ID,
+				Step:        n,
+				ProblemType: "python3unittest",
+				Note:        fmt.Sprintf("step %d", n),
+				Weight:      float64(n),
+				Files:       map[string][]byte{"tests/test.py": []byte("import unittest\n")},
+				Whitelist:   map[string]bool{"hello.py": true},
+				Solution:    map[string][]byte{"hello.py": {0, 1, 2, 0xff}},
+			}
+			if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+				t.Fatalf("inserting problem step: %v", err)
+			}
+		}
+		set := &ProblemSet{Unique: "hello-set", Note: "Hello set", Tags: []string{"Intro"}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+			t.Fatalf("inserting problem set: %v", err)
+		}
+		if err := meddler.Insert(tx, "problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 1.0}); err != nil {
+			t.Fatalf("inserting problem set problem: %v", err)
+		}
+
+		// an LTI launch creates the user, course, and assignment
+		form := &LTIRequest{
+			PersonNameFull:            "Pat Student",
+			PersonContactEmailPrimary: "pat@example.edu",
+			UserID:                    "user-lti-id",
+			ContextTitle:              "CS 1400",
+			ContextLabel:              "CS-1400",
+			ContextID:                 "course-lti-id",
+			ResourceLinkID:            "assignment-lti-id",
+			PersonSourcedID:           "grade-id",
+			CanvasUserLoginID:         "pat",
+			CanvasUserID:              3000000000001,
+			CanvasCourseID:            3000000000002,
+			CanvasAssignmentTitle:     "Hello",
+			CanvasAssignmentID:        3000000000003,
+			CanvasAssignmentDueAt:     now.Format(canvasDateFormat),
+		}
+		user, course, asst := launchTestAssignment(t, tx, form, now, set)
+
+		// the same launch again finds the same records
+		again, err := getUpdateAssignment(tx, form, now, course, set, user)
+		if err != nil {
+			t.Fatalf("getUpdateAssignment again: %v", err)
+		}
+		if again.ID != asst.ID || again.DueAt == nil || !again.DueAt.Equal(now) {
+			t.Errorf("assignment did not round trip: got id %d due %v, expected id %d due %v", again.ID, again.DueAt, asst.ID, now)
+		}
+
+		// commits keep binary files and report cards intact
+		commit := &Commit{
+			AssignmentID: asst.ID,
+			ProblemID:    problem.ID,
+			Step:         1,
+			Action:       "grade",
+			Files:        map[string][]byte{"hello.py": []byte("print('hello?')\n")},
+			Transcript:   []*EventMessage{},
+			ReportCard:   &ReportCard{Passed: true, Note: "all good"},
+			Score:        1.0,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := meddler.Insert(tx, "commits", commit); err != nil {
+			t.Fatalf("inserting commit: %v", err)
+		}
+		loaded := new(Commit)
+		if err := meddler.QueryRow(tx, loaded, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ?`, asst.ID, problem.ID, 1); err != nil {
+			t.Fatalf("loading commit: %v", err)
+		}
+		if string(loaded.Files["hello.py"]) != "print('hello?')\n" || loaded.ReportCard == nil || !loaded.ReportCard.Passed || loaded.Score != 1.0 {
+			t.Errorf("commit did not round trip: %v", loaded)
+		}
+		steps := []*ProblemStep{}
+		if err := meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, problem.ID); err != nil {
+			t.Fatalf("loading problem steps: %v", err)
+		}
+		if len(steps) != 2 || string(steps[0].Solution["hello.py"]) != "\x00\x01\x02\xff" {
+			t.Errorf("problem steps did not round trip: %v", steps)
+		}
+
+		// weights and case-insensitive searches through the views
+		majorWeights, minorWeights, err := GetProblemWeights(tx, asst)
+		if err != nil {
+			t.Fatalf("GetProblemWeights: %v", err)
+		}
+		if majorWeights["hello"] != 1.0 || len(minorWeights["hello"]) != 2 || minorWeights["hello"][1] != 2.0 {
+			t.Errorf("GetProblemWeights: got %v and %v", majorWeights, minorWeights)
+		}
+		where, args := addWhereLike("", nil, "search_text", "PAT@EXAMPLE")
+		var found int64
+		if err := tx.QueryRow(`SELECT assignment_id FROM assignment_search_fields`+where, args...).Scan(&found); err != nil {
+			t.Fatalf("searching assignments: %v", err)
+		}
+		if found != asst.ID {
+			t.Errorf("search found assignment %d, expected %d", found, asst.ID)
+		}
+		quiz := &Quiz{AssignmentID: asst.ID, LtiID: form.ResourceLinkID, Note: "quiz", Weight: 2.0, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "quizzes", quiz); err != nil {
+			t.Fatalf("inserting quiz: %v", err)
+		}
+		question := &Question{QuizID: quiz.ID, Number: 1, Note: "q1", Weight: 1.0, Answers: []Answer{}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "questions", question); err != nil {
+			t.Fatalf("inserting question: %v", err)
+		}
+		quizWeights, _, err := GetQuizWeights(tx, form.ResourceLinkID)
+		if err != nil {
+			t.Fatalf("GetQuizWeights: %v", err)
+		}
+		if quizWeights[fmt.Sprint(quiz.ID)] != 2.0 {
+			t.Errorf("GetQuizWeights: got %v", quizWeights)
+		}
+	})
+}
+
+// openTestPools creates a SQLite database with the schema loaded and opens it
+// the way the TA does, with a pool for writers and a pool for readers.
+func openTestPools(tb testing.TB) (db, readDB *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "codegrinder")
+	if err != nil {
+		tb.Fatalf("creating temporary directory: %v", err)
+	}
+	path := filepath.Join(dir, "codegrinder.db")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		tb.Fatalf("creating database file: %v", err)
+	}
+	db = setupDB(sqliteDriver, path)
+	loadSchema(tb, db, sqliteDriver)
+	readDB = setupReadDB(sqliteDriver, path, db)
+	return db, readDB, func() {
+		readDB.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWithTransaction(t *testing.T) {
+	db, readDB, cleanup := openTestPools(t)
+	defer cleanup()
+
+	now := time.Now().Round(time.Second)
+	started, release := make(chan struct{}), make(chan struct{})
+	m := martini.New()
+	m.Use(render.Renderer())
+	r := martini.NewRouter()
+	withTx := withTransaction(db, readDB)
+	r.Get("/v2/users/count", withTx, func(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM users`).Scan(&count); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		render.JSON(http.StatusOK, count)
+	})
+	r.Get("/v2/users/sneaky", withTx, func(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+		if _, err := tx.Exec(`DELETE FROM users`); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		render.JSON(http.StatusOK, "deleted")
+	})
+	r.Post("/v2/users", withTx, func(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+		name := r.FormValue("name")
+		user := &User{Name: name, Email: name + "@example.com", CanvasLogin: name, LtiID: name, CreatedAt: now, UpdatedAt: now, LastSignedInAt: now}
+		fmt.Sscan(r.FormValue("id"), &user.CanvasID)
+		if err := meddler.Insert(tx, "users", user); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if r.FormValue("hold") != "" {
+			close(started)
+			<-release
+		}
+		render.JSON(http.StatusOK, user)
+	})
+	m.Action(r.Handle)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// a writer holds its transaction open
+	held := make(chan int)
+	go func() { held <- request("POST", "/v2/users?name=held&id=100&hold=true").Code }()
+	<-started
+
+	// readers carry on and see the last committed state
+	read := make(chan *httptest.ResponseRecorder)
+	go func() { read <- request("GET", "/v2/users/count") }()
+	select {
+	case w := <-read:
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "0" {
+			t.Errorf("reading during a write: got status %d: %s", w.Code, w.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reading during a write: still waiting after 5 seconds")
+	}
+	if w := request("GET", "/v2/users/sneaky"); w.Code != http.StatusInternalServerError {
+		t.Errorf("writing from a GET request: got status %d", w.Code)
+	}
+
+	// other writers wait their turn
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = request("POST", fmt.Sprintf("/v2/users?name=user%d&id=%d", i, i+1)).Code
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if code := <-held; code != http.StatusOK {
+		t.Errorf("held writer: got status %d", code)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("writer %d: got status %d", i, code)
+		}
+	}
+	if w := request("GET", "/v2/users/count"); strings.TrimSpace(w.Body.String()) != "11" {
+		t.Errorf("after writing: got %s users, expected 11", w.Body.String())
+	}
+}
+
+func TestConcurrentCommits(t *testing.T) {
+	Config.DaycareSecret = "daycare secret"
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		// a problem set with two one-step problems
+		now := time.Now().Round(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		set := &ProblemSet{Unique: "pair", Note: "Pair", Tags: []string{}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+			t.Fatalf("inserting problem set: %v", err)
+		}
+		var problems []*Problem
+		for _, unique := range []string{"one", "two"} {
+			problem := &Problem{Unique: unique, Note: unique, Tags: []string{}, Options: []string{}, Revision: 1, CreatedAt: now, UpdatedAt: now}
+			if err := meddler.Insert(tx, "problems", problem); err != nil {
+				t.Fatalf("inserting problem: %v", err)
+			}
+			step := &ProblemStep{
+				ProblemID:   problem.ID,
+				Step:        1,
+				ProblemType: "python3unittest",
+				Note:        unique,
+				Weight:      1.0,
+				Files:       map[string][]byte{"doc/doc.md": []byte(unique + "\n")},
+				Whitelist:   map[string]bool{"hello.py": true},
+			}
+			if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+				t.Fatalf("inserting problem step: %v", err)
+			}
+			if err := publishProblemRevision(tx, problem); err != nil {
+				t.Fatalf("publishProblemRevision: %v", err)
+			}
+			if err := meddler.Insert(tx, "problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 1.0}); err != nil {
+				t.Fatalf("inserting problem set problem: %v", err)
+			}
+			problems = append(problems, problem)
+		}
+		form := testLaunchForm("alice", "Learner", 1)
+		form.PersonSourcedID = "grade-id"
+		form.OutcomeServiceURL = "https://lms.example.edu/outcomes"
+		user, _, asst := launchTestAssignment(t, tx, form, now, set)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		m := martini.New()
+		m.Use(render.Renderer())
+		r := martini.NewRouter()
+		withTx := withTransaction(db, db)
+		withUser := func(c martini.Context) { c.Map(user) }
+		r.Post("/v2/commit_bundles/unsigned", withTx, withUser, binding.Json(CommitBundle{}), PostCommitBundlesUnsigned)
+		r.Post("/v2/commit_bundles/signed", withTx, withUser, binding.Json(CommitBundle{}), PostCommitBundlesSigned)
+		m.Action(r.Handle)
+		request := func(path string, bundle *CommitBundle) (int, *CommitBundle) {
+			raw, err := json.Marshal(bundle)
+			if err != nil {
+				t.Errorf("encoding bundle: %v", err)
+				return 0, nil
+			}
+			req := httptest.NewRequest("POST", path, bytes.NewReader(raw))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, req)
+			result := new(CommitBundle)
+			if w.Code == http.StatusOK {
+				if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+					t.Errorf("decoding bundle: %v", err)
+				}
+			}
+			return w.Code, result
+		}
+
+		// start a commit for each problem, and have a daycare pass it
+		var graded []*CommitBundle
+		for _, problem := range problems {
+			commit := &Commit{
+				AssignmentID: asst.ID,
+				ProblemID:    problem.ID,
+				Step:         1,
+				Action:       "grade",
+				Files:        map[string][]byte{"hello.py": []byte("print('hello')\n")},
+			}
+			code, bundle := request("/v2/commit_bundles/unsigned", &CommitBundle{UserID: user.ID, Commit: commit})
+			if code != http.StatusOK {
+				t.Fatalf("starting a commit for %s: got status %d", problem.Unique, code)
+			}
+			bundle.Hostname = "daycare.example.com"
+			bundle.Commit.ReportCard = &ReportCard{Passed: true, Results: []*ReportCardResult{}}
+			bundle.Commit.Transcript = []*EventMessage{}
+			bundle.CommitSignature = bundle.Commit.ComputeSignature(Config.DaycareSecret,
+				bundle.ProblemTypeSignature, bundle.ProblemSignature, bundle.Hostname, bundle.UserID)
+			graded = append(graded, &CommitBundle{
+				Hostname:        bundle.Hostname,
+				UserID:          bundle.UserID,
+				Commit:          bundle.Commit,
+				CommitSignature: bundle.CommitSignature,
+			})
+		}
+
+		// both graded commits arrive at once, and neither score is lost
+		var wg sync.WaitGroup
+		for _, bundle := range graded {
+			wg.Add(1)
+			go func(bundle *CommitBundle) {
+				defer wg.Done()
+				if code, _ := request("/v2/commit_bundles/signed", bundle); code != http.StatusOK {
+					t.Errorf("saving graded commit for problem %d: got status %d", bundle.Commit.ProblemID, code)
+				}
+			}(bundle)
+		}
+		wg.Wait()
+
+		saved := new(Assignment)
+		if err := meddler.Load(db, "assignments", saved, asst.ID); err != nil {
+			t.Fatalf("loading assignment: %v", err)
+		}
+		for _, problem := range problems {
+			if scores := saved.RawScores[problem.Unique]; len(scores) != 1 || scores[0] != 1.0 {
+				t.Errorf("problem %s: got scores %v", problem.Unique, scores)
+			}
+		}
+		if saved.Score != 1.0 {
+			t.Errorf("assignment score: got %v, expected 1.0", saved.Score)
+		}
+		var posts int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM grade_posts WHERE assignment_id = ?`, asst.ID).Scan(&posts); err != nil || posts != 1 {
+			t.Errorf("grade posts: found %d, err %v", posts, err)
+		}
+	})
+}
+
+// BenchmarkDeadlineNight simulates the night an assignment is due:
+// many students at once fetching their step and last commit,
+// with one request in five saving a new commit.
+// The global-lock case runs every transaction one at a time
+// the way the TA used to, for comparison.
+func BenchmarkDeadlineNight(b *testing.B) {
+	const students = 50
+	db, readDB, cleanup := openTestPools(b)
+	defer cleanup()
+
+	// a one-step problem assigned to every student
+	now := time.Now().Round(time.Second)
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatalf("begin: %v", err)
+	}
+	problem := &Problem{Unique: "hello", Note: "Hello", Tags: []string{}, Options: []string{}, Revision: 1, CreatedAt: now, UpdatedAt: now}
+	if err := meddler.Insert(tx, "problems", problem); err != nil {
+		b.Fatalf("inserting problem: %v", err)
+	}
+	step := &ProblemStep{
+		ProblemID:   problem.ID,
+		Step:        1,
+		ProblemType: "python3unittest",
+		Note:        "Say hello",
+		Weight:      1.0,
+		Files:       map[string][]byte{"doc/doc.md": []byte("Say hello\n"), "tests/test.py": []byte("import hello\n")},
+		Whitelist:   map[string]bool{"hello.py": true},
+		Solution:    map[string][]byte{"hello.py": []byte("print('hello')\n")},
+	}
+	if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+		b.Fatalf("inserting problem step: %v", err)
+	}
+	if err := publishProblemRevision(tx, problem); err != nil {
+		b.Fatalf("publishProblemRevision: %v", err)
+	}
+	set := &ProblemSet{Unique: "hello", Note: "Hello", Tags: []string{}, CreatedAt: now, UpdatedAt: now}
+	if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+		b.Fatalf("inserting problem set: %v", err)
+	}
+	if err := meddler.Insert(tx, "problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 1.0}); err != nil {
+		b.Fatalf("inserting problem set problem: %v", err)
+	}
+	users := make([]*User, students)
+	assignments := make([]*Assignment, students)
+	for i := range users {
+		form := testLaunchForm(fmt.Sprintf("student%d", i), "Learner", int64(i+1))
+		users[i], _, assignments[i] = launchTestAssignment(b, tx, form, now, set)
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("commit: %v", err)
+	}
+
+	// saving a commit without the trip to the daycare
+	saveCommit := func(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+		assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+		if err != nil {
+			return
+		}
+		commit := &Commit{
+			AssignmentID: assignmentID,
+			ProblemID:    problem.ID,
+			Step:         1,
+			Files:        map[string][]byte{"hello.py": []byte("print('hello')\n")},
+			Transcript:   []*EventMessage{},
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+		if err := meddler.Insert(tx, "commits", commit); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		render.JSON(http.StatusOK, commit)
+	}
+
+	for _, mode := range []string{"global-lock", "concurrent"} {
+		b.Run(mode, func(b *testing.B) {
+			m := martini.New()
+			m.Use(render.Renderer())
+			r := martini.NewRouter()
+			withTx := withTransaction(db, readDB)
+			if mode == "global-lock" {
+				var lock sync.Mutex
+				concurrent := withTx
+				withTx = func(c martini.Context) {
+					lock.Lock()
+					defer lock.Unlock()
+					c.Invoke(concurrent)
+				}
+			}
+			withCurrentUser := func(c martini.Context, r *http.Request) {
+				var i int
+				fmt.Sscan(r.Header.Get("X-Student"), &i)
+				c.Map(users[i])
+			}
+			r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step", withTx, withCurrentUser, GetAssignmentProblemStep)
+			r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits/last", withTx, withCurrentUser, GetAssignmentProblemStepCommitLast)
+			r.Post("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits", withTx, withCurrentUser, saveCommit)
+			m.Action(r.Handle)
+
+			var next int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := atomic.AddInt64(&next, 1)
+					i := n % students
+					path := fmt.Sprintf("/v2/assignments/%d/problems/%d/steps/1", assignments[i].ID, problem.ID)
+					method := "GET"
+					switch n % 5 {
+					case 0:
+						method, path = "POST", path+"/commits"
+					case 1, 2:
+						path += "/commits/last"
+					}
+					req := httptest.NewRequest(method, path, nil)
+					req.Header.Set("X-Student", fmt.Sprint(i))
+					w := httptest.NewRecorder()
+					m.ServeHTTP(w, req)
+					if w.Code != http.StatusOK && w.Code != http.StatusNotFound {
+						b.Errorf("%s %s: got status %d: %s", method, path, w.Code, w.Body.String())
+					}
+				}
+			})
+		})
+	}
+}
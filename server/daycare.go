@@ -1,7 +1,6 @@
 package main
 
 import (
-	"archive/tar"
 	"bytes"
 	"fmt"
 	"io"
@@ -9,20 +8,16 @@ import (
 	"math/rand"
 	"net/http"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	docker "github.com/fsouza/go-dockerclient"
 	"github.com/go-martini/martini"
 	"github.com/gorilla/websocket"
 	. "github.com/russross/codegrinder/types"
 )
 
-var dockerClient *docker.Client
-
 type limits struct {
 	maxCPU      int64
 	maxSession  int64
@@ -85,6 +80,20 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	// CORS header for browser-based requests if the TA is a different host than the daycare
 	w.Header().Set("Access-Control-Allow-Origin", "https://"+Config.TAHostname)
 
+	// turn the request away before the upgrade if this daycare is shutting down
+	// or there is no room to wait
+	if admission.isDraining() {
+		w.Header().Set("Retry-After", "5")
+		loggedHTTPErrorf(w, http.StatusServiceUnavailable, "daycare is shutting down; try again")
+		return
+	}
+	if admission.full() {
+		retry := admission.retryAfter()
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+		loggedHTTPErrorf(w, http.StatusServiceUnavailable, "daycare is busy; try again in %v", retry)
+		return
+	}
+
 	// get a websocket
 	socket, err := websocket.Upgrade(w, r, nil, 1024, 1024)
 	if err != nil {
@@ -231,7 +240,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		return
 	}
 	if step.ProblemType != problemType.Name {
-		logAndTransmitErrorf("step number %d in the problem has problem type %q but the commit bundle included problem type %q", step.ProblemType, problemType)
+		logAndTransmitErrorf("step number %d in the problem has problem type %q but the commit bundle included problem type %q", commit.Step, step.ProblemType, problemType.Name)
 		return
 	}
 
@@ -247,16 +256,47 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		files[name] = contents
 	}
 
+	// wait for a container slot
+	admitted := time.Now()
+	ticket, err := admission.enter()
+	if err != nil {
+		retry := admission.retryAfter()
+		msg := fmt.Sprintf("%v; try again in %v", err, retry)
+		log.Print(msg)
+		if err := socket.WriteJSON(&DaycareResponse{Error: msg, RetryAfter: int(retry.Seconds())}); err != nil {
+			// what can we do? we already logged the error
+		}
+		return
+	}
+	defer admission.leave(ticket)
+	err = ticket.wait(func(position int) error {
+		res := &DaycareResponse{Event: &EventMessage{Time: time.Now(), Event: "queue", QueuePosition: position}}
+		return socket.WriteJSON(res)
+	})
+	if err == errDraining {
+		log.Printf("request refused while waiting for a container: %v", err)
+		if err := socket.WriteJSON(&DaycareResponse{Error: err.Error() + "; try again", RetryAfter: 5}); err != nil {
+			// what can we do? we already logged the error
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("request abandoned while waiting for a container: %v", err)
+		return
+	}
+
 	// launch a nanny process
 	nannyName := fmt.Sprintf("nanny-%d", req.CommitBundle.UserID)
 	//log.Printf("launching container for %s", nannyName)
 	limits := newLimits(action)
 	limits.override(problem.Options)
+	containerStart := time.Now()
 	n, err := NewNanny(req.CommitBundle.ProblemType, problem, action.Interactive, action.Action, args, limits, nannyName)
 	if err != nil {
 		logAndTransmitErrorf("error creating container: %v", err)
 		return
 	}
+	containerStartDuration.Observe(time.Since(containerStart).Seconds())
 	rw := newReadWriteBuffer()
 
 	// watch for timeouts
@@ -277,6 +317,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 					alive = nil
 				}
 			case <-t.C:
+				actionTimeouts.WithLabelValues(problemType.Name, action.Action).Inc()
 				if err := n.Shutdown("timeout"); err != nil {
 					log.Printf("error shutting down container: %v", err)
 				}
@@ -398,16 +439,14 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	}
 	cmd := strings.Fields(action.Command)
 	switch {
-	case action.Parser == "xunit":
-		runAndParseXUnit(n, cmd)
-
-	case action.Parser == "check":
-		runAndParseCheckXML(n, cmd)
-
 	case action.Parser != "":
-		n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s",
-			action.Parser, action.ProblemType, action.Action)
-		return
+		parser, exists := ReportCardParsers[action.Parser]
+		if !exists {
+			n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s",
+				action.Parser, action.ProblemType, action.Action)
+			return
+		}
+		runAndParse(n, cmd, parser)
 
 	default:
 		_, _, _, status, err := n.Exec(cmd, stdin, true)
@@ -420,8 +459,20 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		}
 	}
 
+	actionDuration.WithLabelValues(problemType.Name, action.Action).Observe(time.Since(n.Start).Seconds())
+	if !n.isClosed() && n.outOfMemory() {
+		oomKills.WithLabelValues(problemType.Name, action.Action).Inc()
+		n.ReportCard.LogAndFailf("the program ran out of memory")
+	}
 	commit.ReportCard = n.ReportCard
 
+	// interactive actions wait on the student, so only the others count toward load balancing
+	latency := time.Since(admitted)
+	gradingLatency.WithLabelValues(problemType.Name, action.Action).Observe(latency.Seconds())
+	if !action.Interactive {
+		admission.recordLatency(latency)
+	}
+
 	// download any files?
 	for _, option := range problem.Options {
 		parts := strings.SplitN(option, "=", 2)
@@ -448,22 +499,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	// send the final commit back to the client
 	if commit.Action == "grade" {
 		// compute the score for this step on a scale of 0.0 to 1.0
-		if commit.ReportCard.Passed {
-			// award full credit for this step
-			commit.Score = 1.0
-		} else if len(commit.ReportCard.Results) == 0 {
-			// no results? fail...
-			commit.Score = 0.0
-		} else {
-			// compute partial credit for this step
-			passed := 0
-			for _, elt := range commit.ReportCard.Results {
-				if elt.Outcome == "passed" {
-					passed++
-				}
-			}
-			commit.Score = float64(passed) / float64(len(commit.ReportCard.Results))
-		}
+		commit.Score = commit.ReportCard.ComputeScore()
 		commit.UpdatedAt = now
 		req.CommitBundle.CommitSignature = commit.ComputeSignature(Config.DaycareSecret, req.CommitBundle.ProblemTypeSignature, req.CommitBundle.ProblemSignature, req.CommitBundle.Hostname, req.CommitBundle.UserID)
 
@@ -476,151 +512,97 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	log.Printf("handler for %s finished", nannyName)
 }
 
+// liveNannies tracks every nanny whose container has not been shut down,
+// so they can all be cleaned up when the daycare exits.
+var liveNannies = struct {
+	sync.Mutex
+	nannies map[*Nanny]bool
+}{nannies: make(map[*Nanny]bool)}
+
+// shutdownNannies shuts down every container that is still running
+// and returns the number it found.
+func shutdownNannies(msg string) int {
+	liveNannies.Lock()
+	var list []*Nanny
+	for n := range liveNannies.nannies {
+		list = append(list, n)
+	}
+	liveNannies.Unlock()
+
+	for _, n := range list {
+		log.Printf("shutting down container %s: %s", n.Name, msg)
+		if err := n.Shutdown(msg); err != nil {
+			log.Printf("error shutting down container: %v", err)
+		}
+	}
+	return len(list)
+}
+
 type Nanny struct {
 	Name       string
 	Start      time.Time
-	Container  *docker.Container
+	Sandbox    Sandbox
 	UID        int64
 	ReportCard *ReportCard
 	Input      chan string
 	Events     chan *EventMessage
 	Transcript []*EventMessage
 	Closed     bool
+	Killed     bool
 	Files      map[string][]byte
-}
-
-var getContainerIDRE = regexp.MustCompile(`The name .* is already in use by container (.*)\. You have to delete \(or rename\) that container to be able to reuse that name`)
 
-func getContainerID(msg string) string {
-	groups := getContainerIDRE.FindStringSubmatch(msg)
-	if len(groups) != 2 {
-		return ""
-	}
-	return groups[1]
+	// closeLock guards Closed, since Shutdown can be called from the
+	// action handler and from shutdownNannies at the same time
+	closeLock sync.Mutex
 }
 
 func NewNanny(problemType *ProblemType, problem *Problem, interactive bool, action string, args []string, limits *limits, name string) (*Nanny, error) {
-	// create a container
-	mem := limits.maxMemory * 1024 * 1024
-	disk := limits.maxFileSize * 1024 * 1024
-	uid, err := allocUID()
-	if err != nil {
-		return nil, err
-	}
-
 	timeLimit := limits.maxCPU * 2
 	if interactive {
 		timeLimit = limits.maxSession
 	}
-	config := &docker.Config{
-		Hostname:        name,
-		User:            uidgid(uid),
-		Memory:          int64(mem),
-		MemorySwap:      -1,
-		Cmd:             []string{"/bin/sleep", strconv.FormatInt(timeLimit, 10) + "s"},
-		Env:             []string{"USER=student", "HOME=/home/student"},
-		Image:           problemType.Image,
-		NetworkDisabled: true,
+	spec := &SandboxSpec{
+		Name:      name,
+		Owner:     Config.Hostname,
+		Image:     problemType.Image,
+		Limits:    limits,
+		TimeLimit: timeLimit,
 	}
 	for _, s := range args {
 		if strings.HasPrefix(s, "COLUMNS=") {
-			config.Env = append(config.Env, s)
+			spec.Env = append(spec.Env, s)
 		}
 		if strings.HasPrefix(s, "LINES=") {
-			config.Env = append(config.Env, s)
+			spec.Env = append(spec.Env, s)
 		}
 		if strings.HasPrefix(s, "TERM=") {
-			config.Env = append(config.Env, s)
+			spec.Env = append(spec.Env, s)
 		}
 	}
 
-	hostConfig := &docker.HostConfig{
-		CapDrop: []string{
-			"NET_RAW",
-			"NET_BIND_SERVICE",
-			"AUDIT_READ",
-			"AUDIT_WRITE",
-			"DAC_OVERRIDE",
-			"SETFCAP",
-			"SETPCAP",
-			"SETGID",
-			"SETUID",
-			"MKNOD",
-			"CHOWN",
-			"FOWNER",
-			"FSETID",
-			"KILL",
-			"SYS_CHROOT",
-		},
-		PidsLimit: &limits.maxThreads,
-		Ulimits: []docker.ULimit{
-			{Name: "core", Soft: 0, Hard: 0},
-			{Name: "cpu", Soft: limits.maxCPU, Hard: limits.maxCPU},
-			{Name: "data", Soft: mem, Hard: mem},
-			{Name: "fsize", Soft: disk, Hard: disk},
-			{Name: "memlock", Soft: 0, Hard: 0},
-			{Name: "nofile", Soft: limits.maxFD, Hard: limits.maxFD},
-			{Name: "nproc", Soft: limits.maxThreads, Hard: limits.maxThreads},
-			//{Name: "stack", Soft: mem, Hard: mem},
-		},
-		Tmpfs: map[string]string{
-			"/home/student": fmt.Sprintf("rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", disk/1024, uid, uid),
-			"/tmp":          fmt.Sprintf("rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", disk/1024, uid, uid),
-		},
-		ReadonlyRootfs: true,
-	}
-
-	log.Printf("new container %s; action %s on %s (%s); params cpu=%d, fd=%d, file=%d, mem=%d, threads=%d",
-		name, action, problem.Unique, problemType.Name,
-		limits.maxCPU, limits.maxFD, limits.maxFileSize, limits.maxMemory, limits.maxThreads)
-	container, err := dockerClient.CreateContainer(docker.CreateContainerOptions{
-		Name:       name,
-		Config:     config,
-		HostConfig: hostConfig,
-	})
-	if err != nil {
-		if err == docker.ErrContainerAlreadyExists {
-			// container already exists with that name--try killing it
-			log.Printf("killing existing container with same name %s", name)
-			err2 := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-				ID:    name,
-				Force: true,
-			})
-			if err2 != nil {
-				log.Printf("error killing existing container with same name: %v", err2)
-				releaseUID(uid)
-				return nil, err2
-			}
-
-			// try it one more time
-			container, err = dockerClient.CreateContainer(docker.CreateContainerOptions{Name: name, Config: config, HostConfig: hostConfig})
+	// use a warm container if one is ready, or else create a sandbox
+	sandbox, uid, warm := warmContainers.take(problemType.Name, spec)
+	kind := "warm container"
+	if !warm {
+		var err error
+		if uid, err = allocUID(); err != nil {
+			return nil, err
 		}
-		if err != nil {
-			log.Printf("CreateContainer: %v", err)
+		spec.UID = uid
+		kind = "new container"
+		if sandbox, err = sandboxes.Create(spec); err != nil {
 			releaseUID(uid)
 			return nil, err
 		}
 	}
+	log.Printf("%s %s; action %s on %s (%s); params cpu=%d, fd=%d, file=%d, mem=%d, threads=%d",
+		kind, name, action, problem.Unique, problemType.Name,
+		limits.maxCPU, limits.maxFD, limits.maxFileSize, limits.maxMemory, limits.maxThreads)
 
-	// start it
-	err = dockerClient.StartContainer(container.ID, nil)
-	if err != nil {
-		log.Printf("StartContainer: %v", err)
-		releaseUID(uid)
-		err2 := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-			ID:    container.ID,
-			Force: true,
-		})
-		if err2 != nil {
-			log.Printf("RemoveContainer: %v", err2)
-		}
-		return nil, err
-	}
-
-	return &Nanny{
+	n := &Nanny{
 		Name:       name,
 		Start:      time.Now(),
-		Container:  container,
+		Sandbox:    sandbox,
 		UID:        uid,
 		ReportCard: NewReportCard(),
 		Input:      make(chan string),
@@ -628,21 +610,30 @@ func NewNanny(problemType *ProblemType, problem *Problem, interactive bool, acti
 		Transcript: []*EventMessage{},
 		Closed:     false,
 		Files:      nil,
-	}, nil
+	}
+	liveNannies.Lock()
+	liveNannies.nannies[n] = true
+	liveNannies.Unlock()
+	return n, nil
 }
 
+// Shutdown removes the container and releases its UID.
+// Only the first call does anything.
 func (n *Nanny) Shutdown(msg string) error {
-	if n.Closed {
+	n.closeLock.Lock()
+	closed := n.Closed
+	n.Closed = true
+	n.closeLock.Unlock()
+	if closed {
 		return nil
 	}
-	n.Closed = true
+	liveNannies.Lock()
+	delete(liveNannies.nannies, n)
+	liveNannies.Unlock()
 
 	// shut down the container
 	//log.Printf("shutting down %s: %s", n.Name, msg)
-	err := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-		ID:    n.Container.ID,
-		Force: true,
-	})
+	err := n.Sandbox.Remove()
 	releaseUID(n.UID)
 	if err != nil {
 		log.Printf("Nanny.Shutdown: %v", err)
@@ -651,6 +642,25 @@ func (n *Nanny) Shutdown(msg string) error {
 	return nil
 }
 
+// isClosed reports whether Shutdown has been called.
+func (n *Nanny) isClosed() bool {
+	n.closeLock.Lock()
+	defer n.closeLock.Unlock()
+	return n.Closed
+}
+
+// outOfMemory reports whether the kernel killed a process in the container
+// for exceeding its memory limit. If the sandbox cannot tell, a command that
+// was killed by SIGKILL while the container was still up is taken as the sign.
+func (n *Nanny) outOfMemory() bool {
+	kills, err := n.Sandbox.OOMKills()
+	if err != nil {
+		log.Printf("checking for out-of-memory kills in %s: %v", n.Name, err)
+		return n.Killed
+	}
+	return kills > 0
+}
+
 // PutFiles copies a set of files to the given container.
 // The container must be running.
 func (n *Nanny) PutFiles(files map[string][]byte, mode int64) error {
@@ -659,93 +669,7 @@ func (n *Nanny) PutFiles(files map[string][]byte, mode int64) error {
 		return nil
 	}
 
-	// tar the files
-	nowish := time.Now().Add(-time.Second)
-	buf := new(bytes.Buffer)
-	writer := tar.NewWriter(buf)
-	dirs := make(map[string]bool)
-	for name, contents := range files {
-		dir := filepath.Dir(name)
-		if dir != "" && !dirs[dir] {
-			dirs[dir] = true
-			header := &tar.Header{
-				Name:       dir,
-				Mode:       0777,
-				Uid:        int(n.UID),
-				Gid:        int(n.UID),
-				Size:       0,
-				ModTime:    nowish,
-				Typeflag:   tar.TypeDir,
-				Uname:      strconv.FormatInt(n.UID, 10),
-				Gname:      strconv.FormatInt(n.UID, 10),
-				AccessTime: nowish,
-				ChangeTime: nowish,
-			}
-			if err := writer.WriteHeader(header); err != nil {
-				log.Printf("writing tar header for directory: %v", err)
-				return err
-			}
-		}
-		header := &tar.Header{
-			Name:       name,
-			Mode:       mode,
-			Uid:        int(n.UID),
-			Gid:        int(n.UID),
-			Size:       int64(len(contents)),
-			ModTime:    nowish,
-			Typeflag:   tar.TypeReg,
-			Uname:      strconv.FormatInt(n.UID, 10),
-			Gname:      strconv.FormatInt(n.UID, 10),
-			AccessTime: nowish,
-			ChangeTime: nowish,
-		}
-		if err := writer.WriteHeader(header); err != nil {
-			log.Printf("writing tar header: %v", err)
-			return err
-		}
-		if _, err := writer.Write(contents); err != nil {
-			log.Printf("writing to tar file: %v", err)
-			return err
-		}
-	}
-	if err := writer.Close(); err != nil {
-		log.Printf("closing tar file: %v", err)
-		return err
-	}
-
-	// exec tar in the container
-	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          false,
-		Cmd:          []string{"/bin/tar", "xf", "-", "-C", "/home/student"},
-		Container:    n.Container.ID,
-		User:         uidgid(n.UID),
-	})
-	if err != nil {
-		log.Printf("creating tar command to upload files: %v", err)
-		return err
-	}
-	out := new(bytes.Buffer)
-	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-		Detach:       false,
-		Tty:          false,
-		InputStream:  buf,
-		OutputStream: out,
-		ErrorStream:  out,
-		RawTerminal:  false,
-	})
-	if err != nil {
-		log.Printf("running tar command to upload files: %v", err)
-		return err
-	}
-	if out.Len() != 0 {
-		log.Printf("tar output: %q", out.String())
-		return fmt.Errorf("tar gave non-empty output when extracting files into container")
-	}
-
-	return nil
+	return n.Sandbox.PutFiles(files, mode)
 }
 
 // GetFiles copies a set of files from the given container.
@@ -762,67 +686,15 @@ func (n *Nanny) GetFiles(filenames []string) (map[string][]byte, error) {
 	// do we need to fetch the files?
 	if n.Files == nil {
 		// cannot fetch files if the container is closed
-		if n.Closed {
+		if n.isClosed() {
 			return nil, nil
 		}
 
-		// exec tar in the container
-		exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
-			AttachStdin:  false,
-			AttachStdout: true,
-			AttachStderr: true,
-			Tty:          false,
-			Cmd:          []string{"/bin/tar", "cf", "-", "-C", "/home/student", "."},
-			Container:    n.Container.ID,
-			User:         uidgid(n.UID),
-		})
-		if err != nil {
-			log.Printf("createing tar command to download files: %v", err)
-			return nil, err
-		}
-		tarFile := new(bytes.Buffer)
-		tarErr := new(bytes.Buffer)
-		err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-			Detach:       false,
-			Tty:          false,
-			InputStream:  nil,
-			OutputStream: tarFile,
-			ErrorStream:  tarErr,
-			RawTerminal:  false,
-		})
+		files, err := n.Sandbox.GetFiles()
 		if err != nil {
-			log.Printf("running tar command to download files: %v", err)
-			return nil, err
-		}
-		if tarErr.Len() != 0 {
-			log.Printf("tar gave non-empty error output when gathering files from container: %q", tarErr.String())
 			return nil, err
 		}
-
-		// extract the files
-		n.Files = make(map[string][]byte)
-		reader := tar.NewReader(bytes.NewReader(tarFile.Bytes()))
-		for {
-			header, err := reader.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				log.Printf("error decoding tar file: %v", err)
-				return nil, err
-			}
-			if header.Typeflag != tar.TypeReg {
-				continue
-			}
-			contents := make([]byte, header.Size)
-			if _, err = io.ReadFull(reader, contents); err != nil {
-				log.Printf("error reading %q from tar file: %v", header.Name, err)
-				return nil, err
-			}
-
-			name := filepath.Clean(header.Name)
-			n.Files[name] = contents
-		}
+		n.Files = files
 	}
 
 	// pick out the requested files
@@ -912,53 +784,26 @@ func (n *Nanny) Exec(cmd []string, stdin io.Reader, useTTY bool) (stdout, stderr
 		ExecCommand: cmd,
 	}
 
-	// create
-	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  stdin != nil,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          useTTY,
-		Cmd:          cmd,
-		Container:    n.Container.ID,
-		User:         uidgid(n.UID),
-	})
-	if err != nil {
-		return nil, nil, nil, -1, err
-	}
-
 	// gather output
 	var out execOutput
 	out.events = n.Events
 
-	// start
-	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-		Detach:       false,
-		Tty:          useTTY,
-		InputStream:  stdin,
-		OutputStream: (*execStdout)(&out),
-		ErrorStream:  (*execStderr)(&out),
-		RawTerminal:  useTTY,
-	})
+	exitCode, err := n.Sandbox.Exec(cmd, stdin, (*execStdout)(&out), (*execStderr)(&out), useTTY)
 	if err != nil {
 		return nil, nil, nil, -1, err
 	}
 
-	// inspect
-	inspect, err := dockerClient.InspectExec(exec.ID)
-	if err != nil {
-		return nil, nil, nil, -1, err
-	}
-	if inspect.Running {
-		return nil, nil, nil, -1, fmt.Errorf("process still running")
-	}
-
 	n.Events <- &EventMessage{
 		Time:       time.Now(),
 		Event:      "exit",
-		ExitStatus: inspect.ExitCode,
+		ExitStatus: exitCode,
+	}
+	if exitCode == 128+9 {
+		// killed by SIGKILL
+		n.Killed = true
 	}
 
-	return &out.stdout, &out.stderr, &out.script, inspect.ExitCode, nil
+	return &out.stdout, &out.stderr, &out.script, exitCode, nil
 }
 
 var uidsInUse map[int64]bool = make(map[int64]bool)
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestSocketProblemTypeActionGrade(t *testing.T) {
+	Config.Hostname = "daycare.example.com"
+	Config.TAHostname = "ta.example.com"
+	Config.DaycareSecret = "daycare secret"
+
+	// the fake grader passes one test and checks the answer with the other
+	runtime := newFakeRuntime(map[string]fakeCommand{
+		"make grade": func(s *fakeSandbox, stdin io.Reader, stdout, stderr io.Writer) int {
+			outcome := "failed"
+			if bytes.Equal(bytes.TrimSpace(s.file("answer.txt")), s.file("tests/expected.txt")) {
+				outcome = "passed"
+			}
+			fmt.Fprintln(stdout, "running tests")
+			s.writeFile("test_detail.json", []byte(`{"results": [`+
+				`{"name": "test_compiles", "outcome": "passed"},`+
+				`{"name": "test_answer [3 pts]", "outcome": "`+outcome+`"}]}`))
+			return 0
+		},
+	})
+	sandboxes = runtime
+	admission = newAdmissionQueue(1, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		SocketProblemTypeAction(w, r, martini.Params{"problem_type": parts[3], "action": parts[4]})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		answer  string
+		passed  bool
+		score   float64
+		results int
+	}{
+		{"42\n", true, 1.0, 2},
+		{"41\n", false, 0.25, 2},
+	}
+	for _, test := range tests {
+		bundle := fakeCommitBundle(test.answer)
+		graded := gradeOverSocket(t, server.URL, bundle)
+		commit := graded.Commit
+		if commit.ReportCard == nil {
+			t.Fatalf("answer %q: no report card", test.answer)
+		}
+		if commit.ReportCard.Passed != test.passed || commit.Score != test.score || len(commit.ReportCard.Results) != test.results {
+			t.Errorf("answer %q: got passed=%v score=%v results=%d, expected passed=%v score=%v results=%d",
+				test.answer, commit.ReportCard.Passed, commit.Score, len(commit.ReportCard.Results),
+				test.passed, test.score, test.results)
+		}
+		typeSig := bundle.ProblemType.ComputeSignature(Config.DaycareSecret)
+		problemSig := bundle.Problem.ComputeSignature(Config.DaycareSecret, bundle.ProblemSteps)
+		if sig := commit.ComputeSignature(Config.DaycareSecret, typeSig, problemSig, bundle.Hostname, bundle.UserID); sig != graded.CommitSignature {
+			t.Errorf("answer %q: graded commit has an invalid signature", test.answer)
+		}
+	}
+
+	if _, _, _, latency := admission.stats(); latency <= 0 {
+		t.Errorf("grading latency was not recorded")
+	}
+
+	for _, s := range runtime.sandboxes {
+		if !s.removed {
+			t.Errorf("sandbox %s was not removed", s.id)
+		}
+	}
+}
+
+func TestSocketProblemTypeActionOutOfMemory(t *testing.T) {
+	Config.Hostname = "daycare.example.com"
+	Config.DaycareSecret = "daycare secret"
+
+	// the grader is killed for using too much memory, and make reports it as an ordinary failure
+	var oomKills, status int
+	runtime := newFakeRuntime(map[string]fakeCommand{
+		"make grade": func(s *fakeSandbox, stdin io.Reader, stdout, stderr io.Writer) int {
+			s.Lock()
+			s.oomKills = oomKills
+			s.Unlock()
+			fmt.Fprintln(stderr, "make: *** [grade] Killed")
+			return status
+		},
+	})
+	sandboxes = runtime
+	admission = newAdmissionQueue(1, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		SocketProblemTypeAction(w, r, martini.Params{"problem_type": parts[3], "action": parts[4]})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		oomKills int
+		status   int
+		oom      bool
+	}{
+		{"cgroup count", 1, 2, true},
+		{"no kills", 0, 2, false},
+		{"no kills but SIGKILL", 0, 137, false},
+		{"no cgroup but SIGKILL", -1, 137, true},
+		{"no cgroup", -1, 2, false},
+	}
+	for _, test := range tests {
+		oomKills, status = test.oomKills, test.status
+		commit := gradeOverSocket(t, server.URL, fakeCommitBundle("42\n")).Commit
+		if commit.ReportCard == nil {
+			t.Fatalf("%s: no report card", test.name)
+		}
+		oom := strings.Contains(commit.ReportCard.Note, "ran out of memory")
+		if oom != test.oom || commit.ReportCard.Passed {
+			t.Errorf("%s: got passed=%v note %q, expected out of memory %v",
+				test.name, commit.ReportCard.Passed, commit.ReportCard.Note, test.oom)
+		}
+	}
+}
+
+// gradeOverSocket sends a commit bundle to the daycare and returns the graded bundle.
+func gradeOverSocket(t *testing.T, serverURL string, bundle *CommitBundle) *CommitBundle {
+	socketURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/v2/sockets/fakeunittest/grade"
+	socket, _, err := websocket.DefaultDialer.Dial(socketURL, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", socketURL, err)
+	}
+	defer socket.Close()
+	if err := socket.WriteJSON(&DaycareRequest{CommitBundle: bundle}); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+
+	for {
+		res := new(DaycareResponse)
+		if err := socket.ReadJSON(res); err != nil {
+			t.Fatalf("reading response: %v", err)
+		}
+		if res.Error != "" {
+			t.Fatalf("daycare returned an error: %s", res.Error)
+		}
+		if res.CommitBundle != nil {
+			return res.CommitBundle
+		}
+	}
+}
+
+func fakeCommitBundle(answer string) *CommitBundle {
+	now := time.Now()
+	problemType := &ProblemType{
+		Name:  "fakeunittest",
+		Image: "codegrinder/fake",
+		Files: map[string][]byte{"Makefile": []byte("grade:\n\ttrue\n")},
+		Actions: map[string]*ProblemTypeAction{
+			"grade": {
+				ProblemType: "fakeunittest",
+				Action:      "grade",
+				Command:     "make grade",
+				Parser:      "json",
+				Message:     "Grading‥",
+				MaxCPU:      10,
+				MaxSession:  30,
+				MaxTimeout:  30,
+				MaxFD:       10,
+				MaxFileSize: 10,
+				MaxMemory:   128,
+				MaxThreads:  10,
+			},
+		},
+	}
+	problem := &Problem{ID: 1, Unique: "fake-problem", Note: "a fake problem", CreatedAt: now, UpdatedAt: now}
+	steps := []*ProblemStep{{
+		ProblemID:   1,
+		Step:        1,
+		ProblemType: "fakeunittest",
+		Note:        "the only step",
+		Weight:      1.0,
+		Files:       map[string][]byte{"tests/expected.txt": []byte("42")},
+		Whitelist:   map[string]bool{"answer.txt": true},
+	}}
+	commit := &Commit{
+		ID:           1,
+		AssignmentID: 1,
+		ProblemID:    1,
+		Step:         1,
+		Action:       "grade",
+		Files:        map[string][]byte{"answer.txt": []byte(answer)},
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	bundle := &CommitBundle{
+		ProblemType:  problemType,
+		Problem:      problem,
+		ProblemSteps: steps,
+		Hostname:     Config.Hostname,
+		UserID:       1,
+		Commit:       commit,
+	}
+	bundle.ProblemTypeSignature = problemType.ComputeSignature(Config.DaycareSecret)
+	bundle.ProblemSignature = problem.ComputeSignature(Config.DaycareSecret, steps)
+	bundle.CommitSignature = commit.ComputeSignature(Config.DaycareSecret, bundle.ProblemTypeSignature, bundle.ProblemSignature, bundle.Hostname, bundle.UserID)
+	return bundle
+}
+
+func TestNannyShutdownConcurrent(t *testing.T) {
+	uid, err := allocUID()
+	if err != nil {
+		t.Fatalf("allocUID: %v", err)
+	}
+	runtime := newFakeRuntime(nil)
+	sandbox, err := runtime.Create(&SandboxSpec{Name: "nanny-shutdown", Owner: "daycare.example.com", UID: uid})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	n := &Nanny{Name: "nanny-shutdown", Sandbox: sandbox, UID: uid}
+	liveNannies.Lock()
+	liveNannies.nannies[n] = true
+	liveNannies.Unlock()
+
+	// the action handler and a daycare shutdown race to close it
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.Shutdown("action finished")
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shutdownNannies("daycare shutting down")
+	}()
+	wg.Wait()
+
+	s := sandbox.(*fakeSandbox)
+	s.Lock()
+	removals := s.removals
+	s.Unlock()
+	if removals != 1 {
+		t.Errorf("sandbox removed %d times, expected 1", removals)
+	}
+	if !n.isClosed() {
+		t.Errorf("nanny not closed")
+	}
+	uidsMutex.Lock()
+	held := uidsInUse[uid]
+	uidsMutex.Unlock()
+	if held {
+		t.Errorf("UID %d still in use", uid)
+	}
+}
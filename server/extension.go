@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getExtension loads the extension that applies to an assignment, if any.
+// An extension for the specific assignment takes precedence over one for the whole course.
+func getExtension(tx *sql.Tx, assignment *Assignment) (*Extension, error) {
+	ext := new(Extension)
+	err := meddler.QueryRow(tx, ext, `SELECT * FROM extensions `+
+		`WHERE user_id = ? AND course_id = ? AND (lti_id = ? OR lti_id = '') `+
+		`ORDER BY lti_id DESC LIMIT 1`,
+		assignment.UserID, assignment.CourseID, assignment.LtiID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// isCourseInstructor reports whether a user can manage extensions for a course,
+// i.e., is an administrator or an instructor for the course.
+func isCourseInstructor(tx *sql.Tx, user *User, courseID int64) (bool, error) {
+	if user.Admin {
+		return true, nil
+	}
+	var count int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM assignments WHERE course_id = ? AND user_id = ? AND instructor`,
+		courseID, user.ID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// courseInstructorOnly checks the course_id parameter and makes sure the current user
+// is an instructor for the course. It reports any error and returns zero on failure.
+func courseInstructorOnly(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) int64 {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return 0
+	}
+	ok, err := isCourseInstructor(tx, currentUser, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return 0
+	}
+	if !ok {
+		loggedHTTPErrorf(w, http.StatusForbidden, "only an instructor for the course can manage extensions")
+		return 0
+	}
+	return courseID
+}
+
+// GetCourseExtensions handles requests to /v2/courses/:course_id/extensions,
+// returning a list of all extensions granted in the course.
+func GetCourseExtensions(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	courseID := courseInstructorOnly(w, tx, params, currentUser)
+	if courseID == 0 {
+		return
+	}
+
+	extensions := []*Extension{}
+	if err := meddler.QueryAll(tx, &extensions, `SELECT * FROM extensions WHERE course_id = ? ORDER BY user_id, lti_id`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// name the assignments
+	rows, err := tx.Query(`SELECT DISTINCT lti_id, canvas_title FROM assignments WHERE course_id = ?`, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	defer rows.Close()
+	titles := make(map[string]string)
+	for rows.Next() {
+		var ltiID, title string
+		if err := rows.Scan(&ltiID, &title); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		titles[ltiID] = title
+	}
+	if err := rows.Err(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, ext := range extensions {
+		ext.CanvasTitle = titles[ext.LtiID]
+	}
+
+	render.JSON(http.StatusOK, extensions)
+}
+
+// PostCourseExtensions handles POST requests to /v2/courses/:course_id/extensions,
+// granting the same extension to every student in the request.
+// An existing extension for the same student and assignment is replaced.
+// It returns the saved extensions.
+func PostCourseExtensions(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, grant ExtensionGrant, render render.Render) {
+	courseID := courseInstructorOnly(w, tx, params, currentUser)
+	if courseID == 0 {
+		return
+	}
+	if len(grant.UserIDs) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "an extension must be granted to at least one student")
+		return
+	}
+
+	// find the Canvas assignment being extended
+	ltiID, title := "", ""
+	if grant.AssignmentID != 0 {
+		asst := new(Assignment)
+		if err := meddler.Load(tx, "assignments", asst, grant.AssignmentID); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		if asst.CourseID != courseID {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "assignment %d is not part of course %d", asst.ID, courseID)
+			return
+		}
+		ltiID = asst.LtiID
+		title = asst.CanvasTitle
+	}
+
+	now := time.Now()
+	extensions := []*Extension{}
+	for _, userID := range grant.UserIDs {
+		var count int64
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM assignments WHERE course_id = ? AND user_id = ?`, courseID, userID).Scan(&count); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if count == 0 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "user %d is not part of course %d", userID, courseID)
+			return
+		}
+
+		ext := new(Extension)
+		err := meddler.QueryRow(tx, ext, `SELECT * FROM extensions WHERE user_id = ? AND course_id = ? AND lti_id = ?`, userID, courseID, ltiID)
+		if err != nil && err != sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		ext.CourseID = courseID
+		ext.UserID = userID
+		ext.LtiID = ltiID
+		ext.DueAt = grant.DueAt
+		ext.LockAt = grant.LockAt
+		ext.Multiplier = grant.Multiplier
+		ext.Note = grant.Note
+		ext.GrantedBy = currentUser.ID
+		if err := ext.Normalize(now); err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if err := meddler.Save(tx, "extensions", ext); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		log.Printf("extension %d granted to user %d in course %d by %s (%d)", ext.ID, userID, courseID, currentUser.Email, currentUser.ID)
+		ext.CanvasTitle = title
+		extensions = append(extensions, ext)
+	}
+	render.JSON(http.StatusOK, extensions)
+}
+
+// DeleteExtension handles DELETE requests to /v2/extensions/:extension_id,
+// removing an extension so the normal deadlines apply again.
+func DeleteExtension(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	extensionID, err := parseID(w, "extension_id", params["extension_id"])
+	if err != nil {
+		return
+	}
+
+	ext := new(Extension)
+	if err := meddler.Load(tx, "extensions", ext, extensionID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	ok, err := isCourseInstructor(tx, currentUser, ext.CourseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !ok {
+		loggedHTTPErrorf(w, http.StatusForbidden, "only an instructor for the course can manage extensions")
+		return
+	}
+
+	log.Printf("deleting extension %d for user %d in course %d", ext.ID, ext.UserID, ext.CourseID)
+	if _, err := tx.Exec(`DELETE FROM extensions WHERE id = ?`, extensionID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+}
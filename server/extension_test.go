@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestExtensionApply(t *testing.T) {
+	unlock := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	due := unlock.Add(48 * time.Hour)
+	lock := due.Add(24 * time.Hour)
+	later := due.Add(96 * time.Hour)
+
+	tests := []struct {
+		name   string
+		ext    *Extension
+		due    time.Time
+		lock   time.Time
+		noLock bool
+	}{
+		{"none", nil, due, lock, false},
+		{"time and a half", &Extension{Multiplier: 1.5}, unlock.Add(72 * time.Hour), unlock.Add(108 * time.Hour), false},
+		{"new due date", &Extension{Multiplier: 1.0, DueAt: &later}, later, later, false},
+		{"new lock date", &Extension{Multiplier: 1.0, LockAt: &later}, due, later, false},
+	}
+	for _, test := range tests {
+		dueAt, lockAt := test.ext.Apply(&unlock, &due, &lock)
+		if dueAt == nil || !dueAt.Equal(test.due) || lockAt == nil || !lockAt.Equal(test.lock) {
+			t.Errorf("%s: got due %v lock %v, expected due %v lock %v", test.name, dueAt, lockAt, test.due, test.lock)
+		}
+	}
+
+	// without an unlock date, a multiplier has nothing to stretch
+	if dueAt, _ := (&Extension{Multiplier: 2.0}).Apply(nil, &due, nil); !dueAt.Equal(due) {
+		t.Errorf("multiplier without unlock date: got due %v", dueAt)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		// an instructor and two students launch the same assignment
+		now := time.Now().Round(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		users := make(map[string]*User)
+		assignments := make(map[string]*Assignment)
+		for i, name := range []string{"instructor", "alice", "bob"} {
+			role := "Learner"
+			if name == "instructor" {
+				role = "Instructor"
+			}
+			users[name], _, assignments[name] = launchTestAssignment(t, tx, testLaunchForm(name, role, int64(i+1)), now, nil)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		courseID := assignments["alice"].CourseID
+
+		m := martini.New()
+		m.Use(render.Renderer())
+		r := martini.NewRouter()
+		var currentUser *User
+		withTx := testWithTx(t, db, &currentUser)
+		r.Get("/v2/courses/:course_id/extensions", withTx, GetCourseExtensions)
+		r.Post("/v2/courses/:course_id/extensions", withTx, binding.Json(ExtensionGrant{}), PostCourseExtensions)
+		r.Delete("/v2/extensions/:extension_id", withTx, DeleteExtension)
+		m.Action(r.Handle)
+
+		request := func(method, path string, upload interface{}, download interface{}) int {
+			var body bytes.Buffer
+			if upload != nil {
+				if err := json.NewEncoder(&body).Encode(upload); err != nil {
+					t.Fatalf("encoding request: %v", err)
+				}
+			}
+			req := httptest.NewRequest(method, path, &body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, req)
+			if w.Code == http.StatusOK && download != nil {
+				if err := json.Unmarshal(w.Body.Bytes(), download); err != nil {
+					t.Fatalf("decoding response: %v", err)
+				}
+			}
+			return w.Code
+		}
+		path := fmt.Sprintf("/v2/courses/%d/extensions", courseID)
+		extended := now.Add(72 * time.Hour)
+
+		// students cannot grant themselves extensions
+		currentUser = users["alice"]
+		grant := &ExtensionGrant{UserIDs: []int64{users["alice"].ID}, AssignmentID: assignments["alice"].ID, DueAt: &extended}
+		if code := request("POST", path, grant, nil); code != http.StatusForbidden {
+			t.Errorf("student granting an extension: got status %d", code)
+		}
+
+		// the instructor gives both students a course-wide accommodation
+		// and alice an extension on this assignment
+		currentUser = users["instructor"]
+		var saved []*Extension
+		group := &ExtensionGrant{UserIDs: []int64{users["alice"].ID, users["bob"].ID}, Multiplier: 1.5, Note: "accommodation"}
+		if code := request("POST", path, group, &saved); code != http.StatusOK || len(saved) != 2 {
+			t.Fatalf("granting to a group: got status %d with %d extensions", code, len(saved))
+		}
+		if code := request("POST", path, grant, &saved); code != http.StatusOK || len(saved) != 1 {
+			t.Fatalf("granting to a student: got status %d with %d extensions", code, len(saved))
+		}
+		specific := saved[0]
+		var listed []*Extension
+		if code := request("GET", path, nil, &listed); code != http.StatusOK || len(listed) != 3 {
+			t.Errorf("listing extensions: got status %d with %d extensions", code, len(listed))
+		}
+
+		// the assignment extension takes precedence over the course-wide one
+		getExt := func(name string) *Extension {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("begin: %v", err)
+			}
+			defer tx.Rollback()
+			ext, err := getExtension(tx, assignments[name])
+			if err != nil {
+				t.Fatalf("getExtension: %v", err)
+			}
+			return ext
+		}
+		if ext := getExt("alice"); ext == nil || ext.ID != specific.ID || ext.DueAt == nil || !ext.DueAt.Equal(extended) {
+			t.Errorf("alice's extension: got %+v", ext)
+		}
+		if ext := getExt("bob"); ext == nil || ext.LtiID != "" || ext.Multiplier != 1.5 {
+			t.Errorf("bob's extension: got %+v", ext)
+		}
+		if ext := getExt("instructor"); ext != nil {
+			t.Errorf("instructor's extension: got %+v", ext)
+		}
+
+		// removing it leaves the course-wide accommodation in place
+		if code := request("DELETE", fmt.Sprintf("/v2/extensions/%d", specific.ID), nil, nil); code != http.StatusOK {
+			t.Errorf("deleting extension: got status %d", code)
+		}
+		if ext := getExt("alice"); ext == nil || ext.LtiID != "" {
+			t.Errorf("alice's extension after delete: got %+v", ext)
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// weights is the grading weights for an assignment, as returned by
+// GetProblemWeights or GetQuizWeights.
+type weights struct {
+	major map[string]float64
+	minor map[string][]float64
+	err   error
+}
+
+// buildGradebook gathers the scores for every student in a course.
+// If problemSetID is not zero, only assignments for that problem set are included.
+func buildGradebook(tx *sql.Tx, courseID, problemSetID int64, now time.Time) (*Gradebook, error) {
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		return nil, err
+	}
+
+	where := `WHERE course_id = ? AND NOT instructor`
+	args := []interface{}{courseID}
+	if problemSetID != 0 {
+		where += ` AND problem_set_id = ?`
+		args = append(args, problemSetID)
+	}
+	assignments := []*Assignment{}
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments `+where+` ORDER BY user_id, id`, args...); err != nil {
+		return nil, err
+	}
+	users := []*User{}
+	if err := meddler.QueryAll(tx, &users, `SELECT DISTINCT users.* FROM users JOIN assignments ON users.id = assignments.user_id `+
+		`WHERE assignments.course_id = ? AND NOT assignments.instructor ORDER BY users.id`, courseID); err != nil {
+		return nil, err
+	}
+	posts := []*GradePost{}
+	if err := meddler.QueryAll(tx, &posts, `SELECT grade_posts.* FROM grade_posts JOIN assignments ON grade_posts.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = ?`, courseID); err != nil {
+		return nil, err
+	}
+	postsByAssignment := make(map[int64]*GradePost)
+	for _, post := range posts {
+		postsByAssignment[post.AssignmentID] = post
+	}
+
+	students := make(map[int64]*GradebookStudent)
+	gradebook := &Gradebook{
+		CourseID:     course.ID,
+		CourseName:   course.Name,
+		ProblemSetID: problemSetID,
+		CreatedAt:    now,
+		Students:     []*GradebookStudent{},
+	}
+	for _, user := range users {
+		student := &GradebookStudent{
+			UserID:      user.ID,
+			Name:        user.Name,
+			Email:       user.Email,
+			CanvasLogin: user.CanvasLogin,
+			Assignments: []*GradebookAssignment{},
+		}
+		students[user.ID] = student
+		gradebook.Students = append(gradebook.Students, student)
+	}
+
+	quizWeights := make(map[string]*weights)
+	problemSets := make(map[int64]string)
+	for _, asst := range assignments {
+		student := students[asst.UserID]
+		if student == nil {
+			continue
+		}
+		entry := &GradebookAssignment{
+			AssignmentID: asst.ID,
+			CanvasTitle:  asst.CanvasTitle,
+			ProblemSetID: asst.ProblemSetID,
+			Quiz:         asst.ProblemSetID == 0,
+			Score:        asst.Score,
+			Problems:     []*GradebookProblem{},
+		}
+
+		// use the same weights used to post grades
+		var w *weights
+		var last time.Time
+		var err error
+		if entry.Quiz {
+			if w = quizWeights[asst.LtiID]; w == nil {
+				w = new(weights)
+				w.major, w.minor, w.err = GetQuizWeights(tx, asst.LtiID)
+				quizWeights[asst.LtiID] = w
+			}
+			err = tx.QueryRow(`SELECT updated_at FROM responses WHERE assignment_id = ? ORDER BY updated_at DESC LIMIT 1`, asst.ID).Scan(&last)
+		} else {
+			// weights can differ between students pinned to different problem revisions
+			w = new(weights)
+			w.major, w.minor, w.err = GetProblemWeights(tx, asst)
+			if _, ok := problemSets[asst.ProblemSetID]; !ok {
+				var unique string
+				if err := tx.QueryRow(`SELECT unique_id FROM problem_sets WHERE id = ?`, asst.ProblemSetID).Scan(&unique); err != nil {
+					return nil, err
+				}
+				problemSets[asst.ProblemSetID] = unique
+			}
+			entry.ProblemSet = problemSets[asst.ProblemSetID]
+			err = tx.QueryRow(`SELECT created_at FROM commits WHERE assignment_id = ? ORDER BY created_at DESC LIMIT 1`, asst.ID).Scan(&last)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		} else if err == nil {
+			entry.LastCommitAt = &last
+		}
+		if w.err == nil {
+			if entry.Score, err = asst.ComputeScore(w.major, w.minor); err != nil {
+				return nil, err
+			}
+			entry.Problems = gradebookProblems(asst, w, entry.Quiz)
+		}
+
+		// measure lateness from the due date after any extension
+		ext, err := getExtension(tx, asst)
+		if err != nil {
+			return nil, err
+		}
+		entry.DueAt, _ = ext.Apply(asst.UnlockAt, asst.DueAt, asst.LockAt)
+		if entry.DueAt != nil && entry.LastCommitAt != nil && entry.LastCommitAt.After(*entry.DueAt) {
+			entry.DaysLate = int(math.Ceil(entry.LastCommitAt.Sub(*entry.DueAt).Hours() / 24))
+		}
+
+		if post := postsByAssignment[asst.ID]; post != nil {
+			entry.Passback = post.Status
+			entry.PassbackAt = post.SentAt
+			entry.PassbackError = post.LastError
+		}
+		student.Assignments = append(student.Assignments, entry)
+	}
+	return gradebook, nil
+}
+
+// gradebookProblems lists the raw scores for each problem and step of an assignment.
+func gradebookProblems(asst *Assignment, w *weights, quiz bool) []*GradebookProblem {
+	var keys []string
+	for key := range w.major {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if quiz {
+			a, _ := strconv.ParseInt(keys[i], 10, 64)
+			b, _ := strconv.ParseInt(keys[j], 10, 64)
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+
+	problems := []*GradebookProblem{}
+	for _, key := range keys {
+		problem := &GradebookProblem{Unique: key, Weight: w.major[key]}
+		if quiz {
+			problem.Unique = "quiz " + key
+		}
+		scores := asst.RawScores[key]
+		for i, weight := range w.minor[key] {
+			step := &GradebookStep{Weight: weight, LatePenalty: asst.LatePenalty(key, i)}
+			if i < len(scores) {
+				step.RawScore = scores[i]
+			}
+			problem.Steps = append(problem.Steps, step)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// GetCourseGradebook handles requests to /v2/courses/:course_id/gradebook,
+// returning the scores for every student in the course.
+// The problem_set_id parameter limits it to one problem set,
+// and format=csv returns it as a spreadsheet instead of JSON.
+func GetCourseGradebook(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	courseID := courseInstructorOnly(w, tx, params, currentUser)
+	if courseID == 0 {
+		return
+	}
+	var problemSetID int64
+	if s := r.FormValue("problem_set_id"); s != "" {
+		id, err := parseID(w, "problem_set_id", s)
+		if err != nil {
+			return
+		}
+		problemSetID = id
+	}
+
+	gradebook, err := buildGradebook(tx, courseID, problemSetID, time.Now())
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	switch r.FormValue("format") {
+	case "", "json":
+		render.JSON(http.StatusOK, gradebook)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=gradebook-"+strconv.FormatInt(courseID, 10)+".csv")
+		if err := gradebook.WriteCSV(w); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing CSV: %v", err)
+			return
+		}
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unknown format %q", r.FormValue("format"))
+	}
+}
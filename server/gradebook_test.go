@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func TestGradebook(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		now := time.Now().Round(time.Second)
+		due := now.Add(-50 * time.Hour)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		defer tx.Rollback()
+
+		// a problem with two steps in a problem set
+		problem := &Problem{Unique: "hello", Note: "Hello", Tags: []string{}, Options: []string{}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problems", problem); err != nil {
+			t.Fatalf("inserting problem: %v", err)
+		}
+		for n := int64(1); n <= 2; n++ {
+			step := &ProblemStep{ProblemID: problem.ID, Step: n, ProblemType: "python3unittest", Weight: 1.0}
+			if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+				t.Fatalf("inserting problem step: %v", err)
+			}
+		}
+		set := &ProblemSet{Unique: "hello-set", Note: "Hello set", Tags: []string{}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+			t.Fatalf("inserting problem set: %v", err)
+		}
+		if err := meddler.Insert(tx, "problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 1.0}); err != nil {
+			t.Fatalf("inserting problem set problem: %v", err)
+		}
+
+		// an instructor and a student who finished late
+		var student *Assignment
+		for i, role := range []string{"Instructor", "Learner"} {
+			form := testLaunchForm(role, role, int64(i+1))
+			form.ContextTitle = "CS 1400"
+			form.CanvasAssignmentTitle = "Hello"
+			form.CanvasAssignmentDueAt = due.Format(canvasDateFormat)
+			if role == "Learner" {
+				form.PersonSourcedID = "grade-id"
+			}
+			_, _, student = launchTestAssignment(t, tx, form, now, set)
+		}
+		student.RawScores = map[string][]float64{"hello": {1.0, 1.0}}
+		student.SetLatePenalty("hello", 1, 0.2)
+		if err := meddler.Update(tx, "assignments", student); err != nil {
+			t.Fatalf("updating assignment: %v", err)
+		}
+		commit := &Commit{
+			AssignmentID: student.ID,
+			ProblemID:    problem.ID,
+			Step:         2,
+			Files:        map[string][]byte{"hello.py": []byte("print('hello')\n")},
+			Transcript:   []*EventMessage{},
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := meddler.Insert(tx, "commits", commit); err != nil {
+			t.Fatalf("inserting commit: %v", err)
+		}
+		if err := queueGrade(tx, student, "report", now); err != nil {
+			t.Fatalf("queueGrade: %v", err)
+		}
+
+		gradebook, err := buildGradebook(tx, student.CourseID, 0, now)
+		if err != nil {
+			t.Fatalf("buildGradebook: %v", err)
+		}
+		if len(gradebook.Students) != 1 || len(gradebook.Students[0].Assignments) != 1 {
+			t.Fatalf("expected one student with one assignment, got %+v", gradebook.Students)
+		}
+		entry := gradebook.Students[0].Assignments[0]
+		if entry.Score < 0.9-1e-9 || entry.Score > 0.9+1e-9 {
+			t.Errorf("score: got %v, expected 0.9", entry.Score)
+		}
+		if entry.ProblemSet != "hello-set" || len(entry.Problems) != 1 || len(entry.Problems[0].Steps) != 2 || entry.Problems[0].Steps[1].LatePenalty != 0.2 {
+			t.Errorf("problems: got %s %+v", entry.ProblemSet, entry.Problems)
+		}
+		if entry.LastCommitAt == nil || !entry.LastCommitAt.Equal(now) || entry.DaysLate != 3 {
+			t.Errorf("lateness: got last commit %v, %d days late", entry.LastCommitAt, entry.DaysLate)
+		}
+		if entry.Passback != gradePostPending {
+			t.Errorf("passback: got %q", entry.Passback)
+		}
+
+		// an extension changes the days late
+		ext := &Extension{CourseID: student.CourseID, UserID: student.UserID, LtiID: student.LtiID, DueAt: &now, Multiplier: 1.0, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "extensions", ext); err != nil {
+			t.Fatalf("inserting extension: %v", err)
+		}
+		if gradebook, err = buildGradebook(tx, student.CourseID, set.ID, now); err != nil {
+			t.Fatalf("buildGradebook: %v", err)
+		}
+		if entry := gradebook.Students[0].Assignments[0]; entry.DaysLate != 0 {
+			t.Errorf("with extension: got %d days late", entry.DaysLate)
+		}
+
+		var buf bytes.Buffer
+		if err := gradebook.WriteCSV(&buf); err != nil {
+			t.Fatalf("WriteCSV: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 || !strings.HasSuffix(lines[0], ",hello step 1,hello step 2") || !strings.Contains(lines[1], ",Hello,hello-set,0.9,") {
+			t.Errorf("CSV: got %q", buf.String())
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getLatePolicy loads the late policy for an assignment's problem set, if any.
+func getLatePolicy(tx *sql.Tx, assignment *Assignment) (*LatePolicy, error) {
+	if assignment.ProblemSetID == 0 {
+		return nil, nil
+	}
+	problemSet := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", problemSet, assignment.ProblemSetID); err != nil {
+		return nil, err
+	}
+	return problemSet.LatePolicy, nil
+}
+
+// stepLatePenalty decides the late penalty for a newly graded step.
+// The penalty is based on when the commit was submitted, and it only applies
+// to the improvement over the step's previous score: a step that scored 0.8
+// on time and 1.0 two days late at 20% per day is worth 0.8 + 0.2*0.6 = 0.92.
+// The result is returned as the fraction of the new score that is deducted,
+// which is how penalties are stored. A commit that does not improve on the
+// step's score keeps the penalty it already had, so re-running finished work
+// after the due date costs nothing.
+// It returns the penalty and an explanation if one was deducted.
+func stepLatePenalty(policy *LatePolicy, dueAt *time.Time, assignment *Assignment, unique string, step int, score float64, now time.Time) (float64, string) {
+	penalty, days := policy.Penalty(dueAt, now)
+	oldScore, oldPenalty := 0.0, assignment.LatePenalty(unique, step)
+	if penalty == 0.0 && oldPenalty == 0.0 {
+		return 0.0, ""
+	}
+	if scores := assignment.RawScores[unique]; step < len(scores) {
+		oldScore = scores[step]
+	}
+
+	if score <= oldScore {
+		if oldPenalty < penalty {
+			return oldPenalty, ""
+		}
+		if penalty == 0.0 {
+			return 0.0, ""
+		}
+		return penalty, policy.Explain(penalty, days)
+	}
+
+	// keep the credit already earned and deduct only from the gain
+	earned := oldScore*(1.0-oldPenalty) + (score-oldScore)*(1.0-penalty)
+	combined := 1.0 - earned/score
+	if penalty == 0.0 {
+		return combined, ""
+	}
+	explanation := policy.Explain(penalty, days)
+	if oldScore > 0.0 {
+		explanation += fmt.Sprintf(" on the %g%% gained since the last submission", roundPercent(score-oldScore))
+	}
+	return combined, explanation
+}
+
+// roundPercent converts a fraction to a percentage for display,
+// hiding floating point noise.
+func roundPercent(fraction float64) float64 {
+	return math.Round(fraction*1e6) / 1e4
+}
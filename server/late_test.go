@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestLatePolicyPenalty(t *testing.T) {
+	due := time.Date(2026, 10, 1, 23, 59, 0, 0, time.UTC)
+	policy := &LatePolicy{PercentPerDay: 10, MaxPercent: 25, GraceMinutes: 15}
+
+	tests := []struct {
+		name    string
+		when    time.Time
+		penalty float64
+		days    int
+	}{
+		{"on time", due.Add(-time.Hour), 0.0, 0},
+		{"within grace", due.Add(15 * time.Minute), 0.0, 0},
+		{"just late", due.Add(16 * time.Minute), 0.1, 1},
+		{"a day late", due.Add(24*time.Hour + 15*time.Minute), 0.1, 1},
+		{"part of a second day", due.Add(25 * time.Hour), 0.2, 2},
+		{"capped", due.Add(10 * 24 * time.Hour), 0.25, 10},
+	}
+	for _, test := range tests {
+		penalty, days := policy.Penalty(&due, test.when)
+		if days != test.days || penalty < test.penalty-1e-9 || penalty > test.penalty+1e-9 {
+			t.Errorf("%s: got penalty %v for %d days, expected %v for %d days", test.name, penalty, days, test.penalty, test.days)
+		}
+	}
+
+	// no policy or no due date means no penalty
+	var none *LatePolicy
+	if penalty, _ := none.Penalty(&due, due.Add(48*time.Hour)); penalty != 0.0 {
+		t.Errorf("nil policy: got penalty %v", penalty)
+	}
+	if penalty, _ := policy.Penalty(nil, due.Add(48*time.Hour)); penalty != 0.0 {
+		t.Errorf("no due date: got penalty %v", penalty)
+	}
+}
+
+func TestLatePenaltyScore(t *testing.T) {
+	due := time.Date(2026, 10, 1, 23, 59, 0, 0, time.UTC)
+	policy := &LatePolicy{PercentPerDay: 20}
+	asst := &Assignment{RawScores: map[string][]float64{"hello": {1.0}}}
+	majorWeights := map[string]float64{"hello": 1.0}
+	minorWeights := map[string][]float64{"hello": {1.0, 1.0}}
+
+	// step 2 passes two days late
+	penalty, note := stepLatePenalty(policy, &due, asst, "hello", 1, 1.0, due.Add(36*time.Hour))
+	if penalty != 0.4 || !strings.Contains(note, "40% deducted for 2 days late") {
+		t.Errorf("late step: got penalty %v with note %q", penalty, note)
+	}
+	asst.SetMinorScore("hello", 1, 1.0)
+	asst.SetLatePenalty("hello", 1, penalty)
+	if score, err := asst.ComputeScore(majorWeights, minorWeights); err != nil || score < 0.8-1e-9 || score > 0.8+1e-9 {
+		t.Errorf("score with a late step: got %v, %v, expected 0.8", score, err)
+	}
+
+	// running the finished step again later does not add to the penalty
+	penalty, note = stepLatePenalty(policy, &due, asst, "hello", 1, 1.0, due.Add(96*time.Hour))
+	if penalty != 0.4 || note != "" {
+		t.Errorf("rerun of finished step: got penalty %v with note %q", penalty, note)
+	}
+
+	// the on-time step is never penalized
+	penalty, _ = stepLatePenalty(policy, &due, asst, "hello", 0, 1.0, due.Add(96*time.Hour))
+	if penalty != 0.0 {
+		t.Errorf("rerun of on-time step: got penalty %v", penalty)
+	}
+}
+
+func TestLatePenaltyImprovement(t *testing.T) {
+	due := time.Date(2026, 10, 1, 23, 59, 0, 0, time.UTC)
+	policy := &LatePolicy{PercentPerDay: 20}
+	asst := &Assignment{RawScores: map[string][]float64{}}
+	majorWeights := map[string]float64{"hello": 1.0}
+	minorWeights := map[string][]float64{"hello": {1.0}}
+	record := func(score float64, when time.Time) string {
+		penalty, note := stepLatePenalty(policy, &due, asst, "hello", 0, score, when)
+		asst.SetMinorScore("hello", 0, score)
+		if penalty > 0.0 || asst.LatePenalty("hello", 0) > 0.0 {
+			asst.SetLatePenalty("hello", 0, penalty)
+		}
+		return note
+	}
+	expect := func(name string, expected float64) {
+		if score, err := asst.ComputeScore(majorWeights, minorWeights); err != nil || score < expected-1e-9 || score > expected+1e-9 {
+			t.Errorf("%s: got %v, %v, expected %v", name, score, err, expected)
+		}
+	}
+
+	// 0.8 on time, then 1.0 two days late: only the extra 0.2 loses 40%
+	if note := record(0.8, due.Add(-time.Hour)); note != "" {
+		t.Errorf("on time: got note %q", note)
+	}
+	expect("on time", 0.8)
+	note := record(1.0, due.Add(36*time.Hour))
+	expect("improved late", 0.92)
+	if !strings.Contains(note, "40% deducted for 2 days late") || !strings.Contains(note, "on the 20% gained") {
+		t.Errorf("improved late: got note %q", note)
+	}
+
+	// a late submission never lowers the credit already earned
+	record(1.0, due.Add(96*time.Hour))
+	expect("rerun later", 0.92)
+
+	// a further improvement is penalized at the later rate, and earlier
+	// improvements keep their own penalties
+	asst = &Assignment{RawScores: map[string][]float64{}}
+	record(0.5, due.Add(12*time.Hour))
+	expect("half a day late", 0.4)
+	record(1.0, due.Add(60*time.Hour))
+	expect("three days late", 0.4+0.5*0.4)
+}
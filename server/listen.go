@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// The ways the server can accept connections, chosen by Config.TLSMode.
+const (
+	tlsLetsEncrypt = "letsencrypt" // https with certificates from LetsEncrypt
+	tlsFiles       = "files"       // https with a certificate and key from Config.TLSCertFile and Config.TLSKeyFile
+	tlsNone        = "none"        // plain http only, for running behind a proxy that handles TLS
+)
+
+// checkListenConfig fills in defaults and checks the settings that
+// control how connections are accepted.
+func checkListenConfig() ([]*net.IPNet, error) {
+	switch Config.TLSMode {
+	case "":
+		Config.TLSMode = tlsLetsEncrypt
+	case tlsLetsEncrypt:
+	case tlsFiles:
+		if Config.TLSCertFile == "" || Config.TLSKeyFile == "" {
+			return nil, fmt.Errorf("tlsMode %q requires tlsCertFile and tlsKeyFile in the config file", tlsFiles)
+		}
+	case tlsNone:
+		if Config.ListenHTTP == "" {
+			return nil, fmt.Errorf("tlsMode %q requires listenHTTP in the config file", tlsNone)
+		}
+	default:
+		return nil, fmt.Errorf("tlsMode must be %q, %q, or %q", tlsLetsEncrypt, tlsFiles, tlsNone)
+	}
+	if Config.TLSMode != tlsNone && Config.ListenHTTPS == "" {
+		return nil, fmt.Errorf("tlsMode %q requires listenHTTPS in the config file", Config.TLSMode)
+	}
+	return parseTrustedProxies(Config.TrustedProxies)
+}
+
+// parseTrustedProxies parses a list of CIDR blocks.
+// A plain address is treated as a block holding just that address.
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, elt := range list {
+		if !strings.Contains(elt, "/") {
+			ip := net.ParseIP(elt)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", elt)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, block, err := net.ParseCIDR(elt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy block %q: %v", elt, err)
+		}
+		proxies = append(proxies, block)
+	}
+	return proxies, nil
+}
+
+// isTrustedProxy reports whether addr, with or without a port,
+// falls inside one of the proxy blocks.
+func isTrustedProxy(proxies []*net.IPNet, addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, block := range proxies {
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeaders returns the martini middleware that decides whether to
+// believe the X-Forwarded-* headers on a request. When the connection comes from
+// a trusted proxy, the client address is taken from the headers and they are left
+// for the handlers to use. Otherwise they are removed, so a client cannot claim
+// to be somewhere else or change the URL that LTI signatures are checked against.
+func forwardedHeaders(proxies []*net.IPNet) func(r *http.Request) {
+	return func(r *http.Request) {
+		if !isTrustedProxy(proxies, r.RemoteAddr) {
+			for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP"} {
+				r.Header.Del(name)
+			}
+			return
+		}
+
+		// the client is the last address not added by one of our proxies
+		var hops []string
+		for _, elt := range r.Header["X-Forwarded-For"] {
+			hops = append(hops, strings.Split(elt, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			r.RemoteAddr = hop
+			if !isTrustedProxy(proxies, hop) {
+				break
+			}
+		}
+	}
+}
+
+// certReloader serves the certificate and key in a pair of files,
+// loading them again whenever either file changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// newCertReloader loads the certificate and key for the first time.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := c.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config.
+// If the files cannot be loaded, it keeps serving the last good certificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var modified time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			if c.cert != nil {
+				return c.cert, nil
+			}
+			return nil, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	if c.cert != nil && modified.Equal(c.modified) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// do not try again until the files change
+			log.Printf("error reloading TLS certificate, keeping the old one: %v", err)
+			c.modified = modified
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	if c.cert != nil {
+		log.Printf("reloaded TLS certificate from %s", c.certFile)
+	}
+	c.cert, c.modified = &cert, modified
+	return c.cert, nil
+}
+
+// serve starts accepting connections for handler as described by the config file.
+// It returns the servers it started so they can be shut down.
+func serve(handler http.Handler, proxies []*net.IPNet) []*http.Server {
+	if Config.TLSMode == tlsNone {
+		log.Printf("accepting http connections on %s", Config.ListenHTTP)
+		server := &http.Server{Addr: Config.ListenHTTP, Handler: handler}
+		go func() {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalf("ListenAndServe: %v", err)
+			}
+		}()
+		return []*http.Server{server}
+	}
+
+	// set up the https server
+	tlsConfig := &tls.Config{
+		PreferServerCipherSuites: true,
+		MinVersion:               tls.VersionTLS10,
+	}
+	var challenges func(http.Handler) http.Handler
+	switch Config.TLSMode {
+	case tlsLetsEncrypt:
+		lem := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(Config.LetsEncryptCache),
+			HostPolicy: autocert.HostWhitelist(Config.Hostname),
+			Email:      Config.LetsEncryptEmail,
+		}
+		tlsConfig.GetCertificate = lem.GetCertificate
+		challenges = lem.HTTPHandler
+	case tlsFiles:
+		certs, err := newCertReloader(Config.TLSCertFile, Config.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		tlsConfig.GetCertificate = certs.GetCertificate
+		challenges = func(h http.Handler) http.Handler { return h }
+	}
+	log.Printf("accepting https connections on %s", Config.ListenHTTPS)
+	server := &http.Server{
+		Addr:      Config.ListenHTTPS,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+
+	// set up the http server
+	// it is necessary for LetsEncrypt challenges
+	// it forwards other requests to https, but only if the host name was correct
+	trust := forwardedHeaders(proxies)
+	forwarder := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// get the address of the client
+		trust(r)
+
+		// make sure the request is for the right host name
+		if Config.Hostname != r.Host {
+			http.Error(w, "http request to invalid host", http.StatusBadRequest)
+			return
+		}
+		var u url.URL = *r.URL
+		u.Scheme = "https"
+		u.Host = Config.Hostname
+		log.Printf("redirecting http request from %s to %s", r.RemoteAddr, u.String())
+		w.Header().Set("Connection", "close")
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	})
+
+	// start both servers
+	servers := []*http.Server{server}
+	if Config.ListenHTTP != "" {
+		redirector := &http.Server{Addr: Config.ListenHTTP, Handler: challenges(forwarder)}
+		servers = append(servers, redirector)
+		go func() {
+			if err := redirector.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalf("ListenAndServe: %v", err)
+			}
+		}()
+	}
+	go func() {
+		if err := server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServeTLS: %v", err)
+		}
+	}()
+	return servers
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("parseTrustedProxies: expected an error for a bad block")
+	}
+	if _, err := parseTrustedProxies([]string{"proxy.example.com"}); err == nil {
+		t.Errorf("parseTrustedProxies: expected an error for a host name")
+	}
+	trust := forwardedHeaders(proxies)
+
+	tests := []struct {
+		remote, forwardedFor, proto string
+		client, keptProto           string
+	}{
+		// clients talking to us directly cannot claim anything
+		{"203.0.113.7:5000", "198.51.100.1", "http", "203.0.113.7:5000", ""},
+		{"203.0.113.7:5000", "", "", "203.0.113.7:5000", ""},
+
+		// our proxies are believed, but not the hops before them
+		{"10.1.2.3:5000", "198.51.100.1", "https", "198.51.100.1", "https"},
+		{"192.168.1.5:5000", "6.6.6.6, 198.51.100.1, 10.9.9.9", "https", "198.51.100.1", "https"},
+		{"[fd00::1]:5000", "2001:db8::1", "https", "2001:db8::1", "https"},
+		{"192.168.1.6:5000", "198.51.100.1", "https", "192.168.1.6:5000", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if test.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		trust(r)
+		if r.RemoteAddr != test.client || r.Header.Get("X-Forwarded-Proto") != test.keptProto {
+			t.Errorf("from %s forwarded for %q: got client %s and proto %q, expected %s and %q",
+				test.remote, test.forwardedFor, r.RemoteAddr, r.Header.Get("X-Forwarded-Proto"), test.client, test.keptProto)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegrinder")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	// write a self-signed certificate with the given serial number
+	when := time.Now().Add(-time.Hour)
+	write := func(serial int64) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "codegrinder.example.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("creating certificate: %v", err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("encoding key: %v", err)
+		}
+		if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+			t.Fatalf("writing certificate: %v", err)
+		}
+		if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+			t.Fatalf("writing key: %v", err)
+		}
+
+		// make each version look newer than the last
+		when = when.Add(time.Minute)
+		os.Chtimes(certFile, when, when)
+		os.Chtimes(keyFile, when, when)
+	}
+	serial := func(c *certReloader) int64 {
+		cert, err := c.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("parsing certificate: %v", err)
+		}
+		return parsed.SerialNumber.Int64()
+	}
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Errorf("newCertReloader with no files: expected an error")
+	}
+	write(1)
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	if n := serial(certs); n != 1 {
+		t.Errorf("first certificate: got serial %d", n)
+	}
+
+	// a renewed certificate is picked up
+	write(2)
+	if n := serial(certs); n != 2 {
+		t.Errorf("renewed certificate: got serial %d", n)
+	}
+
+	// a broken one is not
+	if err := ioutil.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("writing certificate: %v", err)
+	}
+	when = when.Add(time.Minute)
+	os.Chtimes(certFile, when, when)
+	if n := serial(certs); n != 2 {
+		t.Errorf("after a broken certificate: got serial %d", n)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"database/sql"
@@ -74,6 +75,12 @@ type LTIRequest struct {
 	CanvasAssignmentUnlockAt         string  `form:"custom_canvas_assignment_unlock_at"`       // 2019-10-20T21:00:00Z
 	CanvasAssignmentDueAt            string  `form:"custom_canvas_assignment_due_at"`          // 2019-10-20T21:00:00Z
 	CanvasAssignmentLockAt           string  `form:"custom_canvas_assignment_lock_at"`         // 2019-10-20T21:00:00Z
+
+	// LTI 1.3 launches only
+	LTIPlatformID int64  `form:"-"` // platform that sent the launch
+	LTIUserID     string `form:"-"` // sub claim: the user ID for grade services
+	LineItemsURL  string `form:"-"` // AGS line item container for this context
+	LineItemURL   string `form:"-"` // AGS line item for this resource link, if the platform created one
 }
 
 // GradeResponse is the XML format to post a grade back to the LMS.
@@ -536,7 +543,7 @@ func getUpdateCourse(tx *sql.Tx, form *LTIRequest, now time.Time) (*Course, erro
 // get/create/update this assignment
 func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Course, problemSet *ProblemSet, user *User) (*Assignment, error) {
 	asst := new(Assignment)
-	err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE course_id = ? AND lti_id = ? AND user_id = ?`,
+	err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE course_id = ? AND lti_id = ? AND user_id = ?`+forUpdate(),
 		course.ID, form.ResourceLinkID, user.ID)
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -592,6 +599,10 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 		asst.OutcomeExtAccepted != form.ExtOutcomeDataValuesAccepted ||
 		asst.FinishedURL != form.LaunchPresentationReturnURL ||
 		asst.ConsumerKey != form.OAuthConsumerKey ||
+		asst.LTIPlatformID != form.LTIPlatformID ||
+		asst.LTIUserID != form.LTIUserID ||
+		asst.LineItemsURL != form.LineItemsURL ||
+		asst.LineItemURL != form.LineItemURL ||
 		dateMismatch(asst.UnlockAt, form.CanvasAssignmentUnlockAt) ||
 		dateMismatch(asst.DueAt, form.CanvasAssignmentDueAt) ||
 		dateMismatch(asst.LockAt, form.CanvasAssignmentLockAt)
@@ -626,6 +637,10 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 	asst.OutcomeExtAccepted = form.ExtOutcomeDataValuesAccepted
 	asst.FinishedURL = form.LaunchPresentationReturnURL
 	asst.ConsumerKey = form.OAuthConsumerKey
+	asst.LTIPlatformID = form.LTIPlatformID
+	asst.LTIUserID = form.LTIUserID
+	asst.LineItemsURL = form.LineItemsURL
+	asst.LineItemURL = form.LineItemURL
 	if when, err := time.Parse(canvasDateFormat, form.CanvasAssignmentUnlockAt); err == nil {
 		when = when.Local()
 		asst.UnlockAt = &when
@@ -677,7 +692,11 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 	return asst, nil
 }
 
-func saveGrade(asst *Assignment, text string) error {
+// saveGrade posts an assignment grade to the LMS, giving up when ctx is done.
+func saveGrade(ctx context.Context, asst *Assignment, platform *LTIPlatform, text string) error {
+	if asst.LTIPlatformID != 0 {
+		return saveScore(ctx, asst, platform, text)
+	}
 	if asst.GradeID == "" {
 		// instructors do not get grades
 		//log.Printf("cannot post grade for assignment %d user %d because no grade ID is present", asst.ID, asst.UserID)
@@ -720,7 +739,7 @@ func saveGrade(asst *Assignment, text string) error {
 	auth := signXMLRequest(asst.ConsumerKey, "POST", outcomeURL, result, Config.LTISecret)
 
 	// POST the grade
-	req, err := http.NewRequest("POST", outcomeURL, bytes.NewReader(result))
+	req, err := http.NewRequestWithContext(ctx, "POST", outcomeURL, bytes.NewReader(result))
 	if err != nil {
 		log.Printf("error preparing grade request: %v", err)
 		return err
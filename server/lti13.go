@@ -0,0 +1,822 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/gorilla/securecookie"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+	"golang.org/x/sync/singleflight"
+)
+
+// LTI 1.3 launches use OpenID Connect with the LMS (the platform) acting as
+// the identity provider:
+//
+// 1. The platform sends the browser to /v2/lti13/login with the issuer and a login hint.
+// 2. We redirect back to the platform's authorization endpoint with a fresh state and nonce,
+//    and set a cookie holding the same state so the launch must come from this browser.
+// 3. The platform posts a signed id_token (a JWT) to /v2/lti13/launch.
+// 4. We check the signature against the platform's published keys, check the claims,
+//    and then handle the launch exactly like an LTI 1.1 launch.
+
+const (
+	lti13LoginTimeout   = 5 * time.Minute
+	lti13KeySetLifetime = time.Hour
+	lti13KeySetTimeout  = 10 * time.Second
+	lti13ClockSkew      = time.Minute
+
+	lti13ClaimPrefix  = "https://purl.imsglobal.org/spec/lti/claim/"
+	lti13MembershipNS = "http://purl.imsglobal.org/vocab/lis/v2/membership#"
+)
+
+// the key this tool uses to sign its own JWTs, published at /v2/lti13/jwks
+var (
+	ltiKey   *rsa.PrivateKey
+	ltiKeyID string
+)
+
+// loadLTIKey reads the tool's private key, creating one if the file does not exist.
+func loadLTIKey(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("generating a new LTI 1.3 key in %s", path)
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		raw = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return fmt.Errorf("%s does not contain a PEM-encoded RSA private key", path)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	setLTIKey(key)
+	return nil
+}
+
+// setLTIKey installs the tool key. The key ID is derived from the public key.
+func setLTIKey(key *rsa.PrivateKey) {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	ltiKey = key
+	ltiKeyID = base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// JWK is a single RSA public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+func newJWK(kid string, pub *rsa.PublicKey) *JWK {
+	return &JWK{
+		KeyType:   "RSA",
+		Algorithm: "RS256",
+		Use:       "sig",
+		KeyID:     kid,
+		N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func (key *JWK) publicKey() (*rsa.PublicKey, error) {
+	if key.KeyType != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", key.KeyType)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.N, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.E, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %v", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("unsupported exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// keySets caches the public keys of each platform by key set URL.
+// The lock only guards the cache: it is not held while a key set is fetched,
+// and concurrent fetches of the same URL are shared.
+type keySets struct {
+	sync.Mutex
+	sets  map[string]*cachedKeySet
+	fetch singleflight.Group
+}
+
+type cachedKeySet struct {
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+var platformKeys = &keySets{sets: make(map[string]*cachedKeySet)}
+
+// keySetClient fetches platform key sets during launches, so a slow
+// platform cannot hold up a launch for long.
+var keySetClient = &http.Client{Timeout: lti13KeySetTimeout}
+
+// Get finds a key in a key set, fetching the set if it is missing or stale.
+// A set is fetched again if the key is not found, since platforms rotate keys,
+// but not more than once a minute.
+func (k *keySets) Get(keySetURL, kid string) (*rsa.PublicKey, error) {
+	k.Lock()
+	set := k.sets[keySetURL]
+	k.Unlock()
+	if set != nil && time.Since(set.fetched) < lti13KeySetLifetime {
+		if key, exists := set.keys[kid]; exists {
+			return key, nil
+		}
+		if time.Since(set.fetched) < time.Minute {
+			return nil, fmt.Errorf("key %q not found in key set %s", kid, keySetURL)
+		}
+	}
+
+	shared, err, _ := k.fetch.Do(keySetURL, func() (interface{}, error) {
+		set, err := fetchKeySet(keySetURL)
+		if err != nil {
+			return nil, err
+		}
+		k.Lock()
+		k.sets[keySetURL] = set
+		k.Unlock()
+		return set, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	set = shared.(*cachedKeySet)
+	if key, exists := set.keys[kid]; exists {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key %q not found in key set %s", kid, keySetURL)
+}
+
+func fetchKeySet(keySetURL string) (*cachedKeySet, error) {
+	resp, err := keySetClient.Get(keySetURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key set: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set %s: %s", keySetURL, resp.Status)
+	}
+	jwks := new(JWKS)
+	if err := json.NewDecoder(resp.Body).Decode(jwks); err != nil {
+		return nil, fmt.Errorf("decoding key set %s: %v", keySetURL, err)
+	}
+
+	set := &cachedKeySet{keys: make(map[string]*rsa.PublicKey), fetched: time.Now()}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("skipping key %q in key set %s: %v", jwk.KeyID, keySetURL, err)
+			continue
+		}
+		set.keys[jwk.KeyID] = key
+	}
+	return set, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// signJWT encodes and signs a set of claims using RS256.
+func signJWT(key *rsa.PrivateKey, kid string, claims interface{}) (string, error) {
+	header, err := json.Marshal(&jwtHeader{Algorithm: "RS256", Type: "JWT", KeyID: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// verifyJWT checks the RS256 signature on a JWT and decodes its claims.
+// The key is found by calling getKey with the key ID from the header.
+func verifyJWT(token string, getKey func(kid string) (*rsa.PublicKey, error), claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("decoding JWT header: %v", err)
+	}
+	header := new(jwtHeader)
+	if err := json.Unmarshal(rawHeader, header); err != nil {
+		return fmt.Errorf("parsing JWT header: %v", err)
+	}
+	if header.Algorithm != "RS256" {
+		return fmt.Errorf("unsupported JWT algorithm %q", header.Algorithm)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("decoding JWT signature: %v", err)
+	}
+	key, err := getKey(header.KeyID)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return fmt.Errorf("bad JWT signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("decoding JWT payload: %v", err)
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("parsing JWT claims: %v", err)
+	}
+	return nil
+}
+
+// audience is a JWT aud claim, which may be a single string or a list.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+func (aud audience) contains(s string) bool {
+	for _, elt := range aud {
+		if elt == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LTI13Claims is the payload of an LTI 1.3 resource link launch.
+type LTI13Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Name            string   `json:"name,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	Picture         string   `json:"picture,omitempty"`
+
+	MessageType   string   `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version       string   `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID  string   `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLinkURI string   `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	Roles         []string `json:"https://purl.imsglobal.org/spec/lti/claim/roles"`
+	Context       struct {
+		ID    string `json:"id"`
+		Label string `json:"label,omitempty"`
+		Title string `json:"title,omitempty"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/context"`
+	ResourceLink struct {
+		ID    string `json:"id"`
+		Title string `json:"title,omitempty"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/resource_link"`
+	LaunchPresentation struct {
+		ReturnURL string `json:"return_url,omitempty"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/launch_presentation"`
+	Custom map[string]interface{} `json:"https://purl.imsglobal.org/spec/lti/claim/custom,omitempty"`
+
+	// LTI Advantage grade services
+	Endpoint struct {
+		Scope     []string `json:"scope,omitempty"`
+		LineItems string   `json:"lineitems,omitempty"`
+		LineItem  string   `json:"lineitem,omitempty"`
+	} `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"`
+
+	// LTI 1.1 grade passback, if the platform still offers it
+	BasicOutcome struct {
+		SourcedID  string `json:"lis_result_sourcedid,omitempty"`
+		ServiceURL string `json:"lis_outcome_service_url,omitempty"`
+	} `json:"https://purl.imsglobal.org/spec/lti-bo/claim/basicoutcome"`
+
+	// identifiers from LTI 1.1, so existing users and assignments carry over
+	LTI11LegacyUserID string `json:"https://purl.imsglobal.org/spec/lti/claim/lti11_legacy_user_id,omitempty"`
+	LTI1p1            struct {
+		UserID           string `json:"user_id,omitempty"`
+		ResourceLinkID   string `json:"resource_link_id,omitempty"`
+		OAuthConsumerKey string `json:"oauth_consumer_key,omitempty"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/lti1p1"`
+}
+
+// check validates the claims of a launch from the given platform.
+func (claims *LTI13Claims) check(platform *LTIPlatform, nonce string, now time.Time) error {
+	if claims.Issuer != platform.Issuer {
+		return fmt.Errorf("token issuer is %q, expected %q", claims.Issuer, platform.Issuer)
+	}
+	if !claims.Audience.contains(platform.ClientID) {
+		return fmt.Errorf("token audience %v does not include client ID %q", []string(claims.Audience), platform.ClientID)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != platform.ClientID {
+		return fmt.Errorf("token authorized party is %q, expected %q", claims.AuthorizedParty, platform.ClientID)
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(lti13ClockSkew)) {
+		return fmt.Errorf("token expired at %v", time.Unix(claims.ExpiresAt, 0))
+	}
+	if time.Unix(claims.IssuedAt, 0).After(now.Add(lti13ClockSkew)) {
+		return fmt.Errorf("token issued in the future at %v", time.Unix(claims.IssuedAt, 0))
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return fmt.Errorf("token nonce does not match the login request")
+	}
+	if claims.MessageType != "LtiResourceLinkRequest" {
+		return fmt.Errorf("unsupported message type %q", claims.MessageType)
+	}
+	if claims.Version != "1.3.0" {
+		return fmt.Errorf("unsupported LTI version %q", claims.Version)
+	}
+	if !platform.HasDeployment(claims.DeploymentID) {
+		return fmt.Errorf("deployment %q is not registered for platform %s", claims.DeploymentID, platform.Name)
+	}
+	if claims.Subject == "" {
+		return fmt.Errorf("token has no subject; anonymous launches are not supported")
+	}
+	if claims.Context.ID == "" || claims.ResourceLink.ID == "" {
+		return fmt.Errorf("token is missing the context or resource link claim")
+	}
+	return nil
+}
+
+// custom gives a custom parameter as a string.
+func (claims *LTI13Claims) custom(name string) string {
+	value, exists := claims.Custom[name]
+	if !exists || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	if n, ok := value.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// customInt gives a custom parameter as an integer, or zero if it is missing.
+func (claims *LTI13Claims) customInt(name string) int64 {
+	n, _ := strconv.ParseInt(claims.custom(name), 10, 64)
+	return n
+}
+
+func (claims *LTI13Claims) hasScope(scope string) bool {
+	for _, elt := range claims.Endpoint.Scope {
+		if elt == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// LTIRequest maps the claims onto the fields of an LTI 1.1 launch.
+// Canvas-specific values come from custom parameters (see GetLTI13Config).
+func (claims *LTI13Claims) LTIRequest() (*LTIRequest, error) {
+	form := &LTIRequest{
+		PersonNameFull:              claims.Name,
+		PersonContactEmailPrimary:   claims.Email,
+		UserID:                      claims.Subject,
+		UserImage:                   claims.Picture,
+		LTIMessageType:              "basic-lti-launch-request",
+		LTIVersion:                  "LTI-1p3",
+		ContextTitle:                claims.Context.Title,
+		ContextLabel:                claims.Context.Label,
+		ContextID:                   claims.Context.ID,
+		ResourceLinkTitle:           claims.ResourceLink.Title,
+		ResourceLinkID:              claims.ResourceLink.ID,
+		LaunchPresentationReturnURL: claims.LaunchPresentation.ReturnURL,
+		PersonSourcedID:             claims.BasicOutcome.SourcedID,
+		OutcomeServiceURL:           claims.BasicOutcome.ServiceURL,
+		OAuthConsumerKey:            claims.LTI1p1.OAuthConsumerKey,
+		CanvasUserLoginID:           claims.custom("canvas_user_login_id"),
+		CanvasUserID:                claims.customInt("canvas_user_id"),
+		CanvasCourseID:              claims.customInt("canvas_course_id"),
+		CanvasAssignmentTitle:       claims.custom("canvas_assignment_title"),
+		CanvasAssignmentID:          claims.customInt("canvas_assignment_id"),
+		CanvasAPIDomain:             claims.custom("canvas_api_domain"),
+		CanvasAssignmentUnlockAt:    claims.custom("canvas_assignment_unlock_at"),
+		CanvasAssignmentDueAt:       claims.custom("canvas_assignment_due_at"),
+		CanvasAssignmentLockAt:      claims.custom("canvas_assignment_lock_at"),
+	}
+
+	// grade services: a line item is only created if we are allowed to
+	if claims.hasScope(agsScopeScore) {
+		form.LTIUserID = claims.Subject
+		form.LineItemURL = claims.Endpoint.LineItem
+		if claims.hasScope(agsScopeLineItem) {
+			form.LineItemsURL = claims.Endpoint.LineItems
+		}
+	}
+
+	// prefer LTI 1.1 identifiers so existing records are found
+	if claims.LTI1p1.UserID != "" {
+		form.UserID = claims.LTI1p1.UserID
+	} else if claims.LTI11LegacyUserID != "" {
+		form.UserID = claims.LTI11LegacyUserID
+	}
+	if claims.LTI1p1.ResourceLinkID != "" {
+		form.ResourceLinkID = claims.LTI1p1.ResourceLinkID
+	}
+	if form.CanvasAssignmentTitle == "" {
+		form.CanvasAssignmentTitle = claims.ResourceLink.Title
+	}
+
+	// context roles use the same short names as LTI 1.1
+	var roles []string
+	for _, role := range claims.Roles {
+		if strings.HasPrefix(role, lti13MembershipNS) {
+			roles = append(roles, strings.TrimPrefix(role, lti13MembershipNS))
+		}
+	}
+	form.Roles = strings.Join(roles, ",")
+
+	// these are required to be unique in the database
+	if form.CanvasUserID == 0 || form.CanvasCourseID == 0 || form.CanvasUserLoginID == "" {
+		return nil, fmt.Errorf("launch is missing the canvas_user_id, canvas_course_id, or canvas_user_login_id custom parameter; check the tool configuration in the LMS")
+	}
+
+	return form, nil
+}
+
+// lti13Login records an OIDC login in progress. It is signed and used as the
+// state value, so any TA instance can finish the login, and the same value is
+// stored in a cookie so only the browser that started the login can finish it.
+type lti13Login struct {
+	PlatformID int64
+	Nonce      string
+}
+
+// cookieName names the cookie for a login. Each login gets its own
+// so that launches started in several tabs at once do not interfere.
+func (login *lti13Login) cookieName() string {
+	return "codegrinder_lti13_" + login.Nonce[:12]
+}
+
+func lti13StateCodec() *securecookie.SecureCookie {
+	secure := securecookie.New([]byte(Config.SessionSecret), nil)
+	secure.MaxAge(int(lti13LoginTimeout / time.Second))
+	return secure
+}
+
+// startLTI13Login creates the state and nonce for a new login,
+// and sets the cookie that ties them to this browser.
+func startLTI13Login(w http.ResponseWriter, platformID int64) (state, nonce string, err error) {
+	login := &lti13Login{PlatformID: platformID, Nonce: randomToken()}
+	if state, err = lti13StateCodec().Encode("lti13-state", login); err != nil {
+		return "", "", err
+	}
+
+	// the launch is a cross-site POST from the platform, so the cookie must be SameSite=None
+	http.SetCookie(w, &http.Cookie{
+		Name:     login.cookieName(),
+		Value:    state,
+		Path:     "/v2/lti13/",
+		MaxAge:   int(lti13LoginTimeout / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	return state, login.Nonce, nil
+}
+
+// finishLTI13Login checks the state from a launch against the signature, the
+// time limit, and the cookie set by startLTI13Login, then clears the cookie
+// so the state cannot be used again from this browser.
+func finishLTI13Login(w http.ResponseWriter, r *http.Request, state string) (*lti13Login, error) {
+	login := new(lti13Login)
+	if err := lti13StateCodec().Decode("lti13-state", state, login); err != nil || len(login.Nonce) < 12 {
+		return nil, fmt.Errorf("unknown or expired login state")
+	}
+	cookie, err := r.Cookie(login.cookieName())
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return nil, fmt.Errorf("login state was not started by this browser")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     login.cookieName(),
+		Path:     "/v2/lti13/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	return login, nil
+}
+
+func randomToken() string {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// LTI13Login handles /v2/lti13/login requests, the first step in an LTI 1.3 launch.
+// It finds the platform and redirects back to it to authenticate the user.
+func LTI13Login(w http.ResponseWriter, r *http.Request, tx *sql.Tx) {
+	r.ParseForm()
+	issuer := r.Form.Get("iss")
+	loginHint := r.Form.Get("login_hint")
+	targetLinkURI := r.Form.Get("target_link_uri")
+	if issuer == "" || loginHint == "" || targetLinkURI == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "login request must include iss, login_hint, and target_link_uri")
+		return
+	}
+
+	// find the platform
+	platforms := []*LTIPlatform{}
+	where, args := addWhereEq("", nil, "issuer", issuer)
+	if clientID := r.Form.Get("client_id"); clientID != "" {
+		where, args = addWhereEq(where, args, "client_id", clientID)
+	}
+	if err := meddler.QueryAll(tx, &platforms, `SELECT * FROM lti_platforms`+where, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if len(platforms) != 1 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "found %d platforms registered for issuer %q and client ID %q; this is usually due to an error in the LTI 1.3 setup for CodeGrinder",
+			len(platforms), issuer, r.Form.Get("client_id"))
+		return
+	}
+	platform := platforms[0]
+	if deploymentID := r.Form.Get("lti_deployment_id"); deploymentID != "" && !platform.HasDeployment(deploymentID) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "deployment %q is not registered for platform %s", deploymentID, platform.Name)
+		return
+	}
+
+	// send the user back to the platform to authenticate
+	state, nonce, err := startLTI13Login(w, platform.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "creating login state: %v", err)
+		return
+	}
+	v := url.Values{}
+	v.Set("scope", "openid")
+	v.Set("response_type", "id_token")
+	v.Set("response_mode", "form_post")
+	v.Set("prompt", "none")
+	v.Set("client_id", platform.ClientID)
+	v.Set("redirect_uri", "https://"+Config.Hostname+"/v2/lti13/launch")
+	v.Set("login_hint", loginHint)
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	if hint := r.Form.Get("lti_message_hint"); hint != "" {
+		v.Set("lti_message_hint", hint)
+	}
+	sep := "?"
+	if strings.Contains(platform.AuthLoginURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, platform.AuthLoginURL+sep+v.Encode(), http.StatusFound)
+}
+
+// LTI13Launch handles /v2/lti13/launch requests, the final step in an LTI 1.3 launch.
+// It verifies the id_token from the platform, then hands off to the LTI 1.1 handler
+// that matches the target link URI.
+func LTI13Launch(w http.ResponseWriter, r *http.Request, tx *sql.Tx) {
+	r.ParseForm()
+	if msg := r.Form.Get("error"); msg != "" {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "LMS reported an error: %s: %s", msg, r.Form.Get("error_description"))
+		return
+	}
+	login, err := finishLTI13Login(w, r, r.Form.Get("state"))
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "%v; please launch the assignment again", err)
+		return
+	}
+	platform := new(LTIPlatform)
+	if err := meddler.Load(tx, "lti_platforms", platform, login.PlatformID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	// verify the token
+	claims := new(LTI13Claims)
+	getKey := func(kid string) (*rsa.PublicKey, error) {
+		return platformKeys.Get(platform.KeySetURL, kid)
+	}
+	if err := verifyJWT(r.Form.Get("id_token"), getKey, claims); err != nil {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "LTI 1.3 launch from %s: %v", platform.Name, err)
+		return
+	}
+	if err := claims.check(platform, login.Nonce, time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "LTI 1.3 launch from %s: %v", platform.Name, err)
+		return
+	}
+	form, err := claims.LTIRequest()
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "LTI 1.3 launch from %s: %v", platform.Name, err)
+		return
+	}
+	form.LTIPlatformID = platform.ID
+
+	// the target link is the same URL used for LTI 1.1 launches
+	target, err := url.Parse(claims.TargetLinkURI)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "bad target link URI %q: %v", claims.TargetLinkURI, err)
+		return
+	}
+	parts := strings.Split(strings.Trim(target.Path, "/"), "/")
+	switch {
+	case len(parts) == 5 && parts[0] == "v2" && parts[1] == "lti" && parts[2] == "problem_sets":
+		LtiProblemSet(w, r, tx, *form, martini.Params{"ui": parts[3], "unique": parts[4]})
+	case len(parts) == 3 && parts[0] == "v2" && parts[1] == "lti" && parts[2] == "quizzes":
+		LtiQuizzes(w, r, tx, *form, martini.Params{})
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unknown target link URI %q", claims.TargetLinkURI)
+	}
+}
+
+// GetLTI13JWKS handles /v2/lti13/jwks requests,
+// returning the public key this tool uses to sign its requests.
+func GetLTI13JWKS(w http.ResponseWriter, render render.Render) {
+	if ltiKey == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no LTI 1.3 key is configured")
+		return
+	}
+	render.JSON(http.StatusOK, &JWKS{Keys: []*JWK{newJWK(ltiKeyID, &ltiKey.PublicKey)}})
+}
+
+// GetLTI13Config handles /v2/lti13/config.json requests,
+// returning a JSON file to configure a Canvas developer key for this tool.
+func GetLTI13Config(w http.ResponseWriter) {
+	base := "https://" + Config.Hostname
+	config := map[string]interface{}{
+		"title":               Config.ToolName,
+		"description":         Config.ToolDescription,
+		"oidc_initiation_url": base + "/v2/lti13/login",
+		"target_link_uri":     base + "/v2/lti/problem_sets/cli/" + bootstrapAssignmentName,
+		"public_jwk_url":      base + "/v2/lti13/jwks",
+		"scopes":              []string{agsScopeLineItem, agsScopeScore},
+		"custom_fields": map[string]string{
+			"canvas_user_id":              "$Canvas.user.id",
+			"canvas_user_login_id":        "$Canvas.user.loginId",
+			"canvas_course_id":            "$Canvas.course.id",
+			"canvas_api_domain":           "$Canvas.api.domain",
+			"canvas_assignment_id":        "$Canvas.assignment.id",
+			"canvas_assignment_title":     "$Canvas.assignment.title",
+			"canvas_assignment_unlock_at": "$Canvas.assignment.unlockAt.iso8601",
+			"canvas_assignment_due_at":    "$Canvas.assignment.dueAt.iso8601",
+			"canvas_assignment_lock_at":   "$Canvas.assignment.lockAt.iso8601",
+		},
+		"extensions": []interface{}{
+			map[string]interface{}{
+				"platform":      "canvas.instructure.com",
+				"domain":        Config.Hostname,
+				"tool_id":       Config.ToolID,
+				"privacy_level": "public",
+				"settings": map[string]interface{}{
+					"placements": []interface{}{
+						map[string]interface{}{
+							"placement":    "link_selection",
+							"message_type": "LtiResourceLinkRequest",
+						},
+					},
+				},
+			},
+		},
+	}
+	raw, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error rendering JSON config data: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err = w.Write(append(raw, '\n')); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing JSON: %v", err)
+		return
+	}
+}
+
+// GetLTIPlatforms handles /v2/lti13/platforms requests,
+// returning a list of all registered LTI 1.3 platforms.
+func GetLTIPlatforms(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	platforms := []*LTIPlatform{}
+	if err := meddler.QueryAll(tx, &platforms, `SELECT * FROM lti_platforms ORDER BY id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, platforms)
+}
+
+// PostLTIPlatform handles POST /v2/lti13/platforms requests,
+// registering a new LTI 1.3 platform.
+func PostLTIPlatform(w http.ResponseWriter, tx *sql.Tx, platform LTIPlatform, render render.Render) {
+	platform.ID = 0
+	platform.CreatedAt = time.Time{}
+	if err := platform.Normalize(time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if err := meddler.Insert(tx, "lti_platforms", &platform); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "db error: %v", err)
+		return
+	}
+	log.Printf("registered LTI 1.3 platform %d: %s (%s)", platform.ID, platform.Name, platform.Issuer)
+	render.JSON(http.StatusOK, &platform)
+}
+
+// PutLTIPlatform handles PUT /v2/lti13/platforms/:platform_id requests,
+// updating an LTI 1.3 platform registration.
+func PutLTIPlatform(w http.ResponseWriter, tx *sql.Tx, params martini.Params, platform LTIPlatform, render render.Render) {
+	platformID, err := parseID(w, "platform_id", params["platform_id"])
+	if err != nil {
+		return
+	}
+	old := new(LTIPlatform)
+	if err := meddler.Load(tx, "lti_platforms", old, platformID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	platform.ID = old.ID
+	platform.CreatedAt = old.CreatedAt
+	if err := platform.Normalize(time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if err := meddler.Update(tx, "lti_platforms", &platform); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, &platform)
+}
+
+// DeleteLTIPlatform handles DELETE /v2/lti13/platforms/:platform_id requests,
+// removing an LTI 1.3 platform registration.
+func DeleteLTIPlatform(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	platformID, err := parseID(w, "platform_id", params["platform_id"])
+	if err != nil {
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM lti_platforms WHERE id = ?`, platformID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// fakePlatform is an LMS that signs launches with its own key.
+// It also keeps the cookies of the browser doing the launch.
+type fakePlatform struct {
+	key      *rsa.PrivateKey
+	server   *httptest.Server
+	platform *LTIPlatform
+	cookies  map[string]*http.Cookie
+}
+
+func newFakePlatform(t *testing.T) *fakePlatform {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	p := &fakePlatform{key: key, cookies: make(map[string]*http.Cookie)}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&JWKS{Keys: []*JWK{newJWK("platform-key", &key.PublicKey)}})
+	}))
+	p.platform = &LTIPlatform{
+		Name:          "Fake LMS",
+		Issuer:        "https://lms.example.edu",
+		ClientID:      "10000000000042",
+		DeploymentIDs: []string{"1:deployment"},
+		AuthLoginURL:  "https://lms.example.edu/api/lti/authorize_redirect",
+		AuthTokenURL:  "https://lms.example.edu/login/oauth2/token",
+		KeySetURL:     p.server.URL + "/jwks",
+	}
+	return p
+}
+
+func (p *fakePlatform) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                                p.platform.Issuer,
+		"sub":                                "a6d5c443-1f51-4783-ba1a-7686ffe3b54a",
+		"aud":                                p.platform.ClientID,
+		"exp":                                now.Add(5 * time.Minute).Unix(),
+		"iat":                                now.Unix(),
+		"nonce":                              nonce,
+		"name":                               "Ada Student",
+		"email":                              "ada@example.edu",
+		"picture":                            "https://lms.example.edu/ada.png",
+		lti13ClaimPrefix + "message_type":    "LtiResourceLinkRequest",
+		lti13ClaimPrefix + "version":         "1.3.0",
+		lti13ClaimPrefix + "deployment_id":   "1:deployment",
+		lti13ClaimPrefix + "target_link_uri": "https://" + Config.Hostname + "/v2/lti/quizzes",
+		lti13ClaimPrefix + "roles": []string{
+			"http://purl.imsglobal.org/vocab/lis/v2/institution/person#Student",
+			"http://purl.imsglobal.org/vocab/lis/v2/membership#Learner",
+		},
+		lti13ClaimPrefix + "context":       map[string]string{"id": "context-1", "label": "CS 1400", "title": "Fundamentals of Programming"},
+		lti13ClaimPrefix + "resource_link": map[string]string{"id": "resource-link-1", "title": "Quizzes"},
+		lti13ClaimPrefix + "lti1p1":        map[string]string{"user_id": "legacy-user-1"},
+		"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint": map[string]interface{}{
+			"scope":     []string{agsScopeLineItem, agsScopeScore},
+			"lineitems": "https://lms.example.edu/api/lti/courses/456/line_items",
+		},
+		lti13ClaimPrefix + "custom": map[string]interface{}{
+			"canvas_user_id":          123,
+			"canvas_user_login_id":    "ada",
+			"canvas_course_id":        "456",
+			"canvas_assignment_id":    "789",
+			"canvas_assignment_title": "Quizzes",
+			"canvas_api_domain":       "lms.example.edu",
+		},
+	}
+}
+
+// login runs the first step of a launch and returns the state and nonce.
+func (p *fakePlatform) login(t *testing.T, db *sql.DB, form url.Values) (int, url.Values) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	r := httptest.NewRequest("GET", "/v2/lti13/login?"+form.Encode(), nil)
+	w := httptest.NewRecorder()
+	LTI13Login(w, r, tx)
+	if w.Code != http.StatusFound {
+		return w.Code, nil
+	}
+	p.saveCookies(w)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	return w.Code, location.Query()
+}
+
+// launch posts a signed token and returns the response.
+func (p *fakePlatform) launch(t *testing.T, db *sql.DB, state string, key *rsa.PrivateKey, claims map[string]interface{}) *httptest.ResponseRecorder {
+	token, err := signJWT(key, "platform-key", claims)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("starting transaction: %v", err)
+	}
+	form := url.Values{"state": {state}, "id_token": {token}}
+	r := httptest.NewRequest("POST", "/v2/lti13/launch", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range p.cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	LTI13Launch(w, r, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("committing: %v", err)
+	}
+	p.saveCookies(w)
+	return w
+}
+
+// saveCookies updates the browser's cookies from a response.
+func (p *fakePlatform) saveCookies(w *httptest.ResponseRecorder) {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(p.cookies, cookie.Name)
+		} else {
+			p.cookies[cookie.Name] = cookie
+		}
+	}
+}
+
+func TestLTI13Launch(t *testing.T) {
+	Config.Hostname = "ta.example.com"
+	Config.SessionSecret = "session secret"
+
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		p := newFakePlatform(t)
+		defer p.server.Close()
+		if err := p.platform.Normalize(time.Now()); err != nil {
+			t.Fatalf("normalizing platform: %v", err)
+		}
+		if err := meddler.Insert(db, "lti_platforms", p.platform); err != nil {
+			t.Fatalf("inserting platform: %v", err)
+		}
+
+		loginForm := url.Values{
+			"iss":               {p.platform.Issuer},
+			"login_hint":        {"opaque-hint"},
+			"lti_message_hint":  {"opaque-message-hint"},
+			"target_link_uri":   {"https://ta.example.com/v2/lti/quizzes"},
+			"client_id":         {p.platform.ClientID},
+			"lti_deployment_id": {"1:deployment"},
+		}
+		code, auth := p.login(t, db, loginForm)
+		if code != http.StatusFound {
+			t.Fatalf("login: got status %d, expected %d", code, http.StatusFound)
+		}
+		for key, expected := range map[string]string{
+			"scope":            "openid",
+			"response_type":    "id_token",
+			"response_mode":    "form_post",
+			"client_id":        p.platform.ClientID,
+			"redirect_uri":     "https://ta.example.com/v2/lti13/launch",
+			"login_hint":       "opaque-hint",
+			"lti_message_hint": "opaque-message-hint",
+		} {
+			if got := auth.Get(key); got != expected {
+				t.Errorf("login redirect: %s is %q, expected %q", key, got, expected)
+			}
+		}
+		state, nonce := auth.Get("state"), auth.Get("nonce")
+
+		w := p.launch(t, db, state, p.key, p.claims(nonce))
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("launch: got status %d, expected %d: %s", w.Code, http.StatusSeeOther, w.Body.String())
+		}
+
+		user := new(User)
+		if err := meddler.QueryRow(db, user, `SELECT * FROM users WHERE lti_id = ?`, "legacy-user-1"); err != nil {
+			t.Fatalf("loading user: %v", err)
+		}
+		if user.Name != "Ada Student" || user.Email != "ada@example.edu" || user.CanvasID != 123 {
+			t.Errorf("user: got %q %q %d", user.Name, user.Email, user.CanvasID)
+		}
+		asst := new(Assignment)
+		if err := meddler.QueryRow(db, asst, `SELECT * FROM assignments WHERE user_id = ?`, user.ID); err != nil {
+			t.Fatalf("loading assignment: %v", err)
+		}
+		if asst.Instructor || asst.Roles != "Learner" {
+			t.Errorf("assignment roles: got instructor=%v roles=%q", asst.Instructor, asst.Roles)
+		}
+		if asst.LTIPlatformID != p.platform.ID || asst.LTIUserID != "a6d5c443-1f51-4783-ba1a-7686ffe3b54a" ||
+			asst.LineItemsURL != "https://lms.example.edu/api/lti/courses/456/line_items" {
+			t.Errorf("assignment grade services: got platform=%d user=%q line items=%q", asst.LTIPlatformID, asst.LTIUserID, asst.LineItemsURL)
+		}
+		if expected := fmt.Sprintf("/quiz/?assignment=%d", asst.ID); w.Header().Get("Location") != expected {
+			t.Errorf("launch redirect: got %q, expected %q", w.Header().Get("Location"), expected)
+		}
+
+		// the state cannot be used again
+		if w := p.launch(t, db, state, p.key, p.claims(nonce)); w.Code != http.StatusUnauthorized {
+			t.Errorf("replayed launch: got status %d, expected %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestLTI13LaunchRejected(t *testing.T) {
+	Config.Hostname = "ta.example.com"
+	Config.SessionSecret = "session secret"
+
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		p := newFakePlatform(t)
+		defer p.server.Close()
+		if err := p.platform.Normalize(time.Now()); err != nil {
+			t.Fatalf("normalizing platform: %v", err)
+		}
+		if err := meddler.Insert(db, "lti_platforms", p.platform); err != nil {
+			t.Fatalf("inserting platform: %v", err)
+		}
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+
+		loginForm := url.Values{
+			"iss":             {p.platform.Issuer},
+			"login_hint":      {"opaque-hint"},
+			"target_link_uri": {"https://ta.example.com/v2/lti/quizzes"},
+		}
+
+		// unknown issuer and unknown deployment are rejected at login
+		badIssuer := url.Values{"iss": {"https://other.example.edu"}, "login_hint": {"x"}, "target_link_uri": {"https://ta.example.com/"}}
+		if code, _ := p.login(t, db, badIssuer); code != http.StatusBadRequest {
+			t.Errorf("login with unknown issuer: got status %d, expected %d", code, http.StatusBadRequest)
+		}
+		badDeployment := url.Values{"lti_deployment_id": {"2:other"}}
+		for key, value := range loginForm {
+			badDeployment[key] = value
+		}
+		if code, _ := p.login(t, db, badDeployment); code != http.StatusBadRequest {
+			t.Errorf("login with unknown deployment: got status %d, expected %d", code, http.StatusBadRequest)
+		}
+
+		tests := []struct {
+			name   string
+			key    *rsa.PrivateKey
+			state  func(state string) string
+			modify func(claims map[string]interface{})
+			code   int
+		}{
+			{"bad state", p.key, func(string) string { return "made-up" }, nil, http.StatusUnauthorized},
+			{"tampered state", p.key, func(state string) string { return state[:len(state)-2] + "AA" }, nil, http.StatusUnauthorized},
+			{"another browser", p.key, func(state string) string {
+				// the state is valid, but this browser never started the login
+				p.cookies = make(map[string]*http.Cookie)
+				return state
+			}, nil, http.StatusUnauthorized},
+			{"bad signature", otherKey, nil, nil, http.StatusUnauthorized},
+			{"bad nonce", p.key, nil, func(c map[string]interface{}) { c["nonce"] = "made-up" }, http.StatusUnauthorized},
+			{"expired", p.key, nil, func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, http.StatusUnauthorized},
+			{"wrong audience", p.key, nil, func(c map[string]interface{}) { c["aud"] = []string{"someone-else"} }, http.StatusUnauthorized},
+			{"unknown deployment", p.key, nil, func(c map[string]interface{}) { c[lti13ClaimPrefix+"deployment_id"] = "2:other" }, http.StatusUnauthorized},
+			{"missing custom fields", p.key, nil, func(c map[string]interface{}) { delete(c, lti13ClaimPrefix+"custom") }, http.StatusBadRequest},
+		}
+		for _, test := range tests {
+			code, auth := p.login(t, db, loginForm)
+			if code != http.StatusFound {
+				t.Fatalf("%s: login got status %d", test.name, code)
+			}
+			state, nonce := auth.Get("state"), auth.Get("nonce")
+			if test.state != nil {
+				state = test.state(state)
+			}
+			claims := p.claims(nonce)
+			if test.modify != nil {
+				test.modify(claims)
+			}
+			if w := p.launch(t, db, state, test.key, claims); w.Code != test.code {
+				t.Errorf("%s: got status %d, expected %d: %s", test.name, w.Code, test.code, w.Body.String())
+			}
+		}
+
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+			t.Fatalf("counting users: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("rejected launches created %d users", count)
+		}
+	})
+}
+
+func TestKeySetsFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	// one platform answers slowly, and another never does
+	var requests int32
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		json.NewEncoder(w).Encode(&JWKS{Keys: []*JWK{newJWK("platform-key", &key.PublicKey)}})
+	}))
+	defer slow.Close()
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+	defer func(timeout time.Duration) { keySetClient.Timeout = timeout }(keySetClient.Timeout)
+	keySetClient.Timeout = 2 * time.Second
+
+	k := &keySets{sets: make(map[string]*cachedKeySet)}
+
+	// a platform that never answers gives up after the timeout
+	hungDone := make(chan error, 1)
+	go func() {
+		_, err := k.Get(hung.URL, "platform-key")
+		hungDone <- err
+	}()
+
+	// without holding up other platforms, and concurrent lookups share a single fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := k.Get(slow.URL, "platform-key"); err != nil {
+				t.Errorf("Get: %v", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("key set fetched %d times, expected 1", n)
+	}
+	select {
+	case <-hungDone:
+		t.Errorf("the fetch from the hung platform finished first")
+	default:
+	}
+	if err := <-hungDone; err == nil {
+		t.Errorf("Get from a platform that never answers: expected an error")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics served at /metrics in the Prometheus text format
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "codegrinder",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent handling API requests, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "codegrinder",
+		Name:      "http_requests_total",
+		Help:      "API requests handled, by route and status code.",
+	}, []string{"method", "route", "code"})
+
+	containerStartDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "codegrinder",
+		Subsystem: "daycare",
+		Name:      "container_start_seconds",
+		Help:      "Time taken to create and start a container.",
+		Buckets:   []float64{.1, .25, .5, 1, 2, 4, 8, 15, 30},
+	})
+	actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "codegrinder",
+		Subsystem: "daycare",
+		Name:      "action_duration_seconds",
+		Help:      "Time from container start until an action finishes, by problem type and action.",
+		Buckets:   []float64{.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 900},
+	}, []string{"problem_type", "action"})
+	gradingLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "codegrinder",
+		Subsystem: "daycare",
+		Name:      "grading_latency_seconds",
+		Help:      "Time from a request entering the admission queue until its report card is ready, by problem type and action.",
+		Buckets:   []float64{.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 900},
+	}, []string{"problem_type", "action"})
+	actionTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "codegrinder",
+		Subsystem: "daycare",
+		Name:      "action_timeouts_total",
+		Help:      "Containers shut down for exceeding the inactivity timeout, by problem type and action.",
+	}, []string{"problem_type", "action"})
+	oomKills = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "codegrinder",
+		Subsystem: "daycare",
+		Name:      "oom_kills_total",
+		Help:      "Actions where the container ran out of memory, by problem type and action.",
+	}, []string{"problem_type", "action"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestDuration, httpRequests)
+	prometheus.MustRegister(containerStartDuration, actionDuration, gradingLatency, actionTimeouts, oomKills)
+}
+
+// registerQueueMetrics exposes the state of the daycare admission queue.
+func registerQueueMetrics(q *admissionQueue) {
+	gauge := func(name, help string, get func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "codegrinder",
+			Subsystem: "daycare",
+			Name:      name,
+			Help:      help,
+		}, get)
+	}
+	prometheus.MustRegister(
+		gauge("container_slots", "Maximum number of containers that can run at once.", func() float64 {
+			slots, _, _, _ := q.stats()
+			return float64(slots)
+		}),
+		gauge("containers_running", "Number of containers currently running.", func() float64 {
+			_, running, _, _ := q.stats()
+			return float64(running)
+		}),
+		gauge("queue_length", "Number of requests waiting for a container.", func() float64 {
+			_, _, waiting, _ := q.stats()
+			return float64(waiting)
+		}),
+	)
+}
+
+// instrument is martini middleware that records the latency and
+// status code of a request, labeled by its route pattern.
+func instrument(w http.ResponseWriter, r *http.Request, c martini.Context, route martini.Route) {
+	start := time.Now()
+	c.Next()
+	rw := w.(martini.ResponseWriter)
+	httpRequestDuration.WithLabelValues(r.Method, route.Pattern()).Observe(time.Since(start).Seconds())
+	httpRequests.WithLabelValues(r.Method, route.Pattern(), strconv.Itoa(rw.Status())).Inc()
+}
+
+// routeMetrics serves /metrics on the main listener if a metrics token is
+// configured and there is no separate metrics listener. Metrics reveal
+// load, queue lengths, routes, and internal failures that should not be
+// visible to students or the wider internet, so they are never public.
+func routeMetrics(r martini.Router) {
+	switch {
+	case Config.ListenMetrics != "":
+		// served by serveMetrics
+	case Config.MetricsToken != "":
+		r.Get("/metrics", metricsHandler().ServeHTTP)
+	default:
+		log.Printf("not serving /metrics: set metricsToken or listenMetrics in the config file to turn it on")
+	}
+}
+
+// serveMetrics starts the plain http listener for /metrics if one is
+// configured. It is meant for a private address that only the scraper
+// can reach. It returns the server so it can be shut down.
+func serveMetrics() *http.Server {
+	if Config.ListenMetrics == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	log.Printf("serving /metrics on %s", Config.ListenMetrics)
+	server := &http.Server{Addr: Config.ListenMetrics, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServe: %v", err)
+		}
+	}()
+	return server
+}
+
+// metricsHandler serves /metrics. If a metrics token is configured,
+// the scraper must supply it as a bearer token.
+func metricsHandler() http.Handler {
+	h := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := []byte("Bearer " + Config.MetricsToken)
+		if Config.MetricsToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			loggedHTTPErrorf(w, http.StatusUnauthorized, "missing or incorrect metrics token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
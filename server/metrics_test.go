@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+)
+
+func TestMetrics(t *testing.T) {
+	defer func() { Config.MetricsToken, Config.ListenMetrics = "", "" }()
+	var m *martini.Martini
+	setup := func() {
+		r := martini.NewRouter()
+		m = martini.New()
+		m.MapTo(r, (*martini.Routes)(nil))
+		m.Action(r.Handle)
+		routeMetrics(r)
+		r.Get("/v2/widgets/:widget_id", instrument, func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		return w
+	}
+
+	// metrics are not public by default
+	setup()
+	get("/v2/widgets/12", "")
+	if w := get("/metrics", ""); w.Code != http.StatusNotFound {
+		t.Errorf("metrics with no token configured: got status %d, expected %d", w.Code, http.StatusNotFound)
+	}
+
+	// or when they have their own listener
+	Config.ListenMetrics = "127.0.0.1:0"
+	setup()
+	if w := get("/metrics", ""); w.Code != http.StatusNotFound {
+		t.Errorf("metrics with a separate listener: got status %d on the main listener, expected %d", w.Code, http.StatusNotFound)
+	}
+	server := serveMetrics()
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	server.Close()
+	if w.Code != http.StatusOK {
+		t.Errorf("metrics on the separate listener: got status %d", w.Code)
+	}
+	Config.ListenMetrics = ""
+
+	Config.MetricsToken = "scraper token"
+	setup()
+	if w := get("/metrics", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("metrics without a token: got status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+	w = get("/metrics", "scraper token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("metrics with a token: got status %d", w.Code)
+	}
+	body, _ := ioutil.ReadAll(w.Body)
+	expected := `codegrinder_http_requests_total{code="418",method="GET",route="/v2/widgets/:widget_id"} 1`
+	if !strings.Contains(string(body), expected) {
+		t.Errorf("metrics output does not contain %s", expected)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateFresh(t *testing.T) {
+	forEachEmptyDatabase(t, func(t *testing.T, db *sql.DB, driverName string) {
+		if err := migrateDB(db, driverName, false); err == nil {
+			t.Errorf("expected an error checking an unmigrated database")
+		}
+		if err := migrateDB(db, driverName, true); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		version, err := schemaVersion(db, driverName)
+		if err != nil {
+			t.Fatalf("schemaVersion: %v", err)
+		}
+		if latest := migrations[len(migrations)-1].Version; version != latest {
+			t.Errorf("got version %d, expected %d", version, latest)
+		}
+
+		// running it again is harmless
+		if err := migrateDB(db, driverName, false); err != nil {
+			t.Errorf("checking a migrated database: %v", err)
+		}
+	})
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	forEachEmptyDatabase(t, func(t *testing.T, db *sql.DB, driverName string) {
+		// a database created by the original setup script
+		script := migrations[0].SQLite
+		if driverName == postgresDriver {
+			script = migrations[0].Postgres
+		}
+		if _, err := db.Exec(script); err != nil {
+			t.Fatalf("creating legacy schema: %v", err)
+		}
+		if _, err := db.Exec(`INSERT INTO problem_types (name, image) VALUES ('python3unittest', 'codegrinder/python3')`); err != nil {
+			t.Fatalf("inserting problem type: %v", err)
+		}
+		insertAction := `INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, ` +
+			`max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) ` +
+			`VALUES ('python3unittest', ?, 'make grade', ?, 'Grading‥', false, 60, 120, 120, 100, 10, 128, 20)`
+		if _, err := db.Exec(insertAction, "grade", "xunit"); err != nil {
+			t.Fatalf("inserting problem type action: %v", err)
+		}
+		if _, err := db.Exec(insertAction, "tap", "tap"); err == nil {
+			t.Fatalf("legacy schema accepted a tap parser")
+		}
+
+		if err := migrateDB(db, driverName, true); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+
+		// existing data survives and the new parsers are allowed
+		var parser string
+		if err := db.QueryRow(`SELECT parser FROM problem_type_actions WHERE action = 'grade'`).Scan(&parser); err != nil || parser != "xunit" {
+			t.Errorf("existing problem type action: got %q, %v", parser, err)
+		}
+		if _, err := db.Exec(insertAction, "tap", "tap"); err != nil {
+			t.Errorf("migrated schema rejected a tap parser: %v", err)
+		}
+	})
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	forEachEmptyDatabase(t, func(t *testing.T, db *sql.DB, driverName string) {
+		if err := migrateDB(db, driverName, true); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		future := migrations[len(migrations)-1].Version + 1
+		if _, err := db.Exec(`INSERT INTO schema_migrations (version, note, applied_at) VALUES (?, ?, ?)`,
+			future, "from the future", time.Now()); err != nil {
+			t.Fatalf("recording future migration: %v", err)
+		}
+		err := migrateDB(db, driverName, true)
+		if err == nil || !strings.Contains(err.Error(), "only understands") {
+			t.Errorf("expected migrateDB to refuse a newer schema, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// Grades are posted to the LMS through an outbox: grading saves a pending
+// post in the same transaction as the new score, and a background worker
+// sends it, retrying with exponential backoff. Since the outbox is in the
+// database, pending posts survive a restart.
+//
+// The worker claims each post before sending it by moving its next attempt
+// to where the backoff would put it, so a post whose outcome cannot be
+// recorded waits like any other failure instead of being retried at once.
+
+const (
+	gradePostMaxAttempts    = 10
+	gradePostMinBackoff     = 10 * time.Second
+	gradePostMaxBackoff     = 5 * time.Minute
+	gradePostTimeout        = time.Minute
+	gradePostPollInterval   = 30 * time.Second
+	gradePostBatchSize      = 20
+	gradePostStaleAge       = time.Hour
+	gradePostPending        = "pending"
+	gradePostSent           = "sent"
+	gradePostFailed         = "failed"
+	gradePostFilterStale    = "stale"
+	gradePostFilterProblems = "problems"
+)
+
+// queueGrade records that an assignment's grade should be posted to the LMS,
+// replacing any earlier post for the same assignment that has not gone out yet.
+// The caller must have locked the assignment (see forUpdate), which keeps two
+// transactions from both finding no post and both inserting one.
+func queueGrade(tx *sql.Tx, asst *Assignment, msg string, now time.Time) error {
+	// assignments with nowhere to post a grade, e.g., for instructors
+	if asst.Instructor || (asst.LTIPlatformID == 0 && asst.GradeID == "") {
+		return nil
+	}
+
+	post := new(GradePost)
+	if err := meddler.QueryRow(tx, post, `SELECT * FROM grade_posts WHERE assignment_id = ?`, asst.ID); err != nil {
+		if err != sql.ErrNoRows {
+			return err
+		}
+		post = &GradePost{AssignmentID: asst.ID, CreatedAt: now}
+	}
+	post.Message = msg
+	post.Status = gradePostPending
+	post.Revision++
+	post.Attempts = 0
+	post.LastError = ""
+	post.NextAttemptAt = now
+	post.UpdatedAt = now
+	if err := meddler.Save(tx, "grade_posts", post); err != nil {
+		return err
+	}
+	if outbox != nil {
+		outbox.Wake()
+	}
+	return nil
+}
+
+// gradeBackoff gives the delay before the next try after a failed attempt.
+func gradeBackoff(attempts int64) time.Duration {
+	delay := gradePostMinBackoff
+	for i := int64(1); i < attempts && delay < gradePostMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > gradePostMaxBackoff {
+		delay = gradePostMaxBackoff
+	}
+	return delay
+}
+
+// gradeOutbox is the worker that sends pending grade posts.
+// Posts that are still pending when it stops stay in the database
+// for the next time the TA starts.
+type gradeOutbox struct {
+	*worker
+	db      *sql.DB
+	timeout time.Duration // limit for each post, including any token or line item requests
+}
+
+// outbox is the worker for the TA role
+var outbox *gradeOutbox
+
+// newGradeOutbox creates a worker.
+func newGradeOutbox(db *sql.DB) *gradeOutbox {
+	o := &gradeOutbox{db: db, timeout: gradePostTimeout}
+	o.worker = newWorker(gradePostPollInterval, func(ctx context.Context) { o.Drain(ctx, time.Now()) })
+	return o
+}
+
+// Drain sends every post that is due, stopping when none are left,
+// when it cannot claim any of them, or when ctx is done.
+// It returns the number of posts sent successfully.
+func (o *gradeOutbox) Drain(ctx context.Context, now time.Time) int {
+	sent := 0
+	for {
+		var posts []*GradePost
+		err := o.withTx(func(tx *sql.Tx) error {
+			return meddler.QueryAll(tx, &posts, `SELECT * FROM grade_posts `+
+				`WHERE status = ? AND next_attempt_at <= ? `+
+				`ORDER BY next_attempt_at LIMIT ?`, gradePostPending, now.UTC(), gradePostBatchSize)
+		})
+		if err != nil {
+			log.Printf("grade outbox: db error loading pending posts: %v", err)
+			return sent
+		}
+		if len(posts) == 0 {
+			return sent
+		}
+		claimed := 0
+		for _, post := range posts {
+			if ctx.Err() != nil {
+				return sent
+			}
+			ok, err := o.claim(post, now)
+			if err != nil {
+				log.Printf("grade outbox: db error claiming post %d: %v", post.ID, err)
+				continue
+			}
+			if !ok {
+				// replaced since it was loaded; the new revision is due now
+				continue
+			}
+			claimed++
+			if o.send(ctx, post, now) {
+				sent++
+			}
+		}
+		if claimed == 0 {
+			return sent
+		}
+	}
+}
+
+// claim reschedules a post as if the attempt about to be made will fail.
+// It reports false if the post was replaced since it was loaded.
+func (o *gradeOutbox) claim(post *GradePost, now time.Time) (bool, error) {
+	var claimed bool
+	err := o.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`UPDATE grade_posts SET next_attempt_at = ? `+
+			`WHERE id = ? AND revision = ? AND status = ?`,
+			now.Add(gradeBackoff(post.Attempts+1)).UTC(), post.ID, post.Revision, gradePostPending)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		claimed = count == 1
+		return err
+	})
+	return claimed, err
+}
+
+// send makes one attempt at a post and records the outcome.
+// An attempt cut short because ctx is done is not counted;
+// the post stays pending until the backoff set by claim passes.
+func (o *gradeOutbox) send(ctx context.Context, post *GradePost, now time.Time) bool {
+	var asst *Assignment
+	var platforms map[int64]*LTIPlatform
+	err := o.withTx(func(tx *sql.Tx) error {
+		asst = new(Assignment)
+		if err := meddler.Load(tx, "assignments", asst, post.AssignmentID); err != nil {
+			return err
+		}
+		var err error
+		platforms, err = getLTIPlatforms(tx)
+		return err
+	})
+
+	// the network request happens outside of any transaction
+	if err == nil {
+		postCtx, cancel := context.WithTimeout(ctx, o.timeout)
+		err = saveGrade(postCtx, asst, platforms[asst.LTIPlatformID], post.Message)
+		cancel()
+	}
+	if err != nil && ctx.Err() != nil {
+		log.Printf("grade outbox: post for assignment %d interrupted: %v", post.AssignmentID, err)
+		return false
+	}
+
+	post.Attempts++
+	post.UpdatedAt = time.Now()
+	if err == nil {
+		post.Status = gradePostSent
+		post.LastError = ""
+		post.SentAt = &post.UpdatedAt
+	} else {
+		log.Printf("grade outbox: error posting grade for assignment %d (attempt %d/%d): %v",
+			post.AssignmentID, post.Attempts, gradePostMaxAttempts, err)
+		post.LastError = err.Error()
+		if post.Attempts >= gradePostMaxAttempts {
+			log.Printf("  giving up")
+			post.Status = gradePostFailed
+		} else {
+			post.NextAttemptAt = now.Add(gradeBackoff(post.Attempts))
+		}
+	}
+
+	// only record the outcome if the post was not replaced in the meantime;
+	// times are stored in UTC to match meddler's localtime fields
+	var sentAt interface{}
+	if post.SentAt != nil {
+		sentAt = post.SentAt.UTC()
+	}
+	err2 := o.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE grade_posts `+
+			`SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, sent_at = ?, updated_at = ? `+
+			`WHERE id = ? AND revision = ?`,
+			post.Status, post.Attempts, post.LastError, post.NextAttemptAt.UTC(), sentAt, post.UpdatedAt.UTC(),
+			post.ID, post.Revision)
+		return err
+	})
+	if err2 != nil {
+		log.Printf("grade outbox: db error updating post %d: %v", post.ID, err2)
+	}
+	return err == nil
+}
+
+func (o *gradeOutbox) withTx(f func(tx *sql.Tx) error) error {
+	return writeTx(o.db, f)
+}
+
+// GetGradePosts handles /v2/grade_posts requests,
+// returning grade posts that need attention.
+// By default it lists failed posts and pending posts that are stale,
+// i.e., that have failed at least once or are overdue.
+// The status parameter can be pending, sent, failed, stale, or problems (the default).
+func GetGradePosts(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	status := r.FormValue("status")
+	if status == "" {
+		status = gradePostFilterProblems
+	}
+	overdue := time.Now().Add(-gradePostStaleAge).UTC()
+
+	var where string
+	var args []interface{}
+	switch status {
+	case gradePostPending, gradePostSent, gradePostFailed:
+		where, args = `status = ?`, []interface{}{status}
+	case gradePostFilterStale:
+		where, args = `status = ? AND (attempts > 0 OR next_attempt_at < ?)`, []interface{}{gradePostPending, overdue}
+	case gradePostFilterProblems:
+		where, args = `status = ? OR (status = ? AND (attempts > 0 OR next_attempt_at < ?))`, []interface{}{gradePostFailed, gradePostPending, overdue}
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unknown status %q", status)
+		return
+	}
+
+	posts := []*GradePost{}
+	if err := meddler.QueryAll(tx, &posts, `SELECT * FROM grade_posts WHERE `+where+` ORDER BY id`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, posts)
+}
+
+// PostGradePostResend handles /v2/grade_posts/:grade_post_id/resend requests,
+// resetting a post so the worker sends it again right away.
+func PostGradePostResend(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	postID, err := parseID(w, "grade_post_id", params["grade_post_id"])
+	if err != nil {
+		return
+	}
+
+	post := new(GradePost)
+	if err := meddler.Load(tx, "grade_posts", post, postID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	now := time.Now()
+	post.Status = gradePostPending
+	post.Revision++
+	post.Attempts = 0
+	post.LastError = ""
+	post.NextAttemptAt = now
+	post.UpdatedAt = now
+	if err := meddler.Update(tx, "grade_posts", post); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if outbox != nil {
+		outbox.Wake()
+	}
+	render.JSON(http.StatusOK, post)
+}
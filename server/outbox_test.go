@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func TestGradeOutbox(t *testing.T) {
+	Config.LTISecret = "lti secret"
+
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		// the LMS fails until told otherwise
+		var lms struct {
+			sync.Mutex
+			up       bool
+			requests int
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lms.Lock()
+			defer lms.Unlock()
+			lms.requests++
+			if !lms.up {
+				http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		// a student and an instructor launch the same assignment
+		now := time.Now().Round(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		var student, instructor *Assignment
+		for _, role := range []string{"Learner", "Instructor"} {
+			form := testLaunchForm(role, role, 4)
+			form.OutcomeServiceURL = server.URL
+			if role == "Learner" {
+				form.PersonSourcedID = "grade-id"
+				form.CanvasUserID = 3
+			}
+			_, _, asst := launchTestAssignment(t, tx, form, now, nil)
+			if err := queueGrade(tx, asst, "report", now); err != nil {
+				t.Fatalf("queueGrade: %v", err)
+			}
+			if role == "Learner" {
+				student = asst
+			} else {
+				instructor = asst
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		loadPost := func() *GradePost {
+			post := new(GradePost)
+			if err := meddler.QueryRow(db, post, `SELECT * FROM grade_posts WHERE assignment_id = ?`, student.ID); err != nil {
+				t.Fatalf("loading grade post: %v", err)
+			}
+			return post
+		}
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM grade_posts WHERE assignment_id = ?`, instructor.ID).Scan(&count); err != nil || count != 0 {
+			t.Errorf("instructor grade was queued: count=%d err=%v", count, err)
+		}
+
+		// the first attempt fails and is rescheduled
+		o := newGradeOutbox(db)
+		if sent := o.Drain(context.Background(), now); sent != 0 {
+			t.Errorf("sent %d posts while the LMS was down", sent)
+		}
+		post := loadPost()
+		if post.Status != gradePostPending || post.Attempts != 1 || post.LastError == "" || !post.NextAttemptAt.Equal(now.Add(gradePostMinBackoff)) {
+			t.Errorf("after a failure: got %+v", post)
+		}
+
+		// nothing is due until the backoff passes
+		if o.Drain(context.Background(), now); lms.requests != 1 {
+			t.Errorf("expected no retry before the backoff, LMS saw %d requests", lms.requests)
+		}
+
+		// the admin listing shows it as a problem
+		m := martini.New()
+		m.Use(render.Renderer())
+		r := martini.NewRouter()
+		withTx := testWithTx(t, db, nil)
+		r.Get("/v2/grade_posts", withTx, GetGradePosts)
+		r.Post("/v2/grade_posts/:grade_post_id/resend", withTx, PostGradePostResend)
+		m.Action(r.Handle)
+
+		list := func(status string) []*GradePost {
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest("GET", "/v2/grade_posts?status="+status, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("listing %q: status %d: %s", status, w.Code, w.Body.String())
+			}
+			var posts []*GradePost
+			if err := json.Unmarshal(w.Body.Bytes(), &posts); err != nil {
+				t.Fatalf("decoding list: %v", err)
+			}
+			return posts
+		}
+		if posts := list(""); len(posts) != 1 || posts[0].ID != post.ID {
+			t.Errorf("default listing: got %v", posts)
+		}
+
+		// after too many failures it gives up
+		if _, err := db.Exec(`UPDATE grade_posts SET attempts = ? WHERE id = ?`, gradePostMaxAttempts-1, post.ID); err != nil {
+			t.Fatalf("updating attempts: %v", err)
+		}
+		later := now.Add(time.Hour)
+		o.Drain(context.Background(), later)
+		if post = loadPost(); post.Status != gradePostFailed {
+			t.Errorf("after %d failures: status is %q", gradePostMaxAttempts, post.Status)
+		}
+		if posts := list(gradePostFailed); len(posts) != 1 {
+			t.Errorf("failed listing: got %d posts", len(posts))
+		}
+
+		// a forced resend goes out once the LMS is back
+		lms.Lock()
+		lms.up = true
+		lms.Unlock()
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("POST", "/v2/grade_posts/"+strconv.FormatInt(post.ID, 10)+"/resend", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("resend: status %d: %s", w.Code, w.Body.String())
+		}
+		if sent := o.Drain(context.Background(), time.Now()); sent != 1 {
+			t.Errorf("sent %d posts after resend, expected 1", sent)
+		}
+		if post = loadPost(); post.Status != gradePostSent || post.SentAt == nil || post.LastError != "" {
+			t.Errorf("after resend: got %+v", post)
+		}
+		if posts := list(""); len(posts) != 0 {
+			t.Errorf("default listing after success: got %d posts", len(posts))
+		}
+	})
+}
+
+func TestGradeOutboxTimeout(t *testing.T) {
+	Config.LTISecret = "lti secret"
+
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		// the LMS never answers
+		hung := make(chan struct{})
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			select {
+			case <-hung:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(hung)
+
+		now := time.Now().Round(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		form := testLaunchForm("learner", "Learner", 3)
+		form.PersonSourcedID = "grade-id"
+		form.OutcomeServiceURL = server.URL
+		_, _, asst := launchTestAssignment(t, tx, form, now, nil)
+		if err := queueGrade(tx, asst, "report", now); err != nil {
+			t.Fatalf("queueGrade: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		// the attempt gives up after the per-post timeout and is rescheduled
+		o := newGradeOutbox(db)
+		o.timeout = 50 * time.Millisecond
+		if sent := o.Drain(context.Background(), now); sent != 0 {
+			t.Errorf("sent %d posts to an LMS that never answers", sent)
+		}
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Errorf("LMS saw %d requests, expected 1", n)
+		}
+		post := new(GradePost)
+		if err := meddler.QueryRow(db, post, `SELECT * FROM grade_posts WHERE assignment_id = ?`, asst.ID); err != nil {
+			t.Fatalf("loading grade post: %v", err)
+		}
+		if post.Status != gradePostPending || post.Attempts != 1 || post.LastError == "" || !post.NextAttemptAt.Equal(now.Add(gradePostMinBackoff)) {
+			t.Errorf("after a timeout: got %+v", post)
+		}
+
+		// a claimed post is not due again until the backoff passes,
+		// even if the outcome of the attempt is never recorded
+		later := now.Add(time.Hour)
+		if ok, err := o.claim(post, later); err != nil || !ok {
+			t.Fatalf("claim: got %v, %v", ok, err)
+		}
+		var due int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM grade_posts WHERE next_attempt_at <= ?`, later.UTC()).Scan(&due); err != nil || due != 0 {
+			t.Errorf("after claiming: %d posts due, err=%v", due, err)
+		}
+
+		// a post replaced since it was loaded is left alone
+		post.Revision--
+		if ok, err := o.claim(post, later); err != nil || ok {
+			t.Errorf("claiming a replaced post: got %v, %v", ok, err)
+		}
+
+		// a drain cut short by shutdown leaves the post pending without counting the attempt
+		o.timeout = time.Minute
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		later = later.Add(time.Hour)
+		if sent := o.Drain(ctx, later); sent != 0 {
+			t.Errorf("sent %d posts during an interrupted drain", sent)
+		}
+		if n := atomic.LoadInt32(&requests); n != 2 {
+			t.Errorf("LMS saw %d requests, expected 2", n)
+		}
+		if err := meddler.QueryRow(db, post, `SELECT * FROM grade_posts WHERE assignment_id = ?`, asst.ID); err != nil {
+			t.Fatalf("loading grade post: %v", err)
+		}
+		if post.Status != gradePostPending || post.Attempts != 1 {
+			t.Errorf("after an interrupted drain: got %+v", post)
+		}
+
+		// once the deadline has passed, nothing more is tried
+		if sent := o.Drain(ctx, later.Add(time.Hour)); sent != 0 {
+			t.Errorf("sent %d posts after the deadline", sent)
+		}
+		if n := atomic.LoadInt32(&requests); n != 2 {
+			t.Errorf("LMS saw %d requests after the deadline, expected 2", n)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	. "github.com/russross/codegrinder/types"
+)
+
+func runAndParse(n *Nanny, cmd []string, parser ReportCardParser) {
+	filename := parser.ResultsFile()
+
+	// run tests with machine-readable output
+	_, _, _, status, err := n.Exec(cmd, nil, false)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	// parse the test results
+	files, err := n.GetFiles([]string{filename})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting unit test results")
+		return
+	}
+
+	parser.Parse(n.ReportCard, n.Start, files[filename])
+	n.ReportCard.ParsePointsAnnotations()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopTestPostgres()
+	os.Exit(code)
+}
+
+// testPostgres is the PostgreSQL server shared by every test in the package.
+var testPostgres struct {
+	once sync.Once
+	url  string
+	err  error
+
+	// set if the server was started by the tests
+	pgCtl      string
+	dir        string
+	credential *syscall.Credential
+}
+
+// postgresTestURL gives the connection URL of a PostgreSQL server for tests.
+// If CODEGRINDER_TEST_POSTGRES is set, that server is used. Otherwise a private
+// server is started in a temporary directory, listening only on a Unix socket,
+// using the initdb and pg_ctl from the PATH or from a standard install location.
+// It is stopped by TestMain after the tests run.
+func postgresTestURL() (string, error) {
+	testPostgres.once.Do(func() {
+		if url := os.Getenv("CODEGRINDER_TEST_POSTGRES"); url != "" {
+			testPostgres.url = url
+			return
+		}
+		testPostgres.url, testPostgres.err = startTestPostgres()
+	})
+	return testPostgres.url, testPostgres.err
+}
+
+func startTestPostgres() (string, error) {
+	bin, err := findPostgresBin()
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "codegrinder-postgres")
+	if err != nil {
+		return "", err
+	}
+
+	// PostgreSQL will not run as root, so use nobody instead
+	var credential *syscall.Credential
+	if os.Geteuid() == 0 {
+		credential = &syscall.Credential{Uid: 65534, Gid: 65534}
+		if err := os.Chown(dir, 65534, 65534); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	testPostgres.credential = credential
+
+	data := filepath.Join(dir, "data")
+	run := func(name string, args ...string) error {
+		cmd := exec.Command(filepath.Join(bin, name), args...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %v\n%s", name, err, out)
+		}
+		return nil
+	}
+	if err := run("initdb", "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync"); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	// no TCP listener, and no fsync since the data is thrown away
+	options := fmt.Sprintf("-c listen_addresses='' -k %s -F", dir)
+	if err := run("pg_ctl", "-D", data, "-o", options, "-l", filepath.Join(dir, "server.log"), "-w", "start"); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	testPostgres.pgCtl, testPostgres.dir = filepath.Join(bin, "pg_ctl"), dir
+	return fmt.Sprintf("postgres:///postgres?host=%s&user=postgres&sslmode=disable", dir), nil
+}
+
+// findPostgresBin finds the directory holding initdb and pg_ctl.
+func findPostgresBin() (string, error) {
+	if path, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(path), nil
+	}
+	var candidates []string
+	for _, pattern := range []string{
+		"/usr/lib/postgresql/*/bin/initdb",
+		"/usr/pgsql-*/bin/initdb",
+		"/usr/local/opt/postgresql*/bin/initdb",
+		"/opt/homebrew/opt/postgresql*/bin/initdb",
+	} {
+		matches, _ := filepath.Glob(pattern)
+		candidates = append(candidates, matches...)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no PostgreSQL server binaries (initdb and pg_ctl) found; install PostgreSQL or set CODEGRINDER_TEST_POSTGRES")
+	}
+	return filepath.Dir(candidates[0]), nil
+}
+
+// stopTestPostgres shuts down the server started by postgresTestURL, if any.
+func stopTestPostgres() {
+	if testPostgres.pgCtl == "" {
+		return
+	}
+	data := filepath.Join(testPostgres.dir, "data")
+	cmd := exec.Command(testPostgres.pgCtl, "-D", data, "-m", "immediate", "-w", "stop")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: testPostgres.credential}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "stopping test PostgreSQL server: %v\n%s", err, out)
+	}
+	os.RemoveAll(testPostgres.dir)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"log"
 	"net/http"
 	"sort"
@@ -32,8 +33,9 @@ func PostProblemBundleConfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser *
 // PutProblemBundle handles a request to /v2/problem_bundles/:problem_id,
 // updating an existing problem.
 // The bundle must have a full set of passing commits signed by the daycare.
-// If any assignments exist that refer to this problem, then the updates cannot change the number
-// of steps in the problem.
+// Each update publishes a new revision of the problem. Assignments already in progress
+// stay pinned to the revision they started on unless the bundle asks to migrate them,
+// in which case the updates cannot change the number of steps or their problem types.
 func PutProblemBundle(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, bundle ProblemBundle, render render.Render) {
 	if bundle.Problem == nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must contain a problem")
@@ -69,8 +71,8 @@ func PutProblemBundle(w http.ResponseWriter, tx *sql.Tx, params martini.Params,
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
-	if assignmentCount > 0 {
-		// if this is an active problem, it must have the same number of steps
+	if assignmentCount > 0 && bundle.MigrateAssignments {
+		// if this is an active problem being migrated, it must have the same number of steps
 		// and steps must be of the same problem types
 		var oldSteps []*ProblemStep
 		if err := meddler.QueryAll(tx, &oldSteps, `SELECT * FROM problem_steps WHERE problem_id = ?`, bundle.Problem.ID); err != nil {
@@ -179,7 +181,9 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 		steps[i].Solution = commit.Files
 	}
 
+	// each save publishes a new revision of the problem
 	isUpdate, oldStepCount := false, 0
+	problem.Revision = 1
 	if problem.ID != 0 {
 		isUpdate = true
 
@@ -188,29 +192,68 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 			return
 		}
+		if err := tx.QueryRow(`SELECT revision + 1 FROM problems WHERE id = ?`, problem.ID).Scan(&problem.Revision); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+
+		// removing a step would also remove the commits that refer to it
+		if oldStepCount > len(steps) {
+			var commitCount int
+			if err := tx.QueryRow(`SELECT COUNT(1) FROM commits WHERE problem_id = ? AND step > ?`, problem.ID, len(steps)).Scan(&commitCount); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if commitCount > 0 {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "cannot remove steps that students have already worked on; create a new problem instead")
+				return
+			}
+		}
 	}
 	if err := meddler.Save(tx, "problems", problem); err != nil {
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
 
-	// delete the old steps if applicable
-	if _, err := tx.Exec(`DELETE FROM problem_steps WHERE problem_id = ?`, problem.ID); err != nil {
+	// update the steps in place, since commits refer to them
+	if _, err := tx.Exec(`DELETE FROM problem_steps WHERE problem_id = ? AND step > ?`, problem.ID, len(steps)); err != nil {
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
 	for _, step := range steps {
 		step.ProblemID = problem.ID
 
-		// insert a new record
-		if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+		if step.Step > int64(oldStepCount) {
+			// insert a new record
+			if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			continue
+		}
+		if err := updateProblemStep(tx, step); err != nil {
 			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 			return
 		}
 	}
+	if err := publishProblemRevision(tx, problem); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if isUpdate && bundle.MigrateAssignments {
+		result, err := tx.Exec(`UPDATE assignment_problem_revisions SET revision = ? WHERE problem_id = ?`, problem.Revision, problem.ID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if count, err := result.RowsAffected(); err == nil {
+			log.Printf("problem %s (%d): migrated %d assignment(s) to revision %d", problem.Unique, problem.ID, count, problem.Revision)
+		}
+	}
 
 	if isUpdate {
-		log.Printf("problem %s (%d) with %d step(s) updated", problem.Unique, problem.ID, len(steps))
+		log.Printf("problem %s (%d) with %d step(s) updated to revision %d", problem.Unique, problem.ID, len(steps), problem.Revision)
 	} else {
 		log.Printf("problem %s (%d) with %d step(s) created", problem.Unique, problem.ID, len(steps))
 	}
@@ -218,6 +261,26 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 	render.JSON(http.StatusOK, bundle)
 }
 
+// updateProblemStep overwrites an existing problem step.
+func updateProblemStep(tx *sql.Tx, step *ProblemStep) error {
+	files, err := json.Marshal(step.Files)
+	if err != nil {
+		return err
+	}
+	whitelist, err := json.Marshal(step.Whitelist)
+	if err != nil {
+		return err
+	}
+	solution, err := json.Marshal(step.Solution)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE problem_steps SET problem_type = ?, note = ?, instructions = ?, weight = ?, files = ?, whitelist = ?, solution = ? `+
+		`WHERE problem_id = ? AND step = ?`,
+		step.ProblemType, step.Note, step.Instructions, step.Weight, string(files), string(whitelist), string(solution), step.ProblemID, step.Step)
+	return err
+}
+
 // PostProblemBundleUnconfirmed handles a request to /v2/problem_bundles/unconfirmed,
 // signing a new/updated problem that has not yet been tested on the daycare.
 func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle ProblemBundle, render render.Render) {
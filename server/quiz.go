@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -178,7 +177,7 @@ func DeleteQuiz(w http.ResponseWriter, tx *sql.Tx, currentUser *User, params mar
 	}
 
 	assignment := new(Assignment)
-	if err = meddler.QueryRow(tx, assignment, `SELECT DISTINCT(assignments.*) `+
+	if err = meddler.QueryRow(tx, assignment, `SELECT DISTINCT assignments.* `+
 		`FROM assignments JOIN quizzes ON assignments.lti_id = quizzes.lti_id `+
 		`WHERE quizzes.id = ? AND assignments.user_id = ?`, quizID, currentUser.ID); err != nil {
 		loggedHTTPDBNotFoundError(w, err)
@@ -654,11 +653,11 @@ func gradeQuizClass(now time.Time, tx *sql.Tx, quizID int64) error {
 		return err
 	}
 
-	// get the assignments
+	// get the assignments, locked since their scores will be updated
 	assignments := []*Assignment{}
 	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments `+
 		`WHERE lti_id = ? `+
-		`ORDER BY id`, quiz.LtiID); err != nil {
+		`ORDER BY id`+forUpdate(), quiz.LtiID); err != nil {
 		return err
 	}
 
@@ -717,46 +716,20 @@ func gradeQuizClass(now time.Time, tx *sql.Tx, quizID int64) error {
 		index = end
 	}
 
-	// send grade to the LMS in a goroutine
-	// so we can wrap up the transaction and return to the user
-	go func() {
-		start := time.Now()
-	OUTER:
-		for _, asst := range assignments {
-			msg := messages[asst]
-
-			// try up to 10 times before giving up
-			tries := 10
-			minSleepTime := 10 * time.Second
-			maxSleepTime := 5 * time.Minute
-			sleepTime := minSleepTime
-			for i := 0; i < tries; i++ {
-				err := saveGrade(asst, msg)
-				if err == nil {
-					continue OUTER
-				}
-				log.Printf("error posting grade back to LMS (attempt %d/%d): %v", i+1, tries, err)
-				if i+1 < 10 {
-					log.Printf("  will try again in %v", sleepTime)
-					time.Sleep(sleepTime)
-					sleepTime *= 2
-					if sleepTime > maxSleepTime {
-						sleepTime = maxSleepTime
-					}
-				} else {
-					log.Printf("  giving up")
-				}
-			}
+	// queue the grades for the LMS; the outbox worker sends them
+	// after the transaction commits
+	for _, asst := range assignments {
+		if err := queueGrade(tx, asst, messages[asst], now); err != nil {
+			return err
 		}
-		log.Printf("posted %d grades to the LMS in %v", len(assignments), time.Since(start))
-	}()
+	}
 
 	return nil
 }
 
 func GetQuizWeights(tx *sql.Tx, ltiID string) (majorWeights map[string]float64, minorWeights map[string][]float64, err error) {
 	weights := []*StepWeight{}
-	if err := meddler.QueryAll(tx, &weights, `SELECT quizzes.id::text AS major_key, quizzes.weight AS major_weight, questions.question_number AS minor_key, questions.weight AS minor_weight `+
+	if err := meddler.QueryAll(tx, &weights, `SELECT CAST(quizzes.id AS TEXT) AS major_key, quizzes.weight AS major_weight, questions.question_number AS minor_key, questions.weight AS minor_weight `+
 		`FROM quizzes JOIN questions ON quizzes.id = questions.quiz_id `+
 		`WHERE quizzes.lti_id = ? `+
 		`ORDER BY quizzes.id, questions.question_number`, ltiID); err != nil {
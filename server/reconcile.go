@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// how often the daycare looks for containers that no nanny owns
+	sandboxReconcileInterval = 5 * time.Minute
+
+	// a container this young whose UID is allocated may still be starting up,
+	// with its nanny not yet created
+	sandboxReconcileGrace = time.Minute
+)
+
+var orphanedContainers = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "codegrinder",
+	Subsystem: "daycare",
+	Name:      "orphaned_containers_total",
+	Help:      "Containers found with no nanny to own them, by whether they were removed or removal failed.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(orphanedContainers)
+}
+
+// orphanUIDs holds the UIDs of orphaned containers that could not be removed.
+// They stay allocated so no new container shares a UID with one of them.
+// Protected by uidsMutex.
+var orphanUIDs = make(map[int64]bool)
+
+// reconcileReport summarizes one pass of reconcileSandboxes.
+type reconcileReport struct {
+	Found   int // containers created by this daycare
+	Owned   int // containers belonging to a live nanny or waiting in the warm pool
+	Removed int // orphans removed
+	Failed  int // orphans that could not be removed
+}
+
+// reconcileSandboxes finds the containers this daycare created that no nanny owns,
+// such as those left running by a crash, and removes them. The UIDs of any
+// that cannot be removed are held so they will not be handed out again.
+func reconcileSandboxes(rt SandboxRuntime, owner string, now time.Time) (*reconcileReport, error) {
+	list, err := rt.List(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]bool)
+	liveNannies.Lock()
+	for n := range liveNannies.nannies {
+		live[n.Sandbox.ID()] = true
+	}
+	liveNannies.Unlock()
+	for _, id := range warmContainers.IDs() {
+		live[id] = true
+	}
+
+	report := &reconcileReport{Found: len(list)}
+	seen := make(map[int64]bool)
+	for _, info := range list {
+		uidsMutex.Lock()
+		allocated := uidsInUse[info.UID] && !orphanUIDs[info.UID]
+		uidsMutex.Unlock()
+		if live[info.ID] || allocated && now.Sub(info.Created) < sandboxReconcileGrace {
+			report.Owned++
+			continue
+		}
+
+		log.Printf("removing orphaned container %s (%s, uid %d, created %v ago)",
+			info.Name, info.ID, info.UID, now.Sub(info.Created).Round(time.Second))
+		if err := rt.RemoveID(info.ID); err != nil {
+			log.Printf("error removing orphaned container %s: %v", info.Name, err)
+			orphanedContainers.WithLabelValues("failed").Inc()
+			report.Failed++
+
+			// hold its UID until it is gone
+			seen[info.UID] = true
+			uidsMutex.Lock()
+			if !uidsInUse[info.UID] {
+				uidsInUse[info.UID] = true
+				orphanUIDs[info.UID] = true
+			}
+			uidsMutex.Unlock()
+			continue
+		}
+		orphanedContainers.WithLabelValues("removed").Inc()
+		report.Removed++
+	}
+
+	// release the UIDs of held orphans that are gone now
+	uidsMutex.Lock()
+	for uid := range orphanUIDs {
+		if !seen[uid] {
+			delete(orphanUIDs, uid)
+			delete(uidsInUse, uid)
+		}
+	}
+	uidsMutex.Unlock()
+
+	return report, nil
+}
+
+// checkSandboxes runs reconcileSandboxes and logs a report if it found
+// any orphans, or always if verbose is set.
+func checkSandboxes(rt SandboxRuntime, owner string, verbose bool) {
+	report, err := reconcileSandboxes(rt, owner, time.Now())
+	if err != nil {
+		log.Printf("error listing containers to find orphans: %v", err)
+		return
+	}
+	if verbose || report.Removed > 0 || report.Failed > 0 {
+		log.Printf("container check: found %d, %d in use, %d orphans removed, %d could not be removed",
+			report.Found, report.Owned, report.Removed, report.Failed)
+	}
+}
+
+// runSandboxReconciler checks for orphaned containers periodically, forever.
+func runSandboxReconciler(rt SandboxRuntime, owner string) {
+	for {
+		time.Sleep(sandboxReconcileInterval)
+		checkSandboxes(rt, owner, false)
+	}
+}
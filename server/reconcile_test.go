@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileSandboxes(t *testing.T) {
+	const owner = "daycare.example.com"
+	uidsMutex.Lock()
+	uidsInUse, orphanUIDs = make(map[int64]bool), make(map[int64]bool)
+	uidsMutex.Unlock()
+	liveNannies.Lock()
+	liveNannies.nannies = make(map[*Nanny]bool)
+	liveNannies.Unlock()
+
+	rt := newFakeRuntime(nil)
+	now := time.Now()
+	create := func(name, owner string, uid int64, age time.Duration) *fakeSandbox {
+		sandbox, err := rt.Create(&SandboxSpec{Name: name, Owner: owner, UID: uid})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		s := sandbox.(*fakeSandbox)
+		s.created = now.Add(-age)
+		return s
+	}
+	alloc := func() int64 {
+		uid, err := allocUID()
+		if err != nil {
+			t.Fatalf("allocUID: %v", err)
+		}
+		return uid
+	}
+
+	// a nanny's container, one still starting up, and one from another daycare
+	uid := alloc()
+	live := create("nanny-1", owner, uid, time.Hour)
+	n := &Nanny{Name: "nanny-1", Sandbox: live, UID: uid}
+	liveNannies.Lock()
+	liveNannies.nannies[n] = true
+	liveNannies.Unlock()
+	starting := create("nanny-2", owner, alloc(), time.Second)
+	other := create("nanny-3", "other.example.com", 9999, time.Hour)
+
+	// left behind by a crash, and one whose nanny went away long ago
+	crashed := create("nanny-4", owner, 9998, time.Hour)
+	stale := create("nanny-5", owner, alloc(), time.Hour)
+
+	report, err := reconcileSandboxes(rt, owner, now)
+	if err != nil {
+		t.Fatalf("reconcileSandboxes: %v", err)
+	}
+	if report.Found != 4 || report.Owned != 2 || report.Removed != 2 || report.Failed != 0 {
+		t.Errorf("first pass: got %+v", report)
+	}
+	if live.removed || starting.removed || other.removed || !crashed.removed || !stale.removed {
+		t.Errorf("removed: live %v, starting %v, other %v, crashed %v, stale %v",
+			live.removed, starting.removed, other.removed, crashed.removed, stale.removed)
+	}
+
+	// an orphan that cannot be removed keeps its UID until it is gone
+	rt.stuck = true
+	create("nanny-6", owner, 9997, time.Hour)
+	if report, err = reconcileSandboxes(rt, owner, now); err != nil || report.Failed != 1 {
+		t.Errorf("stuck pass: got %+v, err %v", report, err)
+	}
+	uidsMutex.Lock()
+	held := uidsInUse[9997]
+	uidsMutex.Unlock()
+	if !held {
+		t.Errorf("the stuck orphan's UID should be held")
+	}
+	rt.stuck = false
+	if report, err = reconcileSandboxes(rt, owner, now); err != nil || report.Removed != 1 {
+		t.Errorf("unstuck pass: got %+v, err %v", report, err)
+	}
+	uidsMutex.Lock()
+	held = uidsInUse[9997]
+	uidsMutex.Unlock()
+	if held {
+		t.Errorf("the orphan's UID should be released once it is removed")
+	}
+
+	// once the nanny shuts down, only the container that never got one is left,
+	// and it is an orphan when it is no longer new
+	if err := n.Shutdown("test"); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if report, err = reconcileSandboxes(rt, owner, now.Add(time.Hour)); err != nil || report.Found != 1 || report.Removed != 1 {
+		t.Errorf("final pass: got %+v, err %v", report, err)
+	}
+}
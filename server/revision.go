@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getAssignmentProblemRevision returns the revision of a problem that an assignment is pinned to.
+// If the assignment has not started on the problem yet, it returns the current revision
+// with pinned set to false.
+func getAssignmentProblemRevision(tx *sql.Tx, assignmentID, problemID int64) (revision int64, pinned bool, err error) {
+	err = tx.QueryRow(`SELECT revision FROM assignment_problem_revisions WHERE assignment_id = ? AND problem_id = ?`,
+		assignmentID, problemID).Scan(&revision)
+	if err == nil {
+		return revision, true, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if err = tx.QueryRow(`SELECT revision FROM problems WHERE id = ?`, problemID).Scan(&revision); err != nil {
+		return 0, false, err
+	}
+	return revision, false, nil
+}
+
+// pinProblemRevision records the revision of a problem that an assignment started on.
+func pinProblemRevision(tx *sql.Tx, assignmentID, problemID, revision int64) error {
+	_, err := tx.Exec(`INSERT INTO assignment_problem_revisions (assignment_id, problem_id, revision) VALUES (?, ?, ?)`,
+		assignmentID, problemID, revision)
+	return err
+}
+
+// getProblemRevision loads a problem and its steps as they were published in the given revision.
+// The current revision comes from the problems and problem_steps tables;
+// older revisions come from the immutable copies kept when each revision was published.
+func getProblemRevision(tx *sql.Tx, problemID, revision int64) (*Problem, []*ProblemStep, error) {
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		return nil, nil, err
+	}
+	steps := []*ProblemStep{}
+	if problem.Revision == revision {
+		if err := meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, problemID); err != nil {
+			return nil, nil, err
+		}
+		return problem, steps, nil
+	}
+
+	old := new(ProblemRevision)
+	if err := meddler.QueryRow(tx, old, `SELECT * FROM problem_revisions WHERE problem_id = ? AND revision = ?`, problemID, revision); err != nil {
+		return nil, nil, err
+	}
+	problem.Note = old.Note
+	problem.Tags = old.Tags
+	problem.Options = old.Options
+	problem.Revision = old.Revision
+	problem.UpdatedAt = old.CreatedAt
+	if err := meddler.QueryAll(tx, &steps, `SELECT problem_id, step, problem_type, note, instructions, weight, files, whitelist, solution `+
+		`FROM problem_step_revisions WHERE problem_id = ? AND revision = ? ORDER BY step`, problemID, revision); err != nil {
+		return nil, nil, err
+	}
+	return problem, steps, nil
+}
+
+// publishProblemRevision keeps an immutable copy of the current version of a problem and its steps.
+func publishProblemRevision(tx *sql.Tx, problem *Problem) error {
+	if _, err := tx.Exec(`INSERT INTO problem_revisions (problem_id, revision, note, tags, options, created_at) `+
+		`SELECT id, revision, note, tags, options, updated_at FROM problems WHERE id = ?`, problem.ID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO problem_step_revisions (problem_id, revision, step, problem_type, note, instructions, weight, files, whitelist, solution) `+
+		`SELECT problem_id, ?, step, problem_type, note, instructions, weight, files, whitelist, solution FROM problem_steps WHERE problem_id = ?`,
+		problem.Revision, problem.ID)
+	return err
+}
+
+// GetProblemRevisions handles a request to /v2/problems/:problem_id/revisions,
+// returning every published revision of a problem.
+func GetProblemRevisions(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+
+	revisions := []*ProblemRevision{}
+	if err := meddler.QueryAll(tx, &revisions, `SELECT * FROM problem_revisions WHERE problem_id = ? ORDER BY revision`, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if len(revisions) == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+	render.JSON(http.StatusOK, revisions)
+}
+
+// GetAssignmentProblemStep handles a request to /v2/assignments/:assignment_id/problems/:problem_id/steps/:step,
+// returning a single problem step from the revision of the problem the assignment is pinned to.
+func GetAssignmentProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	step, err := parseID(w, "step", params["step"])
+	if err != nil {
+		return
+	}
+
+	// the problem must be part of an assignment the user can see
+	var count int
+	if currentUser.Admin {
+		err = tx.QueryRow(`SELECT COUNT(1) `+
+			`FROM assignments JOIN problem_set_problems ON assignments.problem_set_id = problem_set_problems.problem_set_id `+
+			`WHERE assignments.id = ? AND problem_set_problems.problem_id = ?`,
+			assignmentID, problemID).Scan(&count)
+	} else {
+		err = tx.QueryRow(`SELECT COUNT(1) `+
+			`FROM assignments JOIN problem_set_problems ON assignments.problem_set_id = problem_set_problems.problem_set_id `+
+			`JOIN user_assignments ON assignments.id = user_assignments.assignment_id `+
+			`WHERE assignments.id = ? AND problem_set_problems.problem_id = ? AND user_assignments.user_id = ?`,
+			assignmentID, problemID, currentUser.ID).Scan(&count)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	revision, _, err := getAssignmentProblemRevision(tx, assignmentID, problemID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	_, steps, err := getProblemRevision(tx, problemID, revision)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if step < 1 || step > int64(len(steps)) {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	problemStep := steps[step-1]
+	if !currentUser.Admin && !currentUser.Author {
+		problemStep.Solution = nil
+	}
+	render.JSON(http.StatusOK, problemStep)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func TestProblemRevisions(t *testing.T) {
+	Config.DaycareSecret = "daycare secret"
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		now := time.Now().Add(-time.Hour).Round(time.Second)
+
+		m := martini.New()
+		m.Use(render.Renderer())
+		r := martini.NewRouter()
+		var currentUser *User
+		withTx := testWithTx(t, db, &currentUser)
+		r.Post("/v2/problem_bundles/confirmed", withTx, binding.Json(ProblemBundle{}), PostProblemBundleConfirmed)
+		r.Put("/v2/problem_bundles/:problem_id", withTx, binding.Json(ProblemBundle{}), PutProblemBundle)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step", withTx, GetAssignmentProblemStep)
+		m.Action(r.Handle)
+
+		request := func(method, path string, upload interface{}, download interface{}) int {
+			var body bytes.Buffer
+			if upload != nil {
+				if err := json.NewEncoder(&body).Encode(upload); err != nil {
+					t.Fatalf("encoding request: %v", err)
+				}
+			}
+			req := httptest.NewRequest(method, path, &body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, req)
+			if w.Code == http.StatusOK && download != nil {
+				if err := json.Unmarshal(w.Body.Bytes(), download); err != nil {
+					t.Fatalf("decoding response: %v", err)
+				}
+			}
+			return w.Code
+		}
+
+		// users and a course
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		forms := make(map[string]*LTIRequest)
+		users := make(map[string]*User)
+		var course *Course
+		for i, name := range []string{"instructor", "alice", "bob"} {
+			role := "Learner"
+			if name == "instructor" {
+				role = "Instructor"
+			}
+			forms[name] = testLaunchForm(name, role, int64(i+1))
+			users[name], course = launchTestUser(t, tx, forms[name], now)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		users["instructor"].Author = true
+		currentUser = users["instructor"]
+
+		// a signed bundle with a passing commit for each step
+		sign := func(problem *Problem, doc string, weights ...float64) *ProblemBundle {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("begin: %v", err)
+			}
+			defer tx.Rollback()
+			problemType, err := getProblemType(tx, "python3unittest")
+			if err != nil {
+				t.Fatalf("getProblemType: %v", err)
+			}
+			typeSig := problemType.ComputeSignature(Config.DaycareSecret)
+			bundle := &ProblemBundle{
+				ProblemTypeSignatures: map[string]string{problemType.Name: typeSig},
+				Problem:               problem,
+				Hostname:              "daycare.example.com",
+				UserID:                currentUser.ID,
+			}
+			for i, weight := range weights {
+				bundle.ProblemSteps = append(bundle.ProblemSteps, &ProblemStep{
+					Step:        int64(i + 1),
+					ProblemType: problemType.Name,
+					Note:        fmt.Sprintf("Step %d", i+1),
+					Weight:      weight,
+					Files:       map[string][]byte{"doc/doc.md": []byte(doc), "hello.py": []byte("print('hello')\n")},
+					Whitelist:   map[string]bool{"hello.py": true},
+				})
+			}
+			if err := problem.Normalize(time.Now(), bundle.ProblemSteps); err != nil {
+				t.Fatalf("Normalize: %v", err)
+			}
+			bundle.ProblemSignature = problem.ComputeSignature(Config.DaycareSecret, bundle.ProblemSteps)
+			for _, step := range bundle.ProblemSteps {
+				commit := &Commit{
+					Step:       step.Step,
+					Files:      map[string][]byte{"hello.py": []byte("print('hello')\n")},
+					Score:      1.0,
+					ReportCard: &ReportCard{Passed: true, Results: []*ReportCardResult{}},
+					Transcript: []*EventMessage{},
+					CreatedAt:  now,
+					UpdatedAt:  now,
+				}
+				bundle.Commits = append(bundle.Commits, commit)
+				bundle.CommitSignatures = append(bundle.CommitSignatures, commit.ComputeSignature(Config.DaycareSecret, typeSig, bundle.ProblemSignature, bundle.Hostname, bundle.UserID))
+			}
+			return bundle
+		}
+
+		// publish the first revision and assign it
+		created := new(ProblemBundle)
+		problem := &Problem{Unique: "hello", Note: "Hello", CreatedAt: now, UpdatedAt: now}
+		if code := request("POST", "/v2/problem_bundles/confirmed", sign(problem, "Say hello", 1.0, 1.0), created); code != http.StatusOK {
+			t.Fatalf("creating problem: got status %d", code)
+		}
+		problem = created.Problem
+		if problem.Revision != 1 {
+			t.Fatalf("new problem: got revision %d, expected 1", problem.Revision)
+		}
+
+		tx, err = db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		set := &ProblemSet{Unique: "hello", Note: "Hello", Tags: []string{}, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+			t.Fatalf("inserting problem set: %v", err)
+		}
+		if err := meddler.Insert(tx, "problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 1.0}); err != nil {
+			t.Fatalf("inserting problem set problem: %v", err)
+		}
+		assignments := make(map[string]*Assignment)
+		for _, name := range []string{"instructor", "alice", "bob"} {
+			if assignments[name], err = getUpdateAssignment(tx, forms[name], now, course, set, users[name]); err != nil {
+				t.Fatalf("getUpdateAssignment: %v", err)
+			}
+		}
+
+		// alice starts on the first revision
+		commit := &Commit{
+			AssignmentID: assignments["alice"].ID,
+			ProblemID:    problem.ID,
+			Step:         1,
+			Files:        map[string][]byte{"hello.py": []byte("print('hi')\n")},
+			Transcript:   []*EventMessage{},
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := meddler.Insert(tx, "commits", commit); err != nil {
+			t.Fatalf("inserting commit: %v", err)
+		}
+		if err := pinProblemRevision(tx, assignments["alice"].ID, problem.ID, 1); err != nil {
+			t.Fatalf("pinProblemRevision: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		// an update publishes a second revision without moving alice
+		updated := new(ProblemBundle)
+		path := fmt.Sprintf("/v2/problem_bundles/%d", problem.ID)
+		if code := request("PUT", path, sign(problem, "Say hello politely", 2.0, 1.0, 1.0), updated); code != http.StatusOK {
+			t.Fatalf("updating problem: got status %d", code)
+		}
+		if updated.Problem.Revision != 2 {
+			t.Errorf("updated problem: got revision %d, expected 2", updated.Problem.Revision)
+		}
+		var commits int
+		if err := db.QueryRow(`SELECT COUNT(1) FROM commits WHERE problem_id = ?`, problem.ID).Scan(&commits); err != nil || commits != 1 {
+			t.Errorf("updating a problem should keep its commits: found %d, err %v", commits, err)
+		}
+
+		step := new(ProblemStep)
+		currentUser = users["alice"]
+		if code := request("GET", fmt.Sprintf("/v2/assignments/%d/problems/%d/steps/1", assignments["alice"].ID, problem.ID), nil, step); code != http.StatusOK {
+			t.Fatalf("getting alice's step: got status %d", code)
+		} else if string(step.Files["doc/doc.md"]) != "Say hello\n" || step.Solution != nil {
+			t.Errorf("alice should see the first revision: got %q", step.Files["doc/doc.md"])
+		}
+		if code := request("GET", fmt.Sprintf("/v2/assignments/%d/problems/%d/steps/3", assignments["alice"].ID, problem.ID), nil, nil); code != http.StatusNotFound {
+			t.Errorf("alice should not see the new step: got status %d", code)
+		}
+		if code := request("GET", fmt.Sprintf("/v2/assignments/%d/problems/%d/steps/1", assignments["bob"].ID, problem.ID), nil, nil); code != http.StatusNotFound {
+			t.Errorf("alice should not see bob's assignment: got status %d", code)
+		}
+		currentUser = users["bob"]
+		if code := request("GET", fmt.Sprintf("/v2/assignments/%d/problems/%d/steps/1", assignments["bob"].ID, problem.ID), nil, step); code != http.StatusOK {
+			t.Fatalf("getting bob's step: got status %d", code)
+		} else if string(step.Files["doc/doc.md"]) != "Say hello politely\n" {
+			t.Errorf("bob should see the second revision: got %q", step.Files["doc/doc.md"])
+		}
+
+		// scores use the weights from the pinned revision
+		tx, err = db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		_, minor, err := GetProblemWeights(tx, assignments["alice"])
+		if err != nil || len(minor["hello"]) != 2 || minor["hello"][0] != 1.0 {
+			t.Errorf("alice's weights: got %v, err %v", minor, err)
+		}
+		_, minor, err = GetProblemWeights(tx, assignments["bob"])
+		if err != nil || len(minor["hello"]) != 3 || minor["hello"][0] != 2.0 {
+			t.Errorf("bob's weights: got %v, err %v", minor, err)
+		}
+		tx.Rollback()
+
+		// migrating requires the same steps, then moves alice to the new revision
+		currentUser = users["instructor"]
+		bundle := sign(updated.Problem, "Say hello politely", 1.0, 1.0)
+		bundle.MigrateAssignments = true
+		if code := request("PUT", path, bundle, nil); code != http.StatusBadRequest {
+			t.Errorf("migrating with fewer steps: got status %d", code)
+		}
+		bundle = sign(updated.Problem, "Say hello warmly", 1.0, 1.0, 1.0)
+		bundle.MigrateAssignments = true
+		if code := request("PUT", path, bundle, updated); code != http.StatusOK {
+			t.Fatalf("migrating: got status %d", code)
+		}
+		var revision int64
+		if err := db.QueryRow(`SELECT revision FROM assignment_problem_revisions WHERE assignment_id = ?`, assignments["alice"].ID).Scan(&revision); err != nil || revision != 3 {
+			t.Errorf("alice after migrating: got revision %d, err %v", revision, err)
+		}
+
+		// steps students have worked on cannot be removed
+		commit.ID, commit.Step = 0, 2
+		if err := meddler.Insert(db, "commits", commit); err != nil {
+			t.Fatalf("inserting commit: %v", err)
+		}
+		if code := request("PUT", path, sign(updated.Problem, "Say hello", 1.0), nil); code != http.StatusBadRequest {
+			t.Errorf("removing a step with commits: got status %d", code)
+		}
+	})
+}
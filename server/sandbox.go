@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Sandbox is an isolated environment where student code runs.
+// Files live in the student home directory (/home/student),
+// and every command runs there as the sandbox user.
+type Sandbox interface {
+	// ID identifies the sandbox in log messages.
+	ID() string
+
+	// PutFiles copies a set of files into the home directory.
+	PutFiles(files map[string][]byte, mode int64) error
+
+	// GetFiles copies every regular file out of the home directory,
+	// keyed by its path relative to the home directory.
+	GetFiles() (map[string][]byte, error)
+
+	// Exec runs a command and waits for it to finish, returning its exit status.
+	// If useTTY is true, stdout and stderr are merged into stdout.
+	Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer, useTTY bool) (status int, err error)
+
+	// OOMKills reports how many processes in the sandbox the kernel has
+	// killed for exceeding the memory limit, including those started by Exec.
+	OOMKills() (int, error)
+
+	// Kill stops everything running in the sandbox, as when its time limit
+	// runs out. The sandbox still exists until Remove is called, but nothing
+	// more can run in it and its files can no longer be read.
+	Kill() error
+
+	// Remove destroys the sandbox, killing anything still running in it.
+	Remove() error
+}
+
+// SandboxRuntime creates sandboxes. The daycare uses a single runtime,
+// selected by the sandboxRuntime config setting.
+type SandboxRuntime interface {
+	// Name gives the runtime name for log messages.
+	Name() string
+
+	// Ping checks that the runtime is available.
+	Ping() error
+
+	// Create starts a new sandbox. It is the caller's
+	// responsibility to call Remove when it is finished.
+	Create(spec *SandboxSpec) (Sandbox, error)
+
+	// List finds every sandbox created with the given owner that still
+	// exists, running or not, including those left by an earlier process.
+	List(owner string) ([]*SandboxInfo, error)
+
+	// RemoveID destroys a sandbox found by List.
+	RemoveID(id string) error
+}
+
+// SandboxSpec describes a sandbox to be created.
+type SandboxSpec struct {
+	Name      string   // unique name, also used as the hostname
+	Owner     string   // daycare that created it, so it can be found later by List
+	Image     string   // container image from the problem type
+	UID       int64    // user (and group) that owns the files and runs commands
+	Env       []string // extra environment variables in KEY=value form
+	Limits    *limits  // resource limits
+	TimeLimit int64    // seconds before the sandbox shuts itself down
+}
+
+// SandboxInfo describes a sandbox found by List.
+type SandboxInfo struct {
+	ID      string
+	Name    string
+	UID     int64
+	Created time.Time
+}
+
+// sandboxes is the runtime used to create Nanny sandboxes
+var sandboxes SandboxRuntime
+
+func newSandboxRuntime(name, endpoint string) (SandboxRuntime, error) {
+	switch name {
+	case "", "docker":
+		return newDockerRuntime(endpoint)
+	case "podman":
+		return newPodmanRuntime(endpoint)
+	default:
+		return nil, fmt.Errorf("unknown sandbox runtime %q", name)
+	}
+}
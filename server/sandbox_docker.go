@@ -0,0 +1,399 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerRuntime creates sandboxes as containers using the Docker API.
+// Podman is supported through its Docker-compatible API service.
+type dockerRuntime struct {
+	name   string
+	client *docker.Client
+}
+
+func newDockerRuntime(endpoint string) (*dockerRuntime, error) {
+	if endpoint == "" {
+		endpoint = "unix:///var/run/docker.sock"
+	}
+	client, err := docker.NewVersionedClient(endpoint, "1.23")
+	if err != nil {
+		return nil, err
+	}
+	return &dockerRuntime{name: "docker", client: client}, nil
+}
+
+// newPodmanRuntime connects to the API service started by
+// "podman system service". When run as a normal user this is
+// rootless podman, which needs no daemon running as root; the UIDs
+// used by the sandboxes must fall within the user's subuid range.
+func newPodmanRuntime(endpoint string) (*dockerRuntime, error) {
+	if endpoint == "" {
+		if os.Getuid() == 0 {
+			endpoint = "unix:///run/podman/podman.sock"
+		} else if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			endpoint = "unix://" + filepath.Join(dir, "podman", "podman.sock")
+		} else {
+			endpoint = fmt.Sprintf("unix:///run/user/%d/podman/podman.sock", os.Getuid())
+		}
+	}
+
+	// podman implements the Docker API starting with version 1.40
+	client, err := docker.NewVersionedClient(endpoint, "1.40")
+	if err != nil {
+		return nil, err
+	}
+	return &dockerRuntime{name: "podman", client: client}, nil
+}
+
+func (rt *dockerRuntime) Name() string {
+	return rt.name
+}
+
+func (rt *dockerRuntime) Ping() error {
+	return rt.client.Ping()
+}
+
+// Labels on every container, used to find them again.
+const (
+	sandboxOwnerLabel = "codegrinder.daycare"
+	sandboxUIDLabel   = "codegrinder.uid"
+)
+
+func (rt *dockerRuntime) Create(spec *SandboxSpec) (Sandbox, error) {
+	limits := spec.Limits
+	mem := limits.maxMemory * 1024 * 1024
+	disk := limits.maxFileSize * 1024 * 1024
+	config := &docker.Config{
+		Hostname:        spec.Name,
+		User:            uidgid(spec.UID),
+		Memory:          int64(mem),
+		MemorySwap:      -1,
+		Cmd:             []string{"/bin/sleep", strconv.FormatInt(spec.TimeLimit, 10) + "s"},
+		Env:             append([]string{"USER=student", "HOME=/home/student"}, spec.Env...),
+		Image:           spec.Image,
+		NetworkDisabled: true,
+		Labels: map[string]string{
+			sandboxOwnerLabel: spec.Owner,
+			sandboxUIDLabel:   strconv.FormatInt(spec.UID, 10),
+		},
+	}
+
+	hostConfig := &docker.HostConfig{
+		CapDrop: []string{
+			"NET_RAW",
+			"NET_BIND_SERVICE",
+			"AUDIT_READ",
+			"AUDIT_WRITE",
+			"DAC_OVERRIDE",
+			"SETFCAP",
+			"SETPCAP",
+			"SETGID",
+			"SETUID",
+			"MKNOD",
+			"CHOWN",
+			"FOWNER",
+			"FSETID",
+			"KILL",
+			"SYS_CHROOT",
+		},
+		PidsLimit: &limits.maxThreads,
+		Ulimits: []docker.ULimit{
+			{Name: "core", Soft: 0, Hard: 0},
+			{Name: "cpu", Soft: limits.maxCPU, Hard: limits.maxCPU},
+			{Name: "data", Soft: mem, Hard: mem},
+			{Name: "fsize", Soft: disk, Hard: disk},
+			{Name: "memlock", Soft: 0, Hard: 0},
+			{Name: "nofile", Soft: limits.maxFD, Hard: limits.maxFD},
+			{Name: "nproc", Soft: limits.maxThreads, Hard: limits.maxThreads},
+			//{Name: "stack", Soft: mem, Hard: mem},
+		},
+		Tmpfs: map[string]string{
+			"/home/student": fmt.Sprintf("rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", disk/1024, spec.UID, spec.UID),
+			"/tmp":          fmt.Sprintf("rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", disk/1024, spec.UID, spec.UID),
+		},
+		ReadonlyRootfs: true,
+	}
+
+	container, err := rt.client.CreateContainer(docker.CreateContainerOptions{
+		Name:       spec.Name,
+		Config:     config,
+		HostConfig: hostConfig,
+	})
+	if err != nil {
+		if err == docker.ErrContainerAlreadyExists {
+			// container already exists with that name--try killing it
+			log.Printf("killing existing container with same name %s", spec.Name)
+			err2 := rt.client.RemoveContainer(docker.RemoveContainerOptions{
+				ID:    spec.Name,
+				Force: true,
+			})
+			if err2 != nil {
+				log.Printf("error killing existing container with same name: %v", err2)
+				return nil, err2
+			}
+
+			// try it one more time
+			container, err = rt.client.CreateContainer(docker.CreateContainerOptions{Name: spec.Name, Config: config, HostConfig: hostConfig})
+		}
+		if err != nil {
+			log.Printf("CreateContainer: %v", err)
+			return nil, err
+		}
+	}
+
+	// start it
+	err = rt.client.StartContainer(container.ID, nil)
+	if err != nil {
+		log.Printf("StartContainer: %v", err)
+		err2 := rt.client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    container.ID,
+			Force: true,
+		})
+		if err2 != nil {
+			log.Printf("RemoveContainer: %v", err2)
+		}
+		return nil, err
+	}
+
+	return &dockerSandbox{client: rt.client, container: container, uid: spec.UID}, nil
+}
+
+func (rt *dockerRuntime) List(owner string) ([]*SandboxInfo, error) {
+	containers, err := rt.client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {sandboxOwnerLabel + "=" + owner}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []*SandboxInfo
+	for _, container := range containers {
+		info := &SandboxInfo{ID: container.ID, Created: time.Unix(container.Created, 0)}
+		if len(container.Names) > 0 {
+			info.Name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		info.UID, _ = strconv.ParseInt(container.Labels[sandboxUIDLabel], 10, 64)
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func (rt *dockerRuntime) RemoveID(id string) error {
+	return rt.client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:    id,
+		Force: true,
+	})
+}
+
+// dockerSandbox is a running container
+type dockerSandbox struct {
+	client    *docker.Client
+	container *docker.Container
+	uid       int64
+}
+
+func (s *dockerSandbox) ID() string {
+	return s.container.ID
+}
+
+func (s *dockerSandbox) Kill() error {
+	return s.client.KillContainer(docker.KillContainerOptions{
+		ID: s.container.ID,
+	})
+}
+
+func (s *dockerSandbox) Remove() error {
+	return s.client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:    s.container.ID,
+		Force: true,
+	})
+}
+
+// OOMKills reads the kill count from the container's memory cgroup.
+// The runtime's OOMKilled flag only covers the container's main process,
+// which is just sleep; student code runs in processes started by Exec.
+func (s *dockerSandbox) OOMKills() (int, error) {
+	// one of these exists, depending on the cgroup version
+	cmd := []string{"/bin/cat", "/sys/fs/cgroup/memory.events", "/sys/fs/cgroup/memory/memory.oom_control"}
+	out := new(bytes.Buffer)
+	if _, err := s.Exec(cmd, nil, out, ioutil.Discard, false); err != nil {
+		return 0, err
+	}
+	return parseOOMKills(out.Bytes())
+}
+
+// parseOOMKills finds the oom_kill count in the contents of a
+// memory.events (cgroup v2) or memory.oom_control (cgroup v1) file.
+func parseOOMKills(contents []byte) (int, error) {
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	return 0, fmt.Errorf("no oom_kill count found in the memory cgroup")
+}
+
+func (s *dockerSandbox) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer, useTTY bool) (int, error) {
+	// create
+	exec, err := s.client.CreateExec(docker.CreateExecOptions{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          useTTY,
+		Cmd:          cmd,
+		Container:    s.container.ID,
+		User:         uidgid(s.uid),
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	// start
+	err = s.client.StartExec(exec.ID, docker.StartExecOptions{
+		Detach:       false,
+		Tty:          useTTY,
+		InputStream:  stdin,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		RawTerminal:  useTTY,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	// inspect
+	inspect, err := s.client.InspectExec(exec.ID)
+	if err != nil {
+		return -1, err
+	}
+	if inspect.Running {
+		return -1, fmt.Errorf("process still running")
+	}
+
+	return inspect.ExitCode, nil
+}
+
+// PutFiles copies a set of files to the container by running tar in it.
+func (s *dockerSandbox) PutFiles(files map[string][]byte, mode int64) error {
+	// tar the files
+	nowish := time.Now().Add(-time.Second)
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	dirs := make(map[string]bool)
+	for name, contents := range files {
+		dir := filepath.Dir(name)
+		if dir != "" && !dirs[dir] {
+			dirs[dir] = true
+			header := &tar.Header{
+				Name:       dir,
+				Mode:       0777,
+				Uid:        int(s.uid),
+				Gid:        int(s.uid),
+				Size:       0,
+				ModTime:    nowish,
+				Typeflag:   tar.TypeDir,
+				Uname:      strconv.FormatInt(s.uid, 10),
+				Gname:      strconv.FormatInt(s.uid, 10),
+				AccessTime: nowish,
+				ChangeTime: nowish,
+			}
+			if err := writer.WriteHeader(header); err != nil {
+				log.Printf("writing tar header for directory: %v", err)
+				return err
+			}
+		}
+		header := &tar.Header{
+			Name:       name,
+			Mode:       mode,
+			Uid:        int(s.uid),
+			Gid:        int(s.uid),
+			Size:       int64(len(contents)),
+			ModTime:    nowish,
+			Typeflag:   tar.TypeReg,
+			Uname:      strconv.FormatInt(s.uid, 10),
+			Gname:      strconv.FormatInt(s.uid, 10),
+			AccessTime: nowish,
+			ChangeTime: nowish,
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			log.Printf("writing tar header: %v", err)
+			return err
+		}
+		if _, err := writer.Write(contents); err != nil {
+			log.Printf("writing to tar file: %v", err)
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("closing tar file: %v", err)
+		return err
+	}
+
+	// exec tar in the container
+	out := new(bytes.Buffer)
+	if _, err := s.Exec([]string{"/bin/tar", "xf", "-", "-C", "/home/student"}, buf, out, out, false); err != nil {
+		log.Printf("running tar command to upload files: %v", err)
+		return err
+	}
+	if out.Len() != 0 {
+		log.Printf("tar output: %q", out.String())
+		return fmt.Errorf("tar gave non-empty output when extracting files into container")
+	}
+
+	return nil
+}
+
+// GetFiles copies all files from the container by running tar in it.
+func (s *dockerSandbox) GetFiles() (map[string][]byte, error) {
+	// exec tar in the container
+	tarFile := new(bytes.Buffer)
+	tarErr := new(bytes.Buffer)
+	if _, err := s.Exec([]string{"/bin/tar", "cf", "-", "-C", "/home/student", "."}, nil, tarFile, tarErr, false); err != nil {
+		log.Printf("running tar command to download files: %v", err)
+		return nil, err
+	}
+	if tarErr.Len() != 0 {
+		log.Printf("tar gave non-empty error output when gathering files from container: %q", tarErr.String())
+		return nil, fmt.Errorf("tar gave non-empty error output when gathering files from container")
+	}
+
+	// extract the files
+	files := make(map[string][]byte)
+	reader := tar.NewReader(bytes.NewReader(tarFile.Bytes()))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("error decoding tar file: %v", err)
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents := make([]byte, header.Size)
+		if _, err = io.ReadFull(reader, contents); err != nil {
+			log.Printf("error reading %q from tar file: %v", header.Name, err)
+			return nil, err
+		}
+
+		name := filepath.Clean(header.Name)
+		files[name] = contents
+	}
+
+	return files, nil
+}
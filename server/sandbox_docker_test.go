@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestParseOOMKills(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		kills    int
+		ok       bool
+	}{
+		{"cgroup v2", "low 0\nhigh 0\nmax 12\noom 2\noom_kill 2\noom_group_kill 0\n", 2, true},
+		{"cgroup v1", "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n", 1, true},
+		{"none yet", "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n", 0, true},
+		{"missing", "/bin/cat: /sys/fs/cgroup/memory.events: No such file or directory\n", 0, false},
+	}
+	for _, test := range tests {
+		kills, err := parseOOMKills([]byte(test.contents))
+		if (err == nil) != test.ok || kills != test.kills {
+			t.Errorf("%s: got %d kills, error %v", test.name, kills, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeCommand is the in-process implementation of a command run in a fake sandbox.
+type fakeCommand func(s *fakeSandbox, stdin io.Reader, stdout, stderr io.Writer) int
+
+// fakeRuntime creates sandboxes that never leave the test process.
+// Commands are looked up by their full command line.
+type fakeRuntime struct {
+	sync.Mutex
+	commands  map[string]fakeCommand
+	sandboxes []*fakeSandbox
+
+	// stuck makes RemoveID fail, like a container the runtime cannot kill
+	stuck bool
+}
+
+func newFakeRuntime(commands map[string]fakeCommand) *fakeRuntime {
+	return &fakeRuntime{commands: commands}
+}
+
+func (rt *fakeRuntime) Name() string { return "fake" }
+
+func (rt *fakeRuntime) Ping() error { return nil }
+
+func (rt *fakeRuntime) Create(spec *SandboxSpec) (Sandbox, error) {
+	rt.Lock()
+	defer rt.Unlock()
+	s := &fakeSandbox{
+		runtime: rt,
+		spec:    spec,
+		id:      fmt.Sprintf("fake-%d", len(rt.sandboxes)+1),
+		files:   make(map[string][]byte),
+		created: time.Now(),
+	}
+	rt.sandboxes = append(rt.sandboxes, s)
+	return s, nil
+}
+
+func (rt *fakeRuntime) List(owner string) ([]*SandboxInfo, error) {
+	rt.Lock()
+	defer rt.Unlock()
+	var list []*SandboxInfo
+	for _, s := range rt.sandboxes {
+		s.Lock()
+		if !s.removed && s.spec.Owner == owner {
+			list = append(list, &SandboxInfo{ID: s.id, Name: s.spec.Name, UID: s.spec.UID, Created: s.created})
+		}
+		s.Unlock()
+	}
+	return list, nil
+}
+
+func (rt *fakeRuntime) RemoveID(id string) error {
+	rt.Lock()
+	defer rt.Unlock()
+	for _, s := range rt.sandboxes {
+		if s.id == id {
+			if rt.stuck {
+				return fmt.Errorf("sandbox %s is stuck", id)
+			}
+			return s.Remove()
+		}
+	}
+	return fmt.Errorf("no such sandbox %s", id)
+}
+
+type fakeSandbox struct {
+	sync.Mutex
+	runtime *fakeRuntime
+	spec    *SandboxSpec
+	id      string
+	files   map[string][]byte
+	created time.Time
+	killed  bool
+	removed bool
+
+	// removals counts calls to Remove
+	removals int
+
+	// oomKills is the count reported by OOMKills, or -1 to report an error
+	oomKills int
+}
+
+func (s *fakeSandbox) ID() string { return s.id }
+
+func (s *fakeSandbox) PutFiles(files map[string][]byte, mode int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.removed || s.killed {
+		return fmt.Errorf("sandbox %s is not running", s.id)
+	}
+	for name, contents := range files {
+		s.files[name] = append([]byte{}, contents...)
+	}
+	return nil
+}
+
+func (s *fakeSandbox) GetFiles() (map[string][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.removed || s.killed {
+		return nil, fmt.Errorf("sandbox %s is not running", s.id)
+	}
+	files := make(map[string][]byte)
+	for name, contents := range s.files {
+		files[name] = append([]byte{}, contents...)
+	}
+	return files, nil
+}
+
+func (s *fakeSandbox) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer, useTTY bool) (int, error) {
+	s.Lock()
+	running := !s.removed && !s.killed
+	s.Unlock()
+	if !running {
+		return -1, fmt.Errorf("sandbox %s is not running", s.id)
+	}
+	if useTTY {
+		stderr = stdout
+	}
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	command, exists := s.runtime.commands[strings.Join(cmd, " ")]
+	if !exists {
+		fmt.Fprintf(stderr, "%s: command not found\n", cmd[0])
+		return 127, nil
+	}
+	return command(s, stdin, stdout, stderr), nil
+}
+
+func (s *fakeSandbox) OOMKills() (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.oomKills < 0 {
+		return 0, fmt.Errorf("sandbox %s has no memory cgroup", s.id)
+	}
+	return s.oomKills, nil
+}
+
+func (s *fakeSandbox) Kill() error {
+	s.Lock()
+	defer s.Unlock()
+	s.killed = true
+	return nil
+}
+
+func (s *fakeSandbox) Remove() error {
+	s.Lock()
+	defer s.Unlock()
+	s.removed = true
+	s.removals++
+	return nil
+}
+
+// file gives the contents of a file in the sandbox
+func (s *fakeSandbox) file(name string) []byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.files[name]
+}
+
+// writeFile creates a file in the sandbox
+func (s *fakeSandbox) writeFile(name string, contents []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.files[name] = contents
+}
@@ -3,13 +3,12 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
-	"crypto/tls"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
-	"expvar"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -26,15 +25,12 @@ import (
 	"sync"
 	"time"
 
-	docker "github.com/fsouza/go-dockerclient"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	mgzip "github.com/martini-contrib/gzip"
 	"github.com/martini-contrib/render"
-	_ "github.com/mattn/go-sqlite3"
 	. "github.com/russross/codegrinder/types"
 	"github.com/russross/meddler"
-	"golang.org/x/crypto/acme/autocert"
 )
 
 // Config holds site-specific configuration data.
@@ -45,22 +41,47 @@ var Config struct {
 	DaycareSecret    string `json:"daycareSecret"`    // Random string used to sign daycare requests: `head -c 32 /dev/urandom | base64`
 	LetsEncryptEmail string `json:"letsEncryptEmail"` // Email address to register TLS certificates: "foo@bar.com"
 
+	// optional parameters
+	MetricsToken   string   `json:"metricsToken"`   // Bearer token required to scrape /metrics; without one, /metrics is only served on listenMetrics: default ""
+	ListenMetrics  string   `json:"listenMetrics"`  // Private address for a plain http listener that serves only /metrics: "127.0.0.1:9100", default "" for none
+	ShutdownGrace  int      `json:"shutdownGrace"`  // Seconds to let open requests and running actions finish after SIGTERM: default 60
+	TLSMode        string   `json:"tlsMode"`        // "letsencrypt", "files" to use tlsCertFile and tlsKeyFile, or "none" for plain http behind a proxy: default "letsencrypt"
+	TLSCertFile    string   `json:"tlsCertFile"`    // PEM certificate chain for tlsMode "files", reloaded when it changes
+	TLSKeyFile     string   `json:"tlsKeyFile"`     // PEM private key for tlsMode "files", reloaded when it changes
+	ListenHTTPS    string   `json:"listenHTTPS"`    // Address to accept https connections: default ":https"
+	ListenHTTP     string   `json:"listenHTTP"`     // Address to accept http connections, which redirect to https unless tlsMode is "none": default ":http", "" for none
+	TrustedProxies []string `json:"trustedProxies"` // CIDR blocks of proxies whose X-Forwarded-* headers are believed: [ "10.0.0.0/8", ... ], default none
+
 	// ta-only required parameters
 	LTISecret     string `json:"ltiSecret"`     // LTI authentication shared secret. Must match that given to Canvas course: `head -c 32 /dev/urandom | base64`
 	SessionSecret string `json:"sessionSecret"` // Random string used to sign cookie sessions: `head -c 32 /dev/urandom | base64`
 
 	// daycare-only required parameters
 	TAHostname   string   `json:"taHostname"`   // Hostname for the TA: "your.host.goes.here". Defaults to Hostname
+	TAURL        string   `json:"taURL"`        // Base URL used to register with the TA: "http://ta.internal:8080". Defaults to "https://" + TAHostname
 	Capacity     int      `json:"capacity"`     // Relative capacity of this daycare for containers: 1
 	ProblemTypes []string `json:"problemTypes"` // List of problem types this daycare host supports: [ "python3unittest", "gotest", ... ]
 
+	// daycare-only parameters where the default is usually sufficient
+	SandboxRuntime        string `json:"sandboxRuntime"`        // Container runtime for student code, "docker" or "podman": default "docker"
+	SandboxEndpoint       string `json:"sandboxEndpoint"`       // API socket for the container runtime: default "unix:///var/run/docker.sock" for docker, the podman service socket for podman
+	ContainersPerCapacity int    `json:"containersPerCapacity"` // Containers allowed to run at once for each unit of capacity: default 4
+	MaxQueueLength        int    `json:"maxQueueLength"`        // Requests allowed to wait for a container before new ones are turned away: default 4 times the container limit. The line is kept in memory, not on disk
+
+	// daycare-only optional parameters
+	WarmContainers map[string]int `json:"warmContainers"` // Idle containers to keep ready for each problem type and limits profile: { "cpp": 2, ... }, default none
+
 	// ta-only parameters where the default is usually sufficient
 	ToolName         string      `json:"toolName"`        // LTI human readable name: default "CodeGrinder"
 	ToolID           string      `json:"toolID"`          // LTI unique ID: default "codegrinder"
 	ToolDescription  string      `json:"toolDescription"` // LTI description: default "Programming exercises with grading"
 	LetsEncryptCache string      `json:"letsEncryptDir"`  // Full path of LetsEncrypt cache file: default "$CODEGRINDERROOT/letsencrypt"
+	DatabaseDriver   string      `json:"databaseDriver"`  // database to use, "sqlite3" or "postgres": default "sqlite3"
 	SQLite3Path      string      `json:"sqlite3Path"`     // path to the sqlite database file: default "$CODEGRINDERROOT/db/codegrinder.db"
+	PostgresURL      string      `json:"postgresURL"`     // PostgreSQL connection string: "postgres://codegrinder@db.example.edu/codegrinder?sslmode=verify-full"
+	AutoMigrate      bool        `json:"autoMigrate"`     // apply pending database migrations at startup instead of requiring -migrate: default true
 	SessionsExpire   []time.Time `json:"sessionsExpire"`  // times/dates when sessions should expire (year is ignored)
+	LTIKeyFile       string      `json:"ltiKeyFile"`      // RSA private key for LTI 1.3, created if missing: default "$CODEGRINDERROOT/lti-key.pem"
 }
 var root string
 
@@ -80,13 +101,14 @@ func main() {
 	log.Printf("CODEGRINDERROOT set to %s", root)
 
 	// parse command line
-	var ta, daycare bool
+	var ta, daycare, migrate bool
 	flag.BoolVar(&ta, "ta", false, "Serve the TA role")
 	flag.BoolVar(&daycare, "daycare", false, "Serve the daycare role")
+	flag.BoolVar(&migrate, "migrate", false, "Apply any pending TA database migrations and exit")
 	flag.Parse()
 
-	if !ta && !daycare {
-		log.Fatalf("must run at least one role (ta/daycare)")
+	if !ta && !daycare && !migrate {
+		log.Fatalf("must run at least one role (ta/daycare) or -migrate")
 	}
 
 	// set config defaults
@@ -94,7 +116,14 @@ func main() {
 	Config.ToolID = "codegrinder"
 	Config.ToolDescription = "Programming exercises with grading"
 	Config.LetsEncryptCache = filepath.Join(root, "letsencrypt")
+	Config.DatabaseDriver = sqliteDriver
 	Config.SQLite3Path = filepath.Join(root, "db", "codegrinder.db")
+	Config.AutoMigrate = true
+	Config.LTIKeyFile = filepath.Join(root, "lti-key.pem")
+	Config.ContainersPerCapacity = 4
+	Config.ShutdownGrace = 60
+	Config.ListenHTTPS = ":https"
+	Config.ListenHTTP = ":http"
 	Config.SessionsExpire = []time.Time{
 		time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local),
 		time.Date(2020, 7, 1, 0, 0, 0, 0, time.Local),
@@ -110,6 +139,16 @@ func main() {
 	Config.SessionSecret = unBase64(Config.SessionSecret)
 	Config.DaycareSecret = unBase64(Config.DaycareSecret)
 
+	if migrate {
+		db := openConfigDB()
+		if err := migrateDB(db, Config.DatabaseDriver, true); err != nil {
+			log.Fatalf("%v", err)
+		}
+		db.Close()
+		log.Printf("database schema is up to date")
+		return
+	}
+
 	if Config.Hostname == "" {
 		log.Fatalf("cannot run with no hostname in the config file")
 	}
@@ -117,6 +156,10 @@ func main() {
 		log.Fatalf("cannot run with no daycareSecret in the config file")
 	}
 	// Config.LetsEncryptEmail is optional
+	proxies, err := checkListenConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	// set up martini
 	r := martini.NewRouter()
@@ -124,31 +167,12 @@ func main() {
 	m.Logger(log.New(os.Stderr, "", log.Lshortfile))
 	//m.Use(martini.Logger())
 	m.Use(martini.Recovery())
+	m.Use(forwardedHeaders(proxies))
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
 
-	counter := func(w http.ResponseWriter, r *http.Request, c martini.Context) {
-		start := time.Now()
-		c.Next()
-		now := time.Now()
-		seconds := now.Sub(start).Seconds()
-		hits++
-		hitsCounter.Add(1)
-		if seconds > slowest {
-			slowest = seconds
-			slowestCounter.Set(seconds)
-			slowestTimeCounter.Set(now.Format(time.RFC1123))
-			slowestPathCounter.Set(r.URL.Path)
-		}
-		totalSeconds += seconds
-		totalSecondsCounter.Add(seconds)
-		averageSecondsCounter.Set(totalSeconds / float64(hits))
-		rw := w.(martini.ResponseWriter)
-		if rw.Status() >= 400 {
-			errorsCounter.Add(1)
-		}
-		goroutineCounter.Set(int64(runtime.NumGoroutine()))
-	}
+	// metrics are available for every role
+	routeMetrics(r)
 
 	// set up daycare role
 	// note: this must come before TA role to avoid gzip handler for daycare requests
@@ -160,27 +184,57 @@ func main() {
 		if Config.TAHostname == "" {
 			Config.TAHostname = Config.Hostname
 		}
+		if Config.TAURL == "" {
+			Config.TAURL = "https://" + Config.TAHostname
+		}
+		Config.TAURL = strings.TrimSuffix(Config.TAURL, "/")
 		if len(Config.ProblemTypes) == 0 {
 			log.Fatalf("cannot run Daycare role with no problemTypes in the config file")
 		}
 		if Config.Capacity <= 0 {
 			log.Fatalf("Daycare capacity must be greater than zero")
 		}
+		if Config.ContainersPerCapacity <= 0 {
+			log.Fatalf("Daycare containersPerCapacity must be greater than zero")
+		}
+		slots := Config.Capacity * Config.ContainersPerCapacity
+		if Config.MaxQueueLength <= 0 {
+			Config.MaxQueueLength = 4 * slots
+		}
+		admission = newAdmissionQueue(slots, Config.MaxQueueLength)
+		registerQueueMetrics(admission)
+		log.Printf("daycare will run up to %d containers at once with up to %d requests waiting", slots, Config.MaxQueueLength)
 
-		// attach to docker and try a ping
+		// attach to the container runtime and try a ping
 		var err error
-		dockerClient, err = docker.NewVersionedClient("unix:///var/run/docker.sock", "1.23")
+		sandboxes, err = newSandboxRuntime(Config.SandboxRuntime, Config.SandboxEndpoint)
 		if err != nil {
-			log.Fatalf("NewVersionedClient: %v", err)
+			log.Fatalf("connecting to sandbox runtime: %v", err)
+		}
+		if err = sandboxes.Ping(); err != nil {
+			log.Fatalf("Ping %s: %v", sandboxes.Name(), err)
+		}
+
+		// clean up containers left behind by an earlier run, and keep checking
+		checkSandboxes(sandboxes, Config.Hostname, true)
+		go runSandboxReconciler(sandboxes, Config.Hostname)
+
+		// keep containers warm for the problem types that ask for it
+		for problemType, n := range Config.WarmContainers {
+			if n < 0 {
+				log.Fatalf("warmContainers for %s must not be negative", problemType)
+			}
 		}
-		if err = dockerClient.Ping(); err != nil {
-			log.Fatalf("Ping: %v", err)
+		if len(Config.WarmContainers) > 0 {
+			warmContainers = newWarmPool(sandboxes, Config.Hostname, admission, Config.WarmContainers)
+			go warmContainers.Run()
 		}
 
 		r.Get("/v2/sockets/:problem_type/:action", SocketProblemTypeAction)
 
-		// register with the TA periodically
+		// register with the TA periodically, and deregister when shutting down
 		go func() {
+			defer close(daycareDeregistered)
 			if ta {
 				// it we are also the TA, give the server a chance to start listening
 				time.Sleep(2 * time.Second)
@@ -190,19 +244,26 @@ func main() {
 
 			for {
 				start := time.Now()
+				slots, running, waiting, latency := admission.stats()
 				reg := DaycareRegistration{
 					Hostname:     Config.Hostname,
 					ProblemTypes: Config.ProblemTypes,
 					Capacity:     Config.Capacity,
+					Slots:        slots,
+					Running:      running,
+					Waiting:      waiting,
+					Latency:      latency,
 					Time:         time.Now(),
 					Version:      CurrentVersion.Version,
+					Draining:     admission.isDraining(),
 				}
 				reg.Signature = reg.ComputeSignature(Config.DaycareSecret)
+				reg.StatusSignature = reg.ComputeStatusSignature(Config.DaycareSecret)
 				raw, err := json.MarshalIndent(&reg, "", "    ")
 				if err != nil {
 					log.Fatalf("encoding daycare registration: %v", err)
 				}
-				url := fmt.Sprintf("https://%s/v2/daycare_registrations", Config.TAHostname)
+				url := Config.TAURL + "/v2/daycare_registrations"
 
 				body := ioutil.NopCloser(bytes.NewReader(raw))
 				req, err := http.NewRequest("POST", url, body)
@@ -241,12 +302,20 @@ func main() {
 						status = "failed"
 					}
 				}
-				time.Sleep(daycareRegistrationInterval)
+				if reg.Draining {
+					log.Printf("daycare deregistration %s", status)
+					return
+				}
+				select {
+				case <-daycareDraining:
+				case <-time.After(daycareRegistrationInterval):
+				}
 			}
 		}()
 	}
 
 	// set up TA role
+	var finishTA func(ctx context.Context)
 	if ta {
 		// make sure relevant secrets are included in config file
 		if Config.LTISecret == "" {
@@ -255,8 +324,9 @@ func main() {
 		if Config.SessionSecret == "" {
 			log.Fatalf("cannot run TA role with no sessionSecret in the config file")
 		}
-		if Config.SQLite3Path == "" {
-			log.Fatalf("cannot run TA role with no sqlite3Path in the config file")
+
+		if err := loadLTIKey(Config.LTIKeyFile); err != nil {
+			log.Fatalf("loading LTI 1.3 key: %v", err)
 		}
 
 		m.Use(mgzip.All())
@@ -264,43 +334,46 @@ func main() {
 		m.Use(render.Renderer(render.Options{IndentJSON: false}))
 
 		// set up the database
-		db := setupDB(Config.SQLite3Path)
-		var dbMutex sync.Mutex
+		db := openConfigDB()
+		if err := migrateDB(db, Config.DatabaseDriver, Config.AutoMigrate); err != nil {
+			log.Fatalf("%v", err)
+		}
+		readDB := openConfigReadDB(db)
 
-		// martini service: wrap handler in a transaction
-		withTx := func(c martini.Context, w http.ResponseWriter) {
-			// start a transaction
-			dbMutex.Lock()
-			defer dbMutex.Unlock()
+		// post grades to the LMS in the background
+		outbox = newGradeOutbox(db)
+		go outbox.Run()
 
-			tx, err := db.Begin()
-			if err != nil {
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error starting transaction: %v", err)
+		// compute similarity reports in the background
+		similarity = newSimilarityJobs(db, readDB)
+		go similarity.Run()
+
+		// on the way out, flush the grade posts that are due;
+		// any that are not sent in time stay pending for the next start
+		finishTA = func(ctx context.Context) {
+			if !similarity.Stop(ctx) || !outbox.Stop(ctx) {
+				log.Printf("background jobs did not stop in time; leaving the database open")
 				return
 			}
-
-			// pass it on to the main handler
-			c.Map(tx)
-			c.Next()
-
-			// was it a successful result?
-			rw := w.(martini.ResponseWriter)
-			if rw.Status() < http.StatusBadRequest {
-				// commit the transaction
-				if err := tx.Commit(); err != nil {
-					loggedHTTPErrorf(w, http.StatusInternalServerError, "db error committing transaction: %v", err)
-					return
-				}
-			} else {
-				// rollback
-				//log.Printf("rolling back transaction")
-				if err := tx.Rollback(); err != nil {
-					loggedHTTPErrorf(w, http.StatusInternalServerError, "db error rolling back transaction: %v", err)
-					return
-				}
+			if sent := outbox.Drain(ctx, time.Now()); sent > 0 {
+				log.Printf("sent %d pending grade posts", sent)
+			}
+			closed := make(chan struct{})
+			go func() {
+				readDB.Close()
+				db.Close()
+				close(closed)
+			}()
+			select {
+			case <-closed:
+			case <-ctx.Done():
+				log.Printf("database did not close in time")
 			}
 		}
 
+		// martini service: wrap handler in a transaction
+		withTx := withTransaction(db, readDB)
+
 		// martini service: to require an active logged-in session
 		auth := func(w http.ResponseWriter, r *http.Request) {
 			_, err := GetSession(r)
@@ -376,7 +449,7 @@ func main() {
 		}
 
 		// version
-		r.Get("/v2/version", counter, func(w http.ResponseWriter, render render.Render) {
+		r.Get("/v2/version", instrument, func(w http.ResponseWriter, render render.Render) {
 			render.JSON(http.StatusOK, &CurrentVersion)
 		})
 
@@ -384,7 +457,7 @@ func main() {
 		r.Get("/v2/daycare_registrations",
 			func(w http.ResponseWriter, render render.Render) {
 				daycareRegistrations.Expire()
-				render.JSON(http.StatusOK, daycareRegistrations.daycares)
+				render.JSON(http.StatusOK, daycareRegistrations.List())
 			})
 		r.Post("/v2/daycare_registrations", gunzip, binding.Json(DaycareRegistration{}),
 			func(w http.ResponseWriter, reg DaycareRegistration) {
@@ -395,176 +468,130 @@ func main() {
 				}
 			})
 
-		// stats
-		r.Get("/v2/stats", withTx, withCurrentUser, authorOnly, func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			fmt.Fprintf(w, "{\n")
-			first := true
-			expvar.Do(func(kv expvar.KeyValue) {
-				if !first {
-					fmt.Fprintf(w, ",\n")
-				}
-				first = false
-				fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
-			})
-			fmt.Fprintf(w, "\n}\n")
-		})
-
 		// LTI
-		r.Get("/v2/lti/config.xml", counter, GetConfigXML)
-		//r.Post("/v2/lti/problem_sets", counter, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSets)
-		r.Post("/v2/lti/problem_sets/:ui/:unique", counter, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSet)
-		r.Post("/v2/lti/quizzes", counter, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiQuizzes)
+		r.Get("/v2/lti/config.xml", instrument, GetConfigXML)
+		//r.Post("/v2/lti/problem_sets", instrument, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSets)
+		r.Post("/v2/lti/problem_sets/:ui/:unique", instrument, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSet)
+		r.Post("/v2/lti/quizzes", instrument, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiQuizzes)
+
+		// grade posts to the LMS
+		r.Get("/v2/grade_posts", instrument, withTx, withCurrentUser, administratorOnly, GetGradePosts)
+		r.Post("/v2/grade_posts/:grade_post_id/resend", instrument, withTx, withCurrentUser, administratorOnly, PostGradePostResend)
+
+		// LTI 1.3
+		r.Get("/v2/lti13/config.json", instrument, GetLTI13Config)
+		r.Get("/v2/lti13/jwks", instrument, GetLTI13JWKS)
+		r.Get("/v2/lti13/login", instrument, withTx, LTI13Login)
+		r.Post("/v2/lti13/login", instrument, withTx, LTI13Login)
+		r.Post("/v2/lti13/launch", instrument, withTx, LTI13Launch)
+		r.Get("/v2/lti13/platforms", instrument, withTx, withCurrentUser, administratorOnly, GetLTIPlatforms)
+		r.Post("/v2/lti13/platforms", instrument, withTx, withCurrentUser, administratorOnly, binding.Json(LTIPlatform{}), PostLTIPlatform)
+		r.Put("/v2/lti13/platforms/:platform_id", instrument, withTx, withCurrentUser, administratorOnly, binding.Json(LTIPlatform{}), PutLTIPlatform)
+		r.Delete("/v2/lti13/platforms/:platform_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteLTIPlatform)
 
 		// problem bundles--for problem creation only
-		r.Post("/v2/problem_bundles/unconfirmed", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PostProblemBundleUnconfirmed)
-		r.Post("/v2/problem_bundles/confirmed", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PostProblemBundleConfirmed)
-		r.Put("/v2/problem_bundles/:problem_id", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PutProblemBundle)
+		r.Post("/v2/problem_bundles/unconfirmed", instrument, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PostProblemBundleUnconfirmed)
+		r.Post("/v2/problem_bundles/confirmed", instrument, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PostProblemBundleConfirmed)
+		r.Put("/v2/problem_bundles/:problem_id", instrument, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PutProblemBundle)
+
+		// problem archives--for sharing problems between installations
+		r.Post("/v2/problem_archives", instrument, withTx, withCurrentUser, authorOnly, PostProblemArchive)
 
 		// problem set bundles--for problem set creation only
-		r.Post("/v2/problem_set_bundles", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemSetBundle{}), PostProblemSetBundle)
-		r.Put("/v2/problem_set_bundles/:problem_set_id", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemSetBundle{}), PutProblemSetBundle)
+		r.Post("/v2/problem_set_bundles", instrument, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemSetBundle{}), PostProblemSetBundle)
+		r.Put("/v2/problem_set_bundles/:problem_set_id", instrument, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemSetBundle{}), PutProblemSetBundle)
 
 		// problem types
-		r.Get("/v2/problem_types", counter, auth, withTx, GetProblemTypes)
-		r.Get("/v2/problem_types/:name", counter, auth, withTx, GetProblemType)
+		r.Get("/v2/problem_types", instrument, auth, withTx, GetProblemTypes)
+		r.Get("/v2/problem_types/:name", instrument, auth, withTx, GetProblemType)
 
 		// problems
-		r.Get("/v2/problems", counter, withTx, withCurrentUser, GetProblems)
-		r.Get("/v2/problems/:problem_id", counter, withTx, withCurrentUser, GetProblem)
-		r.Get("/v2/problems/:problem_id/steps", counter, withTx, withCurrentUser, GetProblemSteps)
-		r.Get("/v2/problems/:problem_id/steps/:step", counter, withTx, withCurrentUser, GetProblemStep)
-		r.Delete("/v2/problems/:problem_id", counter, withTx, withCurrentUser, administratorOnly, DeleteProblem)
+		r.Get("/v2/problems", instrument, withTx, withCurrentUser, GetProblems)
+		r.Get("/v2/problems/:problem_id", instrument, withTx, withCurrentUser, GetProblem)
+		r.Get("/v2/problems/:problem_id/steps", instrument, withTx, withCurrentUser, GetProblemSteps)
+		r.Get("/v2/problems/:problem_id/steps/:step", instrument, withTx, withCurrentUser, GetProblemStep)
+		r.Get("/v2/problems/:problem_id/revisions", instrument, withTx, withCurrentUser, authorOnly, GetProblemRevisions)
+		r.Get("/v2/problems/:problem_id/archive", instrument, withTx, withCurrentUser, authorOnly, GetProblemArchive)
+		r.Delete("/v2/problems/:problem_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteProblem)
+
+		// similarity reports
+		r.Post("/v2/problems/:problem_id/steps/:step/similarity", instrument, withTx, withCurrentUser, PostProblemStepSimilarity)
+		r.Get("/v2/similarity_reports/:report_id", instrument, withTx, withCurrentUser, GetSimilarityReport)
 
 		// problem sets
-		r.Get("/v2/problem_sets", counter, withTx, withCurrentUser, GetProblemSets)
-		r.Get("/v2/problem_sets/:problem_set_id", counter, withTx, withCurrentUser, GetProblemSet)
-		r.Get("/v2/problem_sets/:problem_set_id/problems", counter, withTx, withCurrentUser, GetProblemSetProblems)
-		r.Delete("/v2/problem_sets/:problem_set_id", counter, withTx, withCurrentUser, administratorOnly, DeleteProblemSet)
+		r.Get("/v2/problem_sets", instrument, withTx, withCurrentUser, GetProblemSets)
+		r.Get("/v2/problem_sets/:problem_set_id", instrument, withTx, withCurrentUser, GetProblemSet)
+		r.Get("/v2/problem_sets/:problem_set_id/archive", instrument, withTx, withCurrentUser, authorOnly, GetProblemSetArchive)
+		r.Get("/v2/problem_sets/:problem_set_id/problems", instrument, withTx, withCurrentUser, GetProblemSetProblems)
+		r.Delete("/v2/problem_sets/:problem_set_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteProblemSet)
 
 		// courses
-		r.Get("/v2/courses", counter, withTx, withCurrentUser, GetCourses)
-		r.Get("/v2/courses/:course_id", counter, withTx, withCurrentUser, GetCourse)
-		r.Delete("/v2/courses/:course_id", counter, withTx, withCurrentUser, administratorOnly, DeleteCourse)
+		r.Get("/v2/courses", instrument, withTx, withCurrentUser, GetCourses)
+		r.Get("/v2/courses/:course_id", instrument, withTx, withCurrentUser, GetCourse)
+		r.Delete("/v2/courses/:course_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteCourse)
+
+		// extensions
+		r.Get("/v2/courses/:course_id/extensions", instrument, withTx, withCurrentUser, GetCourseExtensions)
+		r.Post("/v2/courses/:course_id/extensions", instrument, withTx, withCurrentUser, binding.Json(ExtensionGrant{}), PostCourseExtensions)
+		r.Delete("/v2/extensions/:extension_id", instrument, withTx, withCurrentUser, DeleteExtension)
+
+		// gradebook
+		r.Get("/v2/courses/:course_id/gradebook", instrument, withTx, withCurrentUser, GetCourseGradebook)
 
 		// users
-		r.Get("/v2/users", counter, withTx, withCurrentUser, GetUsers)
-		r.Get("/v2/users/me", counter, withTx, withCurrentUser, GetUserMe)
-		r.Get("/v2/users/session", counter, GetUserSession)
-		r.Get("/v2/users/:user_id", counter, withTx, withCurrentUser, GetUser)
-		r.Get("/v2/courses/:course_id/users", counter, withTx, withCurrentUser, GetCourseUsers)
-		r.Delete("/v2/users/:user_id", counter, withTx, withCurrentUser, administratorOnly, DeleteUser)
+		r.Get("/v2/users", instrument, withTx, withCurrentUser, GetUsers)
+		r.Get("/v2/users/me", instrument, withTx, withCurrentUser, GetUserMe)
+		r.Get("/v2/users/session", instrument, GetUserSession)
+		r.Get("/v2/users/:user_id", instrument, withTx, withCurrentUser, GetUser)
+		r.Get("/v2/courses/:course_id/users", instrument, withTx, withCurrentUser, GetCourseUsers)
+		r.Delete("/v2/users/:user_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteUser)
 
 		// assignments
-		r.Get("/v2/users/:user_id/assignments", counter, withTx, withCurrentUser, GetUserAssignments)
-		r.Get("/v2/courses/:course_id/users/:user_id/assignments", counter, withTx, withCurrentUser, GetCourseUserAssignments)
-		r.Get("/v2/assignments", counter, withTx, withCurrentUser, GetAssignments)
-		r.Get("/v2/assignments/:assignment_id", counter, withTx, withCurrentUser, GetAssignment)
-		r.Delete("/v2/assignments/:assignment_id", counter, withTx, withCurrentUser, administratorOnly, DeleteAssignment)
+		r.Get("/v2/users/:user_id/assignments", instrument, withTx, withCurrentUser, GetUserAssignments)
+		r.Get("/v2/courses/:course_id/users/:user_id/assignments", instrument, withTx, withCurrentUser, GetCourseUserAssignments)
+		r.Get("/v2/assignments", instrument, withTx, withCurrentUser, GetAssignments)
+		r.Get("/v2/assignments/:assignment_id", instrument, withTx, withCurrentUser, GetAssignment)
+		r.Delete("/v2/assignments/:assignment_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteAssignment)
 
 		// commits
-		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/commits/last", counter, withTx, withCurrentUser, GetAssignmentProblemCommitLast)
-		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits/last", counter, withTx, withCurrentUser, GetAssignmentProblemStepCommitLast)
-		r.Delete("/v2/commits/:commit_id", counter, withTx, withCurrentUser, administratorOnly, DeleteCommit)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/commits/last", instrument, withTx, withCurrentUser, GetAssignmentProblemCommitLast)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/commits", instrument, withTx, withCurrentUser, GetAssignmentProblemCommits)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step", instrument, withTx, withCurrentUser, GetAssignmentProblemStep)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits/last", instrument, withTx, withCurrentUser, GetAssignmentProblemStepCommitLast)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits", instrument, withTx, withCurrentUser, GetAssignmentProblemStepCommits)
+		r.Get("/v2/commits/:commit_id", instrument, withTx, withCurrentUser, GetCommit)
+		r.Delete("/v2/commits/:commit_id", instrument, withTx, withCurrentUser, administratorOnly, DeleteCommit)
 
 		// commit bundles
-		r.Post("/v2/commit_bundles/unsigned", counter, withTx, withCurrentUser, gunzip, binding.Json(CommitBundle{}), PostCommitBundlesUnsigned)
-		r.Post("/v2/commit_bundles/signed", counter, withTx, withCurrentUser, gunzip, binding.Json(CommitBundle{}), PostCommitBundlesSigned)
+		r.Post("/v2/commit_bundles/unsigned", instrument, withTx, withCurrentUser, gunzip, binding.Json(CommitBundle{}), PostCommitBundlesUnsigned)
+		r.Post("/v2/commit_bundles/signed", instrument, withTx, withCurrentUser, gunzip, binding.Json(CommitBundle{}), PostCommitBundlesSigned)
 
 		// quizzes
-		r.Get("/v2/assignments/:assignment_id/quizzes", counter, withTx, withCurrentUser, GetAssignmentQuizzes)
-		r.Get("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, GetQuiz)
-		r.Patch("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, gunzip, binding.Json(QuizPatch{}), PatchQuiz)
-		r.Post("/v2/quizzes", counter, withTx, withCurrentUser, gunzip, binding.Json(Quiz{}), PostQuiz)
-		r.Delete("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, DeleteQuiz)
+		r.Get("/v2/assignments/:assignment_id/quizzes", instrument, withTx, withCurrentUser, GetAssignmentQuizzes)
+		r.Get("/v2/quizzes/:quiz_id", instrument, withTx, withCurrentUser, GetQuiz)
+		r.Patch("/v2/quizzes/:quiz_id", instrument, withTx, withCurrentUser, gunzip, binding.Json(QuizPatch{}), PatchQuiz)
+		r.Post("/v2/quizzes", instrument, withTx, withCurrentUser, gunzip, binding.Json(Quiz{}), PostQuiz)
+		r.Delete("/v2/quizzes/:quiz_id", instrument, withTx, withCurrentUser, DeleteQuiz)
 
 		// questions
-		r.Get("/v2/quizzes/:quiz_id/questions", counter, withTx, withCurrentUser, GetQuizQuestions)
-		r.Get("/v2/assignments/:assignment_id/questions/open", counter, withTx, withCurrentUser, GetAssignmentQuestionsOpen)
-		//r.Get("/v2/assignments/:assignment_id/questions/mock", counter, withTx, withCurrentUser, MockGetAssignmentQuestionsOpen)
-		r.Get("/v2/questions/:question_id", counter, withTx, withCurrentUser, GetQuestion)
-		r.Patch("/v2/questions/:question_id", counter, withTx, withCurrentUser, gunzip, binding.Json(QuestionPatch{}), PatchQuestion)
-		r.Post("/v2/questions", counter, withTx, withCurrentUser, gunzip, binding.Json(Question{}), PostQuestion)
-		r.Delete("/v2/questions/:question_id", counter, withTx, withCurrentUser, DeleteQuestion)
+		r.Get("/v2/quizzes/:quiz_id/questions", instrument, withTx, withCurrentUser, GetQuizQuestions)
+		r.Get("/v2/assignments/:assignment_id/questions/open", instrument, withTx, withCurrentUser, GetAssignmentQuestionsOpen)
+		//r.Get("/v2/assignments/:assignment_id/questions/mock", instrument, withTx, withCurrentUser, MockGetAssignmentQuestionsOpen)
+		r.Get("/v2/questions/:question_id", instrument, withTx, withCurrentUser, GetQuestion)
+		r.Patch("/v2/questions/:question_id", instrument, withTx, withCurrentUser, gunzip, binding.Json(QuestionPatch{}), PatchQuestion)
+		r.Post("/v2/questions", instrument, withTx, withCurrentUser, gunzip, binding.Json(Question{}), PostQuestion)
+		r.Delete("/v2/questions/:question_id", instrument, withTx, withCurrentUser, DeleteQuestion)
 
 		// responses
-		r.Get("/v2/questions/:question_id/responses", counter, withTx, withCurrentUser, GetQuestionResponses)
-		r.Post("/v2/responses", counter, withTx, withCurrentUser, gunzip, binding.Json(Response{}), PostResponse)
-	}
-
-	// set up letsencrypt
-	lem := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache(Config.LetsEncryptCache),
-		HostPolicy: autocert.HostWhitelist(Config.Hostname),
-		Email:      Config.LetsEncryptEmail,
-	}
-
-	// set up the https server
-	log.Printf("accepting https connections")
-	server := &http.Server{
-		Addr:    ":https",
-		Handler: m,
-		TLSConfig: &tls.Config{
-			PreferServerCipherSuites: true,
-			MinVersion:               tls.VersionTLS10,
-			GetCertificate:           lem.GetCertificate,
-		},
-	}
-
-	// set up the http server
-	// it is necessary for LetsEncrypt challenges
-	// it forwards other requests to https, but only if the host name was correct
-	forwarder := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// get the address of the client
-		addr := r.Header.Get("X-Real-IP")
-		if addr == "" {
-			addr = r.Header.Get("X-Forwarded-For")
-			if addr == "" {
-				addr = r.RemoteAddr
-			}
-		}
-
-		// make sure the request is for the right host name
-		if Config.Hostname != r.Host {
-			http.Error(w, "http request to invalid host", http.StatusBadRequest)
-			return
-		}
-		var u url.URL = *r.URL
-		u.Scheme = "https"
-		u.Host = Config.Hostname
-		log.Printf("redirecting http request from %s to %s", addr, u.String())
-		w.Header().Set("Connection", "close")
-		http.Redirect(w, r, u.String(), http.StatusFound)
-	})
-
-	// start both servers
-	go func() {
-		if err := http.ListenAndServe(":http", lem.HTTPHandler(forwarder)); err != nil {
-			log.Fatalf("ListenAndServe: %v", err)
-		}
-	}()
-	if err := server.ListenAndServeTLS("", ""); err != nil {
-		log.Fatalf("ListenAndServeTLS: %v", err)
+		r.Get("/v2/questions/:question_id/responses", instrument, withTx, withCurrentUser, GetQuestionResponses)
+		r.Post("/v2/responses", instrument, withTx, withCurrentUser, gunzip, binding.Json(Response{}), PostResponse)
 	}
-}
 
-func setupDB(path string) *sql.DB {
-	meddler.Default = meddler.SQLite
-
-	options :=
-		"?" + "_busy_timeout=10000" +
-			"&" + "_case_sensitive_like=OFF" +
-			"&" + "_foreign_keys=ON" +
-			"&" + "_journal_mode=WAL" +
-			"&" + "mode=rw" +
-			"&" + "_synchronous=FULL"
-	db, err := sql.Open("sqlite3", path+options)
-	if err != nil {
-		log.Fatalf("error opening database: %v", err)
+	servers := serve(m, proxies)
+	if server := serveMetrics(); server != nil {
+		servers = append(servers, server)
 	}
-
-	return db
+	waitForShutdown(servers, daycare, finishTA)
 }
 
 func addWhereEq(where string, args []interface{}, label string, value interface{}) (string, []interface{}) {
@@ -586,8 +613,8 @@ func addWhereLike(where string, args []interface{}, label string, value string)
 	}
 	args = append(args, "%"+strings.ToLower(value)+"%")
 
-	// sqlite is set to use case insensitive LIKEs
-	where += fmt.Sprintf(" %s LIKE ?", label)
+	// compare in lower case so the search is case insensitive in every database
+	where += fmt.Sprintf(" LOWER(%s) LIKE ?", label)
 	return where, args
 }
 
@@ -688,6 +715,12 @@ func (m *daycares) Insert(reg *DaycareRegistration) error {
 	if sig != reg.Signature {
 		return fmt.Errorf("signature mismatch: computed %s but found %s", sig, reg.Signature)
 	}
+	if reg.StatusSignature == "" {
+		// an older daycare that does not report its status
+		reg.Slots, reg.Running, reg.Waiting, reg.Latency, reg.Draining = 0, 0, 0, 0, false
+	} else if sig := reg.ComputeStatusSignature(Config.DaycareSecret); sig != reg.StatusSignature {
+		return fmt.Errorf("status signature mismatch: computed %s but found %s", sig, reg.StatusSignature)
+	}
 	if reg.Version != CurrentVersion.Version {
 		return fmt.Errorf("version mismatch: daycare is %s, but ta is %s", reg.Version, CurrentVersion.Version)
 	}
@@ -699,11 +732,22 @@ func (m *daycares) Insert(reg *DaycareRegistration) error {
 		return fmt.Errorf("time drift is too great")
 	}
 
+	// a daycare that is shutting down is removed right away
+	if reg.Draining {
+		if m.daycares[reg.Hostname] != nil {
+			log.Printf("daycare registration for %s removed: daycare is shutting down", reg.Hostname)
+			delete(m.daycares, reg.Hostname)
+		}
+		return nil
+	}
+
 	// clean it up a bit
 	sort.Strings(reg.ProblemTypes)
+	reg.Assigned = 0
 	reg.Time = time.Now()
 	reg.Version = ""
 	reg.Signature = ""
+	reg.StatusSignature = ""
 	if m.daycares[reg.Hostname] == nil {
 		log.Printf("daycare registration for %s added", reg.Hostname)
 	}
@@ -712,12 +756,29 @@ func (m *daycares) Insert(reg *DaycareRegistration) error {
 	return nil
 }
 
+// List gives a copy of the current registrations, keyed by hostname.
+func (m *daycares) List() map[string]*DaycareRegistration {
+	m.Lock()
+	defer m.Unlock()
+
+	list := make(map[string]*DaycareRegistration)
+	for host, elt := range m.daycares {
+		reg := *elt
+		list[host] = &reg
+	}
+	return list
+}
+
+// Assign picks the least-loaded daycare that supports all of the given problem types.
+// Load counts running and waiting requests as reported in the latest registration,
+// plus requests assigned since then, relative to the number of container slots.
+// Ties go to the host with the lowest recent latency, then are broken at random.
 func (m *daycares) Assign(problemTypes map[string]bool) (string, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	// gather the total weights of all of the eligible daycare hosts
-	totalWeight := 0
+	var best []*DaycareRegistration
+	bestLoad := 0.0
 	for _, elt := range m.daycares {
 		// does this daycare support all required problem types?
 		supported := true
@@ -728,43 +789,64 @@ func (m *daycares) Assign(problemTypes map[string]bool) (string, error) {
 				break
 			}
 		}
-		if supported {
-			totalWeight += elt.Capacity
+		if !supported {
+			continue
+		}
+
+		load := elt.Load()
+		switch {
+		case len(best) == 0 || load < bestLoad || load == bestLoad && elt.Latency < best[0].Latency:
+			best = []*DaycareRegistration{elt}
+			bestLoad = load
+		case load == bestLoad && elt.Latency == best[0].Latency:
+			best = append(best, elt)
 		}
 	}
-	if totalWeight == 0 {
+	if len(best) == 0 {
 		return "", fmt.Errorf("no eligible daycare found")
 	}
 
-	// pick a random point in pool of weights
-	point := rand.Intn(totalWeight)
-	skippedWeight := 0
-	for host, elt := range m.daycares {
-		supported := true
-		for problemType := range problemTypes {
-			n := sort.SearchStrings(elt.ProblemTypes, problemType)
-			if n >= len(elt.ProblemTypes) || elt.ProblemTypes[n] != problemType {
-				supported = false
-				break
-			}
-		}
-		if supported {
-			skippedWeight += elt.Capacity
-		}
-		if point < skippedWeight {
-			return host, nil
-		}
-	}
-	return "", fmt.Errorf("failed to find daycare, please report this error")
+	// pick one of the best at random
+	chosen := best[rand.Intn(len(best))]
+	chosen.Assigned++
+	return chosen.Hostname, nil
 }
 
+// DaycareRegistration is sent periodically by each daycare to the TA.
+// Slots, Running, Waiting, and Latency describe the current load on the daycare.
+// Assigned counts requests the TA has sent to the daycare since the registration.
+//
+// Signature covers the fields every version has sent, so a TA and daycares
+// running different versions can still verify each other during an upgrade.
+// The newer fields are covered by StatusSignature instead, and are ignored
+// if it is missing.
 type DaycareRegistration struct {
-	Hostname     string    `json:"hostname"`
-	ProblemTypes []string  `json:"problemTypes"`
-	Capacity     int       `json:"capacity"`
-	Time         time.Time `json:"time"`
-	Version      string    `json:"version,omitempty"`
-	Signature    string    `json:"signature,omitempty"`
+	Hostname     string        `json:"hostname"`
+	ProblemTypes []string      `json:"problemTypes"`
+	Capacity     int           `json:"capacity"`
+	Slots        int           `json:"slots"`
+	Running      int           `json:"running"`
+	Waiting      int           `json:"waiting"`
+	Latency      time.Duration `json:"latency"`
+	Assigned     int           `json:"assigned"`
+	Time         time.Time     `json:"time"`
+	Version      string        `json:"version,omitempty"`
+	Draining     bool          `json:"draining,omitempty"`
+	Signature    string        `json:"signature,omitempty"`
+
+	StatusSignature string `json:"statusSignature,omitempty"`
+}
+
+// Load gives the number of requests per container slot on the daycare.
+func (reg *DaycareRegistration) Load() float64 {
+	slots := reg.Slots
+	if slots < 1 {
+		slots = reg.Capacity
+	}
+	if slots < 1 {
+		slots = 1
+	}
+	return float64(reg.Running+reg.Waiting+reg.Assigned) / float64(slots)
 }
 
 func (reg *DaycareRegistration) ComputeSignature(secret string) string {
@@ -788,16 +870,22 @@ func (reg *DaycareRegistration) ComputeSignature(secret string) string {
 	return sig
 }
 
-var (
-	hits                  int
-	hitsCounter           = expvar.NewInt("hits")
-	slowest               float64
-	slowestCounter        = expvar.NewFloat("slowestSeconds")
-	slowestPathCounter    = expvar.NewString("slowestPath")
-	slowestTimeCounter    = expvar.NewString("slowestTime")
-	totalSeconds          float64
-	totalSecondsCounter   = expvar.NewFloat("totalSeconds")
-	averageSecondsCounter = expvar.NewFloat("averageSeconds")
-	errorsCounter         = expvar.NewInt("errors")
-	goroutineCounter      = expvar.NewInt("goroutines")
-)
+func (reg *DaycareRegistration) ComputeStatusSignature(secret string) string {
+	v := make(url.Values)
+
+	// gather all relevant fields
+	v.Add("hostname", reg.Hostname)
+	v.Add("time", reg.Time.Round(time.Second).UTC().Format(time.RFC3339))
+	v.Add("slots", strconv.Itoa(reg.Slots))
+	v.Add("running", strconv.Itoa(reg.Running))
+	v.Add("waiting", strconv.Itoa(reg.Waiting))
+	v.Add("latency", reg.Latency.String())
+	v.Add("draining", strconv.FormatBool(reg.Draining))
+
+	// compute signature
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(encode(v))
+	sum := mac.Sum(nil)
+	sig := base64.StdEncoding.EncodeToString(sum)
+	return sig
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestDaycaresAssignLeastLoaded(t *testing.T) {
+	m := &daycares{daycares: map[string]*DaycareRegistration{
+		"busy":   {Hostname: "busy", ProblemTypes: []string{"python3unittest"}, Capacity: 1, Slots: 4, Running: 4, Waiting: 2},
+		"idle":   {Hostname: "idle", ProblemTypes: []string{"gotest", "python3unittest"}, Capacity: 1, Slots: 2, Running: 0},
+		"slow":   {Hostname: "slow", ProblemTypes: []string{"python3unittest"}, Capacity: 1, Slots: 2, Running: 0, Latency: time.Second},
+		"gohost": {Hostname: "gohost", ProblemTypes: []string{"gotest"}, Capacity: 1, Slots: 8},
+	}}
+	want := map[string]bool{"python3unittest": true}
+
+	// idle wins on load and latency, then fills up until slow looks better
+	for i, expected := range []string{"idle", "slow", "idle", "slow"} {
+		host, err := m.Assign(want)
+		if err != nil {
+			t.Fatalf("assignment %d: %v", i, err)
+		}
+		if host != expected {
+			t.Errorf("assignment %d: got %s, expected %s", i, host, expected)
+		}
+	}
+
+	if _, err := m.Assign(map[string]bool{"rust": true}); err == nil {
+		t.Errorf("expected an error when no daycare supports the problem type")
+	}
+}
+
+func TestDaycaresDeregister(t *testing.T) {
+	Config.DaycareSecret = "daycare secret"
+	m := &daycares{daycares: make(map[string]*DaycareRegistration)}
+	register := func(draining bool) error {
+		reg := &DaycareRegistration{
+			Hostname:     "daycare.example.com",
+			ProblemTypes: []string{"python3unittest"},
+			Capacity:     1,
+			Slots:        4,
+			Time:         time.Now(),
+			Version:      CurrentVersion.Version,
+			Draining:     draining,
+		}
+		reg.Signature = reg.ComputeSignature(Config.DaycareSecret)
+		reg.StatusSignature = reg.ComputeStatusSignature(Config.DaycareSecret)
+		return m.Insert(reg)
+	}
+
+	if err := register(false); err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+	if _, err := m.Assign(map[string]bool{"python3unittest": true}); err != nil {
+		t.Errorf("registered daycare should be assigned work: %v", err)
+	}
+
+	// the draining flag is covered by the status signature
+	reg := &DaycareRegistration{Hostname: "daycare.example.com", Time: time.Now(), Version: CurrentVersion.Version}
+	reg.Signature = reg.ComputeSignature(Config.DaycareSecret)
+	reg.StatusSignature = reg.ComputeStatusSignature(Config.DaycareSecret)
+	reg.Draining = true
+	if err := m.Insert(reg); err == nil {
+		t.Errorf("expected a signature mismatch when the draining flag is changed")
+	}
+
+	// but not by the signature older TAs check, and it is ignored without a status signature
+	if sig := reg.ComputeSignature(Config.DaycareSecret); sig != reg.Signature {
+		t.Errorf("the signature checked by older TAs should not cover the draining flag")
+	}
+	reg.StatusSignature = ""
+	if err := m.Insert(reg); err != nil {
+		t.Errorf("registration from an older daycare: %v", err)
+	}
+	if len(m.List()) != 1 {
+		t.Errorf("the draining flag should be ignored without a status signature, found %v", m.List())
+	}
+
+	if err := register(true); err != nil {
+		t.Fatalf("deregistering: %v", err)
+	}
+	if len(m.List()) != 0 {
+		t.Errorf("daycare should be removed after deregistering, found %v", m.List())
+	}
+	if _, err := m.Assign(map[string]bool{"python3unittest": true}); err == nil {
+		t.Errorf("expected no daycare to be assigned after deregistering")
+	}
+}
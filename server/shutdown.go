@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// daycareDraining is closed when a daycare starts shutting down, prompting its
+// registration loop to send one last registration that removes it from the TA.
+// The loop closes daycareDeregistered when it is finished.
+var (
+	daycareDraining     = make(chan struct{})
+	daycareDeregistered = make(chan struct{})
+)
+
+// shutdownPollInterval is how often to check whether running actions have finished.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// waitForShutdown blocks until the process receives SIGINT or SIGTERM,
+// then shuts down. A second signal exits immediately.
+func waitForShutdown(servers []*http.Server, daycare bool, finishTA func(ctx context.Context)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("received %v, shutting down; send it again to exit immediately", sig)
+	go func() {
+		sig := <-signals
+		log.Fatalf("received %v again, exiting now", sig)
+	}()
+
+	shutdown(servers, daycare, finishTA)
+}
+
+// shutdown stops taking new work and lets the work in progress finish,
+// giving up after Config.ShutdownGrace seconds.
+//
+// A daycare refuses new sessions and deregisters from the TA first.
+// Once the servers have stopped, running actions get whatever is left of
+// the grace period, and then every container the daycare still owns is removed.
+// For the TA, finishTA runs last, after every request has finished,
+// with whatever is left of the grace period.
+func shutdown(servers []*http.Server, daycare bool, finishTA func(ctx context.Context)) {
+	grace := time.Duration(Config.ShutdownGrace) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if daycare {
+		admission.drain()
+		if n := warmContainers.Close(); n > 0 {
+			log.Printf("removed %d warm containers", n)
+		}
+		close(daycareDraining)
+		select {
+		case <-daycareDeregistered:
+		case <-ctx.Done():
+		}
+	}
+
+	// stop accepting connections and let open requests finish
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("requests to %s did not finish in time: %v", server.Addr, err)
+				server.Close()
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	// websocket sessions are not tracked by the http servers
+	if daycare {
+		if !admission.idle(shutdownPollInterval, ctx.Done()) {
+			log.Printf("running actions did not finish in time")
+		}
+		if n := shutdownNannies("daycare shutting down"); n > 0 {
+			log.Printf("shut down %d containers", n)
+		}
+		checkSandboxes(sandboxes, Config.Hostname, false)
+
+		// give the handlers a moment to report back to their clients
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		admission.idle(shutdownPollInterval, ctx.Done())
+		cancel()
+	}
+
+	if finishTA != nil {
+		finishTA(ctx)
+	}
+	log.Printf("shutdown complete")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestShutdownDaycare(t *testing.T) {
+	Config.Hostname = "daycare.example.com"
+	Config.TAHostname = "ta.example.com"
+	Config.DaycareSecret = "daycare secret"
+	Config.ShutdownGrace = 1
+
+	// the grader runs until its container is removed
+	started := make(chan struct{}, 1)
+	runtime := newFakeRuntime(map[string]fakeCommand{
+		"make grade": func(s *fakeSandbox, stdin io.Reader, stdout, stderr io.Writer) int {
+			started <- struct{}{}
+			for {
+				s.Lock()
+				removed := s.removed
+				s.Unlock()
+				if removed {
+					return 137
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		},
+	})
+	sandboxes = runtime
+	admission = newAdmissionQueue(1, 1)
+
+	// the registration loop is not running, so it has nothing to say on the way out
+	daycareDraining, daycareDeregistered = make(chan struct{}), make(chan struct{})
+	close(daycareDeregistered)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		SocketProblemTypeAction(w, r, martini.Params{"problem_type": parts[3], "action": parts[4]})
+	}))
+	defer server.Close()
+	socketURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v2/sockets/fakeunittest/grade"
+	dial := func() *websocket.Conn {
+		socket, _, err := websocket.DefaultDialer.Dial(socketURL, nil)
+		if err != nil {
+			t.Fatalf("dialing %s: %v", socketURL, err)
+		}
+		if err := socket.WriteJSON(&DaycareRequest{CommitBundle: fakeCommitBundle("42\n")}); err != nil {
+			t.Fatalf("writing request: %v", err)
+		}
+		return socket
+	}
+
+	// one action is running and another is waiting for a container
+	running := dial()
+	defer running.Close()
+	<-started
+	waiting := dial()
+	defer waiting.Close()
+	for {
+		if _, _, n, _ := admission.stats(); n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	shutdown([]*http.Server{server.Config}, true, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("shutdown took %v with a one second grace period", elapsed)
+	}
+
+	// the waiting request is told to go elsewhere
+	for {
+		res := new(DaycareResponse)
+		if err := waiting.ReadJSON(res); err != nil {
+			t.Fatalf("reading response for the waiting request: %v", err)
+		}
+		if res.Error != "" {
+			if !strings.Contains(res.Error, "shutting down") || res.RetryAfter == 0 {
+				t.Errorf("waiting request: got error %q with retry after %d", res.Error, res.RetryAfter)
+			}
+			break
+		}
+	}
+
+	// the running action was cut off and its container is gone
+	for _, s := range runtime.sandboxes {
+		if !s.removed {
+			t.Errorf("sandbox %s was not removed", s.id)
+		}
+	}
+	if n := shutdownNannies("test"); n != 0 {
+		t.Errorf("found %d containers still running after shutdown", n)
+	}
+
+	// and new sessions are not accepted
+	if _, _, err := websocket.DefaultDialer.Dial(socketURL, nil); err == nil {
+		t.Errorf("expected a new session to be refused after shutdown")
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// Similarity reports are computed by a background worker. A request saves
+// a report in the running state and the worker picks it up, compares the
+// final submissions for the problem step, and saves the ranked results.
+
+const (
+	similarityRunning      = "running"
+	similarityDone         = "done"
+	similarityFailed       = "failed"
+	similarityPollInterval = time.Minute
+	similarityMinScore     = 0.2
+	similarityMaxPairs     = 100
+	similarityMaxMatches   = 20
+	similarityMaxExcerpt   = 40
+	similarityMaxRepeats   = 4
+)
+
+// submission is one student's final files for a problem step.
+type submission struct {
+	info   *SimilaritySubmission
+	files  []*sourceFile
+	hashes map[uint64][]location
+}
+
+// location is where a fingerprint was found in a submission.
+type location struct {
+	file int
+	pos  int
+}
+
+func newSubmission(info *SimilaritySubmission, files map[string][]byte) *submission {
+	sub := &submission{
+		info:   info,
+		files:  tokenizeFiles(files),
+		hashes: make(map[uint64][]location),
+	}
+	for i, file := range sub.files {
+		for _, fp := range file.prints {
+			sub.hashes[fp.hash] = append(sub.hashes[fp.hash], location{file: i, pos: fp.pos})
+		}
+	}
+	return sub
+}
+
+// compareSubmissions finds pairs of submissions that share code.
+// Fingerprints from the starter code of any of the given revisions are ignored,
+// as are fingerprints found in more than half of the submissions, since those
+// are most likely code that everyone was expected to write the same way.
+func compareSubmissions(subs []*submission, starters []map[string][]byte) []*SimilarityPair {
+	ignore := make(map[uint64]bool)
+	for _, starter := range starters {
+		for _, file := range tokenizeFiles(starter) {
+			for _, fp := range file.prints {
+				ignore[fp.hash] = true
+			}
+		}
+	}
+	for _, sub := range subs {
+		for hash := range sub.hashes {
+			if ignore[hash] {
+				delete(sub.hashes, hash)
+			}
+		}
+	}
+
+	// index the fingerprints and drop the common ones
+	index := make(map[uint64][]int)
+	for i, sub := range subs {
+		for hash := range sub.hashes {
+			index[hash] = append(index[hash], i)
+		}
+	}
+	for hash, list := range index {
+		if len(list) > 2 && len(list)*2 > len(subs) {
+			delete(index, hash)
+			for _, i := range list {
+				delete(subs[i].hashes, hash)
+			}
+		}
+	}
+
+	// count the fingerprints each pair shares
+	type pairKey struct{ a, b int }
+	shared := make(map[pairKey]int)
+	for _, list := range index {
+		for x := 0; x < len(list); x++ {
+			for y := x + 1; y < len(list); y++ {
+				shared[pairKey{list[x], list[y]}]++
+			}
+		}
+	}
+
+	var pairs []*SimilarityPair
+	keys := make(map[*SimilarityPair]pairKey)
+	for key, count := range shared {
+		a, b := subs[key.a], subs[key.b]
+		shareA := float64(count) / float64(len(a.hashes))
+		shareB := float64(count) / float64(len(b.hashes))
+		score := shareA
+		if shareB > score {
+			score = shareB
+		}
+		if score < similarityMinScore {
+			continue
+		}
+		infoA, infoB := *a.info, *b.info
+		infoA.Shared, infoB.Shared = shareA, shareB
+		pair := &SimilarityPair{A: &infoA, B: &infoB, Score: score}
+		pairs = append(pairs, pair)
+		keys[pair] = key
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		if pairs[i].A.AssignmentID != pairs[j].A.AssignmentID {
+			return pairs[i].A.AssignmentID < pairs[j].A.AssignmentID
+		}
+		return pairs[i].B.AssignmentID < pairs[j].B.AssignmentID
+	})
+	if len(pairs) > similarityMaxPairs {
+		pairs = pairs[:similarityMaxPairs]
+	}
+	for _, pair := range pairs {
+		key := keys[pair]
+		pair.Matches = matchRegions(subs[key.a], subs[key.b])
+	}
+	return pairs
+}
+
+// matchRegions finds the regions of code shared by two submissions by
+// chaining fingerprints that appear in the same order in both.
+func matchRegions(a, b *submission) []*SimilarityMatch {
+	type hit struct{ a, b location }
+	var hits []hit
+	for hash, locsA := range a.hashes {
+		locsB := b.hashes[hash]
+		if len(locsB) == 0 || len(locsA) > similarityMaxRepeats || len(locsB) > similarityMaxRepeats {
+			continue
+		}
+		for _, la := range locsA {
+			for _, lb := range locsB {
+				hits = append(hits, hit{la, lb})
+			}
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].a != hits[j].a {
+			return hits[i].a.file < hits[j].a.file || hits[i].a.file == hits[j].a.file && hits[i].a.pos < hits[j].a.pos
+		}
+		return hits[i].b.file < hits[j].b.file || hits[i].b.file == hits[j].b.file && hits[i].b.pos < hits[j].b.pos
+	})
+
+	// chain hits into regions
+	type region struct{ first, last hit }
+	var regions []*region
+	gap := similarityK + similarityWindow
+	for _, h := range hits {
+		extended := false
+		for _, r := range regions {
+			if h.a.file == r.last.a.file && h.b.file == r.last.b.file &&
+				h.a.pos > r.last.a.pos && h.a.pos-r.last.a.pos <= gap &&
+				h.b.pos > r.last.b.pos && h.b.pos-r.last.b.pos <= gap {
+				r.last = h
+				extended = true
+				break
+			}
+		}
+		if !extended {
+			regions = append(regions, &region{first: h, last: h})
+		}
+	}
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].last.a.pos-regions[i].first.a.pos > regions[j].last.a.pos-regions[j].first.a.pos
+	})
+
+	// report the largest regions that do not overlap
+	var matches []*SimilarityMatch
+	for _, r := range regions {
+		if len(matches) >= similarityMaxMatches {
+			break
+		}
+		fileA, fileB := a.files[r.first.a.file], b.files[r.first.b.file]
+		startA, endA := tokenLines(fileA, r.first.a.pos, r.last.a.pos+similarityK-1)
+		startB, endB := tokenLines(fileB, r.first.b.pos, r.last.b.pos+similarityK-1)
+		overlap := false
+		for _, m := range matches {
+			if (m.FileA == fileA.name && startA <= m.EndLineA && endA >= m.StartLineA) ||
+				(m.FileB == fileB.name && startB <= m.EndLineB && endB >= m.StartLineB) {
+				overlap = true
+				break
+			}
+		}
+		if overlap {
+			continue
+		}
+		matches = append(matches, &SimilarityMatch{
+			FileA:      fileA.name,
+			StartLineA: startA,
+			EndLineA:   endA,
+			TextA:      excerpt(fileA, startA, endA),
+			FileB:      fileB.name,
+			StartLineB: startB,
+			EndLineB:   endB,
+			TextB:      excerpt(fileB, startB, endB),
+			Tokens:     r.last.a.pos - r.first.a.pos + similarityK,
+		})
+	}
+	return matches
+}
+
+// tokenLines gives the source lines covered by a range of tokens.
+func tokenLines(file *sourceFile, first, last int) (int, int) {
+	if last >= len(file.tokens) {
+		last = len(file.tokens) - 1
+	}
+	return file.tokens[first].line, file.tokens[last].line
+}
+
+func excerpt(file *sourceFile, start, end int) string {
+	if end-start+1 > similarityMaxExcerpt {
+		end = start + similarityMaxExcerpt - 1
+	}
+	if end > len(file.lines) {
+		end = len(file.lines)
+	}
+	return strings.Join(file.lines[start-1:end], "\n")
+}
+
+// similarityJobs is the worker that computes similarity reports.
+// Submissions are loaded in a read-only transaction on readDB, so a long
+// comparison never holds the write lock; only the finished report is
+// written to db. Reports that have not started when it stops stay in
+// the database for the next time the TA starts.
+type similarityJobs struct {
+	*worker
+	db     *sql.DB
+	readDB *sql.DB
+}
+
+// similarity is the worker for the TA role
+var similarity *similarityJobs
+
+// newSimilarityJobs creates a worker. readDB must come from the same
+// database as db, as with withTransaction.
+func newSimilarityJobs(db, readDB *sql.DB) *similarityJobs {
+	j := &similarityJobs{db: db, readDB: readDB}
+	j.worker = newWorker(similarityPollInterval, func(ctx context.Context) { j.Drain(ctx) })
+	return j
+}
+
+// Drain computes every report that is waiting, oldest first.
+// It returns the number of reports completed, stopping early if ctx is done.
+func (j *similarityJobs) Drain(ctx context.Context) int {
+	done := 0
+	for ctx.Err() == nil {
+		report := new(SimilarityReport)
+		err := readTx(j.readDB, func(tx *sql.Tx) error {
+			return meddler.QueryRow(tx, report, `SELECT * FROM similarity_reports WHERE status = ? ORDER BY id LIMIT 1`, similarityRunning)
+		})
+		if err == sql.ErrNoRows {
+			return done
+		}
+		if err != nil {
+			log.Printf("similarity: db error loading reports: %v", err)
+			return done
+		}
+
+		if err := j.compute(report); err != nil {
+			log.Printf("similarity: report %d failed: %v", report.ID, err)
+			report.Status = similarityFailed
+			report.Error = err.Error()
+		} else {
+			report.Status = similarityDone
+			done++
+		}
+		report.UpdatedAt = time.Now()
+		if err := writeTx(j.db, func(tx *sql.Tx) error {
+			return meddler.Update(tx, "similarity_reports", report)
+		}); err != nil {
+			log.Printf("similarity: db error saving report %d: %v", report.ID, err)
+			return done
+		}
+	}
+	return done
+}
+
+// compute loads the submissions for a report and compares them.
+func (j *similarityJobs) compute(report *SimilarityReport) error {
+	var commits []*Commit
+	var assignments []*Assignment
+	var users []*User
+	var starters []map[string][]byte
+	err := readTx(j.readDB, func(tx *sql.Tx) error {
+		requester := new(User)
+		if err := meddler.Load(tx, "users", requester, report.UserID); err != nil {
+			return err
+		}
+
+		// only compare students the requester can see,
+		// and only the last commit for each assignment counts
+		from := `FROM commits JOIN assignments ON commits.assignment_id = assignments.id `
+		where := `WHERE commits.problem_id = ? AND commits.step = ? AND NOT assignments.instructor ` +
+			`AND commits.id = (SELECT MAX(latest.id) FROM commits AS latest ` +
+			`WHERE latest.assignment_id = commits.assignment_id AND latest.problem_id = commits.problem_id AND latest.step = commits.step)`
+		args := []interface{}{report.ProblemID, report.Step}
+		if !requester.Admin {
+			from += `JOIN user_assignments ON assignments.id = user_assignments.assignment_id `
+			where += ` AND user_assignments.user_id = ?`
+			args = append(args, requester.ID)
+		}
+		if err := meddler.QueryAll(tx, &commits, `SELECT commits.* `+from+where+` ORDER BY commits.assignment_id`, args...); err != nil {
+			return err
+		}
+		if err := meddler.QueryAll(tx, &assignments, `SELECT assignments.* `+from+where, args...); err != nil {
+			return err
+		}
+		if err := meddler.QueryAll(tx, &users, `SELECT DISTINCT users.* `+from+`JOIN users ON assignments.user_id = users.id `+where, args...); err != nil {
+			return err
+		}
+
+		// the starter code for each revision the students are pinned to
+		revisions := make(map[int64]bool)
+		for _, asst := range assignments {
+			revision, _, err := getAssignmentProblemRevision(tx, asst.ID, report.ProblemID)
+			if err != nil {
+				return err
+			}
+			if revisions[revision] {
+				continue
+			}
+			revisions[revision] = true
+			_, steps, err := getProblemRevision(tx, report.ProblemID, revision)
+			if err != nil {
+				return err
+			}
+			for _, step := range steps {
+				if step.Step == report.Step {
+					starters = append(starters, step.Files)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	assignmentsByID := make(map[int64]*Assignment)
+	for _, asst := range assignments {
+		assignmentsByID[asst.ID] = asst
+	}
+	usersByID := make(map[int64]*User)
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	var subs []*submission
+	for _, commit := range commits {
+		asst := assignmentsByID[commit.AssignmentID]
+		if asst == nil || len(commit.Files) == 0 {
+			continue
+		}
+		info := &SimilaritySubmission{
+			AssignmentID: asst.ID,
+			CommitID:     commit.ID,
+			UserID:       asst.UserID,
+			CourseID:     asst.CourseID,
+		}
+		if user := usersByID[asst.UserID]; user != nil {
+			info.UserName = user.Name
+		}
+		subs = append(subs, newSubmission(info, commit.Files))
+	}
+
+	report.Submissions = int64(len(subs))
+	report.Pairs = compareSubmissions(subs, starters)
+	report.Error = ""
+	log.Printf("similarity: report %d compared %d submissions for problem %d step %d, found %d pairs",
+		report.ID, len(subs), report.ProblemID, report.Step, len(report.Pairs))
+	return nil
+}
+
+// PostProblemStepSimilarity handles POST requests to /v2/problems/:problem_id/steps/:step/similarity,
+// starting a similarity report for the final submissions to the given step.
+// It returns the new report, which is filled in by a background worker.
+func PostProblemStepSimilarity(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	step, err := parseID(w, "step", params["step"])
+	if err != nil {
+		return
+	}
+
+	if !currentUser.Admin {
+		var count int64
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM assignments WHERE user_id = ? AND instructor`, currentUser.ID).Scan(&count); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if count == 0 {
+			loggedHTTPErrorf(w, http.StatusForbidden, "only instructors can request similarity reports")
+			return
+		}
+	}
+	var count int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM problem_steps WHERE problem_id = ? AND step = ?`, problemID, step).Scan(&count); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	now := time.Now()
+	report := &SimilarityReport{
+		ProblemID: problemID,
+		Step:      step,
+		UserID:    currentUser.ID,
+		Status:    similarityRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := meddler.Insert(tx, "similarity_reports", report); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if similarity != nil {
+		similarity.Wake()
+	}
+	render.JSON(http.StatusOK, report)
+}
+
+// GetSimilarityReport handles requests to /v2/similarity_reports/:report_id,
+// returning the given report.
+func GetSimilarityReport(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	reportID, err := parseID(w, "report_id", params["report_id"])
+	if err != nil {
+		return
+	}
+
+	report := new(SimilarityReport)
+	if err := meddler.Load(tx, "similarity_reports", report, reportID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if !currentUser.Admin && report.UserID != currentUser.ID {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const similarityStarter = `import sys
+
+def main():
+    data = sys.stdin.read().split()
+    print(solve(data))
+`
+
+const similarityOriginal = `
+def solve(words):
+    # count each word, then find the most common one
+    counts = {}
+    for word in words:
+        if word in counts:
+            counts[word] += 1
+        else:
+            counts[word] = 1
+    best = None
+    for word in counts:
+        if best is None or counts[word] > counts[best]:
+            best = word
+    return best
+`
+
+// the same code with new names, comments, and spacing
+const similarityCopied = `
+def solve(items):
+    tally = {}
+    for x in items:
+        if x in tally:   tally[x] += 1
+        else:
+            tally[x] = 1
+    # pick the winner
+    top = None
+    for x in tally:
+        if top is None or tally[x] > tally[top]:
+            top = x
+    return top
+`
+
+// a helper that was part of the starter code in an earlier revision
+const similarityHelper = `
+def read_words(path):
+    with open(path) as f:
+        lines = f.readlines()
+    result = []
+    for line in lines:
+        for part in line.strip().split(","):
+            if part:
+                result.append(part.lower())
+    return result
+`
+
+const similarityOther = `
+def solve(words):
+    return max(words, key=words.count) if words else None
+`
+
+const similarityDifferent = `
+def solve(words):
+    return sorted(set(words), key=lambda w: (-words.count(w), w))[0] if words else None
+`
+
+func TestTokenize(t *testing.T) {
+	a := tokenize(python, similarityOriginal)
+	b := tokenize(python, similarityCopied)
+	if len(a) != len(b) {
+		t.Fatalf("renamed code gave %d tokens, original gave %d", len(b), len(a))
+	}
+	for i := range a {
+		if a[i].text != b[i].text {
+			t.Fatalf("token %d: got %q, expected %q", i, b[i].text, a[i].text)
+		}
+	}
+	if a[0].text != "def" || a[0].line != 2 {
+		t.Errorf("first token: got %+v", a[0])
+	}
+
+	// comments and literals in other languages
+	c := tokenize(cLike, "/* header */\nint x = 42; // answer\nchar *s = \"hi // there\";\n")
+	var texts []string
+	for _, tok := range c {
+		texts = append(texts, tok.text)
+	}
+	if got := strings.Join(texts, " "); got != "int V = N ; char * V = S ;" {
+		t.Errorf("C tokens: got %q", got)
+	}
+	ml := tokenize(sml, "(* outer (* inner *) still outer *) val x' = 1")
+	if len(ml) != 4 || ml[0].text != "val" || ml[1].text != "V" {
+		t.Errorf("SML tokens: got %+v", ml)
+	}
+}
+
+func TestCompareSubmissions(t *testing.T) {
+	files := func(body string) map[string][]byte {
+		return map[string][]byte{"main.py": []byte(similarityStarter + body)}
+	}
+	subs := []*submission{
+		newSubmission(&SimilaritySubmission{AssignmentID: 1, UserName: "alice"}, files(similarityOriginal)),
+		newSubmission(&SimilaritySubmission{AssignmentID: 2, UserName: "bob"}, files(similarityCopied)),
+		newSubmission(&SimilaritySubmission{AssignmentID: 3, UserName: "carol"}, files(similarityDifferent)),
+	}
+	pairs := compareSubmissions(subs, []map[string][]byte{{"main.py": []byte(similarityStarter)}})
+	if len(pairs) != 1 {
+		t.Fatalf("expected 1 pair, found %d", len(pairs))
+	}
+	pair := pairs[0]
+	if pair.A.UserName != "alice" || pair.B.UserName != "bob" || pair.Score < 0.9 {
+		t.Errorf("pair: got %s and %s with score %v", pair.A.UserName, pair.B.UserName, pair.Score)
+	}
+	if len(pair.Matches) == 0 {
+		t.Fatalf("no matched regions")
+	}
+
+	// the starter code is not part of the match
+	m := pair.Matches[0]
+	if m.FileA != "main.py" || m.StartLineA <= 6 || !strings.Contains(m.TextA, "counts[word] += 1") || !strings.Contains(m.TextB, "tally[x] += 1") {
+		t.Errorf("match: got %+v", m)
+	}
+}
+
+func TestSimilarityJob(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		now := time.Now().Round(time.Second)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		// the first revision of the starter code included a helper, which the second dropped
+		oldStarter := map[string][]byte{"main.py": []byte(similarityStarter), "words.py": []byte(similarityHelper)}
+		problem := &Problem{Unique: "common-word", Note: "Most common word", Tags: []string{}, Options: []string{}, Revision: 1, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "problems", problem); err != nil {
+			t.Fatalf("inserting problem: %v", err)
+		}
+		step := &ProblemStep{
+			ProblemID:   problem.ID,
+			Step:        1,
+			ProblemType: "python3inout",
+			Files:       oldStarter,
+			Whitelist:   map[string]bool{"main.py": true, "words.py": true},
+		}
+		if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+			t.Fatalf("inserting problem step: %v", err)
+		}
+		if err := publishProblemRevision(tx, problem); err != nil {
+			t.Fatalf("publishProblemRevision: %v", err)
+		}
+		problem.Revision = 2
+		step.Files = map[string][]byte{"main.py": []byte(similarityStarter)}
+		if err := meddler.Update(tx, "problems", problem); err != nil {
+			t.Fatalf("updating problem: %v", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM problem_steps WHERE problem_id = ?`, problem.ID); err != nil {
+			t.Fatalf("deleting problem step: %v", err)
+		}
+		if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+			t.Fatalf("inserting problem step: %v", err)
+		}
+
+		// the instructor's own code is never compared, and carol and dave
+		// are pinned to the first revision, so they share the old helper
+		var instructor *User
+		for i, name := range []string{"instructor", "alice", "bob", "carol", "dave"} {
+			role, body, pinned := "Learner", similarityOriginal, false
+			switch name {
+			case "instructor":
+				role = "Instructor"
+			case "bob":
+				body = similarityCopied
+			case "carol":
+				body, pinned = similarityDifferent, true
+			case "dave":
+				body, pinned = similarityOther, true
+			}
+			user, _, asst := launchTestAssignment(t, tx, testLaunchForm(name, role, int64(i+1)), now, nil)
+			if name == "instructor" {
+				instructor = user
+			}
+			if pinned {
+				if err := pinProblemRevision(tx, asst.ID, problem.ID, 1); err != nil {
+					t.Fatalf("pinProblemRevision: %v", err)
+				}
+			}
+			// carol started out with the same code, but only the last commit counts
+			bodies := []string{body}
+			if name == "carol" {
+				bodies = []string{similarityOriginal, body}
+			}
+			for _, body := range bodies {
+				commit := &Commit{
+					AssignmentID: asst.ID,
+					ProblemID:    problem.ID,
+					Step:         1,
+					Files:        map[string][]byte{"main.py": []byte(similarityStarter + body)},
+					Transcript:   []*EventMessage{},
+					CreatedAt:    now,
+					UpdatedAt:    now,
+				}
+				if pinned {
+					commit.Files["words.py"] = []byte(similarityHelper)
+				}
+				if err := meddler.Insert(tx, "commits", commit); err != nil {
+					t.Fatalf("inserting commit: %v", err)
+				}
+			}
+		}
+		report := &SimilarityReport{ProblemID: problem.ID, Step: 1, UserID: instructor.ID, Status: similarityRunning, CreatedAt: now, UpdatedAt: now}
+		if err := meddler.Insert(tx, "similarity_reports", report); err != nil {
+			t.Fatalf("inserting report: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		jobs := newSimilarityJobs(db, db)
+		if done := jobs.Drain(context.Background()); done != 1 {
+			t.Fatalf("completed %d reports, expected 1", done)
+		}
+		if err := meddler.Load(db, "similarity_reports", report, report.ID); err != nil {
+			t.Fatalf("loading report: %v", err)
+		}
+		if report.Status != similarityDone || report.Submissions != 4 || len(report.Pairs) != 1 {
+			t.Fatalf("report: got status %s with %d submissions and %d pairs: %s", report.Status, report.Submissions, len(report.Pairs), report.Error)
+		}
+		names := fmt.Sprintf("%s/%s", report.Pairs[0].A.UserName, report.Pairs[0].B.UserName)
+		if names != "alice/bob" || len(report.Pairs[0].Matches) == 0 {
+			t.Errorf("pair: got %s with %d matches", names, len(report.Pairs[0].Matches))
+		}
+	})
+}
@@ -432,13 +432,13 @@ func GetAssignmentProblemCommitLast(w http.ResponseWriter, tx *sql.Tx, params ma
 	commit := new(Commit)
 
 	if currentUser.Admin {
-		err = meddler.QueryRow(tx, commit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? ORDER BY step DESC, updated_at DESC LIMIT 1`,
+		err = meddler.QueryRow(tx, commit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? ORDER BY step DESC, updated_at DESC, id DESC LIMIT 1`,
 			assignmentID, problemID)
 	} else {
 		err = meddler.QueryRow(tx, commit, `SELECT commits.* `+
 			`FROM commits JOIN user_assignments ON commits.assignment_id = user_assignments.assignment_id `+
 			`WHERE commits.assignment_id = ? AND problem_id = ? AND user_assignments.user_id = ? `+
-			`ORDER BY step DESC, updated_at DESC LIMIT 1`, assignmentID, problemID, currentUser.ID)
+			`ORDER BY step DESC, updated_at DESC, commits.id DESC LIMIT 1`, assignmentID, problemID, currentUser.ID)
 	}
 
 	if err != nil {
@@ -449,7 +449,7 @@ func GetAssignmentProblemCommitLast(w http.ResponseWriter, tx *sql.Tx, params ma
 	render.JSON(http.StatusOK, commit)
 }
 
-// GetUserAssignmentProblemStepCommitLast handles requests to /v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits/last,
+// GetAssignmentProblemStepCommitLast handles requests to /v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits/last,
 // returning the most recent commit for the given step of the given problem of the given assignment.
 func GetAssignmentProblemStepCommitLast(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
 	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
@@ -468,12 +468,12 @@ func GetAssignmentProblemStepCommitLast(w http.ResponseWriter, tx *sql.Tx, param
 	commit := new(Commit)
 
 	if currentUser.Admin {
-		err = meddler.QueryRow(tx, commit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ? ORDER BY updated_at DESC LIMIT 1`, assignmentID, problemID, step)
+		err = meddler.QueryRow(tx, commit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ? ORDER BY updated_at DESC, id DESC LIMIT 1`, assignmentID, problemID, step)
 	} else {
 		err = meddler.QueryRow(tx, commit, `SELECT commits.* `+
 			`FROM commits JOIN user_assignments ON commits.assignment_id = user_assignments.assignment_id `+
 			`WHERE commits.assignment_id = ? AND problem_id = ? AND step = ? AND user_assignments.user_id = ? `+
-			`ORDER BY updated_at DESC LIMIT 1`,
+			`ORDER BY updated_at DESC, commits.id DESC LIMIT 1`,
 			assignmentID, problemID, step, currentUser.ID)
 	}
 
@@ -485,6 +485,102 @@ func GetAssignmentProblemStepCommitLast(w http.ResponseWriter, tx *sql.Tx, param
 	render.JSON(http.StatusOK, commit)
 }
 
+// GetAssignmentProblemCommits handles requests to /v2/assignments/:assignment_id/problems/:problem_id/commits,
+// returning every saved commit for the given problem of the given assignment, oldest first.
+func GetAssignmentProblemCommits(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+
+	commits := []*Commit{}
+
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &commits, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? ORDER BY step, created_at, id`,
+			assignmentID, problemID)
+	} else {
+		err = meddler.QueryAll(tx, &commits, `SELECT commits.* `+
+			`FROM commits JOIN user_assignments ON commits.assignment_id = user_assignments.assignment_id `+
+			`WHERE commits.assignment_id = ? AND problem_id = ? AND user_assignments.user_id = ? `+
+			`ORDER BY step, created_at, commits.id`, assignmentID, problemID, currentUser.ID)
+	}
+
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, commits)
+}
+
+// GetAssignmentProblemStepCommits handles requests to /v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits,
+// returning every saved commit for the given step of the given problem of the given assignment, oldest first.
+func GetAssignmentProblemStepCommits(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	step, err := parseID(w, "step", params["step"])
+	if err != nil {
+		return
+	}
+
+	commits := []*Commit{}
+
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &commits, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ? ORDER BY created_at, id`,
+			assignmentID, problemID, step)
+	} else {
+		err = meddler.QueryAll(tx, &commits, `SELECT commits.* `+
+			`FROM commits JOIN user_assignments ON commits.assignment_id = user_assignments.assignment_id `+
+			`WHERE commits.assignment_id = ? AND problem_id = ? AND step = ? AND user_assignments.user_id = ? `+
+			`ORDER BY created_at, commits.id`,
+			assignmentID, problemID, step, currentUser.ID)
+	}
+
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, commits)
+}
+
+// GetCommit handles requests to /v2/commits/:commit_id,
+// returning the given commit.
+func GetCommit(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	commitID, err := parseID(w, "commit_id", params["commit_id"])
+	if err != nil {
+		return
+	}
+
+	commit := new(Commit)
+
+	if currentUser.Admin {
+		err = meddler.Load(tx, "commits", commit, commitID)
+	} else {
+		err = meddler.QueryRow(tx, commit, `SELECT commits.* `+
+			`FROM commits JOIN user_assignments ON commits.assignment_id = user_assignments.assignment_id `+
+			`WHERE commits.id = ? AND user_assignments.user_id = ?`,
+			commitID, currentUser.ID)
+	}
+
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	render.JSON(http.StatusOK, commit)
+}
+
 // DeleteCommit handles requests to /v2/commits/:commit_id,
 // deleting the given commit.
 func DeleteCommit(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
@@ -500,7 +596,7 @@ func DeleteCommit(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
 }
 
 // PostCommitBundlesUnsigned handles requests to /v2/commit_bundles/unsigned,
-// saving a new commit (or updating the most recent one), gathering the problem data,
+// saving a new commit in the history for the step, gathering the problem data,
 // signing everything, and returning it in a form ready to send to the daycare.
 func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
 	now := time.Now()
@@ -530,7 +626,7 @@ func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, currentUser *U
 }
 
 // PostCommitBundlesSigned handles requests to /v2/commit_bundles/signed,
-// saving a new commit (or updating the most recent one), gathering the problem data,
+// completing the commit that was saved when the bundle was signed, gathering the problem data,
 // verifying signatures, and posting a grade (if appropriate).
 func PostCommitBundlesSigned(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
 	now := time.Now()
@@ -580,7 +676,8 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	// get the assignment and figure out if this is the student or the instructor
 	isInstructor := false
 	assignment := new(Assignment)
-	err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = ? AND user_id = ?`, commit.AssignmentID, currentUser.ID)
+	// the student's assignment is locked, since its scores may be updated
+	err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = ? AND user_id = ?`+forUpdate(), commit.AssignmentID, currentUser.ID)
 	if err == sql.ErrNoRows {
 		// try loading it as the instructor
 		err = meddler.QueryRow(tx, assignment, `SELECT assignments.* FROM assignments JOIN user_assignments ON assignments.id = user_assignments.assignment_id `+
@@ -607,12 +704,32 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	//     * else accept
 	// * else if the course-wide lock at has passed, reject
 	// * else accept
+	// an extension granted in CodeGrinder replaces these heuristics:
+	// * it applies to the student's deadlines, falling back on the course-wide lock at
+	// * if the extended lock at is in the past, reject
+	// * else accept
+	extension, err := getExtension(tx, assignment)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	dueAt := assignment.DueAt
 	var courseWideLockAt time.Time
 	err = tx.QueryRow(`SELECT lock_at FROM assignments WHERE instructor AND lti_id = ? AND lock_at IS NOT NULL ORDER BY lock_at DESC LIMIT 1`,
 		assignment.LtiID).Scan(&courseWideLockAt)
 	if err != nil && err != sql.ErrNoRows {
 		loggedHTTPDBNotFoundError(w, err)
 		return
+	} else if extension != nil {
+		lockAt := assignment.LockAt
+		if lockAt == nil && err == nil {
+			lockAt = &courseWideLockAt
+		}
+		dueAt, lockAt = extension.Apply(assignment.UnlockAt, dueAt, lockAt)
+		if lockAt != nil && now.After(*lockAt) {
+			loggedHTTPErrorf(w, http.StatusForbidden, "a commit cannot be submitted after the assignment is locked, even with an extension")
+			return
+		}
 	} else if err == nil {
 		// there is a course-wide deadline, should we reject?
 		if (assignment.LockAt != nil && now.After(*assignment.LockAt)) ||
@@ -622,14 +739,14 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		}
 	}
 
-	// get the problem
-	problem := new(Problem)
-	if err = meddler.QueryRow(tx, problem, `SELECT * FROM problems WHERE id = ?`, commit.ProblemID); err != nil {
+	// get the problem as of the revision this assignment is pinned to
+	revision, pinned, err := getAssignmentProblemRevision(tx, assignment.ID, commit.ProblemID)
+	if err != nil {
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
-	steps := []*ProblemStep{}
-	if err = meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, commit.ProblemID); err != nil {
+	problem, steps, err := getProblemRevision(tx, commit.ProblemID, revision)
+	if err != nil {
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
@@ -685,19 +802,22 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		return
 	}
 
-	// update an existing commit if it exists
-	// note: this used to include AND action IS NULL AND updated_at > now.Add(-OpenCommitTimeout)
-	openCommit := new(Commit)
-	if err := meddler.QueryRow(tx, openCommit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ? LIMIT 1`, commit.AssignmentID, commit.ProblemID, commit.Step); err != nil {
-		if err == sql.ErrNoRows {
-			commit.ID = 0
-		} else {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	// commits are never overwritten:
+	// * an unsigned commit is always saved as a new entry in the history
+	// * a signed commit completes the entry that was created when it was unsigned
+	if bundle.CommitSignature == "" {
+		commit.ID = 0
+	} else if commit.ID > 0 {
+		openCommit := new(Commit)
+		if err := meddler.QueryRow(tx, openCommit, `SELECT * FROM commits WHERE id = ? AND assignment_id = ? AND problem_id = ? AND step = ?`,
+			commit.ID, commit.AssignmentID, commit.ProblemID, commit.Step); err != nil {
+			if err == sql.ErrNoRows {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "signed commit %d does not match any saved commit for this step", commit.ID)
+			} else {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			}
 			return
 		}
-	} else {
-		commit.ID = openCommit.ID
-		commit.CreatedAt = openCommit.CreatedAt
 	}
 
 	// sign the problem and the commit
@@ -721,6 +841,29 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		}
 	}
 
+	// record which revision of the problem was graded
+	if bundle.CommitSignature != "" && commit.ReportCard != nil {
+		commit.ReportCard.ProblemRevision = problem.Revision
+	}
+
+	// apply the late policy to a graded commit
+	latePenalty := 0.0
+	if !isInstructor && bundle.CommitSignature != "" && commit.ReportCard != nil {
+		policy, err := getLatePolicy(tx, assignment)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		penalty, explanation := stepLatePenalty(policy, dueAt, assignment, problem.Unique, int(commit.Step-1), commit.ReportCard.ComputeScore(), now)
+		latePenalty = penalty
+		if explanation != "" {
+			if commit.ReportCard.Note != "" {
+				commit.ReportCard.Note += "; "
+			}
+			commit.ReportCard.Note += explanation
+		}
+	}
+
 	// save the commit
 	action := commit.Action
 	if bundle.CommitSignature == "" {
@@ -735,6 +878,14 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 			return
 		}
 
+		// the first commit pins the assignment to the current revision of the problem
+		if !pinned {
+			if err := pinProblemRevision(tx, assignment.ID, problem.ID, problem.Revision); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+		}
+
 		// save an updated timestamp on the assignment if it would otherwise not be updated
 		if commit.ReportCard == nil {
 			assignment.UpdatedAt = now
@@ -775,6 +926,9 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	// save the grade update
 	if !isInstructor && signed.Commit.ReportCard != nil {
 		assignment.SetMinorScore(problem.Unique, int(signed.Commit.Step-1), signed.Commit.ReportCard.ComputeScore())
+		if latePenalty > 0.0 || assignment.LatePenalty(problem.Unique, int(signed.Commit.Step-1)) > 0.0 {
+			assignment.SetLatePenalty(problem.Unique, int(signed.Commit.Step-1), latePenalty)
+		}
 
 		// get the weight of each step in the problem and problem in the set
 		majorWeights, minorWeights, err := GetProblemWeights(tx, assignment)
@@ -834,32 +988,12 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 			}
 		}
 
-		// send grade to the LMS in a goroutine
-		// so we can wrap up the transaction and return to the user
-		go func(asst *Assignment, msg string) {
-			// try up to 10 times before giving up
-			tries := 10
-			minSleepTime := 10 * time.Second
-			maxSleepTime := 5 * time.Minute
-			sleepTime := minSleepTime
-			for i := 0; i < tries; i++ {
-				err := saveGrade(asst, msg)
-				if err == nil {
-					return
-				}
-				log.Printf("error posting grade back to LMS (attempt %d/%d): %v", i+1, tries, err)
-				if i+1 < 10 {
-					log.Printf("  will try again in %v", sleepTime)
-					time.Sleep(sleepTime)
-					sleepTime *= 2
-					if sleepTime > maxSleepTime {
-						sleepTime = maxSleepTime
-					}
-				} else {
-					log.Printf("  giving up")
-				}
-			}
-		}(assignment, report.String())
+		// queue the grade for the LMS; the outbox worker sends it
+		// after the transaction commits
+		if err := queueGrade(tx, assignment, report.String(), now); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
 	}
 
 	note := ""
@@ -887,6 +1021,8 @@ type StepWeight struct {
 	MinorWeight float64 `meddler:"minor_weight"`
 }
 
+// GetProblemWeights returns the weight of each problem in an assignment's problem set
+// and of each step in those problems, using the revision each problem is pinned to.
 func GetProblemWeights(tx *sql.Tx, assignment *Assignment) (majorWeights map[string]float64, minorWeights map[string][]float64, err error) {
 	weights := []*StepWeight{}
 	if err := meddler.QueryAll(tx, &weights, `SELECT problems.unique_id AS major_key, problem_set_problems.weight AS major_weight, problem_steps.step AS minor_key, problem_steps.weight AS minor_weight `+
@@ -899,6 +1035,31 @@ func GetProblemWeights(tx *sql.Tx, assignment *Assignment) (majorWeights map[str
 	if len(weights) == 0 {
 		return nil, nil, fmt.Errorf("no problem step weights found, unable to compute score")
 	}
+
+	// problems pinned to an older revision use the step weights from that revision
+	pinned := []*StepWeight{}
+	if err := meddler.QueryAll(tx, &pinned, `SELECT problems.unique_id AS major_key, problem_set_problems.weight AS major_weight, problem_step_revisions.step AS minor_key, problem_step_revisions.weight AS minor_weight `+
+		`FROM assignment_problem_revisions JOIN problems ON assignment_problem_revisions.problem_id = problems.id `+
+		`JOIN problem_set_problems ON problem_set_problems.problem_id = problems.id `+
+		`JOIN problem_step_revisions ON problem_step_revisions.problem_id = problems.id AND problem_step_revisions.revision = assignment_problem_revisions.revision `+
+		`WHERE assignment_problem_revisions.assignment_id = ? AND problem_set_problems.problem_set_id = ? AND assignment_problem_revisions.revision <> problems.revision `+
+		`ORDER BY unique_id, step`, assignment.ID, assignment.ProblemSetID); err != nil {
+		return nil, nil, fmt.Errorf("db error: %v", err)
+	}
+	if len(pinned) > 0 {
+		old := make(map[string]bool)
+		for _, elt := range pinned {
+			old[elt.MajorKey] = true
+		}
+		current := weights
+		weights = pinned
+		for _, elt := range current {
+			if !old[elt.MajorKey] {
+				weights = append(weights, elt)
+			}
+		}
+	}
+
 	majorWeights = make(map[string]float64)
 	minorWeights = make(map[string][]float64)
 	for _, elt := range weights {
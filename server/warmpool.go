@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// idle containers older than this are replaced with fresh ones; a warm
+	// container sleeps this much longer than its time limit, so it is killed
+	// by a timer once its real time limit has passed after it is put to work
+	warmMaxIdle = 5 * time.Minute
+
+	// profiles that have not been used for this long are no longer kept warm
+	warmProfileTTL = time.Hour
+
+	// how often the pool replaces old containers and drops unused profiles
+	warmMaintenanceInterval = time.Minute
+)
+
+var warmRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "codegrinder",
+	Subsystem: "daycare",
+	Name:      "warm_pool_requests_total",
+	Help:      "Requests for a container from the warm pool, by whether one was ready.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(warmRequests, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "codegrinder",
+		Subsystem: "daycare",
+		Name:      "warm_containers",
+		Help:      "Number of idle containers waiting in the warm pool.",
+	}, func() float64 { return float64(len(warmContainers.IDs())) }))
+}
+
+// warmProfile identifies containers that are interchangeable:
+// the same image, limits, and time limit.
+type warmProfile struct {
+	problemType string
+	image       string
+	limits      limits
+	timeLimit   int64
+}
+
+// warmProfileState holds the idle containers for one profile.
+type warmProfileState struct {
+	idle     []*warmSandbox
+	filling  int
+	lastUsed time.Time
+}
+
+// warmSandbox is an idle container waiting in the pool.
+type warmSandbox struct {
+	sandbox Sandbox
+	uid     int64
+	created time.Time
+}
+
+// claimedSandbox is a warm container that has been put to work.
+// Its timer kills it when the time limit of the action runs out.
+type claimedSandbox struct {
+	Sandbox
+	timer *time.Timer
+}
+
+func (s *claimedSandbox) Remove() error {
+	s.timer.Stop()
+	return s.Sandbox.Remove()
+}
+
+// warmPool keeps idle, network-disabled containers ready for each problem type
+// and limits profile so an action does not have to wait for one to start.
+// Profiles are learned from the actions the daycare runs. Each container is used
+// once and destroyed like any other; the pool refills in the background.
+// Every warm container holds a slot in the admission queue, so the pool only
+// grows into capacity that running actions are not using.
+type warmPool struct {
+	sync.Mutex
+	rt       SandboxRuntime
+	owner    string
+	queue    *admissionQueue
+	sizes    map[string]int
+	profiles map[warmProfile]*warmProfileState
+	next     int
+	closed   bool
+}
+
+// warmContainers is the pool for the daycare role, or nil if it is turned off
+var warmContainers *warmPool
+
+// newWarmPool creates a pool that keeps sizes[problemType] idle containers
+// ready for each profile of that problem type, as far as queue has room.
+func newWarmPool(rt SandboxRuntime, owner string, queue *admissionQueue, sizes map[string]int) *warmPool {
+	return &warmPool{
+		rt:       rt,
+		owner:    owner,
+		queue:    queue,
+		sizes:    sizes,
+		profiles: make(map[warmProfile]*warmProfileState),
+	}
+}
+
+// take hands over an idle container that matches spec, along with the UID
+// it runs as. It returns false if there is none ready, in which case the caller
+// should create its own, and an idle container for another profile is destroyed
+// if needed to make room. Either way, the pool refills in the background.
+// The caller must hold an admission slot.
+// Containers that need extra environment variables are never pooled.
+func (p *warmPool) take(problemType string, spec *SandboxSpec) (Sandbox, int64, bool) {
+	if p == nil || p.sizes[problemType] <= 0 || len(spec.Env) > 0 {
+		return nil, 0, false
+	}
+	key := warmProfile{problemType: problemType, image: spec.Image, limits: *spec.Limits, timeLimit: spec.TimeLimit}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return nil, 0, false
+	}
+	state := p.profiles[key]
+	if state == nil {
+		state = new(warmProfileState)
+		p.profiles[key] = state
+	}
+	state.lastUsed = time.Now()
+	go p.fill(key)
+
+	// use the newest one
+	if len(state.idle) == 0 {
+		warmRequests.WithLabelValues("miss").Inc()
+		if p.queue.overcommitted() {
+			if elt := p.evict(); elt != nil {
+				go p.destroy(elt)
+			}
+		}
+		return nil, 0, false
+	}
+	elt := state.idle[len(state.idle)-1]
+	state.idle = state.idle[:len(state.idle)-1]
+	warmRequests.WithLabelValues("hit").Inc()
+
+	// the container now runs in the caller's slot
+	p.queue.releaseWarm()
+	timeLimit := time.Duration(key.timeLimit) * time.Second
+	timer := time.AfterFunc(timeLimit, func() {
+		if err := elt.sandbox.Kill(); err != nil {
+			log.Printf("error killing warm container %s at its time limit: %v", elt.sandbox.ID(), err)
+		}
+	})
+	return &claimedSandbox{Sandbox: elt.sandbox, timer: timer}, elt.uid, true
+}
+
+// evict removes the oldest idle container from the pool, or returns nil if
+// there is none. The caller must hold the lock, and must destroy it.
+func (p *warmPool) evict() *warmSandbox {
+	var oldest *warmProfileState
+	for _, state := range p.profiles {
+		if len(state.idle) > 0 && (oldest == nil || state.idle[0].created.Before(oldest.idle[0].created)) {
+			oldest = state
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	elt := oldest.idle[0]
+	oldest.idle = oldest.idle[1:]
+	return elt
+}
+
+// fill creates containers until the profile has its full share.
+// It gives up on the first error and tries again on the next take or maintenance pass.
+func (p *warmPool) fill(key warmProfile) {
+	for {
+		p.Lock()
+		state := p.profiles[key]
+		if p.closed || state == nil || len(state.idle)+state.filling >= p.sizes[key.problemType] || !p.queue.reserveWarm() {
+			p.Unlock()
+			return
+		}
+		state.filling++
+		p.next++
+		name := fmt.Sprintf("warm-%d", p.next)
+		p.Unlock()
+
+		elt, err := p.create(key, name)
+
+		p.Lock()
+		state.filling--
+		if err != nil {
+			p.Unlock()
+			p.queue.releaseWarm()
+			log.Printf("error creating warm container for %s: %v", key.problemType, err)
+			return
+		}
+
+		// give up the slot if an action needed it while the container started
+		if p.closed || p.profiles[key] != state || p.queue.overcommitted() {
+			p.Unlock()
+			p.destroy(elt)
+			return
+		}
+		state.idle = append(state.idle, elt)
+		p.Unlock()
+	}
+}
+
+// create starts one container for the pool.
+func (p *warmPool) create(key warmProfile, name string) (*warmSandbox, error) {
+	uid, err := allocUID()
+	if err != nil {
+		return nil, err
+	}
+	l := key.limits
+	spec := &SandboxSpec{
+		Name:      name,
+		Owner:     p.owner,
+		Image:     key.image,
+		UID:       uid,
+		Limits:    &l,
+		TimeLimit: key.timeLimit + int64(warmMaxIdle/time.Second),
+	}
+	sandbox, err := p.rt.Create(spec)
+	if err != nil {
+		releaseUID(uid)
+		return nil, err
+	}
+	return &warmSandbox{sandbox: sandbox, uid: uid, created: time.Now()}, nil
+}
+
+// destroy removes a container that will not be used and gives up its slot.
+func (p *warmPool) destroy(elt *warmSandbox) {
+	if err := elt.sandbox.Remove(); err != nil {
+		log.Printf("error removing warm container %s: %v", elt.sandbox.ID(), err)
+	}
+	releaseUID(elt.uid)
+	p.queue.releaseWarm()
+}
+
+// maintain replaces containers that have been idle too long, stops keeping
+// profiles that are no longer used, and refills the rest.
+func (p *warmPool) maintain(now time.Time) {
+	var stale []*warmSandbox
+	var keys []warmProfile
+	p.Lock()
+	for key, state := range p.profiles {
+		if now.Sub(state.lastUsed) > warmProfileTTL {
+			stale = append(stale, state.idle...)
+			delete(p.profiles, key)
+			continue
+		}
+		fresh := state.idle[:0]
+		for _, elt := range state.idle {
+			if now.Sub(elt.created) > warmMaxIdle {
+				stale = append(stale, elt)
+			} else {
+				fresh = append(fresh, elt)
+			}
+		}
+		state.idle = fresh
+		keys = append(keys, key)
+	}
+	p.Unlock()
+
+	for _, elt := range stale {
+		p.destroy(elt)
+	}
+	for _, key := range keys {
+		go p.fill(key)
+	}
+}
+
+// Run maintains the pool until it is closed.
+func (p *warmPool) Run() {
+	for {
+		time.Sleep(warmMaintenanceInterval)
+		p.Lock()
+		closed := p.closed
+		p.Unlock()
+		if closed {
+			return
+		}
+		p.maintain(time.Now())
+	}
+}
+
+// IDs lists the idle containers, so they are not mistaken for orphans.
+func (p *warmPool) IDs() []string {
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	var ids []string
+	for _, state := range p.profiles {
+		for _, elt := range state.idle {
+			ids = append(ids, elt.sandbox.ID())
+		}
+	}
+	return ids
+}
+
+// Close destroys the idle containers and stops the pool from making more.
+// It returns the number of containers destroyed.
+func (p *warmPool) Close() int {
+	if p == nil {
+		return 0
+	}
+	var idle []*warmSandbox
+	p.Lock()
+	p.closed = true
+	for _, state := range p.profiles {
+		idle = append(idle, state.idle...)
+	}
+	p.profiles = make(map[warmProfile]*warmProfileState)
+	p.Unlock()
+
+	for _, elt := range idle {
+		p.destroy(elt)
+	}
+	return len(idle)
+}
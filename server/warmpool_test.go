@@ -0,0 +1,215 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWarmPool(t *testing.T) {
+	const owner = "daycare.example.com"
+	uidsMutex.Lock()
+	uidsInUse, orphanUIDs = make(map[int64]bool), make(map[int64]bool)
+	uidsMutex.Unlock()
+	liveNannies.Lock()
+	liveNannies.nannies = make(map[*Nanny]bool)
+	liveNannies.Unlock()
+
+	rt := newFakeRuntime(nil)
+	queue := newAdmissionQueue(10, 0)
+	pool := newWarmPool(rt, owner, queue, map[string]int{"python3unittest": 2})
+	warmContainers = pool
+	defer func() { warmContainers = nil }()
+
+	spec := func() *SandboxSpec {
+		return &SandboxSpec{
+			Name:      "nanny-test",
+			Owner:     owner,
+			Image:     "codegrinder/python3",
+			Limits:    &limits{maxCPU: 10, maxMemory: 128},
+			TimeLimit: 20,
+		}
+	}
+	waitFor := func(n int) {
+		for i := 0; i < 200 && len(pool.IDs()) != n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(pool.IDs()); got != n {
+			t.Fatalf("expected %d warm containers, found %d", n, got)
+		}
+		if _, _, _, warm := queueCounts(queue); warm != n {
+			t.Fatalf("expected %d warm containers to hold slots, found %d", n, warm)
+		}
+	}
+
+	// the first request for a profile misses and starts filling the pool
+	if _, _, warm := pool.take("python3unittest", spec()); warm {
+		t.Errorf("first take: expected a miss")
+	}
+	waitFor(2)
+
+	// problem types without a pool, and containers with extra environment, are not served
+	if _, _, warm := pool.take("cpp", spec()); warm {
+		t.Errorf("take for a problem type with no pool: expected a miss")
+	}
+	withEnv := spec()
+	withEnv.Env = []string{"SECRET=1"}
+	if _, _, warm := pool.take("python3unittest", withEnv); warm {
+		t.Errorf("take with environment variables: expected a miss")
+	}
+	different := spec()
+	different.Limits = &limits{maxCPU: 30, maxMemory: 128}
+	if _, _, warm := pool.take("python3unittest", different); warm {
+		t.Errorf("take with different limits: expected a miss")
+	}
+
+	// the next one hits, and the pool refills
+	sandbox, uid, warm := pool.take("python3unittest", spec())
+	if !warm {
+		t.Fatalf("second take: expected a hit")
+	}
+	s := sandbox.(*claimedSandbox).Sandbox.(*fakeSandbox)
+	if s.spec.UID != uid || s.spec.Owner != owner || s.spec.Image != "codegrinder/python3" || s.spec.Limits.maxCPU != 10 ||
+		s.spec.TimeLimit != 20+int64(warmMaxIdle/time.Second) {
+		t.Errorf("warm container has spec %+v, uid %d", s.spec, uid)
+	}
+	uidsMutex.Lock()
+	held := uidsInUse[uid]
+	uidsMutex.Unlock()
+	if !held {
+		t.Errorf("the UID of a warm container should stay allocated")
+	}
+	waitFor(4)
+
+	// idle containers are not orphans; only the one taken, which has no nanny, is removed
+	if report, err := reconcileSandboxes(rt, owner, time.Now().Add(time.Hour)); err != nil || report.Removed != 1 {
+		t.Errorf("reconcile: got %+v, err %v", report, err)
+	}
+	waitFor(4)
+
+	// old containers are replaced
+	before := pool.IDs()
+	pool.maintain(time.Now().Add(warmMaxIdle + time.Minute))
+	waitFor(4)
+	for _, id := range before {
+		for _, elt := range pool.IDs() {
+			if id == elt {
+				t.Errorf("container %s should have been replaced", id)
+			}
+		}
+	}
+
+	// profiles that are no longer used are dropped
+	pool.maintain(time.Now().Add(warmProfileTTL + time.Minute))
+	waitFor(0)
+
+	// closing the pool removes what is left and stops it filling
+	pool.take("python3unittest", spec())
+	waitFor(2)
+	if n := pool.Close(); n != 2 {
+		t.Errorf("Close: removed %d containers, expected 2", n)
+	}
+	if _, _, warm := pool.take("python3unittest", spec()); warm {
+		t.Errorf("take after Close: expected a miss")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if list, _ := rt.List(owner); len(list) != 0 {
+		t.Errorf("after Close: %d containers left", len(list))
+	}
+	uidsMutex.Lock()
+	left := len(uidsInUse)
+	uidsMutex.Unlock()
+	if left != 1 {
+		t.Errorf("after Close: %d UIDs still allocated, expected only the one taken", left)
+	}
+}
+
+func TestWarmPoolCapacity(t *testing.T) {
+	const owner = "daycare.example.com"
+	uidsMutex.Lock()
+	uidsInUse, orphanUIDs = make(map[int64]bool), make(map[int64]bool)
+	uidsMutex.Unlock()
+
+	rt := newFakeRuntime(nil)
+	queue := newAdmissionQueue(2, 0)
+	pool := newWarmPool(rt, owner, queue, map[string]int{"python3unittest": 3})
+	defer pool.Close()
+
+	spec := func(maxCPU int64) *SandboxSpec {
+		return &SandboxSpec{
+			Name:      "nanny-test",
+			Owner:     owner,
+			Image:     "codegrinder/python3",
+			Limits:    &limits{maxCPU: maxCPU, maxMemory: 128},
+			TimeLimit: 1,
+		}
+	}
+	waitFor := func(n int) {
+		for i := 0; i < 200 && len(pool.IDs()) != n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if got := len(pool.IDs()); got != n {
+			t.Fatalf("expected %d warm containers, found %d", n, got)
+		}
+		if _, _, _, warm := queueCounts(queue); warm != n {
+			t.Fatalf("expected %d warm containers to hold slots, found %d", n, warm)
+		}
+	}
+
+	// the pool only fills the slots that are free
+	pool.take("python3unittest", spec(10))
+	waitFor(2)
+
+	// an action that cannot use a warm container has one destroyed to make room
+	first, err := queue.enter()
+	if err != nil {
+		t.Fatalf("enter: %v", err)
+	}
+	if _, _, warm := pool.take("python3unittest", spec(30)); warm {
+		t.Errorf("take with different limits: expected a miss")
+	}
+	waitFor(1)
+
+	// an action that takes one over leaves no room to refill
+	second, err := queue.enter()
+	if err != nil {
+		t.Fatalf("enter: %v", err)
+	}
+	sandbox, _, warm := pool.take("python3unittest", spec(10))
+	if !warm {
+		t.Fatalf("take: expected a hit")
+	}
+	waitFor(0)
+	if slots, running, _, _ := queueCounts(queue); running != slots {
+		t.Errorf("expected %d running, found %d", slots, running)
+	}
+
+	// the container it took is killed when the time limit of the action runs out
+	s := sandbox.(*claimedSandbox).Sandbox.(*fakeSandbox)
+	if _, err := s.Exec([]string{"true"}, nil, ioutil.Discard, ioutil.Discard, false); err != nil {
+		t.Errorf("exec before the time limit: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := s.Exec([]string{"true"}, nil, ioutil.Discard, ioutil.Discard, false); err == nil {
+		t.Errorf("exec after the time limit: expected an error")
+	}
+	if _, err := s.GetFiles(); err == nil {
+		t.Errorf("GetFiles after the time limit: expected an error")
+	}
+	sandbox.Remove()
+
+	// the pool refills once the actions are finished
+	queue.leave(first)
+	queue.leave(second)
+	pool.maintain(time.Now())
+	waitFor(2)
+}
+
+// queueCounts gives the slots, running requests, waiting requests,
+// and warm containers of an admission queue.
+func queueCounts(q *admissionQueue) (slots, running, waiting, warm int) {
+	q.Lock()
+	defer q.Unlock()
+	return q.slots, q.running, len(q.waiting), q.warm
+}
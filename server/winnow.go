@@ -0,0 +1,377 @@
+package main
+
+import (
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Submissions are compared using winnowing, as in MOSS:
+// source files are reduced to a stream of tokens that ignores
+// comments, whitespace, and the names chosen for identifiers,
+// each run of similarityK tokens is hashed, and the smallest hash
+// in every window of similarityWindow hashes is kept as a fingerprint.
+// Any shared run of at least similarityK+similarityWindow-1 tokens
+// is guaranteed to produce a shared fingerprint.
+
+const (
+	similarityK      = 8
+	similarityWindow = 4
+)
+
+// token is one lexical unit of a source file, normalized so that
+// renaming variables or changing literals does not change it.
+type token struct {
+	text string
+	line int
+}
+
+// language describes enough of a programming language's lexical
+// structure to tokenize it.
+type language struct {
+	lineComments  []string
+	blockComments [][2]string
+	nestedBlocks  bool
+	quotes        string
+	tripleQuotes  bool
+	wordChars     string
+	foldCase      bool
+	keepWords     bool
+	spaceWords    bool
+	keywords      map[string]bool
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+var (
+	cLike = &language{
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'` + "`",
+		keywords: wordSet(`auto break case char class const continue default delete do double else enum
+			extern float for goto if inline int long namespace new private protected public register
+			return short signed sizeof static struct switch template this throw try typedef union
+			unsigned using virtual void volatile while bool true false nullptr std cout cin endl
+			chan defer fallthrough func go import interface map package range select type var nil
+			append len make cap string byte rune error
+			as crate dyn fn impl in let loop match mod move mut pub ref self Self trait unsafe where
+			usize isize u8 u16 u32 u64 i8 i16 i32 i64 f32 f64 str Vec Option Some None Ok Err String
+			field method function constructor var static let class do return if else while
+			IN OUT PARTS CHIP Nand Not And Or Mux DMux CLOCKED BUILTIN push pop add sub neg eq gt lt
+			label goto call function`),
+	}
+	python = &language{
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		tripleQuotes: true,
+		keywords: wordSet(`and as assert async await break class continue def del elif else except
+			False finally for from global if import in is lambda None nonlocal not or pass raise
+			return True try while with yield print len range int float str list dict set tuple
+			input open self super enumerate zip sorted append`),
+	}
+	sqlLang = &language{
+		lineComments:  []string{"--"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `'"`,
+		foldCase:      true,
+		keywords: wordSet(`select from where group by having order asc desc limit offset join inner
+			left right outer cross natural on using as and or not in is null like between exists
+			distinct all union intersect except insert into values update set delete create table
+			view index drop alter primary key foreign references unique check default with case
+			when then else end count sum avg min max cast integer text real`),
+	}
+	sml = &language{
+		blockComments: [][2]string{{"(*", "*)"}},
+		nestedBlocks:  true,
+		quotes:        `"`,
+		wordChars:     "'",
+		keywords: wordSet(`abstype and andalso as case datatype do else end exception fn fun handle
+			if in infix infixr let local nonfix of op open orelse raise rec sharing sig signature
+			struct structure then type val where while withtype nil true false ref
+			int real string char bool list option SOME NONE`),
+	}
+	prolog = &language{
+		lineComments:  []string{"%"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+		keywords:      wordSet(`is not true fail call findall bagof setof assert asserta assertz retract format write nl member append length`),
+	}
+	lisp = &language{
+		lineComments:  []string{";"},
+		blockComments: [][2]string{{"#|", "|#"}},
+		nestedBlocks:  true,
+		quotes:        `"`,
+		wordChars:     "-?!*<>=/+",
+		keywords: wordSet(`define lambda let let* letrec if cond else and or when unless begin set!
+			quote car cdr cons list null? empty? first rest map filter foldl foldr apply
+			define-struct struct require provide`),
+	}
+	forth = &language{
+		lineComments:  []string{`\ `, "\\\n"},
+		blockComments: [][2]string{{"( ", ")"}},
+		foldCase:      true,
+		spaceWords:    true,
+	}
+	assembly = &language{
+		lineComments:  []string{"@", "//", ";"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+		wordChars:     ".",
+		foldCase:      true,
+		keepWords:     true,
+	}
+	plainText = &language{
+		keepWords: true,
+	}
+)
+
+// languageFor picks a language based on a file's extension.
+func languageFor(name string) *language {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".c", ".h", ".cc", ".cpp", ".cxx", ".hpp", ".hh", ".go", ".rs", ".java", ".js", ".ts", ".jack", ".hdl", ".vm", ".tst":
+		return cLike
+	case ".py":
+		return python
+	case ".sql":
+		return sqlLang
+	case ".sml", ".sig", ".fun":
+		return sml
+	case ".pl", ".pro":
+		return prolog
+	case ".rkt", ".scm", ".ss", ".lisp", ".el":
+		return lisp
+	case ".fs", ".fth", ".4th", ".forth":
+		return forth
+	case ".s", ".asm":
+		return assembly
+	}
+	return plainText
+}
+
+// tokenize breaks source code into normalized tokens.
+// Comments and whitespace are dropped, string and number literals are
+// replaced by placeholders, and identifiers other than keywords become
+// a single placeholder so that renaming does not hide copying.
+func tokenize(lang *language, src string) []token {
+	var tokens []token
+	line := 1
+	i := 0
+
+	// advance moves past n bytes, counting lines
+	advance := func(n int) {
+		if i+n > len(src) {
+			n = len(src) - i
+		}
+		line += strings.Count(src[i:i+n], "\n")
+		i += n
+	}
+	isWordByte := func(c byte, first bool) bool {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+			return true
+		case c >= '0' && c <= '9':
+			return !first
+		}
+		return strings.IndexByte(lang.wordChars, c) >= 0
+	}
+
+outer:
+	for i < len(src) {
+		c := src[i]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v' {
+			advance(1)
+			continue
+		}
+
+		// comments
+		for _, prefix := range lang.lineComments {
+			if strings.HasPrefix(src[i:], prefix) {
+				end := strings.IndexByte(src[i:], '\n')
+				if end < 0 {
+					end = len(src) - i
+				}
+				advance(end)
+				continue outer
+			}
+		}
+		for _, pair := range lang.blockComments {
+			if strings.HasPrefix(src[i:], pair[0]) {
+				depth := 0
+				for i < len(src) {
+					if strings.HasPrefix(src[i:], pair[0]) && (depth == 0 || lang.nestedBlocks) {
+						depth++
+						advance(len(pair[0]))
+					} else if strings.HasPrefix(src[i:], pair[1]) {
+						depth--
+						advance(len(pair[1]))
+						if depth == 0 {
+							break
+						}
+					} else {
+						advance(1)
+					}
+				}
+				continue outer
+			}
+		}
+
+		start := line
+		switch {
+		case lang.spaceWords:
+			end := strings.IndexAny(src[i:], " \t\r\n\f\v")
+			if end < 0 {
+				end = len(src) - i
+			}
+			word := src[i : i+end]
+			advance(end)
+			if isNumber(word) {
+				word = "N"
+			} else if lang.foldCase {
+				word = strings.ToLower(word)
+			}
+			tokens = append(tokens, token{text: word, line: start})
+
+		case strings.IndexByte(lang.quotes, c) >= 0:
+			if lang.tripleQuotes && strings.HasPrefix(src[i:], strings.Repeat(string(c), 3)) {
+				delim := strings.Repeat(string(c), 3)
+				end := strings.Index(src[i+3:], delim)
+				if end < 0 {
+					end = len(src) - i - 3
+				}
+				advance(3 + end + 3)
+			} else {
+				j := i + 1
+				for j < len(src) && src[j] != c && src[j] != '\n' {
+					if src[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				advance(j + 1 - i)
+			}
+			tokens = append(tokens, token{text: "S", line: start})
+
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (isWordByte(src[j], false) || src[j] == '.') {
+				j++
+			}
+			advance(j - i)
+			tokens = append(tokens, token{text: "N", line: start})
+
+		case isWordByte(c, true):
+			j := i
+			for j < len(src) && isWordByte(src[j], false) {
+				j++
+			}
+			word := src[i:j]
+			advance(j - i)
+			if lang.foldCase {
+				word = strings.ToLower(word)
+			}
+			if !lang.keepWords && !lang.keywords[word] {
+				word = "V"
+			}
+			tokens = append(tokens, token{text: word, line: start})
+
+		default:
+			advance(1)
+			tokens = append(tokens, token{text: string(c), line: start})
+		}
+	}
+	return tokens
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && !(i == 0 && s[i] == '-' && len(s) > 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// fingerprint is a selected k-gram hash and the index of the first token it covers.
+type fingerprint struct {
+	hash uint64
+	pos  int
+}
+
+// winnow selects fingerprints from a token stream.
+func winnow(tokens []token) []fingerprint {
+	if len(tokens) < similarityK {
+		return nil
+	}
+
+	// hash every k-gram
+	hashes := make([]uint64, len(tokens)-similarityK+1)
+	for i := range hashes {
+		h := fnv.New64a()
+		for _, tok := range tokens[i : i+similarityK] {
+			h.Write([]byte(tok.text))
+			h.Write([]byte{0})
+		}
+		hashes[i] = h.Sum64()
+	}
+
+	// keep the rightmost minimum hash in each window
+	var prints []fingerprint
+	last := -1
+	window := similarityWindow
+	if window > len(hashes) {
+		window = len(hashes)
+	}
+	for start := 0; start+window <= len(hashes); start++ {
+		min := start
+		for j := start + 1; j < start+window; j++ {
+			if hashes[j] <= hashes[min] {
+				min = j
+			}
+		}
+		if min != last {
+			prints = append(prints, fingerprint{hash: hashes[min], pos: min})
+			last = min
+		}
+	}
+	return prints
+}
+
+// sourceFile is one tokenized file of a submission.
+type sourceFile struct {
+	name   string
+	lines  []string
+	tokens []token
+	prints []fingerprint
+}
+
+// tokenizeFiles tokenizes and fingerprints a set of files in name order.
+func tokenizeFiles(files map[string][]byte) []*sourceFile {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []*sourceFile
+	for _, name := range names {
+		src := string(files[name])
+		tokens := tokenize(languageFor(name), src)
+		out = append(out, &sourceFile{
+			name:   name,
+			lines:  strings.Split(src, "\n"),
+			tokens: tokens,
+			prints: winnow(tokens),
+		})
+	}
+	return out
+}
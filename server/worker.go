@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// worker runs a background job for the TA: it calls drain when it starts,
+// whenever it is woken, and every poll interval, until it is stopped.
+// The grade outbox and similarity reports each embed one.
+type worker struct {
+	poll  time.Duration
+	drain func(ctx context.Context)
+	wake  chan struct{}
+
+	// ctx is cancelled to ask Run to return, so a long drain can quit early,
+	// and Run closes stopped when it does
+	ctx     context.Context
+	stop    context.CancelFunc
+	stopped chan struct{}
+}
+
+func newWorker(poll time.Duration, drain func(ctx context.Context)) *worker {
+	ctx, stop := context.WithCancel(context.Background())
+	return &worker{
+		poll:    poll,
+		drain:   drain,
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		stop:    stop,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Wake asks the worker to drain now instead of waiting for its next poll.
+func (w *worker) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains repeatedly until Stop is called.
+func (w *worker) Run() {
+	defer close(w.stopped)
+	for {
+		w.drain(w.ctx)
+		select {
+		case <-w.wake:
+		case <-w.ctx.Done():
+			return
+		case <-time.After(w.poll):
+		}
+	}
+}
+
+// Stop cancels the drain in progress, if any, and waits for Run to return.
+// It reports false if ctx is done first.
+func (w *worker) Stop(ctx context.Context) bool {
+	w.stop()
+	select {
+	case <-w.stopped:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
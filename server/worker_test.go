@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorker(t *testing.T) {
+	drained := make(chan struct{}, 10)
+	w := newWorker(time.Hour, func(ctx context.Context) { drained <- struct{}{} })
+	go w.Run()
+
+	// it drains once at the start and again each time it is woken
+	for i := 0; i < 3; i++ {
+		select {
+		case <-drained:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("drain %d did not happen", i+1)
+		}
+		w.Wake()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !w.Stop(ctx) {
+		t.Fatalf("Run did not return after Stop")
+	}
+	if w.ctx.Err() == nil {
+		t.Errorf("drain context not cancelled after Stop")
+	}
+}
+
+func TestWorkerStopDeadline(t *testing.T) {
+	// a drain that ignores cancellation holds up Stop only until the deadline
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	w := newWorker(time.Hour, func(ctx context.Context) {
+		close(started)
+		<-release
+	})
+	go w.Run()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if w.Stop(ctx) {
+		t.Errorf("Stop returned true while a drain was still running")
+	}
+}
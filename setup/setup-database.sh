@@ -6,6 +6,22 @@ if [ -z "$CODEGRINDERROOT"]; then
     CODEGRINDERROOT="$HOME"/codegrinder
 fi
 
+if [ "$1" = "postgres" ]; then
+    # usage: setup-database.sh postgres postgres://user@host/dbname
+    # the URL must match postgresURL in the config file
+    if [ -z "$2" ]; then
+        echo "usage: $0 postgres <connection URL>"
+        exit 1
+    fi
+
+    echo Creating database tables
+    codegrinder -migrate
+
+    echo Creating problem types
+    psql --set ON_ERROR_STOP=1 "$2" < "$CODEGRINDERROOT"/setup/problemtypes.sql
+    exit 0
+fi
+
 DBFILE="$CODEGRINDERROOT"/db/codegrinder.db
 
 if [ ! -f "$HOME"/.sqliterc ]; then
@@ -19,7 +35,8 @@ echo Deleting old database if it exists
 rm -f "$DBFILE"
 
 echo Creating database tables
-sqlite3 "$DBFILE" < "$CODEGRINDERROOT"/setup/schema.sql
+touch "$DBFILE"
+codegrinder -migrate
 
 echo Creating problem types
 sqlite3 "$DBFILE" < "$CODEGRINDERROOT"/setup/problemtypes.sql
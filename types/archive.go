@@ -0,0 +1,73 @@
+package types
+
+import "time"
+
+// ArchiveVersion is the version of the archive format written by this release.
+const ArchiveVersion = 1
+
+// ArchiveManifestName is the name of the manifest inside a problem archive.
+// The rest of the archive holds the step files and solutions as
+//
+//	problems/<unique>/<step>/files/<name>
+//	problems/<unique>/<step>/solution/<name>
+const ArchiveManifestName = "manifest.json"
+
+// ArchiveManifest describes the problems and problem sets in a portable archive
+// used to share problems between installations.
+// IDs, signatures, and timestamps are local to an installation and are not included.
+type ArchiveManifest struct {
+	Version     int                  `json:"version"`
+	Hostname    string               `json:"hostname"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	Problems    []*ArchiveProblem    `json:"problems"`
+	ProblemSets []*ArchiveProblemSet `json:"problemSets"`
+}
+
+// ArchiveProblem is a problem in an archive.
+// ProblemTypes lists the problem types an installation must have to import it.
+type ArchiveProblem struct {
+	Unique       string         `json:"unique"`
+	Note         string         `json:"note"`
+	Tags         []string       `json:"tags"`
+	Options      []string       `json:"options"`
+	ProblemTypes []string       `json:"problemTypes"`
+	Steps        []*ArchiveStep `json:"steps"`
+}
+
+// ArchiveStep is a problem step in an archive.
+// Its files and solution are stored alongside the manifest.
+type ArchiveStep struct {
+	Step        int64    `json:"step"`
+	ProblemType string   `json:"problemType"`
+	Note        string   `json:"note"`
+	Weight      float64  `json:"weight"`
+	Whitelist   []string `json:"whitelist"`
+}
+
+// ArchiveProblemSet is a problem set in an archive.
+// Its problems are identified by their unique IDs.
+type ArchiveProblemSet struct {
+	Unique     string                      `json:"unique"`
+	Note       string                      `json:"note"`
+	Tags       []string                    `json:"tags"`
+	LatePolicy *LatePolicy                 `json:"latePolicy,omitempty"`
+	Problems   []*ArchiveProblemSetProblem `json:"problems"`
+}
+
+// ArchiveProblemSetProblem is a reference to a problem from a problem set in an archive.
+type ArchiveProblemSetProblem struct {
+	Unique string  `json:"unique"`
+	Weight float64 `json:"weight"`
+}
+
+// ArchiveImport is an archive unpacked and checked by the server, ready to be confirmed.
+// Bundles holds an unsigned problem bundle for each problem with the author solutions as commits,
+// so each can be confirmed on a daycare and saved like a new problem.
+// RenamedProblems and RenamedProblemSets map any unique IDs that were changed
+// to avoid conflicts to their new values; the manifest and bundles already use the new values.
+type ArchiveImport struct {
+	Manifest           *ArchiveManifest  `json:"manifest"`
+	Bundles            []*ProblemBundle  `json:"bundles"`
+	RenamedProblems    map[string]string `json:"renamedProblems"`
+	RenamedProblemSets map[string]string `json:"renamedProblemSets"`
+}
@@ -17,6 +17,7 @@ type ProblemBundle struct {
 	UserID                int64                   `json:"userID"`
 	Commits               []*Commit               `json:"commits"`
 	CommitSignatures      []string                `json:"commitSignatures,omitempty"`
+	MigrateAssignments    bool                    `json:"migrateAssignments,omitempty"`
 }
 
 type CommitBundle struct {
@@ -46,8 +47,11 @@ type DaycareRequest struct {
 
 // DaycareResponse represents a single response from the daycare back to a client.
 // These objects are streamed across a websockets connection.
+// If the daycare is too busy to accept the request, Error explains
+// and RetryAfter gives the number of seconds to wait before trying again.
 type DaycareResponse struct {
 	CommitBundle *CommitBundle `json:"commitBundle,omitempty"`
 	Event        *EventMessage `json:"event,omitempty"`
 	Error        string        `json:"error,omitempty"`
+	RetryAfter   int           `json:"retryAfter,omitempty"`
 }
@@ -9,12 +9,14 @@ import (
 
 const MaxDetailsLen = 50e3
 
-// ReportCard gives the results of a graded run
+// ReportCard gives the results of a graded run.
+// ProblemRevision records which revision of the problem was graded.
 type ReportCard struct {
-	Passed   bool                `json:"passed"`
-	Note     string              `json:"note"`
-	Duration time.Duration       `json:"duration"`
-	Results  []*ReportCardResult `json:"results"`
+	Passed          bool                `json:"passed"`
+	Note            string              `json:"note"`
+	Duration        time.Duration       `json:"duration"`
+	Results         []*ReportCardResult `json:"results"`
+	ProblemRevision int64               `json:"problemRevision,omitempty"`
 }
 
 // ReportCardResult Outcomes:
@@ -26,11 +28,17 @@ type ReportCard struct {
 //   be displayed in a monospace font
 // Context:
 //   path/to/file.py:line#
+// Points, PointsPossible:
+//   the weight of this result in the score; a result with
+//   no PointsPossible is worth one point, and a passed result
+//   with no Points earns all of its PointsPossible
 type ReportCardResult struct {
-	Name    string `json:"name"`
-	Outcome string `json:"outcome"`
-	Details string `json:"details,omitempty"`
-	Context string `json:"context,omitempty"`
+	Name           string  `json:"name"`
+	Outcome        string  `json:"outcome"`
+	Details        string  `json:"details,omitempty"`
+	Context        string  `json:"context,omitempty"`
+	Points         float64 `json:"points,omitempty"`
+	PointsPossible float64 `json:"pointsPossible,omitempty"`
 }
 
 // EventMessage follows one of these forms:
@@ -43,6 +51,7 @@ type ReportCardResult struct {
 //   error Error
 //   reportcard ReportCard
 //   files Files
+//   queue QueuePosition
 type EventMessage struct {
 	Time        time.Time         `json:"time"`
 	Event       string            `json:"event"`
@@ -52,6 +61,8 @@ type EventMessage struct {
 	Error       string            `json:"error,omitempty"`
 	ReportCard  *ReportCard       `json:"reportCard,omitempty"`
 	Files       map[string][]byte `json:"files,omitempty"`
+
+	QueuePosition int `json:"queuePosition,omitempty"`
 }
 
 func (e *EventMessage) String() string {
@@ -77,6 +88,8 @@ func (e *EventMessage) String() string {
 			names = append(names, name)
 		}
 		return fmt.Sprintf("event: files %s", strings.Join(names, ", "))
+	case "queue":
+		return fmt.Sprintf("event: queue position %d", e.QueuePosition)
 	default:
 		return fmt.Sprintf("unknown event: %s", e.Event)
 	}
@@ -155,23 +168,63 @@ func (elt *ReportCard) AddPassedResult(name, details string) *ReportCardResult {
 	return r
 }
 
+// ComputeScore gives the weighted fraction of points earned, on a scale
+// of 0.0 to 1.0. If all points were earned but the report card did not pass,
+// the score is reduced by the weight of one average result. For report cards
+// with no weighted results this is the same score as before weights were
+// supported: passed/total, or passed/(total+1) if every result passed but
+// the report card did not.
+//
+// The daycare also uses this for each step's score. Compared with the rule it
+// used before, a report card whose results all passed but that failed anyway
+// (e.g., from a timeout) no longer gets full credit for the step. A passing
+// report card with no results scores 1.0, where this used to give 0.0.
 func (elt *ReportCard) ComputeScore() float64 {
 	if len(elt.Results) == 0 {
+		if elt.Passed {
+			return 1.0
+		}
 		return 0.0
 	}
-	passed := 0
+	earned, possible := 0.0, 0.0
 	for _, result := range elt.Results {
-		if result.Outcome == "passed" {
-			passed++
-		}
+		earned += result.EarnedPoints()
+		possible += result.PossiblePoints()
 	}
-	score := float64(passed) / float64(len(elt.Results))
+	if possible <= 0.0 {
+		return 0.0
+	}
+	score := earned / possible
 	if !elt.Passed && score >= 1.0 {
-		score = float64(passed) / float64(len(elt.Results)+1)
+		score = earned / (possible + possible/float64(len(elt.Results)))
 	}
 	return score
 }
 
+// PossiblePoints gives the weight of this result.
+func (result *ReportCardResult) PossiblePoints() float64 {
+	if result.PointsPossible > 0.0 {
+		return result.PointsPossible
+	}
+	return 1.0
+}
+
+// EarnedPoints gives the points awarded for this result,
+// never more than its PossiblePoints.
+func (result *ReportCardResult) EarnedPoints() float64 {
+	possible := result.PossiblePoints()
+	if result.Outcome == "passed" && result.Points == 0.0 {
+		return possible
+	}
+	if result.Points <= 0.0 {
+		return 0.0
+	}
+	if result.Points > possible {
+		return possible
+	}
+	return result.Points
+}
+
 var signals = map[int]string{
 	1:  "SIGHUP",
 	2:  "SIGINT",
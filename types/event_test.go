@@ -0,0 +1,85 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParsePointsAnnotations(t *testing.T) {
+	tests := []struct {
+		name           string
+		outcome        string
+		points         float64
+		pointsPossible float64
+
+		wantName           string
+		wantPoints         float64
+		wantPointsPossible float64
+	}{
+		{"test_recursion [5 pts]", "passed", 0, 0, "test_recursion", 0, 5},
+		{"test_recursion [5pt]", "passed", 0, 0, "test_recursion", 0, 5},
+		{"test_loops (2/4 points)", "failed", 0, 0, "test_loops", 2, 4},
+		{"test_loops ( 1.5 / 3 point )", "failed", 0, 0, "test_loops", 1.5, 3},
+		{"[3 pts] test_prefix", "passed", 0, 0, "test_prefix", 0, 3},
+		{"test_plain", "passed", 0, 0, "test_plain", 0, 0},
+		{"test_list [1, 2, 3]", "passed", 0, 0, "test_list [1, 2, 3]", 0, 0},
+
+		// weights from the parser win, but the annotation is still removed
+		{"test_xunit [5 pts]", "failed", 1, 2, "test_xunit", 1, 2},
+		{"test_xunit (3/5 pts)", "failed", 0, 10, "test_xunit", 0, 10},
+	}
+	for _, test := range tests {
+		reportCard := NewReportCard()
+		reportCard.Results = append(reportCard.Results, &ReportCardResult{
+			Name:           test.name,
+			Outcome:        test.outcome,
+			Points:         test.points,
+			PointsPossible: test.pointsPossible,
+		})
+		reportCard.ParsePointsAnnotations()
+		result := reportCard.Results[0]
+		if result.Name != test.wantName || result.Points != test.wantPoints || result.PointsPossible != test.wantPointsPossible {
+			t.Errorf("%q: got name %q, points %v/%v, expected %q, %v/%v", test.name,
+				result.Name, result.Points, result.PointsPossible,
+				test.wantName, test.wantPoints, test.wantPointsPossible)
+		}
+	}
+}
+
+func TestComputeScore(t *testing.T) {
+	passed := func(points, possible float64) *ReportCardResult {
+		return &ReportCardResult{Outcome: "passed", Points: points, PointsPossible: possible}
+	}
+	failed := func(points, possible float64) *ReportCardResult {
+		return &ReportCardResult{Outcome: "failed", Points: points, PointsPossible: possible}
+	}
+	tests := []struct {
+		name    string
+		passed  bool
+		results []*ReportCardResult
+		score   float64
+	}{
+		// unweighted report cards score as they did before weights
+		{"no results, passed", true, nil, 1.0},
+		{"no results, failed", false, nil, 0.0},
+		{"all passed", true, []*ReportCardResult{passed(0, 0), passed(0, 0)}, 1.0},
+		{"some passed", false, []*ReportCardResult{passed(0, 0), failed(0, 0), failed(0, 0), passed(0, 0)}, 0.5},
+		{"all passed but failed", false, []*ReportCardResult{passed(0, 0), passed(0, 0), passed(0, 0)}, 0.75},
+		{"none passed", false, []*ReportCardResult{failed(0, 0), failed(0, 0)}, 0.0},
+
+		// weighted results
+		{"one hard test", false, []*ReportCardResult{passed(0, 0), passed(0, 0), failed(0, 8)}, 0.2},
+		{"hard test passed", false, []*ReportCardResult{failed(0, 0), failed(0, 0), passed(0, 8)}, 0.8},
+		{"partial credit", false, []*ReportCardResult{passed(0, 2), failed(1, 4)}, 0.5},
+		{"partial credit on a passed test", true, []*ReportCardResult{passed(3, 4)}, 0.75},
+		{"points over possible are capped", false, []*ReportCardResult{failed(9, 4), failed(0, 4)}, 0.5},
+		{"negative points earn nothing", false, []*ReportCardResult{failed(-2, 4), passed(0, 4)}, 0.5},
+		{"all weighted points but failed", false, []*ReportCardResult{passed(0, 3), passed(0, 1)}, 4.0 / 6.0},
+	}
+	for _, test := range tests {
+		reportCard := &ReportCard{Passed: test.passed, Results: test.results}
+		if score := reportCard.ComputeScore(); math.Abs(score-test.score) > 1e-9 {
+			t.Errorf("%s: score is %v, expected %v", test.name, score, test.score)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package types
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Gradebook is an export of every student's scores in a course,
+// optionally limited to a single problem set.
+type Gradebook struct {
+	CourseID     int64               `json:"courseID"`
+	CourseName   string              `json:"courseName"`
+	ProblemSetID int64               `json:"problemSetID,omitempty"`
+	CreatedAt    time.Time           `json:"createdAt"`
+	Students     []*GradebookStudent `json:"students"`
+}
+
+// GradebookStudent is one student's row in a gradebook.
+type GradebookStudent struct {
+	UserID      int64                  `json:"userID"`
+	Name        string                 `json:"name"`
+	Email       string                 `json:"email"`
+	CanvasLogin string                 `json:"canvasLogin"`
+	Assignments []*GradebookAssignment `json:"assignments"`
+}
+
+// GradebookAssignment is a student's score on one assignment, computed with
+// the same weights used when posting the grade to the LMS.
+// DaysLate counts partial days, measured from the due date after any extension.
+// Passback is the status of the last grade post to the LMS, if any.
+type GradebookAssignment struct {
+	AssignmentID  int64               `json:"assignmentID"`
+	CanvasTitle   string              `json:"canvasTitle"`
+	ProblemSetID  int64               `json:"problemSetID,omitempty"`
+	ProblemSet    string              `json:"problemSet,omitempty"`
+	Quiz          bool                `json:"quiz,omitempty"`
+	Score         float64             `json:"score"`
+	Problems      []*GradebookProblem `json:"problems"`
+	LastCommitAt  *time.Time          `json:"lastCommitAt"`
+	DueAt         *time.Time          `json:"dueAt"`
+	DaysLate      int                 `json:"daysLate"`
+	Passback      string              `json:"passback"`
+	PassbackAt    *time.Time          `json:"passbackAt"`
+	PassbackError string              `json:"passbackError,omitempty"`
+}
+
+// GradebookProblem is the scores for one problem (or quiz) of an assignment.
+type GradebookProblem struct {
+	Unique string           `json:"unique"`
+	Weight float64          `json:"weight"`
+	Steps  []*GradebookStep `json:"steps"`
+}
+
+// GradebookStep is the raw score for one step of a problem (or question of a quiz)
+// and the fraction of it deducted for lateness.
+type GradebookStep struct {
+	Weight      float64 `json:"weight"`
+	RawScore    float64 `json:"rawScore"`
+	LatePenalty float64 `json:"latePenalty,omitempty"`
+}
+
+// WriteCSV writes the gradebook with one row per student assignment.
+// Each problem step gets its own column holding the raw score.
+func (gradebook *Gradebook) WriteCSV(w io.Writer) error {
+	// find the step columns in the order they first appear
+	type column struct {
+		unique string
+		step   int
+	}
+	var columns []column
+	seen := make(map[column]bool)
+	for _, student := range gradebook.Students {
+		for _, asst := range student.Assignments {
+			for _, problem := range asst.Problems {
+				for i := range problem.Steps {
+					col := column{problem.Unique, i}
+					if !seen[col] {
+						seen[col] = true
+						columns = append(columns, col)
+					}
+				}
+			}
+		}
+	}
+
+	out := csv.NewWriter(w)
+	header := []string{
+		"user_id", "name", "email", "canvas_login",
+		"assignment_id", "assignment", "problem_set", "score",
+		"last_commit_at", "due_at", "days_late", "passback", "passback_at", "passback_error",
+	}
+	for _, col := range columns {
+		header = append(header, fmt.Sprintf("%s step %d", col.unique, col.step+1))
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	formatFloat := func(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	for _, student := range gradebook.Students {
+		for _, asst := range student.Assignments {
+			scores := make(map[column]string)
+			for _, problem := range asst.Problems {
+				for i, step := range problem.Steps {
+					scores[column{problem.Unique, i}] = formatFloat(step.RawScore)
+				}
+			}
+			row := []string{
+				strconv.FormatInt(student.UserID, 10), student.Name, student.Email, student.CanvasLogin,
+				strconv.FormatInt(asst.AssignmentID, 10), asst.CanvasTitle, asst.ProblemSet, formatFloat(asst.Score),
+				formatTime(asst.LastCommitAt), formatTime(asst.DueAt), strconv.Itoa(asst.DaysLate),
+				asst.Passback, formatTime(asst.PassbackAt), asst.PassbackError,
+			}
+			for _, col := range columns {
+				row = append(row, scores[col])
+			}
+			if err := out.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
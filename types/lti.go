@@ -0,0 +1,100 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LTIPlatform is an LMS registered to launch this tool using LTI 1.3.
+// The values come from the LMS when the tool is registered with it;
+// for Canvas, the client ID is the developer key ID and the deployment
+// IDs are shown when the tool is added to an account or course.
+type LTIPlatform struct {
+	ID            int64     `json:"id" meddler:"id,pk"`
+	Name          string    `json:"name" meddler:"name"`
+	Issuer        string    `json:"issuer" meddler:"issuer"`                     // https://canvas.instructure.com
+	ClientID      string    `json:"clientID" meddler:"client_id"`                // 10000000000001
+	DeploymentIDs []string  `json:"deploymentIDs" meddler:"deployment_ids,json"` // every deployment that may launch the tool
+	AuthLoginURL  string    `json:"authLoginURL" meddler:"auth_login_url"`       // OIDC authorization endpoint: https://sso.canvaslms.com/api/lti/authorize_redirect
+	AuthTokenURL  string    `json:"authTokenURL" meddler:"auth_token_url"`       // OAuth2 token endpoint: https://sso.canvaslms.com/login/oauth2/token
+	KeySetURL     string    `json:"keySetURL" meddler:"key_set_url"`             // platform public keys: https://sso.canvaslms.com/api/lti/security/jwks
+	CreatedAt     time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt     time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks a platform registration and tidies up its fields.
+func (platform *LTIPlatform) Normalize(now time.Time) error {
+	platform.Name = strings.TrimSpace(platform.Name)
+	platform.Issuer = strings.TrimSpace(platform.Issuer)
+	platform.ClientID = strings.TrimSpace(platform.ClientID)
+	if platform.Issuer == "" {
+		return fmt.Errorf("issuer cannot be empty")
+	}
+	if platform.ClientID == "" {
+		return fmt.Errorf("client ID cannot be empty")
+	}
+	if platform.Name == "" {
+		platform.Name = platform.Issuer
+	}
+
+	var deployments []string
+	for _, id := range platform.DeploymentIDs {
+		if id = strings.TrimSpace(id); id != "" {
+			deployments = append(deployments, id)
+		}
+	}
+	if len(deployments) == 0 {
+		return fmt.Errorf("at least one deployment ID is required")
+	}
+	platform.DeploymentIDs = deployments
+
+	for _, elt := range []struct {
+		name  string
+		value *string
+	}{
+		{"auth login URL", &platform.AuthLoginURL},
+		{"auth token URL", &platform.AuthTokenURL},
+		{"key set URL", &platform.KeySetURL},
+	} {
+		*elt.value = strings.TrimSpace(*elt.value)
+		u, err := url.Parse(*elt.value)
+		if err != nil || !u.IsAbs() {
+			return fmt.Errorf("%s must be an absolute URL, not %q", elt.name, *elt.value)
+		}
+	}
+
+	if platform.CreatedAt.IsZero() {
+		platform.CreatedAt = now
+	}
+	platform.UpdatedAt = now
+	return nil
+}
+
+// HasDeployment reports whether the platform lists the given deployment ID.
+func (platform *LTIPlatform) HasDeployment(id string) bool {
+	for _, elt := range platform.DeploymentIDs {
+		if elt == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GradePost is a grade waiting to be sent to the LMS, or the record of the
+// last one sent. Each assignment has at most one, which is updated every time
+// the assignment is graded.
+type GradePost struct {
+	ID            int64      `json:"id" meddler:"id,pk"`
+	AssignmentID  int64      `json:"assignmentID" meddler:"assignment_id"`
+	Message       string     `json:"-" meddler:"message"`
+	Status        string     `json:"status" meddler:"status"` // pending, sent, or failed
+	Revision      int64      `json:"revision" meddler:"revision"`
+	Attempts      int64      `json:"attempts" meddler:"attempts"`
+	LastError     string     `json:"lastError" meddler:"last_error"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" meddler:"next_attempt_at,localtime"`
+	SentAt        *time.Time `json:"sentAt" meddler:"sent_at,localtime"`
+	CreatedAt     time.Time  `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt     time.Time  `json:"updatedAt" meddler:"updated_at,localtime"`
+}
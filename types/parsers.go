@@ -0,0 +1,295 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportCardParser turns the results written by a test runner into a
+// report card. The command for the action is run in the container, after
+// which the contents of ResultsFile are fetched and passed to Parse.
+// Parse should add one result per test case to the report card.
+type ReportCardParser interface {
+	ResultsFile() string
+	Parse(reportCard *ReportCard, start time.Time, contents []byte)
+}
+
+// ReportCardParsers maps the parser field of a problem type action
+// to the parser that handles it. Names must also be allowed by the
+// CHECK constraint on problem_type_actions.parser in the schema.
+var ReportCardParsers = map[string]ReportCardParser{
+	"xunit": xunitParser{},
+	"check": checkParser{},
+	"tap":   tapParser{},
+	"json":  jsonParser{},
+}
+
+// testPointsAnnotation matches a weight given in a test name,
+// such as "test_recursion [5 pts]" or "test_loops (2/4 points)".
+var testPointsAnnotation = regexp.MustCompile(`\s*[\[(]\s*(?:(\d+(?:\.\d+)?)\s*/\s*)?(\d+(?:\.\d+)?)\s*(?:pts?|points?)\s*[\])]`)
+
+// ParsePointsAnnotations sets the points for any result that has
+// a weight annotation in its name and no weight from the parser.
+// The annotation is removed from the name.
+func (elt *ReportCard) ParsePointsAnnotations() {
+	for _, result := range elt.Results {
+		groups := testPointsAnnotation.FindStringSubmatch(result.Name)
+		if len(groups) == 0 {
+			continue
+		}
+		if result.PointsPossible == 0.0 {
+			result.PointsPossible, _ = strconv.ParseFloat(groups[2], 64)
+			if groups[1] != "" && result.Points == 0.0 {
+				result.Points, _ = strconv.ParseFloat(groups[1], 64)
+			}
+		}
+		result.Name = strings.TrimSpace(strings.Replace(result.Name, groups[0], "", 1))
+	}
+}
+
+// tapParser handles Test Anything Protocol output (versions 12 and 13).
+// Skipped tests are counted as failures, matching the xunit parser.
+type tapParser struct{}
+
+func (tapParser) ResultsFile() string { return "test_detail.tap" }
+
+func (tapParser) Parse(reportCard *ReportCard, start time.Time, contents []byte) {
+	parseTAP(reportCard, start, contents)
+}
+
+// The plan line comes from the program under test, so it is not trusted to
+// size the report card: planned tests that never report a result are listed
+// one by one only when there are a few of them in a plan of reasonable size.
+const (
+	tapMaxPlan    = 10000
+	tapMaxMissing = 100
+)
+
+var tapPlan = regexp.MustCompile(`^1\.\.(\d+)\s*(?:#\s*(.*))?$`)
+var tapTestLine = regexp.MustCompile(`^(ok|not ok)\b\s*(\d+)?\s*(?:-\s*)?([^#]*?)\s*(?:#\s*(.*))?$`)
+var tapDirective = regexp.MustCompile(`(?i)^(skip|todo)\S*\s*(.*)$`)
+var tapBailOut = regexp.MustCompile(`^Bail out!\s*(.*)$`)
+
+func parseTAP(reportCard *ReportCard, start time.Time, contents []byte) {
+	if len(contents) == 0 {
+		reportCard.LogAndFailf("No unit test results found")
+		return
+	}
+
+	planned, count, fails := -1, 0, 0
+	seen := make(map[int]bool)
+	bailed := ""
+	var last *ReportCardResult
+	var details []string
+	inYAML := false
+
+	// diagnostic lines following a test line are attached to that test
+	flush := func() {
+		if last != nil && len(details) > 0 {
+			if last.Details != "" {
+				last.Details += "\n"
+			}
+			last.Details += strings.Join(details, "\n")
+		}
+		details = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(make([]byte, 64*1024), int(MaxDetailsLen))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+
+		// YAML diagnostic blocks (TAP 13)
+		if inYAML {
+			if strings.TrimSpace(line) == "..." {
+				inYAML = false
+			} else {
+				details = append(details, strings.TrimPrefix(line, "  "))
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "---" && last != nil {
+			inYAML = true
+			continue
+		}
+
+		// indented lines are subtests, summarized by a later top-level line
+		if line != strings.TrimLeft(line, " \t") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "TAP version") {
+			continue
+		} else if groups := tapPlan.FindStringSubmatch(line); len(groups) > 0 {
+			planned, _ = strconv.Atoi(groups[1])
+		} else if groups := tapBailOut.FindStringSubmatch(line); len(groups) > 0 {
+			bailed = groups[1]
+			if bailed == "" {
+				bailed = "no reason given"
+			}
+			break
+		} else if groups := tapTestLine.FindStringSubmatch(line); len(groups) > 0 {
+			flush()
+			count++
+			number := count
+			if groups[2] != "" {
+				number, _ = strconv.Atoi(groups[2])
+			}
+			seen[number] = true
+			name := groups[3]
+			if name == "" {
+				name = fmt.Sprintf("test %d", number)
+			}
+			ok := groups[1] == "ok"
+			note := ""
+			if directive := tapDirective.FindStringSubmatch(groups[4]); len(directive) > 0 {
+				switch strings.ToLower(directive[1]) {
+				case "skip":
+					ok = false
+					note = "skipped"
+				case "todo":
+					ok = true
+					note = "todo"
+				}
+				if directive[2] != "" {
+					note += ": " + directive[2]
+				}
+			}
+			if ok {
+				last = reportCard.AddPassedResult(name, note)
+			} else {
+				fails++
+				last = reportCard.AddFailedResult(name, note, "")
+			}
+		} else if strings.HasPrefix(line, "#") {
+			details = append(details, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		reportCard.LogAndFailf("error parsing unit test results: %v", err)
+		return
+	}
+
+	// try to parse context for failed tests
+	for _, result := range reportCard.Results {
+		if result.Outcome != "passed" && result.Context == "" {
+			if groups := testFailureContextPython.FindStringSubmatch(result.Details); len(groups) > 1 {
+				result.Context = groups[1] + ":" + groups[2]
+			}
+		}
+	}
+
+	// tests that were planned but never reported count as failures
+	total := count
+	missing := 0
+	if planned > 0 {
+		missing = planned
+		for number := range seen {
+			if number >= 1 && number <= planned {
+				missing--
+			}
+		}
+	}
+	if missing > 0 && missing <= tapMaxMissing && planned <= tapMaxPlan {
+		for i := 1; i <= planned; i++ {
+			if !seen[i] {
+				fails++
+				total++
+				reportCard.AddFailedResult(fmt.Sprintf("test %d", i), "test did not report a result", "")
+			}
+		}
+	} else if missing > 0 {
+		fails++
+		total++
+		reportCard.AddFailedResult("missing tests", fmt.Sprintf("%d of %d planned tests did not report a result", missing, planned), "")
+	}
+
+	// form a report card
+	reportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v", total-fails, total, time.Since(start))
+	if bailed != "" {
+		reportCard.Failf("test run bailed out: %s", bailed)
+	} else if planned < 0 {
+		reportCard.Failf("test output did not include a plan")
+	} else if planned > tapMaxPlan {
+		reportCard.Failf("test plan of %d tests is more than the limit of %d", planned, tapMaxPlan)
+	}
+	reportCard.Passed = reportCard.Passed && total > 0 && fails == 0
+}
+
+// jsonParser handles a report card written directly as JSON:
+//
+//	{
+//	  "passed": true,
+//	  "note": "optional note",
+//	  "results": [
+//	    { "name": "test name", "outcome": "passed" },
+//	    { "name": "other test", "outcome": "failed", "details": "...", "context": "file.pl:12" },
+//	    { "name": "hard test", "outcome": "failed", "points": 2, "pointsPossible": 5 }
+//	  ]
+//	}
+//
+// Outcomes are those listed for ReportCardResult. If passed is omitted,
+// the run passes if every result passed. Points and pointsPossible are
+// optional and weight the result as described for ReportCardResult.
+type jsonParser struct{}
+
+func (jsonParser) ResultsFile() string { return "test_detail.json" }
+
+func (jsonParser) Parse(reportCard *ReportCard, start time.Time, contents []byte) {
+	parseJSONReportCard(reportCard, start, contents)
+}
+
+type JSONReportCard struct {
+	Passed  *bool               `json:"passed"`
+	Note    string              `json:"note"`
+	Results []*ReportCardResult `json:"results"`
+}
+
+func parseJSONReportCard(reportCard *ReportCard, start time.Time, contents []byte) {
+	if len(contents) == 0 {
+		reportCard.LogAndFailf("No unit test results found")
+		return
+	}
+
+	results := new(JSONReportCard)
+	if err := json.Unmarshal(contents, results); err != nil {
+		reportCard.LogAndFailf("error parsing unit test results: %v", err)
+		return
+	}
+
+	fails := 0
+	for _, result := range results.Results {
+		var r *ReportCardResult
+		switch result.Outcome {
+		case "passed":
+			r = reportCard.AddPassedResult(result.Name, result.Details)
+		case "failed", "error", "skipped":
+			fails++
+			r = reportCard.AddFailedResult(result.Name, result.Details, result.Context)
+			r.Outcome = result.Outcome
+		default:
+			reportCard.LogAndFailf("unknown outcome %q for test %q", result.Outcome, result.Name)
+			return
+		}
+		r.Points = result.Points
+		r.PointsPossible = result.PointsPossible
+	}
+
+	// form a report card
+	total := len(results.Results)
+	reportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v", total-fails, total, time.Since(start))
+	if results.Note != "" {
+		reportCard.Note += ", " + results.Note
+	}
+	reportCard.Passed = reportCard.Passed && total > 0 && fails == 0
+	if results.Passed != nil && !*results.Passed {
+		reportCard.Passed = false
+	}
+}
@@ -0,0 +1,237 @@
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// outcomes summarizes a report card's results as "name=outcome" pairs.
+func outcomes(reportCard *ReportCard) string {
+	var list []string
+	for _, result := range reportCard.Results {
+		list = append(list, result.Name+"="+result.Outcome)
+	}
+	return strings.Join(list, ", ")
+}
+
+func TestParseTAP(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		passed   bool
+		outcomes string
+		note     string
+	}{
+		{
+			name:     "plan first",
+			input:    "TAP version 13\n1..2\nok 1 - adds\nok 2 - subtracts\n",
+			passed:   true,
+			outcomes: "adds=passed, subtracts=passed",
+			note:     "Passed 2/2 tests",
+		},
+		{
+			name:     "plan last",
+			input:    "ok 1 - adds\nnot ok 2 - subtracts\n1..2\n",
+			passed:   false,
+			outcomes: "adds=passed, subtracts=failed",
+			note:     "Passed 1/2 tests",
+		},
+		{
+			name:     "no plan",
+			input:    "ok 1 - adds\nok 2 - subtracts\n",
+			passed:   false,
+			outcomes: "adds=passed, subtracts=passed",
+			note:     "test output did not include a plan",
+		},
+		{
+			name:     "no test names",
+			input:    "1..2\nok\nok\n",
+			passed:   true,
+			outcomes: "test 1=passed, test 2=passed",
+		},
+		{
+			name:     "bail out",
+			input:    "1..3\nok 1 - adds\nBail out! database is down\nok 2 - never seen\n",
+			passed:   false,
+			outcomes: "adds=passed, test 2=failed, test 3=failed",
+			note:     "test run bailed out: database is down",
+		},
+		{
+			name:     "skip and todo",
+			input:    "1..3\nok 1 - adds\nok 2 - divides # SKIP no floats\nnot ok 3 - multiplies # TODO later\n",
+			passed:   false,
+			outcomes: "adds=passed, divides=failed, multiplies=passed",
+			note:     "Passed 2/3 tests",
+		},
+		{
+			name:     "missing planned tests",
+			input:    "1..4\nok 1 - adds\nok 3 - divides\n",
+			passed:   false,
+			outcomes: "adds=passed, divides=passed, test 2=failed, test 4=failed",
+			note:     "Passed 2/4 tests",
+		},
+		{
+			name:     "many missing planned tests",
+			input:    "1..500\nok 1 - adds\n",
+			passed:   false,
+			outcomes: "adds=passed, missing tests=failed",
+			note:     "Passed 1/2 tests",
+		},
+		{
+			name:     "huge plan",
+			input:    "1..2000000000\nok 1 - adds\nok 2 - subtracts\n",
+			passed:   false,
+			outcomes: "adds=passed, subtracts=passed, missing tests=failed",
+			note:     "test plan of 2000000000 tests is more than the limit",
+		},
+		{
+			name:     "subtests",
+			input:    "1..1\n    1..2\n    ok 1 - inner\n    not ok 2 - inner\nnot ok 1 - outer\n",
+			passed:   false,
+			outcomes: "outer=failed",
+		},
+		{
+			name:     "empty",
+			input:    "",
+			passed:   false,
+			outcomes: "",
+			note:     "No unit test results found",
+		},
+	}
+	for _, test := range tests {
+		reportCard := NewReportCard()
+		parseTAP(reportCard, time.Now(), []byte(test.input))
+		if reportCard.Passed != test.passed {
+			t.Errorf("%s: passed is %v, expected %v", test.name, reportCard.Passed, test.passed)
+		}
+		if got := outcomes(reportCard); got != test.outcomes {
+			t.Errorf("%s: results are %q, expected %q", test.name, got, test.outcomes)
+		}
+		if !strings.Contains(reportCard.Note, test.note) {
+			t.Errorf("%s: note is %q, expected it to contain %q", test.name, reportCard.Note, test.note)
+		}
+	}
+}
+
+func TestParseTAPDiagnostics(t *testing.T) {
+	input := strings.Join([]string{
+		"TAP version 13",
+		"1..2",
+		"not ok 1 - adds",
+		"  ---",
+		"  message: expected 4, got 5",
+		"  severity: fail",
+		"  ...",
+		"not ok 2 - subtracts",
+		"# Traceback (most recent call last):",
+		`#   File "tests/test_math.py", line 12, in test_subtracts`,
+		"ok 3 - extra # skip not planned",
+	}, "\n")
+	reportCard := NewReportCard()
+	parseTAP(reportCard, time.Now(), []byte(input))
+	if len(reportCard.Results) != 3 {
+		t.Fatalf("got %d results: %s", len(reportCard.Results), outcomes(reportCard))
+	}
+	adds, subtracts, extra := reportCard.Results[0], reportCard.Results[1], reportCard.Results[2]
+	if adds.Details != "message: expected 4, got 5\nseverity: fail" {
+		t.Errorf("YAML block: details are %q", adds.Details)
+	}
+	if !strings.Contains(subtracts.Details, "Traceback") || subtracts.Context != "test_math.py:12" {
+		t.Errorf("comment diagnostics: details are %q, context %q", subtracts.Details, subtracts.Context)
+	}
+	if extra.Outcome != "failed" || extra.Details != "skipped: not planned" {
+		t.Errorf("skipped test: outcome %s, details %q", extra.Outcome, extra.Details)
+	}
+}
+
+func TestParseJSONReportCard(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		passed   bool
+		outcomes string
+		note     string
+	}{
+		{
+			name:     "all passed",
+			input:    `{"results": [{"name": "a", "outcome": "passed"}, {"name": "b", "outcome": "passed"}]}`,
+			passed:   true,
+			outcomes: "a=passed, b=passed",
+			note:     "Passed 2/2 tests",
+		},
+		{
+			name:     "failures keep their outcome",
+			input:    `{"note": "ran twice", "results": [{"name": "a", "outcome": "error"}, {"name": "b", "outcome": "skipped"}]}`,
+			passed:   false,
+			outcomes: "a=error, b=skipped",
+			note:     "ran twice",
+		},
+		{
+			name:     "passed false",
+			input:    `{"passed": false, "note": "style check failed", "results": [{"name": "a", "outcome": "passed"}]}`,
+			passed:   false,
+			outcomes: "a=passed",
+			note:     "style check failed",
+		},
+		{
+			name:     "passed true does not hide failures",
+			input:    `{"passed": true, "results": [{"name": "a", "outcome": "failed"}]}`,
+			passed:   false,
+			outcomes: "a=failed",
+		},
+		{
+			name:     "unknown outcome",
+			input:    `{"results": [{"name": "a", "outcome": "passed"}, {"name": "b", "outcome": "maybe"}]}`,
+			passed:   false,
+			outcomes: "a=passed",
+			note:     `unknown outcome "maybe" for test "b"`,
+		},
+		{
+			name:   "no results",
+			input:  `{"results": []}`,
+			passed: false,
+		},
+		{
+			name:   "not json",
+			input:  `ok 1 - adds`,
+			passed: false,
+			note:   "error parsing unit test results",
+		},
+	}
+	for _, test := range tests {
+		reportCard := NewReportCard()
+		parseJSONReportCard(reportCard, time.Now(), []byte(test.input))
+		if reportCard.Passed != test.passed {
+			t.Errorf("%s: passed is %v, expected %v", test.name, reportCard.Passed, test.passed)
+		}
+		if got := outcomes(reportCard); got != test.outcomes {
+			t.Errorf("%s: results are %q, expected %q", test.name, got, test.outcomes)
+		}
+		if !strings.Contains(reportCard.Note, test.note) {
+			t.Errorf("%s: note is %q, expected it to contain %q", test.name, reportCard.Note, test.note)
+		}
+	}
+}
+
+func TestParseJSONReportCardPoints(t *testing.T) {
+	input := `{"results": [
+		{"name": "easy", "outcome": "passed", "pointsPossible": 1},
+		{"name": "hard", "outcome": "failed", "points": 2, "pointsPossible": 5, "details": "half done", "context": "main.pl:3"}
+	]}`
+	reportCard := NewReportCard()
+	parseJSONReportCard(reportCard, time.Now(), []byte(input))
+	if len(reportCard.Results) != 2 {
+		t.Fatalf("got %d results", len(reportCard.Results))
+	}
+	easy, hard := reportCard.Results[0], reportCard.Results[1]
+	if easy.PointsPossible != 1 || hard.Points != 2 || hard.PointsPossible != 5 {
+		t.Errorf("points were not carried through: easy %+v, hard %+v", easy, hard)
+	}
+	if hard.Details != "half done" || hard.Context != "main.pl:3" {
+		t.Errorf("details were not carried through: %+v", hard)
+	}
+	if score := reportCard.ComputeScore(); score != 3.0/6.0 {
+		t.Errorf("score is %v, expected %v", score, 3.0/6.0)
+	}
+}
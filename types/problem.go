@@ -55,10 +55,23 @@ type Problem struct {
 	Note      string    `json:"note" meddler:"note"`
 	Tags      []string  `json:"tags" meddler:"tags,json"`
 	Options   []string  `json:"options" meddler:"options,json"`
+	Revision  int64     `json:"revision" meddler:"revision"`
 	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
 	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
+// ProblemRevision is an immutable snapshot of a problem as it was published.
+// Each update to a problem publishes a new revision, and each assignment
+// is pinned to the revision it started on.
+type ProblemRevision struct {
+	ProblemID int64     `json:"problemID" meddler:"problem_id"`
+	Revision  int64     `json:"revision" meddler:"revision"`
+	Note      string    `json:"note" meddler:"note"`
+	Tags      []string  `json:"tags" meddler:"tags,json"`
+	Options   []string  `json:"options" meddler:"options,json"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
 // ProblemStep represents a single step of a problem.
 // Anything in the root directory of Files is added to the working directory,
 // possibly overwriting existing content. The subdirectory contents of Files
@@ -76,12 +89,21 @@ type ProblemStep struct {
 }
 
 type ProblemSet struct {
-	ID        int64     `json:"id" meddler:"id,pk"`
-	Unique    string    `json:"unique" meddler:"unique_id"`
-	Note      string    `json:"note" meddler:"note"`
-	Tags      []string  `json:"tags" meddler:"tags,json"`
-	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+	ID         int64       `json:"id" meddler:"id,pk"`
+	Unique     string      `json:"unique" meddler:"unique_id"`
+	Note       string      `json:"note" meddler:"note"`
+	Tags       []string    `json:"tags" meddler:"tags,json"`
+	LatePolicy *LatePolicy `json:"latePolicy,omitempty" meddler:"late_policy,json"`
+	CreatedAt  time.Time   `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt  time.Time   `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// LatePolicy describes the penalty for work submitted after the due date.
+// Each step is penalized according to when its score was earned.
+type LatePolicy struct {
+	PercentPerDay float64 `json:"percentPerDay"` // deducted for each day or part of a day late
+	MaxPercent    float64 `json:"maxPercent"`    // most that can be deducted: 0 for no limit
+	GraceMinutes  int64   `json:"graceMinutes"`  // work submitted this soon after the due date is not late
 }
 
 type ProblemSetProblem struct {
@@ -403,6 +425,16 @@ func (set *ProblemSet) Normalize(now time.Time) error {
 	}
 	sort.Strings(set.Tags)
 
+	// check the late policy
+	if set.LatePolicy != nil {
+		if err := set.LatePolicy.Normalize(); err != nil {
+			return err
+		}
+		if set.LatePolicy.PercentPerDay == 0.0 {
+			set.LatePolicy = nil
+		}
+	}
+
 	// sanity check timestamps
 	if set.CreatedAt.Before(BeginningOfTime) || set.CreatedAt.After(now) {
 		return fmt.Errorf("problem set CreatedAt time of %v is invalid", set.CreatedAt)
@@ -414,6 +446,59 @@ func (set *ProblemSet) Normalize(now time.Time) error {
 	return nil
 }
 
+// Normalize checks that a late policy makes sense.
+func (policy *LatePolicy) Normalize() error {
+	if policy.PercentPerDay < 0.0 || policy.PercentPerDay > 100.0 {
+		return fmt.Errorf("late penalty per day must be between 0 and 100 percent, not %v", policy.PercentPerDay)
+	}
+	if policy.MaxPercent < 0.0 || policy.MaxPercent > 100.0 {
+		return fmt.Errorf("maximum late penalty must be between 0 and 100 percent, not %v", policy.MaxPercent)
+	}
+	if policy.GraceMinutes < 0 {
+		return fmt.Errorf("late grace period cannot be negative")
+	}
+	return nil
+}
+
+// Penalty gives the fraction of credit (0.0 to 1.0) deducted for work
+// submitted at the given time, along with the number of days late.
+// Every day or part of a day past the due date and grace period counts.
+func (policy *LatePolicy) Penalty(dueAt *time.Time, when time.Time) (float64, int) {
+	if policy == nil || dueAt == nil {
+		return 0.0, 0
+	}
+	late := when.Sub(dueAt.Add(time.Duration(policy.GraceMinutes) * time.Minute))
+	if late <= 0 {
+		return 0.0, 0
+	}
+	days := int((late + 24*time.Hour - 1) / (24 * time.Hour))
+	percent := float64(days) * policy.PercentPerDay
+	if policy.MaxPercent > 0.0 && percent > policy.MaxPercent {
+		percent = policy.MaxPercent
+	}
+	if percent > 100.0 {
+		percent = 100.0
+	}
+	return percent / 100.0, days
+}
+
+// Explain describes a late penalty for a report card.
+func (policy *LatePolicy) Explain(penalty float64, days int) string {
+	msg := fmt.Sprintf("late penalty: %g%% deducted for %d day%s late (%g%% per day",
+		penalty*100.0, days, plural(days), policy.PercentPerDay)
+	if policy.MaxPercent > 0.0 {
+		msg += fmt.Sprintf(", at most %g%%", policy.MaxPercent)
+	}
+	return msg + ")"
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
 func fixLineEndings(s []byte) []byte {
 	s = append(bytes.Replace(s, []byte("\r\n"), []byte("\n"), -1), '\n')
 	for bytes.Contains(s, []byte(" \n")) {
@@ -0,0 +1,53 @@
+package types
+
+import "time"
+
+// SimilarityReport is the result of comparing every student's submission
+// for one step of a problem against every other submission.
+type SimilarityReport struct {
+	ID          int64             `json:"id" meddler:"id,pk"`
+	ProblemID   int64             `json:"problemID" meddler:"problem_id"`
+	Step        int64             `json:"step" meddler:"step"` // note: one-based
+	UserID      int64             `json:"userID" meddler:"user_id"`
+	Status      string            `json:"status" meddler:"status"` // running, done, or failed
+	Submissions int64             `json:"submissions" meddler:"submissions"`
+	Error       string            `json:"error" meddler:"error"`
+	Pairs       []*SimilarityPair `json:"pairs" meddler:"pairs,json"`
+	CreatedAt   time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt   time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// SimilarityPair is two submissions that share code, with the regions they share.
+// Pairs in a report are ranked by Score.
+type SimilarityPair struct {
+	A       *SimilaritySubmission `json:"a"`
+	B       *SimilaritySubmission `json:"b"`
+	Score   float64               `json:"score"`
+	Matches []*SimilarityMatch    `json:"matches"`
+}
+
+// SimilaritySubmission identifies one side of a pair. Shared is the fraction of
+// this submission's fingerprints that also appear in the other submission.
+type SimilaritySubmission struct {
+	AssignmentID int64   `json:"assignmentID"`
+	CommitID     int64   `json:"commitID"`
+	UserID       int64   `json:"userID"`
+	UserName     string  `json:"userName"`
+	CourseID     int64   `json:"courseID"`
+	Shared       float64 `json:"shared"`
+}
+
+// SimilarityMatch is a region of code found in both submissions of a pair.
+// Lines are one-based and inclusive, and the text of each side is included
+// so the regions can be shown side by side.
+type SimilarityMatch struct {
+	FileA      string `json:"fileA"`
+	StartLineA int    `json:"startLineA"`
+	EndLineA   int    `json:"endLineA"`
+	TextA      string `json:"textA"`
+	FileB      string `json:"fileB"`
+	StartLineB int    `json:"startLineB"`
+	EndLineB   int    `json:"endLineB"`
+	TextB      string `json:"textB"`
+	Tokens     int    `json:"tokens"`
+}
@@ -60,6 +60,7 @@ type Assignment struct {
 	Roles              string               `json:"roles" meddler:"roles"`
 	Instructor         bool                 `json:"instructor" meddler:"instructor"`
 	RawScores          map[string][]float64 `json:"rawScores" meddler:"raw_scores,json"`
+	LatePenalties      map[string][]float64 `json:"latePenalties,omitempty" meddler:"late_penalties,json"`
 	Score              float64              `json:"score" meddler:"score,zeroisnull"`
 	GradeID            string               `json:"-" meddler:"grade_id,zeroisnull"`
 	LtiID              string               `json:"-" meddler:"lti_id"`
@@ -71,6 +72,10 @@ type Assignment struct {
 	OutcomeExtAccepted string               `json:"-" meddler:"outcome_ext_accepted"`
 	FinishedURL        string               `json:"-" meddler:"finished_url"`
 	ConsumerKey        string               `json:"consumerKey" meddler:"consumer_key"`
+	LTIPlatformID      int64                `json:"-" meddler:"lti_platform_id,zeroisnull"`
+	LTIUserID          string               `json:"-" meddler:"lti_user_id"`
+	LineItemsURL       string               `json:"-" meddler:"line_items_url"`
+	LineItemURL        string               `json:"-" meddler:"line_item_url"`
 	UnlockAt           *time.Time           `json:"unlockAt" meddler:"unlock_at,localtime"`
 	DueAt              *time.Time           `json:"dueAt" meddler:"due_at,localtime"`
 	LockAt             *time.Time           `json:"lockAt" meddler:"lock_at,localtime"`
@@ -115,6 +120,31 @@ func (assignment *Assignment) SetMinorScore(major string, minor int, score float
 	assignment.RawScores[major] = scores
 }
 
+// SetLatePenalty records the fraction of credit deducted from a step or question
+// because it was submitted late.
+func (assignment *Assignment) SetLatePenalty(major string, minor int, penalty float64) {
+	if assignment.LatePenalties == nil {
+		assignment.LatePenalties = map[string][]float64{}
+	}
+	penalties := assignment.LatePenalties[major]
+	for minor >= len(penalties) {
+		penalties = append(penalties, 0.0)
+	}
+	penalties[minor] = penalty
+	assignment.LatePenalties[major] = penalties
+}
+
+// LatePenalty gives the fraction of credit deducted from a step or question
+// because it was submitted late.
+func (assignment *Assignment) LatePenalty(major string, minor int) float64 {
+	penalties := assignment.LatePenalties[major]
+	if minor < len(penalties) {
+		return penalties[minor]
+	}
+	return 0.0
+}
+
+// ComputeScore gives the weighted score for the assignment, with any late penalties applied.
 func (assignment *Assignment) ComputeScore(majorWeights map[string]float64, minorWeights map[string][]float64) (float64, error) {
 	// compute an overall score
 	majorWeightSum, majorScoreSum := 0.0, 0.0
@@ -124,7 +154,7 @@ func (assignment *Assignment) ComputeScore(majorWeights map[string]float64, mino
 		for i, minorWeight := range minorWeights[unique] {
 			minorWeightSum += minorWeight
 			if i < len(scores) {
-				minorScoreSum += scores[i] * minorWeight
+				minorScoreSum += scores[i] * (1.0 - assignment.LatePenalty(unique, i)) * minorWeight
 			}
 		}
 		if minorWeightSum == 0.0 {
@@ -142,6 +172,92 @@ func (assignment *Assignment) ComputeScore(majorWeights map[string]float64, mino
 	return majorScoreSum / majorWeightSum, nil
 }
 
+// Extension gives a student more time than the LMS deadlines allow,
+// either on one assignment or, when LtiID is empty, on every assignment
+// in a course. A multiplier (e.g., 1.5 for time and a half) stretches the
+// time between when the assignment unlocks and its due and lock dates;
+// explicit dates replace the deadlines outright.
+type Extension struct {
+	ID         int64      `json:"id" meddler:"id,pk"`
+	CourseID   int64      `json:"courseID" meddler:"course_id"`
+	UserID     int64      `json:"userID" meddler:"user_id"`
+	LtiID      string     `json:"ltiID" meddler:"lti_id"`
+	DueAt      *time.Time `json:"dueAt" meddler:"due_at,localtime"`
+	LockAt     *time.Time `json:"lockAt" meddler:"lock_at,localtime"`
+	Multiplier float64    `json:"multiplier" meddler:"multiplier"`
+	Note       string     `json:"note" meddler:"note"`
+	GrantedBy  int64      `json:"grantedBy" meddler:"granted_by"`
+	CreatedAt  time.Time  `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt  time.Time  `json:"updatedAt" meddler:"updated_at,localtime"`
+
+	// for display only
+	CanvasTitle string `json:"canvasTitle,omitempty" meddler:"-"`
+}
+
+// ExtensionGrant is a request to give the same extension to a group of students.
+// AssignmentID is any assignment for the Canvas assignment being extended,
+// or zero for every assignment in the course.
+type ExtensionGrant struct {
+	UserIDs      []int64    `json:"userIDs"`
+	AssignmentID int64      `json:"assignmentID"`
+	DueAt        *time.Time `json:"dueAt"`
+	LockAt       *time.Time `json:"lockAt"`
+	Multiplier   float64    `json:"multiplier"`
+	Note         string     `json:"note"`
+}
+
+// Normalize checks an extension and tidies up its fields.
+func (ext *Extension) Normalize(now time.Time) error {
+	if ext.Multiplier == 0.0 {
+		ext.Multiplier = 1.0
+	}
+	if ext.Multiplier < 1.0 || ext.Multiplier > 10.0 {
+		return fmt.Errorf("extension multiplier must be between 1 and 10, not %g", ext.Multiplier)
+	}
+	if ext.DueAt == nil && ext.LockAt == nil && ext.Multiplier == 1.0 {
+		return fmt.Errorf("an extension needs a due date, a lock date, or a multiplier")
+	}
+	if ext.DueAt != nil && ext.LockAt != nil && ext.LockAt.Before(*ext.DueAt) {
+		return fmt.Errorf("extension lock date cannot be before its due date")
+	}
+	ext.Note = strings.TrimSpace(ext.Note)
+	if ext.CreatedAt.IsZero() {
+		ext.CreatedAt = now
+	}
+	ext.UpdatedAt = now
+	return nil
+}
+
+// Apply gives the due and lock dates for an assignment after the extension.
+// The multiplier only applies to deadlines that have an unlock date to measure from.
+// A nil extension leaves the dates unchanged.
+func (ext *Extension) Apply(unlockAt, dueAt, lockAt *time.Time) (*time.Time, *time.Time) {
+	if ext == nil {
+		return dueAt, lockAt
+	}
+	stretch := func(when *time.Time) *time.Time {
+		if when == nil || unlockAt == nil || ext.Multiplier <= 1.0 || !when.After(*unlockAt) {
+			return when
+		}
+		window := float64(when.Sub(*unlockAt)) * ext.Multiplier
+		later := unlockAt.Add(time.Duration(window))
+		return &later
+	}
+	dueAt, lockAt = stretch(dueAt), stretch(lockAt)
+	if ext.DueAt != nil {
+		dueAt = ext.DueAt
+	}
+	if ext.LockAt != nil {
+		lockAt = ext.LockAt
+	}
+
+	// an extended due date also pushes back the lock date
+	if dueAt != nil && lockAt != nil && lockAt.Before(*dueAt) {
+		lockAt = dueAt
+	}
+	return dueAt, lockAt
+}
+
 func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSignature, daycareHost string, userID int64) string {
 	v := make(url.Values)
 
@@ -171,6 +287,12 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 			if result.Context != "" {
 				v.Add(fmt.Sprintf("reportcard-%d-context", n), result.Context)
 			}
+			if result.Points != 0.0 {
+				v.Add(fmt.Sprintf("reportcard-%d-points", n), strconv.FormatFloat(result.Points, 'g', -1, 64))
+			}
+			if result.PointsPossible != 0.0 {
+				v.Add(fmt.Sprintf("reportcard-%d-pointspossible", n), strconv.FormatFloat(result.PointsPossible, 'g', -1, 64))
+			}
 		}
 	}
 	v.Add("score", strconv.FormatFloat(commit.Score, 'g', -1, 64))
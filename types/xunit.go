@@ -1,9 +1,11 @@
-package main
+package types
 
 import (
 	"encoding/xml"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -32,14 +34,23 @@ type XUnitSuite struct {
 }
 
 type XUnitCase struct {
-	Name      string         `xml:"name,attr"`
-	Status    string         `xml:"status,attr"`
-	Time      float64        `xml:"time,attr"`
-	ClassName string         `xml:"classname,attr"`
-	Failure   *XUnitFailure  `xml:"failure"`
-	Error     *XUnitError    `xml:"error"`
-	Disabled  *XUnitDisabled `xml:"disabled"`
-	Skipped   *XUnitSkipped  `xml:"skipped"`
+	Name       string           `xml:"name,attr"`
+	Status     string           `xml:"status,attr"`
+	Time       float64          `xml:"time,attr"`
+	ClassName  string           `xml:"classname,attr"`
+	Failure    *XUnitFailure    `xml:"failure"`
+	Error      *XUnitError      `xml:"error"`
+	Disabled   *XUnitDisabled   `xml:"disabled"`
+	Skipped    *XUnitSkipped    `xml:"skipped"`
+	Properties []*XUnitProperty `xml:"properties>property"`
+}
+
+// XUnitProperty is a name/value pair attached to a test case.
+// The properties "points" (points possible) and "points-earned"
+// (partial credit for a failed test) set the weight of the test.
+type XUnitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
 }
 
 type XUnitFailure struct {
@@ -66,39 +77,22 @@ type XUnitSkipped struct {
 	Body    string `xml:",chardata"`
 }
 
-func runAndParseXUnit(n *Nanny, cmd []string) {
-	filename := "test_detail.xml"
+// xunitParser handles JUnit-style XML files as generated by
+// googletest, python's xmlrunner, and similar tools.
+type xunitParser struct{}
 
-	// run tests with XML output
-	_, _, _, status, err := n.Exec(cmd, nil, false)
-	if err != nil {
-		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
-		return
-	}
+func (xunitParser) ResultsFile() string { return "test_detail.xml" }
 
-	// did it end in a segfault?
-	if status > 127 {
-		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
-		return
-	}
-	n.ReportCard.Passed = status == 0
-
-	// parse the test results
-	xmlfiles, err := n.GetFiles([]string{filename})
-	if err != nil {
-		n.ReportCard.LogAndFailf("Error getting unit test results")
-		return
-	}
-
-	parseXUnit(n, xmlfiles[filename])
+func (xunitParser) Parse(reportCard *ReportCard, start time.Time, contents []byte) {
+	parseXUnit(reportCard, start, contents)
 }
 
 var testFailureContextGTest = regexp.MustCompile(`^(tests/[^:/]*:\d+)`)
 var testFailureContextPython = regexp.MustCompile(`File "[^"]*/([^/]+)", line (\d+)`)
 
-func parseXUnit(n *Nanny, contents []byte) {
+func parseXUnit(reportCard *ReportCard, start time.Time, contents []byte) {
 	if len(contents) == 0 {
-		n.ReportCard.LogAndFailf("No unit test results found")
+		reportCard.LogAndFailf("No unit test results found")
 		return
 	}
 
@@ -108,7 +102,7 @@ func parseXUnit(n *Nanny, contents []byte) {
 		results.Suites = nil
 		err := xml.Unmarshal(contents, &results.Suites)
 		if err != nil {
-			n.ReportCard.LogAndFailf("error parsing unit test results: %v", err)
+			reportCard.LogAndFailf("error parsing unit test results: %v", err)
 			return
 		}
 	}
@@ -132,9 +126,9 @@ func parseXUnit(n *Nanny, contents []byte) {
 
 	// form a report card
 	fails := results.Failures + results.Disabled + results.Skipped + results.Errors
-	n.ReportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v",
-		results.Tests-fails, results.Tests, time.Since(n.Start))
-	n.ReportCard.Passed = n.ReportCard.Passed && results.Tests > 0 && fails == 0
+	reportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v",
+		results.Tests-fails, results.Tests, time.Since(start))
+	reportCard.Passed = reportCard.Passed && results.Tests > 0 && fails == 0
 
 	// prepare a report for each test case
 	for _, suite := range results.Suites {
@@ -143,12 +137,13 @@ func parseXUnit(n *Nanny, contents []byte) {
 			if testCase.ClassName != "" {
 				name = fmt.Sprintf("%s -> %s", testCase.ClassName, testCase.Name)
 			}
+			var result *ReportCardResult
 			if (testCase.Status == "run" || testCase.Status == "") &&
 				testCase.Failure == nil &&
 				testCase.Error == nil &&
 				testCase.Disabled == nil &&
 				testCase.Skipped == nil {
-				n.ReportCard.AddPassedResult(name, "")
+				result = reportCard.AddPassedResult(name, "")
 			} else {
 				body := ""
 				if testCase.Failure != nil {
@@ -168,7 +163,21 @@ func parseXUnit(n *Nanny, contents []byte) {
 				} else if groups := testFailureContextPython.FindStringSubmatch(body); len(groups) > 1 {
 					ctx = groups[1] + ":" + groups[2]
 				}
-				n.ReportCard.AddFailedResult(name, body, ctx)
+				result = reportCard.AddFailedResult(name, body, ctx)
+			}
+
+			// look for points assigned to this test case
+			for _, prop := range testCase.Properties {
+				value, err := strconv.ParseFloat(strings.TrimSpace(prop.Value), 64)
+				if err != nil || value < 0.0 {
+					continue
+				}
+				switch prop.Name {
+				case "points":
+					result.PointsPossible = value
+				case "points-earned":
+					result.Points = value
+				}
 			}
 		}
 	}
@@ -199,42 +208,25 @@ type CheckXMLTest struct {
 	Message     string  `xml:"message"`
 }
 
-func runAndParseCheckXML(n *Nanny, cmd []string) {
-	filename := "test_detail.xml"
-
-	// run tests with XML output
-	_, _, _, status, err := n.Exec(cmd, nil, false)
-	if err != nil {
-		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
-		return
-	}
+// checkParser handles the XML files generated by the check unit
+// testing framework for C.
+type checkParser struct{}
 
-	// did it end in a segfault?
-	if status > 127 {
-		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
-		return
-	}
-	n.ReportCard.Passed = status == 0
-
-	// parse the test results
-	xmlfiles, err := n.GetFiles([]string{filename})
-	if err != nil {
-		n.ReportCard.LogAndFailf("Error getting unit test results")
-		return
-	}
+func (checkParser) ResultsFile() string { return "test_detail.xml" }
 
-	parseCheckXML(n, xmlfiles[filename])
+func (checkParser) Parse(reportCard *ReportCard, start time.Time, contents []byte) {
+	parseCheckXML(reportCard, start, contents)
 }
 
-func parseCheckXML(n *Nanny, contents []byte) {
+func parseCheckXML(reportCard *ReportCard, start time.Time, contents []byte) {
 	if len(contents) == 0 {
-		n.ReportCard.LogAndFailf("No unit test results found")
+		reportCard.LogAndFailf("No unit test results found")
 		return
 	}
 
 	results := new(CheckXMLProgram)
 	if err := xml.Unmarshal(contents, results); err != nil {
-		n.ReportCard.LogAndFailf("error parsing unit test results: %v", err)
+		reportCard.LogAndFailf("error parsing unit test results: %v", err)
 		return
 	}
 
@@ -244,25 +236,25 @@ func parseCheckXML(n *Nanny, contents []byte) {
 			switch test.Result {
 			case "success":
 				successes++
-				n.ReportCard.AddPassedResult(test.ID, test.Message)
+				reportCard.AddPassedResult(test.ID, test.Message)
 			case "failure":
 				failures++
-				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
+				reportCard.AddFailedResult(test.ID, test.Message, test.Function)
 			case "error":
 				errors++
-				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
+				reportCard.AddFailedResult(test.ID, test.Message, test.Function)
 			default:
 				errors++
-				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
+				reportCard.AddFailedResult(test.ID, test.Message, test.Function)
 			}
 		}
 	}
 
 	// form a report card
-	n.ReportCard.Passed = successes > 0 && failures == 0 && errors == 0
+	reportCard.Passed = successes > 0 && failures == 0 && errors == 0
 	if successes+failures+errors < 1 {
-		n.ReportCard.Note = fmt.Sprintf("No test results found in %v", time.Since(n.Start))
+		reportCard.Note = fmt.Sprintf("No test results found in %v", time.Since(start))
 	} else {
-		n.ReportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v", successes, successes+failures+errors, time.Since(n.Start))
+		reportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v", successes, successes+failures+errors, time.Since(start))
 	}
 }